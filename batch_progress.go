@@ -0,0 +1,86 @@
+package main
+
+import (
+	"strings"
+	"sync/atomic"
+
+	"github.com/David-solly/mxbcode/pkg/models"
+)
+
+// batch event names
+const (
+	eventDevice   = "device"
+	eventFailed   = "failed"
+	eventProgress = "progress"
+	eventError    = "error"
+	eventDone     = "done"
+)
+
+// batchProgress :
+// Follows a batch as its devices are registered. Confirmed devices and
+// failures are sent on `events` in the order the provider answers.
+// Closing `cancel` stops the batch once the requests in flight finish -
+// shortcodes drawn but not yet sent are given up rather than reissued.
+// A nil *batchProgress follows nothing
+type batchProgress struct {
+	cancel <-chan struct{}
+	events chan models.BatchEvent
+
+	requested, inFlight, registered, failed int64
+}
+
+func newBatchProgress(requested int64, cancel <-chan struct{}) *batchProgress {
+	return &batchProgress{cancel: cancel, events: make(chan models.BatchEvent, requested), requested: requested}
+}
+
+// closed once the batch is cancelled - nil never fires
+func (p *batchProgress) cancelled() <-chan struct{} {
+	if p == nil {
+		return nil
+	}
+	return p.cancel
+}
+
+func (p *batchProgress) stopped() bool {
+	select {
+	case <-p.cancelled():
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *batchProgress) sent() {
+	if p != nil {
+		atomic.AddInt64(&p.inFlight, 1)
+	}
+}
+
+func (p *batchProgress) confirmed(deveui *models.DevEUI) {
+	if p == nil {
+		return
+	}
+	atomic.AddInt64(&p.registered, 1)
+	atomic.AddInt64(&p.inFlight, -1)
+	p.events <- models.BatchEvent{Event: eventDevice, DevEUI: strings.ToUpper(deveui.DevEUI), ShortCode: strings.ToUpper(deveui.ShortCode)}
+}
+
+func (p *batchProgress) refused(deveui *models.DevEUI, reason string) {
+	if p == nil {
+		return
+	}
+	atomic.AddInt64(&p.failed, 1)
+	atomic.AddInt64(&p.inFlight, -1)
+	p.events <- models.BatchEvent{Event: eventFailed, ShortCode: strings.ToUpper(deveui.ShortCode), Error: reason}
+}
+
+// the counts so far
+func (p *batchProgress) snapshot() *models.BatchProgress {
+	return &models.BatchProgress{
+		Requested:  p.requested,
+		Registered: atomic.LoadInt64(&p.registered),
+		Failed:     atomic.LoadInt64(&p.failed),
+		InFlight:   atomic.LoadInt64(&p.inFlight),
+		Cancelled:  p.stopped(),
+	}
+}
@@ -0,0 +1,577 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/David-solly/mxbcode/pkg/apikey"
+	"github.com/David-solly/mxbcode/pkg/cache"
+	"github.com/David-solly/mxbcode/pkg/export"
+	gen "github.com/David-solly/mxbcode/pkg/generator"
+	"github.com/David-solly/mxbcode/pkg/importer"
+	"github.com/David-solly/mxbcode/pkg/keys"
+	"github.com/David-solly/mxbcode/pkg/label"
+	"github.com/David-solly/mxbcode/pkg/models"
+	"github.com/David-solly/mxbcode/pkg/tenant"
+	"github.com/David-solly/mxbcode/pkg/zpl"
+)
+
+// Commands available when the cli is run with positional arguments
+// flags for the generator still go first
+// eg `go run . -redis-addr=localhost:6379 reserve -label=demo 0BEEF`
+var commands = map[string]func(args []string) (string, error){
+	"reserve":      reserveCommand,
+	"reservations": reservationsCommand,
+	"release":      releaseCommand,
+	"claim":        claimCommand,
+	"block":        blockCommand,
+	"blocks":       blocksCommand,
+	"export-keys":  exportKeysCommand,
+	"unwrap-keys":  unwrapKeysCommand,
+	"qr":           qrCommand,
+	"labels":       labelsCommand,
+	"zpl":          zplCommand,
+	"export":       exportCommand,
+	"import":       importCommand,
+	"keys":         keysCommand,
+}
+
+// run the named command against the application cache
+// the in-memory cache is persisted afterwards so
+// records survive between invocations
+func runCommand(args []string) string {
+	cmd, k := commands[args[0]]
+	if !k {
+		fmt.Printf("Unknown command %q\n", args[0])
+		return ""
+	}
+
+	out, err := cmd(args[1:])
+	if err != nil {
+		fmt.Println(err)
+		return ""
+	}
+
+	cache.Persist(c.Client)
+
+	fmt.Println(out)
+	return out
+}
+
+// reserve [-label=text] <shortcode|from-to>
+func reserveCommand(args []string) (string, error) {
+	fs := flag.NewFlagSet("reserve", flag.ContinueOnError)
+	label := fs.String("label", "", "Why the shortcodes are held back")
+	if err := fs.Parse(args); err != nil {
+		return "", err
+	}
+	if fs.NArg() != 1 {
+		return "", errors.New("usage: reserve [-label=text] <shortcode|from-to>")
+	}
+
+	from, to := splitRange(fs.Arg(0))
+	r, err := gen.Reserve(c.Client, from, to, *label)
+	if err != nil {
+		return "", err
+	}
+
+	data, _ := json.Marshal(r)
+	return string(data), nil
+}
+
+// reservations
+func reservationsCommand(args []string) (string, error) {
+	list, err := gen.Reservations(c.Client)
+	if err != nil {
+		return "", err
+	}
+
+	data, _ := json.Marshal(list)
+	return string(data), nil
+}
+
+// release <shortcode>
+func releaseCommand(args []string) (string, error) {
+	if len(args) != 1 {
+		return "", errors.New("usage: release <shortcode>")
+	}
+
+	r, err := gen.Release(c.Client, args[0])
+	if err != nil {
+		return "", err
+	}
+
+	data, _ := json.Marshal(r)
+	return string(data), nil
+}
+
+// claim <shortcode>
+func claimCommand(args []string) (string, error) {
+	if len(args) != 1 {
+		return "", errors.New("usage: claim <shortcode>")
+	}
+
+	dev, err := gen.ClaimReserved(c.Client, args[0])
+	if err != nil {
+		return "", err
+	}
+	if err := registerDevice(dev, c); err != nil {
+		gen.Unclaim(c.Client, dev.ShortCode)
+		return "", err
+	}
+
+	return string(toJSON("deveui", strings.ToUpper(dev.DevEUI))), nil
+}
+
+// block [-size=n] <owner> [from-to]
+func blockCommand(args []string) (string, error) {
+	fs := flag.NewFlagSet("block", flag.ContinueOnError)
+	size := fs.Int64("size", 0, "Number of shortcodes to allocate from the top of the free space")
+	if err := fs.Parse(args); err != nil {
+		return "", err
+	}
+	if fs.NArg() < 1 || fs.NArg() > 2 || (fs.NArg() == 1) == (*size == 0) {
+		return "", errors.New("usage: block -size=n <owner> | block <owner> <from-to>")
+	}
+
+	if tenant.OwnsBlock(fs.Arg(0)) {
+		return "", fmt.Errorf("blocks of %q are allocated to tenants when they are created", tenant.BlockOwnerPrefix)
+	}
+
+	from, to := "", ""
+	if fs.NArg() == 2 {
+		from, to = splitRange(fs.Arg(1))
+	}
+	b, err := gen.AllocateBlock(c.Client, fs.Arg(0), from, to, *size)
+	if err != nil {
+		return "", err
+	}
+
+	data, _ := json.Marshal(b)
+	return string(data), nil
+}
+
+// blocks
+func blocksCommand(args []string) (string, error) {
+	list, err := gen.Blocks(c.Client)
+	if err != nil {
+		return "", err
+	}
+
+	data, _ := json.Marshal(list)
+	return string(data), nil
+}
+
+// export-keys -kek=file [-label=name] [deveui...]
+// wraps the root keys of the devices - every device by default
+func exportKeysCommand(args []string) (string, error) {
+	fs := flag.NewFlagSet("export-keys", flag.ContinueOnError)
+	kekFile := fs.String("kek", "", "File holding the hex key encryption key")
+	label := fs.String("label", "", "Name of the KEK recorded in the manifest - defaults to the file name")
+	if err := fs.Parse(args); err != nil {
+		return "", err
+	}
+	if *kekFile == "" {
+		return "", errors.New("usage: export-keys -kek=file [-label=name] [deveui...]")
+	}
+	if keyVault == nil {
+		return "", errors.New("root keys need a master key - use -master-key-file or " + masterKeyEnv)
+	}
+
+	kek, err := keys.LoadKEK(*kekFile)
+	if err != nil {
+		return "", err
+	}
+	if *label == "" {
+		*label = strings.TrimSuffix(filepath.Base(*kekFile), keys.KEKExtension)
+	}
+
+	m, err := keyVault.WrapManifest(fs.Args(), kek, *label, "cli", "")
+	if err != nil {
+		return "", err
+	}
+
+	data, _ := json.Marshal(m)
+	return string(data), nil
+}
+
+// unwrap-keys -kek=file <manifest.json>
+// recovers the plain root keys of a manifest - join server side tooling
+func unwrapKeysCommand(args []string) (string, error) {
+	fs := flag.NewFlagSet("unwrap-keys", flag.ContinueOnError)
+	kekFile := fs.String("kek", "", "File holding the hex key encryption key")
+	if err := fs.Parse(args); err != nil {
+		return "", err
+	}
+	if *kekFile == "" || fs.NArg() != 1 {
+		return "", errors.New("usage: unwrap-keys -kek=file <manifest.json>")
+	}
+
+	kek, err := keys.LoadKEK(*kekFile)
+	if err != nil {
+		return "", err
+	}
+	data, err := ioutil.ReadFile(fs.Arg(0))
+	if err != nil {
+		return "", err
+	}
+	m := models.KeyManifest{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return "", fmt.Errorf("invalid manifest - %v", err)
+	}
+
+	list, err := keys.UnwrapManifest(m, kek)
+	if err != nil {
+		return "", err
+	}
+
+	data, _ = json.Marshal(list)
+	return string(data), nil
+}
+
+// qr [-format=png|svg] [-dir=path] [-scale=n] [-batch=file] [device...]
+// writes the TR005 QR code of each device to `<DEVEUI>.<format>`
+// -batch reads the devices of a generated batch - the printed RegisteredDevEUIList
+func qrCommand(args []string) (string, error) {
+	fs := flag.NewFlagSet("qr", flag.ContinueOnError)
+	format := fs.String("format", "png", "Image format - png or svg")
+	dir := fs.String("dir", ".", "Directory the images are written to")
+	scale := fs.Int("scale", defaultQRScale, "Pixels per module")
+	batch := fs.String("batch", "", "File holding a generated batch")
+	if err := fs.Parse(args); err != nil {
+		return "", err
+	}
+	if *format != "png" && *format != "svg" {
+		return "", fmt.Errorf("unsupported format %q - png or svg", *format)
+	}
+
+	devices, err := batchDevices(*batch, fs.Args())
+	if err != nil {
+		return "", err
+	}
+	if len(devices) == 0 {
+		return "", errors.New("usage: qr [-format=png|svg] [-dir=path] [-scale=n] [-batch=file] [device...]")
+	}
+
+	written := []string{}
+	for _, d := range devices {
+		deveui, found, err := lookupDevice(c.Client, d)
+		if err != nil {
+			return "", err
+		}
+		if !found {
+			return "", fmt.Errorf("shortcode - %v is Not Found", d)
+		}
+
+		img, _, err := deviceQR(deveui, *format, *scale)
+		if err != nil {
+			return "", err
+		}
+		path := filepath.Join(*dir, deveui+"."+*format)
+		if err := ioutil.WriteFile(path, img, 0644); err != nil {
+			return "", err
+		}
+		written = append(written, path)
+	}
+
+	data, _ := json.Marshal(written)
+	return string(data), nil
+}
+
+// labels [-format=pdf|svg] [-template=a4|letter] [-sheet=file] [-dir=path] [-batch=file] [device...]
+// writes the label sheets to `labels.pdf` or one `labels-<page>.svg` per sheet
+// -sheet reads a custom template as json in place of the named one
+func labelsCommand(args []string) (string, error) {
+	fs := flag.NewFlagSet("labels", flag.ContinueOnError)
+	format := fs.String("format", "pdf", "Document format - pdf or svg")
+	name := fs.String("template", "a4", "Sheet template - a4 or letter")
+	sheet := fs.String("sheet", "", "File holding a custom sheet template")
+	dir := fs.String("dir", ".", "Directory the sheets are written to")
+	batch := fs.String("batch", "", "File holding a generated batch")
+	if err := fs.Parse(args); err != nil {
+		return "", err
+	}
+
+	devices, err := batchDevices(*batch, fs.Args())
+	if err != nil {
+		return "", err
+	}
+	if len(devices) == 0 {
+		return "", errors.New("usage: labels [-format=pdf|svg] [-template=a4|letter] [-sheet=file] [-dir=path] [-batch=file] [device...]")
+	}
+
+	tmpl, err := label.TemplateFor(*name)
+	if *sheet != "" {
+		data, e := ioutil.ReadFile(*sheet)
+		if e != nil {
+			return "", e
+		}
+		tmpl = label.Template{}
+		if e := json.Unmarshal(data, &tmpl); e != nil {
+			return "", fmt.Errorf("invalid sheet - %v", e)
+		}
+		err = tmpl.Validate()
+	}
+	if err != nil {
+		return "", err
+	}
+
+	labels, err := deviceLabels(c.Client, devices)
+	if err != nil {
+		return "", err
+	}
+	docs, _, err := renderLabels(tmpl, labels, *format)
+	if err != nil {
+		return "", err
+	}
+
+	written := []string{}
+	for i, doc := range docs {
+		path := filepath.Join(*dir, "labels."+*format)
+		if *format == "svg" {
+			path = filepath.Join(*dir, fmt.Sprintf("labels-%d.svg", i+1))
+		}
+		if err := ioutil.WriteFile(path, doc, 0644); err != nil {
+			return "", err
+		}
+		written = append(written, path)
+	}
+
+	data, _ := json.Marshal(written)
+	return string(data), nil
+}
+
+// zpl [-layout=file] [-printer=host[:port]] [-out=file] [-batch=file] [device...]
+// renders one ZPL job for the devices - printed unless it is written
+// to -out or sent to a Zebra printer listening on raw TCP port 9100
+func zplCommand(args []string) (string, error) {
+	fs := flag.NewFlagSet("zpl", flag.ContinueOnError)
+	layoutFile := fs.String("layout", "", "File holding a text/template ZPL label layout")
+	printer := fs.String("printer", "", "Printer address the job is sent to - port 9100 by default")
+	out := fs.String("out", "", "File the job is written to")
+	batch := fs.String("batch", "", "File holding a generated batch")
+	if err := fs.Parse(args); err != nil {
+		return "", err
+	}
+
+	devices, err := batchDevices(*batch, fs.Args())
+	if err != nil {
+		return "", err
+	}
+	if len(devices) == 0 {
+		return "", errors.New("usage: zpl [-layout=file] [-printer=host[:port]] [-out=file] [-batch=file] [device...]")
+	}
+
+	layout := zplLayout
+	if *layoutFile != "" {
+		l, err := zpl.Load(*layoutFile)
+		if err != nil {
+			return "", err
+		}
+		layout = l
+	}
+
+	job, err := deviceZPL(c.Client, layout, devices)
+	if err != nil {
+		return "", err
+	}
+	if *out == "" && *printer == "" {
+		return string(job), nil
+	}
+
+	if *out != "" {
+		if err := ioutil.WriteFile(*out, job, 0644); err != nil {
+			return "", err
+		}
+	}
+	if *printer != "" {
+		if err := zpl.Send(*printer, job, printerTimeout); err != nil {
+			return "", err
+		}
+	}
+
+	data, _ := json.Marshal(map[string]interface{}{"labels": len(devices), "file": *out, "printer": *printer})
+	return string(data), nil
+}
+
+// export [-format=csv|ndjson|chirpstack|tts] [-columns=a,b] [-batch=file] [-batch-id=id] [-since=time] [-until=time] [-keys] [-out=file] [device...]
+// writes the device store in a network server import format - printed unless -out is given.
+// -keys includes the root keys of each device and is audited
+func exportCommand(args []string) (string, error) {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", "csv", "Export format - "+strings.Join(export.Formats(), ", "))
+	cols := fs.String("columns", "", "Comma separated csv and ndjson fields - "+strings.Join(export.DefaultColumns, ", ")+", "+strings.Join(export.KeyColumns, ", "))
+	batch := fs.String("batch", "", "File holding a generated batch")
+	batchID := fs.String("batch-id", "", "Only devices generated in this batch")
+	since := fs.String("since", "", "Only devices registered from this time - RFC 3339 or 2006-01-02")
+	until := fs.String("until", "", "Only devices registered before this time - RFC 3339 or 2006-01-02")
+	withKeys := fs.Bool("keys", false, "Include the root keys of each device")
+	out := fs.String("out", "", "File the export is written to")
+	appID := fs.String("application-id", "", "Network server application the devices are imported to")
+	profileID := fs.String("device-profile-id", "", "ChirpStack device profile of the devices")
+	plan := fs.String("frequency-plan", export.DefaultFrequencyPlan, "The Things Stack frequency plan of the devices")
+	if err := fs.Parse(args); err != nil {
+		return "", err
+	}
+
+	opts := export.Options{Format: *format, Columns: export.ParseColumns(*cols), ApplicationID: *appID, DeviceProfileID: *profileID, FrequencyPlan: *plan}
+	if err := opts.Validate(); err != nil {
+		return "", err
+	}
+	f, err := exportFilter(*batchID, *since, *until)
+	if err != nil {
+		return "", err
+	}
+	devices, err := batchDevices(*batch, fs.Args())
+	if err != nil {
+		return "", err
+	}
+	if *batch != "" && len(devices) == 0 {
+		return "", errors.New("the batch holds no devices")
+	}
+
+	rows, err := exportRows(c.Client, f, devices, *withKeys, "cli", "")
+	if err != nil {
+		return "", err
+	}
+	buf := bytes.Buffer{}
+	if err := export.Write(&buf, rows, opts); err != nil {
+		return "", err
+	}
+
+	if *out == "" {
+		return strings.TrimSuffix(buf.String(), "\n"), nil
+	}
+	if err := ioutil.WriteFile(*out, buf.Bytes(), 0644); err != nil {
+		return "", err
+	}
+	data, _ := json.Marshal(map[string]interface{}{"devices": len(rows), "file": *out})
+	return string(data), nil
+}
+
+// import [-format=csv|ndjson] [-advance] <file>
+// stores devices provisioned elsewhere so their shortcodes are never generated.
+// Shortcodes ahead of the counter are reserved unless -advance moves it past them
+func importCommand(args []string) (string, error) {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := fs.String("format", "", "File format - csv or ndjson, told from the content when blank")
+	advance := fs.Bool("advance", false, "Advance the counter past imported shortcodes instead of reserving them")
+	if err := fs.Parse(args); err != nil {
+		return "", err
+	}
+	if fs.NArg() != 1 {
+		return "", errors.New("usage: import [-format=csv|ndjson] [-advance] <file>")
+	}
+
+	data, err := ioutil.ReadFile(fs.Arg(0))
+	if err != nil {
+		return "", err
+	}
+	records, problems, err := importer.Read(bytes.NewReader(data), *format)
+	if err != nil {
+		return "", err
+	}
+	report, err := importDevices(c.Client, records, problems, *advance)
+	if err != nil {
+		return "", err
+	}
+
+	data, _ = json.Marshal(report)
+	return string(data), nil
+}
+
+// keys create -scopes=list <name> | keys list | keys rotate <id> | keys revoke <id>
+// manages the access keys of the API - keys are shown once when created or rotated
+func keysCommand(args []string) (string, error) {
+	usage := errors.New("usage: keys create -scopes=generate,lookup,export,admin <name> | keys list | keys rotate <id> | keys revoke <id>")
+	if len(args) < 1 {
+		return "", usage
+	}
+
+	var v interface{}
+	switch args[0] {
+	case "create":
+		fs := flag.NewFlagSet("keys create", flag.ContinueOnError)
+		list := fs.String("scopes", "", "Comma separated scopes granted to the key")
+		if err := fs.Parse(args[1:]); err != nil {
+			return "", err
+		}
+		if fs.NArg() != 1 {
+			return "", usage
+		}
+		scopes, err := apikey.ParseScopes(*list)
+		if err != nil {
+			return "", err
+		}
+		k, key, err := apikey.Create(c.Client, fs.Arg(0), scopes)
+		if err != nil {
+			return "", err
+		}
+		v = apiKeyResponse{APIKey: k, Key: key}
+
+	case "list":
+		list, err := apikey.List(c.Client)
+		if err != nil {
+			return "", err
+		}
+		v = list
+
+	case "rotate":
+		if len(args) != 2 {
+			return "", usage
+		}
+		k, key, err := apikey.Rotate(c.Client, args[1])
+		if err != nil {
+			return "", err
+		}
+		v = apiKeyResponse{APIKey: k, Key: key}
+
+	case "revoke":
+		if len(args) != 2 {
+			return "", usage
+		}
+		k, err := apikey.Revoke(c.Client, args[1])
+		if err != nil {
+			return "", err
+		}
+		v = k
+
+	default:
+		return "", usage
+	}
+
+	data, _ := json.Marshal(v)
+	return string(data), nil
+}
+
+// the listed devices followed by those of the batch file
+// a batch is the printed RegisteredDevEUIList
+func batchDevices(batch string, devices []string) ([]string, error) {
+	if batch == "" {
+		return devices, nil
+	}
+	data, err := ioutil.ReadFile(batch)
+	if err != nil {
+		return nil, err
+	}
+	registered := models.RegisteredDevEUIList{}
+	if err := json.Unmarshal(data, &registered); err != nil {
+		return nil, fmt.Errorf("invalid batch - %v", err)
+	}
+	return append(devices, registered.DevEUIs...), nil
+}
+
+// splits `from-to` - a single shortcode is returned as from
+func splitRange(arg string) (string, string) {
+	parts := strings.SplitN(arg, "-", 2)
+	if len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+	return parts[0], ""
+}
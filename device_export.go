@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/David-solly/mxbcode/pkg/cache"
+	"github.com/David-solly/mxbcode/pkg/claim"
+	"github.com/David-solly/mxbcode/pkg/export"
+	"github.com/David-solly/mxbcode/pkg/registry"
+)
+
+// the devices of the store matching the filter ready to export
+// devices limits the export to those DevEUIs eg the devices of a batch file.
+// Root keys are unsealed for actor when asked for - every device is audited
+func exportRows(cc cache.Service, f registry.Filter, devices []string, withKeys bool, actor, remote string) ([]export.Row, error) {
+	if withKeys && keyVault == nil {
+		return nil, errors.New("root keys need a master key - use -master-key-file or " + masterKeyEnv)
+	}
+
+	list, err := registry.List(cc, f)
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := map[string]bool{}
+	for _, d := range devices {
+		wanted[strings.ToUpper(d)] = true
+	}
+
+	rows := []export.Row{}
+	for _, d := range list {
+		if len(wanted) > 0 && !wanted[d.DevEUI] {
+			continue
+		}
+
+		row := export.Row{Device: d}
+		if cl, _ := claim.ForDevEUI(RequestCache.Client, d.DevEUI); cl != nil {
+			row.Serial = cl.Serial
+		}
+		if withKeys {
+			if row.Keys, err = keyVault.Export(d.DevEUI, actor, remote); err != nil {
+				return nil, err
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// the filter of a batch ID and registration window
+func exportFilter(batch, since, until string) (registry.Filter, error) {
+	f := registry.Filter{Batch: batch}
+	var err error
+	if f.Since, err = registry.ParseTime(since); err != nil {
+		return f, err
+	}
+	f.Until, err = registry.ParseTime(until)
+	return f, err
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/David-solly/mxbcode/pkg/cache"
+	"github.com/David-solly/mxbcode/pkg/claim"
+	"github.com/David-solly/mxbcode/pkg/events"
+	gen "github.com/David-solly/mxbcode/pkg/generator"
+	"github.com/David-solly/mxbcode/pkg/importer"
+	"github.com/David-solly/mxbcode/pkg/models"
+	"github.com/David-solly/mxbcode/pkg/registry"
+)
+
+// importDevices :
+// Stores devices provisioned elsewhere as one batch and protects their
+// shortcodes from the counter - advancing it past them or reserving them.
+// Devices already stored are duplicates; a shortcode, reservation or serial
+// held by another device is a conflict. Neither is imported
+func importDevices(cc cache.Service, records []importer.Record, problems []importer.Problem, advance bool) (importer.Report, error) {
+	report := importer.Report{Batch: registry.NewBatchID(), Reserved: []models.Reservation{}, Problems: []importer.Problem{}}
+	for _, p := range problems {
+		report.Add(p)
+	}
+
+	for _, rec := range records {
+		if rec.HasKeys() && keyVault == nil {
+			return report, errors.New("root keys need a master key - use -master-key-file or " + masterKeyEnv)
+		}
+	}
+
+	shortcodes := []string{}
+	for _, rec := range records {
+		if p := importConflict(cc, rec); p != nil {
+			report.Add(*p)
+			if p.Kind == importer.Conflict {
+				publish(cc, models.Event{Type: events.Conflict, Batch: report.Batch, DevEUI: rec.DevEUI, ShortCode: rec.ShortCode(), Detail: p.Reason})
+			}
+			continue
+		}
+
+		deveui := models.DevEUI{DevEUI: rec.DevEUI, ShortCode: rec.ShortCode()}
+		if _, err := cc.StoreDUID(deveui); err != nil {
+			return report, err
+		}
+		d := models.Device{DevEUI: rec.DevEUI, ShortCode: rec.ShortCode(), Batch: report.Batch, Registered: time.Now().UTC()}
+		if err := registry.Record(cc, d); err != nil {
+			return report, err
+		}
+		report.Imported++
+		shortcodes = append(shortcodes, rec.ShortCode())
+
+		if rec.HasKeys() {
+			k := models.RootKeys{DevEUI: rec.DevEUI, JoinEUI: rec.JoinEUI, AppKey: rec.AppKey, NwkKey: rec.NwkKey}
+			if err := keyVault.Store(k); err != nil {
+				return report, err
+			}
+			report.Keys++
+		}
+		if rec.Serial != "" {
+			_, created, err := claim.Claim(RequestCache.Client, rec.Serial, func() (string, error) { return rec.DevEUI, nil })
+			if err != nil || !created {
+				reason := fmt.Sprintf("serial %q is already bound", rec.Serial)
+				if err != nil {
+					reason = err.Error()
+				}
+				report.Add(importer.Problem{Line: rec.Line, DevEUI: rec.DevEUI, Kind: importer.Conflict, Reason: "imported without its serial - " + reason})
+				publish(cc, models.Event{Type: events.Conflict, Batch: report.Batch, DevEUI: rec.DevEUI, ShortCode: rec.ShortCode(), Detail: reason})
+				continue
+			}
+			report.Serials++
+		}
+	}
+
+	var err error
+	report.Last, report.Reserved, err = gen.ProtectShortcodes(cc, shortcodes, advance)
+	return report, err
+}
+
+// the duplicate or conflict keeping rec out of the store - nil when there is none
+func importConflict(cc cache.Service, rec importer.Record) *importer.Problem {
+	problem := func(kind, reason string) *importer.Problem {
+		return &importer.Problem{Line: rec.Line, DevEUI: rec.DevEUI, Kind: kind, Reason: reason}
+	}
+
+	if existing, found, _ := cc.ReadCache(rec.ShortCode()); found {
+		if existing == rec.DevEUI {
+			return problem(importer.Duplicate, "already in the store")
+		}
+		return problem(importer.Conflict, fmt.Sprintf("shortcode %s belongs to %s", rec.ShortCode(), existing))
+	}
+	held, err := gen.HeldBy(cc, rec.ShortCode())
+	if err != nil {
+		return problem(importer.Invalid, err.Error())
+	}
+	if held != "" {
+		return problem(importer.Conflict, fmt.Sprintf("shortcode %s is held by %s", rec.ShortCode(), held))
+	}
+	if rec.Serial != "" {
+		if cl, _ := claim.Get(RequestCache.Client, rec.Serial); cl != nil && cl.DevEUI != rec.DevEUI {
+			return problem(importer.Conflict, fmt.Sprintf("serial %q is bound to %s", rec.Serial, cl.DevEUI))
+		}
+	}
+	return nil
+}
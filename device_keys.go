@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/David-solly/mxbcode/pkg/keys"
+	"github.com/David-solly/mxbcode/pkg/tenant"
+)
+
+// environment variable holding the hex master key
+// when no `-master-key-file` is given
+const masterKeyEnv = "MMAX_MASTER_KEY"
+
+var (
+	// Seals OTAA root keys at rest
+	// nil unless a master key is configured
+	keyVault *keys.Vault
+
+	// generate root keys for each registered device
+	keyOptions   keys.Options
+	provisioning bool
+
+	// hash of the bearer token allowed to export keys
+	// export is disabled while it is blank
+	exportTokenHash string
+)
+
+// configure root key generation and export from the commandline
+func startKeys(generate bool, opts keys.Options, masterFile, tokenFile string) error {
+	var master []byte
+	var err error
+	switch {
+	case masterFile != "":
+		master, err = keys.LoadMasterKey(masterFile)
+	case os.Getenv(masterKeyEnv) != "":
+		master, err = keys.ParseMasterKey(os.Getenv(masterKeyEnv))
+	case generate || tokenFile != "":
+		err = fmt.Errorf("root keys need a master key - use -master-key-file or %s", masterKeyEnv)
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if keyVault, err = keys.NewVault(c.Client, master); err != nil {
+		return err
+	}
+	keyOptions = opts
+	provisioning = generate
+
+	if tokenFile != "" {
+		data, err := ioutil.ReadFile(tokenFile)
+		if err != nil {
+			return err
+		}
+		token := strings.TrimSpace(string(data))
+		if token == "" {
+			return errors.New("export token file is empty")
+		}
+		exportTokenHash = tenant.HashAPIKey(token)
+	}
+	return nil
+}
+
+// generate and seal the root keys of a newly registered device
+// a failure is reported but does not undo the registration
+func provisionKeys(deveui string) {
+	if !provisioning || keyVault == nil {
+		return
+	}
+	if _, err := keyVault.Provision(deveui, keyOptions); err != nil {
+		fmt.Printf("Error provisioning keys for %q:\n%s\n", deveui, err.Error())
+	}
+}
+
+// reports whether token may export keys
+// compared in constant time as hashes
+func exportAllowed(token string) bool {
+	if exportTokenHash == "" || token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(tenant.HashAPIKey(token)), []byte(exportTokenHash)) == 1
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/David-solly/mxbcode/pkg/cache"
+	"github.com/David-solly/mxbcode/pkg/label"
+)
+
+// the most labels rendered in one request
+const maxLabels = 1000
+
+// resolves DevEUIs or shortcodes to the labels printed for them
+// the shortcode is the last 5 digits of the DevEUI
+func deviceLabels(cc cache.Service, devices []string) ([]label.Label, error) {
+	if len(devices) == 0 {
+		return nil, fmt.Errorf("no devices to label")
+	}
+	if len(devices) > maxLabels {
+		return nil, fmt.Errorf("too many devices to label - at most %d", maxLabels)
+	}
+
+	labels := []label.Label{}
+	for _, d := range devices {
+		deveui, found, err := lookupDevice(cc, d)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return nil, fmt.Errorf("shortcode - %v is Not Found", d)
+		}
+		labels = append(labels, label.Label{ShortCode: deveui[len(deveui)-5:], DevEUI: deveui})
+	}
+	return labels, nil
+}
+
+// the label sheets as a single pdf document
+// or one svg document per sheet
+func renderLabels(t label.Template, labels []label.Label, format string) ([][]byte, string, error) {
+	switch format {
+	case "pdf":
+		doc, err := t.PDF(labels)
+		return [][]byte{doc}, "application/pdf", err
+	case "svg":
+		pages, err := t.SVG(labels)
+		return pages, "image/svg+xml", err
+	}
+	return nil, "", fmt.Errorf("unsupported format %q - pdf or svg", format)
+}
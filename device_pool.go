@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/David-solly/mxbcode/pkg/cache"
+	"github.com/David-solly/mxbcode/pkg/claim"
+	"github.com/David-solly/mxbcode/pkg/models"
+	"github.com/David-solly/mxbcode/pkg/pool"
+)
+
+var (
+	// Pool of registered DevEUIs for instant allocation
+	// nil unless the server is started with `-pool-size`
+	devicePool *pool.Pool
+
+	// stops the replenisher and any batch it is generating
+	poolStop = make(chan bool)
+)
+
+// starts the background replenisher of the device pool
+func startPool(size, low int64) error {
+	p, err := pool.New(c.Client, size, low, fillPool)
+	if err != nil {
+		return err
+	}
+	devicePool = p
+	go p.Run(poolStop)
+	return nil
+}
+
+// generate and register a batch for the pool
+// runs through the same path as every other batch
+func fillPool(n int) ([]string, error) {
+	_, data, err := generateBatchIDs(int64(n), c, poolStop)
+	registered := models.RegisteredDevEUIList{}
+	json.Unmarshal([]byte(data), &registered)
+	return registered.DevEUIs, err
+}
+
+// the next registered DevEUI for a serial number claim
+// taken from the pool when it is enabled and holds one
+// otherwise a single device is generated and registered on demand
+func claimSource(cc cache.Cache) claim.SourceFunc {
+	return func() (string, error) {
+		if devicePool != nil {
+			if devices, err := devicePool.Allocate(1); err == nil && len(devices) > 0 {
+				return devices[0], nil
+			}
+		}
+
+		_, data, err := generateBatchIDs(1, cc, make(chan bool))
+		if err != nil {
+			return "", err
+		}
+		registered := models.RegisteredDevEUIList{}
+		json.Unmarshal([]byte(data), &registered)
+		if len(registered.DevEUIs) == 0 {
+			return "", errors.New("no device could be registered for the claim")
+		}
+		return registered.DevEUIs[0], nil
+	}
+}
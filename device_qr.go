@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/David-solly/mxbcode/pkg/cache"
+	"github.com/David-solly/mxbcode/pkg/claim"
+	"github.com/David-solly/mxbcode/pkg/qr"
+	"github.com/David-solly/mxbcode/pkg/tr005"
+)
+
+// JoinEUI of devices without root keys or a configured JoinEUI
+const defaultJoinEUI = "0000000000000000"
+
+// a full 16 digit DevEUI rather than a shortcode
+var deviceIDPattern = regexp.MustCompile(`^[a-fA-F0-9]{16}$`)
+
+// resolves a DevEUI or 5 digit shortcode to the stored DevEUI
+// the bool reports whether the device was found
+func lookupDevice(cc cache.Service, device string) (string, bool, error) {
+	if deviceIDPattern.MatchString(device) {
+		return strings.ToUpper(device), true, nil
+	}
+	if valid, _ := regexp.MatchString(`^[a-fA-F0-9]{1,5}$`, device); !valid {
+		return "", false, fmt.Errorf("invalid device - %v", device)
+	}
+	deveui, found, _ := cc.ReadCache(device)
+	return deveui, found, nil
+}
+
+// the TR005 onboarding payload of a device
+// the JoinEUI comes from its root keys when they are held
+// and the serial from its claim when it has been claimed
+func devicePayload(deveui string) (tr005.Payload, error) {
+	joinEUI := keyOptions.JoinEUI
+	if keyVault != nil {
+		k, err := keyVault.Load(deveui)
+		if err != nil {
+			return tr005.Payload{}, err
+		}
+		if k != nil {
+			joinEUI = k.JoinEUI
+		}
+	}
+	if joinEUI == "" {
+		joinEUI = defaultJoinEUI
+	}
+
+	serial := ""
+	if cl, _ := claim.ForDevEUI(RequestCache.Client, deveui); cl != nil {
+		serial = cl.Serial
+	}
+	return tr005.New(joinEUI, deveui, *prof, serial)
+}
+
+// the QR code of a device rendered as png or svg
+func deviceQR(deveui, format string, scale int) ([]byte, string, error) {
+	p, err := devicePayload(deveui)
+	if err != nil {
+		return nil, "", err
+	}
+	code, err := qr.Encode(p.String(), qr.Medium)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if format == "svg" {
+		return code.SVG(scale), "image/svg+xml", nil
+	}
+	data, err := code.PNG(scale)
+	return data, "image/png", err
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/David-solly/mxbcode/pkg/cache"
+	"github.com/David-solly/mxbcode/pkg/zpl"
+)
+
+// how long a printer has to accept a job
+const printerTimeout = 5 * time.Second
+
+// ZPL layout used by the api and cli
+// replaced by `-zpl-layout`
+var zplLayout = zpl.Default()
+
+// the printable fields of a device
+func deviceFields(deveui string) (zpl.Fields, error) {
+	p, err := devicePayload(deveui)
+	if err != nil {
+		return zpl.Fields{}, err
+	}
+	return zpl.Fields{DevEUI: deveui, ShortCode: deveui[len(deveui)-5:], Serial: p.SerialNumber, QR: p.String()}, nil
+}
+
+// one ZPL job labelling every device - DevEUIs or shortcodes
+func deviceZPL(cc cache.Service, layout *zpl.Layout, devices []string) ([]byte, error) {
+	labels, err := deviceLabels(cc, devices)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := []zpl.Fields{}
+	for _, l := range labels {
+		f, err := deviceFields(l.DevEUI)
+		if err != nil {
+			return nil, fmt.Errorf("device %s - %v", l.DevEUI, err)
+		}
+		fields = append(fields, f)
+	}
+	return layout.Render(fields)
+}
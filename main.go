@@ -6,29 +6,34 @@ import (
 	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"os/signal"
 	"regexp"
 	"strconv"
+	"time"
 
 	"syscall"
 
+	"github.com/David-solly/mxbcode/pkg/breaker"
 	"github.com/David-solly/mxbcode/pkg/cache"
+	"github.com/David-solly/mxbcode/pkg/events"
 	gen "github.com/David-solly/mxbcode/pkg/generator"
+	"github.com/David-solly/mxbcode/pkg/keys"
 	"github.com/David-solly/mxbcode/pkg/models"
+	"github.com/David-solly/mxbcode/pkg/registrar"
+	"github.com/David-solly/mxbcode/pkg/registry"
+	"github.com/David-solly/mxbcode/pkg/signing"
+	"github.com/David-solly/mxbcode/pkg/tenant"
+	"github.com/David-solly/mxbcode/pkg/zpl"
 )
 
 var (
 	// Application cache /store
 	c cache.Cache
 
-	// default http client
-	// used for making requests to server
-	// global timeout available
-	cl = &http.Client{}
-
 	// Channels to interupt the program and signal
 	// when generation is complete
 	//
@@ -49,10 +54,54 @@ var (
 var (
 	last  = flag.String("l", "", "Explicitly set the last shortcode of previous batch.\nThe next batch will begin from here")
 	reg   = flag.String("reg-url", "", "The registration endpoint url- \ndefault used if none is supplied")
+	regA  = flag.String("reg-ca", "", "PEM bundle of the CAs the registration endpoint is verified against - the system roots if blank")
+	regC  = flag.String("reg-cert", "", "PEM client certificate presented to the registration endpoint")
+	regK  = flag.String("reg-key", "", "PEM private key of -reg-cert")
+	regP  = flag.String("reg-proxy", "", "Proxy url registrations are sent through - HTTPS_PROXY if blank")
+	regD  = flag.Duration("reg-connect-timeout", registrar.DefaultConnectTimeout, "Time allowed to connect to the registration endpoint")
+	regT  = flag.Duration("reg-timeout", registrar.DefaultTimeout, "Time allowed for a registration request as a whole")
+	regN  = flag.Int("reg-concurrency", registrar.DefaultConcurrency, "Most registration requests in flight - the limit adapts below it and the connection pool is sized to match")
+	regL  = flag.Duration("reg-latency", 0, "Registration response time counted as overloading the endpoint - 3 times the usual response time if 0")
+	regB  = flag.Float64("reg-failure-ratio", breaker.DefaultFailureRatio, "Share of recent registrations failing with errors or 5xx that opens the breaker and pauses generation")
+	regO  = flag.Duration("reg-cooldown", breaker.DefaultCooldown, "How long an open breaker pauses registration before a probe is sent")
+	regS  = flag.String("reg-auth", "", "How registration requests are signed - hmac, bearer or api-key - unsigned if blank")
+	regW  = flag.String("reg-secret-file", "", "File holding the HMAC secret, bearer token or API key of -reg-auth")
+	regI  = flag.String("reg-key-id", "", "Key id sent with HMAC signatures naming the secret")
+	regH  = flag.String("reg-auth-header", "", "Header the API key is sent in - X-API-Key if blank")
+	regF  = flag.String("registrars", "", "Json file of registrars serving tenants each with their own client settings")
 	count = flag.String("count", "", "Number of DevEUIs to generate")
 	addr  = flag.String("addr", "", "Bind address")
 	port  = flag.String("port", "", "Bind port")
 	redis = flag.String("redis-addr", "", "The address of the redis instance to use as a datacahe store")
+	block = flag.String("blocklist", "", "File of shortcodes and /patterns/ the generator must skip")
+	owner = flag.String("block", "", "Generate from the shortcode block allocated to this owner")
+	pSize = flag.Int64("pool-size", 0, "Keep this many registered DevEUIs ready for /allocate - server mode only")
+	pLow  = flag.Int64("pool-low", 0, "Top the pool up when it falls below this many - defaults to half the pool size")
+	otaa  = flag.Bool("otaa", false, "Generate OTAA root keys for every registered device")
+	lw11  = flag.Bool("lorawan-1.1", false, "Also generate an NwkKey for LoRaWAN 1.1 devices")
+	jEUI  = flag.String("join-eui", "", "JoinEUI given to every device - random per device if blank")
+	mKey  = flag.String("master-key-file", "", "File holding the hex master key sealing root keys at rest - defaults to $MMAX_MASTER_KEY")
+	xTok  = flag.String("export-token-file", "", "File holding the bearer token allowed to export root keys")
+	prof  = flag.String("profile-id", "00000000", "TR005 ProfileID of the devices - VendorID and VendorProfileID as 8 hex digits")
+	zplL  = flag.String("zpl-layout", "", "File holding a text/template ZPL label layout - the built in 2 x 1 inch layout if blank")
+	kekD  = flag.String("kek-dir", "", "Directory of <label>.kek files wrapping exported root keys for join servers")
+	jwtK  = flag.String("jwt-keys", "", "JWKS or PEM public key file verifying RS256 and ES256 bearer tokens")
+	jwtS  = flag.String("jwt-secret-file", "", "File holding the shared secret verifying HS256 bearer tokens")
+	jwtI  = flag.String("jwt-issuer", "", "Only accept bearer tokens whose iss claim is this issuer")
+	jwtA  = flag.String("jwt-audience", "", "Only accept bearer tokens whose aud claim names this audience")
+	jwtT  = flag.String("jwt-tenant-claim", "tenant", "Claim naming the tenant of a bearer token")
+	jwtC  = flag.String("jwt-scope-claim", "scope", "Claim holding the scopes of a bearer token")
+	rateL = flag.Float64("rate", 0, "Requests a second allowed per API key, tenant or address - unlimited if 0")
+	burst = flag.Int64("burst", 0, "Requests a client may make at once before -rate applies - defaults to one second worth")
+	dayQ  = flag.Int64("daily-quota", 0, "DevEUIs each API key, tenant or address may generate a day - unlimited if 0")
+	drain = flag.Duration("shutdown-timeout", 30*time.Second, "How long running batches are given to finish on shutdown before they are checkpointed")
+	tlsC  = flag.String("tls-cert", "", "PEM certificate served over HTTPS - reloaded when the file is rotated")
+	tlsK  = flag.String("tls-key", "", "PEM private key of -tls-cert")
+	tlsA  = flag.String("tls-client-ca", "", "PEM bundle of the CAs client certificates are verified against")
+	tlsT  = flag.String("tls-client-tenants", "", "File of <tenant> <subject> lines mapping client certificates to tenants")
+	tlsR  = flag.Bool("tls-require-client-cert", false, "Refuse connections without a client certificate - needs -tls-client-ca")
+	anon  = flag.Bool("allow-anonymous", false, "Let requests without an API key or bearer token generate and look up in the shared namespace - for local development only")
+	proxy = flag.String("trusted-proxies", "", "Comma separated addresses and CIDRs of proxies whose X-Forwarded-For and X-Real-IP name the client - none if blank")
 )
 
 // Init a cache
@@ -119,33 +168,120 @@ func mmax() (dta string) {
 		url = *reg
 	}
 
+	regCfg := registrar.Config{URL: url, CA: *regA, Cert: *regC, Key: *regK, Proxy: *regP,
+		ConnectTimeout: registrar.Duration(*regD), Timeout: registrar.Duration(*regT), Concurrency: *regN,
+		Latency: registrar.Duration(*regL), FailureRatio: *regB, Cooldown: registrar.Duration(*regO)}
+	if *regS != "" {
+		regCfg.Auth = &signing.Config{Type: *regS, SecretFile: *regW, KeyID: *regI, Header: *regH}
+	}
+	if err := startRegistrars(regCfg, *regF); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	if *block != "" {
+		b, err := gen.LoadBlocklist(*block)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		gen.DefaultBlocklist = b
+	}
+
+	if err := startKeys(*otaa, keys.Options{JoinEUI: *jEUI, LoRaWAN11: *lw11}, *mKey, *xTok); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	if *zplL != "" {
+		l, err := zpl.Load(*zplL)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		zplLayout = l
+	}
+
 	go func() {
 		c := make(chan os.Signal, 1)
 		signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
 		interrupt <- fmt.Errorf("%s", <-c)
 	}()
 
+	// positional arguments name a command rather than a batch
+	if flag.NArg() > 0 {
+		return runCommand(flag.Args())
+	}
+
 	// run the http endpoint if the supplied flags match
 	if *port != "" && len(*port) >= 1 {
+		if *pSize > 0 {
+			if err := startPool(*pSize, *pLow); err != nil {
+				fmt.Println(err)
+				return
+			}
+		}
+
+		requireAPIKey = !*anon
+		if err := startProxies(*proxy); err != nil {
+			fmt.Println(err)
+			return
+		}
+		if err := startLimits(*rateL, *burst, *dayQ); err != nil {
+			fmt.Println(err)
+			return
+		}
+		if err := startJWT(*jwtK, *jwtS, *jwtI, *jwtA, *jwtT, *jwtC); err != nil {
+			fmt.Println(err)
+			return
+		}
 
-		go http.ListenAndServe(*addr+":"+*port, GetRouter())
-		<-interrupt
-		log.Print("shutting down - server")
+		// the cache layer reports stored devices to `/events`
+		c.Client = observe(c.Client, "")
+		RequestCache = c
+
+		tlsConfig, err := startTLS(*tlsC, *tlsK, *tlsA, *tlsT, *tlsR)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		shutdownTimeout = *drain
+		if err := serve(*addr+":"+*port, GetRouter(), tlsConfig); err != nil {
+			fmt.Println(err)
+		}
 		return
 	}
 
+	// draw from the owners block rather than the global counter
+	// tenant blocks are only drawn from through their tenant
+	if *owner != "" {
+		if tenant.OwnsBlock(*owner) {
+			fmt.Printf("block of %q is generated from through its tenant\n", *owner)
+			return
+		}
+		return runScopedGenerator(idCount, c, gen.ForBlock(*owner))
+	}
+
 	return runGenerator(idCount)
 }
 
 func runGenerator(idCount int64) string {
+	return runScopedGenerator(idCount, c, gen.GenerateDUIDBatch)
+}
+
+// run the generator against `cc` drawing shortcodes from `source`
+// either the global counter, an owners block or a tenants namespace
+func runScopedGenerator(idCount int64, cc cache.Cache, source gen.BatchFunc) string {
 
 	fmt.Println("MMAX - BATCH DevEUI Generator")
 	var data string = ""
+	shouldExit = false
 	// Maximum default value to generate if no commandline args
 	// are provided
 	//
 	go func() {
-		_, s, err := generateBatchIDs(idCount, c, shutdown)
+		_, s, err := generateScopedBatchIDs(idCount, cc, shutdown, source)
 		if err != nil {
 			fmt.Println(err)
 		}
@@ -160,7 +296,12 @@ func runGenerator(idCount int64) string {
 		{
 			fmt.Println("\nGraceful shutdown...")
 			shouldExit = true
-			shutdown <- true
+			// the batch only listens while a registration is in flight
+			// otherwise it sees `shouldExit` before drawing again
+			select {
+			case shutdown <- true:
+			default:
+			}
 
 			<-complete
 
@@ -175,35 +316,62 @@ func runGenerator(idCount int64) string {
 }
 
 func generateBatchIDs(count int64, c cache.Cache, ch chan bool) (generated int, data string, err error) {
+	return generateScopedBatchIDs(count, c, ch, gen.GenerateDUIDBatch)
+}
+
+func generateScopedBatchIDs(count int64, c cache.Cache, ch chan bool, source gen.BatchFunc) (generated int, data string, err error) {
+	return generateObservedBatchIDs(count, c, ch, source, nil)
+}
+
+// generateScopedBatchIDs reporting to `p` as devices are registered
+func generateObservedBatchIDs(count int64, c cache.Cache, ch chan bool, source gen.BatchFunc, p *batchProgress) (generated int, data string, err error) {
+	batches.start()
+	defer batches.done()
+
 	registered := models.RegisteredDevEUIList{
 		DevEUIs: []string{},
+		Batch:   registry.NewBatchID(),
 	}
 
 	defer func() {
 		generated = len(registered.DevEUIs)
+		if generated == 0 {
+			registered.Batch = ""
+		}
 		uids, _ := json.Marshal(registered)
 		data = string(uids)
 		fmt.Println("Generated and registered ", len(registered.DevEUIs))
+		if registered.Skipped > 0 {
+			fmt.Println("Skipped blocklisted shortcodes ", registered.Skipped)
+		}
 		if generated > 0 {
-			if c, k := c.Client.(*cache.MemoryCache); k {
-				c.Persist()
-			}
-
+			cache.Persist(c.Client)
 		}
 	}()
 
-	for int64(len(registered.DevEUIs)) < count && !shouldExit {
+	for int64(len(registered.DevEUIs)) < count && !shouldExit && !p.stopped() && !batches.stopping() {
 
-		ids, e := gen.GenerateDUIDBatch(int(count)-len(registered.DevEUIs), c.Client)
+		// no shortcodes are drawn while the registrar is down
+		// they would only be burnt by requests bound to fail
+		if holdWhileOpen(registrarFor(c).Breaker.RetryIn, ch, p) {
+			return
+		}
+		if p.stopped() || batches.stopping() {
+			return
+		}
+
+		ids, skipped, e := source(int(count)-len(registered.DevEUIs), c.Client)
 		if e != nil {
 			return generated, data, e
 		}
+		registered.Skipped += skipped
 
 		if count == 0 {
 			return
 		}
+		publish(c.Client, models.Event{Type: events.Generated, Batch: registered.Batch, Count: len(*ids)})
 
-		registered, _, err = registerBatch(*ids, c, ch, &registered)
+		registered, _, err = registerObservedBatch(*ids, c, ch, &registered, p)
 		if err != nil {
 			return
 		}
@@ -215,13 +383,8 @@ func generateBatchIDs(count int64, c cache.Cache, ch chan bool) (generated int,
 
 // method that sends the request to the endpoint
 // deals with registering the 5 character code with the LoRaWAN provider
-// pops the in-flight queue when done `<-ch`
-func register(shortcode, url string, ch chan int) (string, int, error) {
-	// pop item from channel buffer on completion
-	// used to let the time of flight counter to decrease
-	defer func() {
-		<-ch
-	}()
+// through the client of a registrar
+func registerWith(client *http.Client, shortcode, url string) (string, int, error) {
 
 	body, e := json.Marshal(map[string]string{"deveui": shortcode})
 	if e != nil {
@@ -241,8 +404,8 @@ func register(shortcode, url string, ch chan int) (string, int, error) {
 
 	// Send and read the response body
 	//
-	resp, err := cl.Do(req)
-	if e != nil {
+	resp, err := client.Do(req)
+	if err != nil {
 		return "Bad Request 400", 400, err
 	}
 
@@ -250,5 +413,9 @@ func register(shortcode, url string, ch chan int) (string, int, error) {
 		return "Internal Server Error 500", 500, errors.New("Blank response - check url is correct")
 	}
 
+	// read to the end so the connection goes back to the pool
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
 	return resp.Status, resp.StatusCode, nil
 }
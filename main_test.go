@@ -5,16 +5,32 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
 	"net/http/httptest"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	mockendpoint "github.com/David-solly/mxbcode/mock_lorawan_endpoint"
+	"github.com/David-solly/mxbcode/pkg/apikey"
+	"github.com/David-solly/mxbcode/pkg/breaker"
 	"github.com/David-solly/mxbcode/pkg/cache"
+	"github.com/David-solly/mxbcode/pkg/claim"
+	gen "github.com/David-solly/mxbcode/pkg/generator"
+	"github.com/David-solly/mxbcode/pkg/importer"
+	"github.com/David-solly/mxbcode/pkg/keys"
 	"github.com/David-solly/mxbcode/pkg/models"
+	"github.com/David-solly/mxbcode/pkg/registrar"
+	"github.com/David-solly/mxbcode/pkg/registry"
+	"github.com/David-solly/mxbcode/pkg/signing"
+	"github.com/David-solly/mxbcode/pkg/tenant"
 
 	"github.com/docker/docker/pkg/testutil/assert"
 )
@@ -28,7 +44,7 @@ var testRedis = false
 // resets the listening server database
 // for testing responses
 func reset() {
-	resp, err := cl.Get(urlDebug)
+	resp, err := registrarFor(c).Client.Get(urlDebug)
 	if err != nil {
 		fmt.Print(err)
 	}
@@ -41,7 +57,6 @@ func TestMain(t *testing.M) {
 
 	//Start mock registration endpoint server
 	ts := httptest.NewServer(mockendpoint.GetLorawanRouter(true))
-	cl = ts.Client() //global http client
 	mockendpoint.DB.Initialise("", false)
 
 	c.Initialise("", false) // Initialise in-memory cache
@@ -55,6 +70,8 @@ func TestMain(t *testing.M) {
 	//stretch api
 	RequestCache.Initialise("", false)
 	rt = GetRouter()
+	// as with -allow-anonymous - most tests call the endpoints without a key
+	requireAPIKey = false
 
 	v := t.Run()
 	ts.Close()
@@ -129,7 +146,7 @@ func TestGenerateCli(t *testing.T) {
 
 	hk := url
 	ch := make(chan bool)
-	chi := make(chan int, 1)
+	client := registrarFor(c).Client
 
 	suite := []struct {
 		testName  string
@@ -149,12 +166,9 @@ func TestGenerateCli(t *testing.T) {
 			// Pre register devices in generate range
 			// should automatically generate new values to compensate
 			// should return requested quantity
-			chi <- i
-			register("00005", hk, chi)
-			chi <- i
-			register("00022", hk, chi)
-			chi <- i
-			register("00007", hk, chi)
+			registerWith(client, "00005", hk)
+			registerWith(client, "00022", hk)
+			registerWith(client, "00007", hk)
 		}
 		t.Run(fmt.Sprintf("\n#%d: %q", i, test.testName), func(t *testing.T) {
 			generated, uids, err := generateBatchIDs(int64(test.want), c, ch)
@@ -171,7 +185,11 @@ func TestGenerateCli(t *testing.T) {
 				if test.testName == "GENERATE - -1" {
 					assert.Equal(t, len(uids), 2)
 				} else {
-					assert.Equal(t, len(uids), (18)*test.want+13+test.want)
+					// every batch carries its ID
+					registered := models.RegisteredDevEUIList{}
+					json.Unmarshal([]byte(uids), &registered)
+					assert.Equal(t, len(registered.Batch), 22)
+					assert.Equal(t, len(uids)-len(`,"batch":""`)-len(registered.Batch), (18)*test.want+13+test.want)
 				}
 
 			}
@@ -196,12 +214,17 @@ func TestRegisterFromCli(t *testing.T) {
 	for i, test := range suite {
 		reset()
 		resetCache()
+		done := make(chan bool)
 		if i == len(suite)-1 {
 			go func() {
 				// Simulate interrupt signal
-				//
+				// given up once the test is over so it doesn't
+				// interrupt whichever test reads it next
 				time.Sleep(time.Duration(time.Millisecond * 15))
-				interrupt <- true
+				select {
+				case interrupt <- true:
+				case <-done:
+				}
 
 			}()
 		}
@@ -225,12 +248,55 @@ func TestRegisterFromCli(t *testing.T) {
 			}
 
 		})
+		close(done)
+	}
+}
+
+func TestInterruptedBatchRewinds(t *testing.T) {
+	reset()
+	resetCache()
+	defer func() {
+		c.Client.DeleteValue(gen.BlockKeyPrefix + "line-sig")
+		c.Client.DeleteValue(gen.BlockCounterPrefix + "line-sig")
+		resetCache()
+	}()
+	_, err := gen.AllocateBlock(c.Client, "line-sig", "", "", 8)
+	assert.NilError(t, err)
+
+	// interrupted before anything is sent
+	sigint := make(chan bool)
+	close(sigint)
+
+	suite := []struct {
+		testName string
+		source   gen.BatchFunc
+	}{
+		{"INTERRUPT - global batch", gen.GenerateDUIDBatch},
+		{"INTERRUPT - block batch", gen.ForBlock("line-sig")},
+	}
+	for i, test := range suite {
+		t.Run(fmt.Sprintf("#%d: %q", i, test.testName), func(t *testing.T) {
+			last, _, _ := c.Client.ReadCache(cache.LastUIDKey)
+			before, _ := gen.BlockFor(c.Client, "line-sig")
+
+			ids, _, err := test.source(4, c.Client)
+			assert.NilError(t, err)
+			_, count, err := registerBatch(*ids, c, sigint, &models.RegisteredDevEUIList{Batch: "sig"})
+			assert.NilError(t, err)
+			assert.Equal(t, count, 0)
+
+			// only the counter the batch drew from moved and it is back
+			after, _, _ := c.Client.ReadCache(cache.LastUIDKey)
+			assert.Equal(t, strings.ToUpper(after), strings.ToUpper(last))
+			block, _ := gen.BlockFor(c.Client, "line-sig")
+			assert.Equal(t, block.Last, before.Last)
+		})
 	}
 }
 
 func TestRegisterWithProvider(t *testing.T) {
 
-	tof := make(chan int, tofMaxRequests)
+	client := registrarFor(c).Client
 	suite := []struct {
 		testName  string
 		shortcode string
@@ -243,10 +309,9 @@ func TestRegisterWithProvider(t *testing.T) {
 	}
 
 	for i, test := range suite {
-		tof <- i
 		reset()
 		t.Run(fmt.Sprintf("#%d: %q", i, test.testName), func(t *testing.T) {
-			sc, result, err := register(test.shortcode, url, tof)
+			sc, result, err := registerWith(client, test.shortcode, url)
 			if test.err != "" {
 				assert.Error(t, err, test.err)
 			} else {
@@ -302,6 +367,7 @@ func TestGenerateFromCMD(t *testing.T) {
 func TestToFRequests(t *testing.T) {
 	tofMaxRequests := 10
 	tof := make(chan int, tofMaxRequests)
+	client := registrarFor(c).Client
 	suite := []struct {
 		testName  string
 		shortcode string
@@ -322,7 +388,9 @@ func TestToFRequests(t *testing.T) {
 				go func() {
 					defer wg.Done()
 					wg.Add(1)
-					sc, result, err := register(test.shortcode, urlDebug, tof)
+					// pop the in-flight queue when done
+					defer func() { <-tof }()
+					sc, result, err := registerWith(client, test.shortcode, urlDebug)
 					if test.err != "" {
 						assert.Error(t, err, test.err)
 					}
@@ -340,6 +408,11 @@ func TestToFRequests(t *testing.T) {
 }
 
 func TestMMaxFunction(t *testing.T) {
+	// mmax points the request store at the generator store
+	// and configures the registrars for the rest of the process
+	tmpCache, tmpURL := RequestCache, url
+	defer func() { RequestCache, url, registrars = tmpCache, tmpURL, nil }()
+
 	t.Run("Test command line flags", func(t *testing.T) {
 		suite := []struct {
 			testName string
@@ -368,3 +441,659 @@ func TestMMaxFunction(t *testing.T) {
 	})
 
 }
+
+func TestCommands(t *testing.T) {
+	reset()
+	resetCache()
+	defer releaseCommand([]string{"E0000"})
+
+	suite := []struct {
+		testName string
+		args     []string
+		contains string
+	}{
+		{"COMMAND - reserve", []string{"reserve", "-label=lab", "E0000-E00FF"}, "\"to\":\"E00FF\""},
+		{"COMMAND - reserve overlap", []string{"reserve", "E0010"}, ""},
+		{"COMMAND - reservations", []string{"reservations"}, "\"label\":\"lab\""},
+		{"COMMAND - claim", []string{"claim", "E0001"}, "E0001\"}"},
+		{"COMMAND - claim again", []string{"claim", "E0001"}, ""},
+		{"COMMAND - unknown", []string{"unknown"}, ""},
+	}
+
+	for i, test := range suite {
+		t.Run(fmt.Sprintf("#%d: %q", i, test.testName), func(t *testing.T) {
+			out := runCommand(test.args)
+			if test.contains == "" {
+				assert.Equal(t, out, "")
+			} else {
+				assert.Contains(t, out, test.contains)
+			}
+		})
+	}
+}
+
+func TestKeyCommands(t *testing.T) {
+	master, _ := keys.ParseMasterKey("000102030405060708090A0B0C0D0E0F")
+	keyVault, _ = keys.NewVault(c.Client, master)
+	defer func() { keyVault = nil }()
+
+	k, err := keyVault.Provision("00000000000DEAD1", keys.Options{LoRaWAN11: true})
+	assert.NilError(t, err)
+
+	dir, _ := ioutil.TempDir("", "kek")
+	defer os.RemoveAll(dir)
+	kek := filepath.Join(dir, "js1.kek")
+	ioutil.WriteFile(kek, []byte("00112233445566778899AABBCCDDEEFF"), 0600)
+
+	out := runCommand([]string{"export-keys", "-kek=" + kek, "00000000000DEAD1"})
+	assert.Contains(t, out, `"kek_label":"js1"`)
+	assert.Equal(t, strings.Contains(out, k.AppKey), false)
+
+	// round trip through the join server tooling
+	mf := filepath.Join(dir, "manifest.json")
+	ioutil.WriteFile(mf, []byte(out), 0600)
+	out = runCommand([]string{"unwrap-keys", "-kek=" + kek, mf})
+	data, _ := json.Marshal([]models.RootKeys{k})
+	assert.Equal(t, out, string(data))
+
+	assert.Equal(t, runCommand([]string{"unwrap-keys", "-kek=" + filepath.Join(dir, "missing.kek"), mf}), "")
+	assert.Equal(t, runCommand([]string{"export-keys"}), "")
+}
+
+func TestQRCommand(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "qr")
+	defer os.RemoveAll(dir)
+
+	batch := filepath.Join(dir, "batch.json")
+	ioutil.WriteFile(batch, []byte(`{"deveuis":["0000000000000001","00000000000FFFF2"]}`), 0644)
+	c.Client.StoreDUID(models.DevEUI{ShortCode: "ABCDE", DevEUI: "00000000000ABCDE"})
+
+	suite := []struct {
+		testName string
+		args     []string
+		files    []string
+	}{
+		{"QR - batch", []string{"qr", "-dir=" + dir, "-batch=" + batch}, []string{"0000000000000001.png", "00000000000FFFF2.png"}},
+		{"QR - shortcode as svg", []string{"qr", "-dir=" + dir, "-format=svg", "abcde"}, []string{"00000000000ABCDE.svg"}},
+		{"QR - unknown shortcode", []string{"qr", "-dir=" + dir, "FFFF0"}, nil},
+		{"QR - format", []string{"qr", "-format=gif", "abcde"}, nil},
+		{"QR - no devices", []string{"qr"}, nil},
+	}
+	for i, test := range suite {
+		t.Run(fmt.Sprintf("#%d: %q", i, test.testName), func(t *testing.T) {
+			out := runCommand(test.args)
+			if test.files == nil {
+				assert.Equal(t, out, "")
+				return
+			}
+			for _, f := range test.files {
+				assert.Contains(t, out, f)
+				_, err := os.Stat(filepath.Join(dir, f))
+				assert.NilError(t, err)
+			}
+		})
+	}
+}
+
+func TestLabelsCommand(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "labels")
+	defer os.RemoveAll(dir)
+
+	batch := filepath.Join(dir, "batch.json")
+	ioutil.WriteFile(batch, []byte(`{"deveuis":["0000000000000001","00000000000FFFF2"]}`), 0644)
+	sheet := filepath.Join(dir, "sheet.json")
+	ioutil.WriteFile(sheet, []byte(`{"name":"roll","page_width":100,"page_height":50,"columns":1,"rows":1}`), 0644)
+	c.Client.StoreDUID(models.DevEUI{ShortCode: "ABCDE", DevEUI: "00000000000ABCDE"})
+
+	suite := []struct {
+		testName string
+		args     []string
+		files    []string
+	}{
+		{"LABELS - batch", []string{"labels", "-dir=" + dir, "-batch=" + batch}, []string{"labels.pdf"}},
+		{"LABELS - svg per sheet", []string{"labels", "-dir=" + dir, "-format=svg", "-sheet=" + sheet, "abcde", "0000000000000001"}, []string{"labels-1.svg", "labels-2.svg"}},
+		{"LABELS - letter", []string{"labels", "-dir=" + dir, "-template=letter", "abcde"}, []string{"labels.pdf"}},
+		{"LABELS - unknown shortcode", []string{"labels", "-dir=" + dir, "FFFF0"}, nil},
+		{"LABELS - template", []string{"labels", "-template=a3", "abcde"}, nil},
+		{"LABELS - format", []string{"labels", "-format=png", "abcde"}, nil},
+		{"LABELS - no devices", []string{"labels"}, nil},
+	}
+	for i, test := range suite {
+		t.Run(fmt.Sprintf("#%d: %q", i, test.testName), func(t *testing.T) {
+			out := runCommand(test.args)
+			if test.files == nil {
+				assert.Equal(t, out, "")
+				return
+			}
+			for _, f := range test.files {
+				assert.Contains(t, out, f)
+				_, err := os.Stat(filepath.Join(dir, f))
+				assert.NilError(t, err)
+			}
+		})
+	}
+}
+
+func TestZPLCommand(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "zpl")
+	defer os.RemoveAll(dir)
+
+	batch := filepath.Join(dir, "batch.json")
+	ioutil.WriteFile(batch, []byte(`{"deveuis":["0000000000000001","00000000000FFFF2"]}`), 0644)
+	layout := filepath.Join(dir, "layout.zpl")
+	ioutil.WriteFile(layout, []byte("^XA^FD{{.ShortCode}}^FS^XZ\n"), 0644)
+	c.Client.StoreDUID(models.DevEUI{ShortCode: "ABCDE", DevEUI: "00000000000ABCDE"})
+
+	// a local printer on a raw TCP port
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	defer ln.Close()
+	printed := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		data, _ := ioutil.ReadAll(conn)
+		conn.Close()
+		printed <- string(data)
+	}()
+
+	suite := []struct {
+		testName string
+		args     []string
+		contains string
+	}{
+		{"ZPL - batch", []string{"zpl", "-batch=" + batch}, "^FDMA,LW:D0:0000000000000000:0000000000000001:00000000^FS"},
+		{"ZPL - layout", []string{"zpl", "-layout=" + layout, "abcde"}, "^XA^FDABCDE^FS^XZ"},
+		{"ZPL - printer", []string{"zpl", "-printer=" + ln.Addr().String(), "-out=" + filepath.Join(dir, "job.zpl"), "abcde"}, `"labels":1`},
+		{"ZPL - unknown shortcode", []string{"zpl", "FFFF0"}, ""},
+		{"ZPL - missing layout", []string{"zpl", "-layout=" + filepath.Join(dir, "missing.zpl"), "abcde"}, ""},
+		{"ZPL - no devices", []string{"zpl"}, ""},
+	}
+	for i, test := range suite {
+		t.Run(fmt.Sprintf("#%d: %q", i, test.testName), func(t *testing.T) {
+			out := runCommand(test.args)
+			if test.contains == "" {
+				assert.Equal(t, out, "")
+			} else {
+				assert.Contains(t, out, test.contains)
+			}
+		})
+	}
+
+	select {
+	case job := <-printed:
+		assert.Contains(t, job, "^FDABCDE^FS")
+		saved, _ := ioutil.ReadFile(filepath.Join(dir, "job.zpl"))
+		assert.Equal(t, string(saved), job)
+	case <-time.After(time.Second):
+		t.Fatal("printer received nothing")
+	}
+}
+
+func TestExportCommand(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "export")
+	defer os.RemoveAll(dir)
+
+	registry.Record(c.Client, models.Device{DevEUI: "00000000000E0001", ShortCode: "E0001", Batch: "export-1", Registered: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)})
+	registry.Record(c.Client, models.Device{DevEUI: "00000000000E0002", ShortCode: "E0002", Batch: "export-2", Registered: time.Date(2026, 10, 2, 0, 0, 0, 0, time.UTC)})
+	defer c.Client.DeleteValue(registry.KeyPrefix + "00000000000E0001")
+	defer c.Client.DeleteValue(registry.KeyPrefix + "00000000000E0002")
+
+	batch := filepath.Join(dir, "batch.json")
+	ioutil.WriteFile(batch, []byte(`{"deveuis":["00000000000E0002"]}`), 0644)
+
+	suite := []struct {
+		testName string
+		args     []string
+		contains string
+		excludes string
+	}{
+		{"EXPORT - batch id", []string{"export", "-batch-id=export-1"}, "00000000000E0001,E0001,export-1,2026-10-01T00:00:00Z,", "E0002"},
+		{"EXPORT - batch file", []string{"export", "-format=ndjson", "-columns=shortcode", "-batch=" + batch}, `{"shortcode":"E0002"}`, "E0001"},
+		{"EXPORT - window", []string{"export", "-format=chirpstack", "-since=2026-10-02", "-until=2026-10-03"}, "00000000000e0002,0000000000000000", "E0001"},
+		{"EXPORT - tts to file", []string{"export", "-format=tts", "-batch-id=export-2", "-out=" + filepath.Join(dir, "tts.json")}, `"devices":1`, ""},
+		{"EXPORT - keys without master key", []string{"export", "-keys"}, "", ""},
+		{"EXPORT - format", []string{"export", "-format=xml"}, "", ""},
+		{"EXPORT - time", []string{"export", "-since=yesterday"}, "", ""},
+	}
+	for i, test := range suite {
+		t.Run(fmt.Sprintf("#%d: %q", i, test.testName), func(t *testing.T) {
+			out := runCommand(test.args)
+			if test.contains == "" {
+				assert.Equal(t, out, "")
+				return
+			}
+			assert.Contains(t, out, test.contains)
+			if test.excludes != "" {
+				assert.Equal(t, strings.Contains(out, test.excludes), false)
+			}
+		})
+	}
+
+	data, _ := ioutil.ReadFile(filepath.Join(dir, "tts.json"))
+	assert.Contains(t, string(data), `"device_id":"eui-00000000000e0002"`)
+}
+
+func TestImportCommand(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "import")
+	defer os.RemoveAll(dir)
+
+	devices := []string{"00000000000F1001", "00000000000F1002", "00000000000F1005", "00000000000F1009"}
+	c.Client.StoreDUID(models.DevEUI{DevEUI: "00000000000F1009", ShortCode: "F1009"})
+	defer func() {
+		gen.Release(c.Client, "F1001")
+		gen.Release(c.Client, "F1005")
+		for _, d := range devices {
+			c.Client.DeleteValue(d[11:])
+			c.Client.DeleteValue(registry.KeyPrefix + d)
+			c.Client.DeleteValue(keys.KeyPrefix + d)
+		}
+		RequestCache.Client.DeleteValue(claim.KeyPrefix + "IMPORT-1")
+		RequestCache.Client.DeleteValue(claim.DevicePrefix + "00000000000F1001")
+	}()
+
+	file := filepath.Join(dir, "devices.csv")
+	ioutil.WriteFile(file, []byte(strings.Join([]string{
+		"dev_eui,join_eui,app_key,serial",
+		"00000000000f1001,,,IMPORT-1",
+		"00000000000F1002,70B3D57ED0000000,000102030405060708090A0B0C0D0E0F,",
+		"00000000000F1005,,,",
+		"00000000000F1009,,,",
+		"0000000000AF1009,,,",
+		"F1010,,,",
+	}, "\n")), 0644)
+
+	assert.Equal(t, runCommand([]string{"import", file}), "")
+
+	master, _ := keys.ParseMasterKey("000102030405060708090A0B0C0D0E0F")
+	keyVault, _ = keys.NewVault(c.Client, master)
+	defer func() { keyVault = nil }()
+
+	report := importer.Report{}
+	assert.NilError(t, json.Unmarshal([]byte(runCommand([]string{"import", file})), &report))
+	assert.Equal(t, report.Imported, 3)
+	assert.Equal(t, report.Keys, 1)
+	assert.Equal(t, report.Serials, 1)
+	assert.Equal(t, report.Duplicates, 1)
+	assert.Equal(t, report.Conflicts, 1)
+	assert.Equal(t, report.Invalid, 1)
+	assert.Equal(t, len(report.Reserved), 2)
+	assert.Equal(t, report.Reserved[0].From+"-"+report.Reserved[0].To, "F1001-F1002")
+
+	d, _ := registry.Get(c.Client, "00000000000F1005")
+	assert.Equal(t, d.Batch, report.Batch)
+	k, _ := keyVault.Load("00000000000F1002")
+	assert.Equal(t, k.AppKey, "000102030405060708090A0B0C0D0E0F")
+	cl, _ := claim.Get(RequestCache.Client, "IMPORT-1")
+	assert.Equal(t, cl.DevEUI, "00000000000F1001")
+
+	// a second run only finds duplicates
+	report = importer.Report{}
+	json.Unmarshal([]byte(runCommand([]string{"import", "-format=csv", file})), &report)
+	assert.Equal(t, report.Imported, 0)
+	assert.Equal(t, report.Duplicates, 4)
+	assert.Equal(t, len(report.Reserved), 0)
+
+	assert.Equal(t, runCommand([]string{"import"}), "")
+	assert.Equal(t, runCommand([]string{"import", "-format=xml", file}), "")
+}
+
+func TestAccessKeysCommand(t *testing.T) {
+	out := runCommand([]string{"keys", "create", "-scopes=lookup,generate", "station-1"})
+	created := struct {
+		ID     string   `json:"id"`
+		Scopes []string `json:"scopes"`
+		Key    string   `json:"key"`
+	}{}
+	assert.NilError(t, json.Unmarshal([]byte(out), &created))
+	assert.DeepEqual(t, created.Scopes, []string{"lookup", "generate"})
+	defer c.Client.DeleteValue(apikey.KeyPrefix + created.ID)
+
+	assert.Contains(t, runCommand([]string{"keys", "list"}), `"name":"station-1"`)
+	assert.Equal(t, strings.Contains(runCommand([]string{"keys", "list"}), created.Key), false)
+
+	out = runCommand([]string{"keys", "rotate", created.ID})
+	assert.Contains(t, out, `"rotated":"`)
+	assert.Equal(t, strings.Contains(out, created.Key), false)
+
+	assert.Contains(t, runCommand([]string{"keys", "revoke", created.ID}), `"revoked":"`)
+	assert.Equal(t, runCommand([]string{"keys", "revoke", created.ID}), "")
+	assert.Equal(t, runCommand([]string{"keys", "create", "-scopes=root", "station-2"}), "")
+	assert.Equal(t, runCommand([]string{"keys"}), "")
+}
+
+func TestServerShutdown(t *testing.T) {
+	// a registrar slow enough for shutdown to catch the batch part way
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+	}))
+	defer slow.Close()
+	tmp := url
+	url = slow.URL
+	defer func() { url = tmp }()
+	last, _, _ := c.Client.ReadCache(cache.LastUIDKey)
+	defer c.Client.StoreLastDUID(models.LastDevEUI{ShortCode: last})
+
+	// blocks are generated against the application cache as in server mode
+	defer func(rc cache.Cache) {
+		c.Client.DeleteValue(gen.BlockKeyPrefix + "line-d")
+		c.Client.DeleteValue(gen.BlockCounterPrefix + "line-d")
+		c.Client.DeleteValue(gen.BlockHeldPrefix + "line-d")
+		dropTenant(c.Client, "drain")
+		RequestCache = rc
+	}(RequestCache)
+	RequestCache = c
+	_, err := gen.AllocateBlock(c.Client, "line-d", "", "", 128)
+	assert.NilError(t, err)
+	_, tenantKey, err := tenant.Create(c.Client, "drain", 0)
+	assert.NilError(t, err)
+
+	suite := []struct {
+		testName     string
+		path         string
+		key          string
+		owner        string
+		timeout      time.Duration
+		finished     bool
+		checkpointed int64
+	}{
+		{"SHUTDOWN - batch finishes", "/generate/shutdown", "", "", 5 * time.Second, true, 0},
+		{"SHUTDOWN - batch checkpointed", "/generate/shutdown", "", "", 50 * time.Millisecond, false, 1},
+		{"SHUTDOWN - block batch checkpointed", "/blocks/line-d/generate/shutdown", "", "line-d", 50 * time.Millisecond, false, 1},
+		{"SHUTDOWN - tenant batch checkpointed", "/generate/shutdown", tenantKey, tenant.BlockOwnerPrefix + "drain", 50 * time.Millisecond, false, 1},
+	}
+
+	for i, test := range suite {
+		t.Run(fmt.Sprintf("#%d: %q", i, test.testName), func(t *testing.T) {
+			batches = newBatchTracker()
+			defer func() { batches = newBatchTracker() }()
+
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			assert.NilError(t, err)
+			srv := &http.Server{Handler: rt}
+			go srv.Serve(ln)
+
+			responses := make(chan string, 1)
+			go func() {
+				request, _ := http.NewRequest("GET", "http://"+ln.Addr().String()+test.path, nil)
+				request.Header.Set("User-Agent", fmt.Sprintf("shutdown-%d", i))
+				if test.key != "" {
+					request.Header.Set("X-API-Key", test.key)
+				}
+				resp, err := http.DefaultClient.Do(request)
+				if err != nil {
+					responses <- err.Error()
+					return
+				}
+				defer resp.Body.Close()
+				body, _ := ioutil.ReadAll(resp.Body)
+				responses <- string(body)
+			}()
+
+			for atomic.LoadInt64(&batches.running) == 0 {
+				time.Sleep(time.Millisecond)
+			}
+			shutdownServer(srv, test.timeout)
+
+			registered := models.RegisteredDevEUIList{}
+			assert.NilError(t, json.Unmarshal([]byte(<-responses), &registered))
+			assert.Equal(t, len(registered.DevEUIs) == 100, test.finished)
+			assert.Equal(t, atomic.LoadInt64(&batches.checkpointed), test.checkpointed)
+			assert.Equal(t, int64(len(registered.DevEUIs))+atomic.LoadInt64(&batches.held), int64(100))
+
+			held := 0
+			list, _ := gen.Reservations(c.Client)
+			for _, r := range list {
+				if r.Label == "unfinished batch "+registered.Batch {
+					held++
+					gen.Release(c.Client, r.From)
+				}
+			}
+
+			if test.owner == "" {
+				assert.Equal(t, held > 0, !test.finished)
+				return
+			}
+			// block batches are given back to their block - never reserved
+			assert.Equal(t, held, 0)
+			requeued, _ := c.Client.CountValues(gen.BlockHeldPrefix + test.owner)
+			assert.Equal(t, requeued, atomic.LoadInt64(&batches.held))
+			b, _ := gen.BlockFor(c.Client, test.owner)
+			assert.Equal(t, b.Issued, int64(len(registered.DevEUIs)))
+		})
+	}
+}
+
+func TestRegistrarPerTenant(t *testing.T) {
+	reset()
+	defer reset()
+	defer dropTenant(RequestCache.Client, "acme")
+	_, tenantKey, _ := tenant.Create(RequestCache.Client, "acme", 0)
+
+	// the network server of acme - counting requests and those in flight
+	var requests, inFlight, most int64
+	m := sync.Mutex{}
+	acme := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.Lock()
+		requests++
+		if inFlight++; inFlight > most {
+			most = inFlight
+		}
+		m.Unlock()
+
+		time.Sleep(2 * time.Millisecond)
+
+		m.Lock()
+		inFlight--
+		m.Unlock()
+	}))
+	defer acme.Close()
+
+	dir, _ := ioutil.TempDir("", "registrars")
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "registrars.json")
+
+	tmpURL := url
+	defer func() { url, registrars = tmpURL, nil }()
+	last, _, _ := c.Client.ReadCache(cache.LastUIDKey)
+	defer c.Client.StoreLastDUID(models.LastDevEUI{ShortCode: last})
+
+	ioutil.WriteFile(file, []byte(`{"registrars": [{"name": "acme-ns", "url": "`+acme.URL+`", "tenants": ["acme"]}, {"name": "other", "url": "`+acme.URL+`", "tenants": ["ACME"]}]}`), 0600)
+	assert.Error(t, startRegistrars(registrar.Config{}, file), `tenant "acme" is served by both "acme-ns" and "other"`)
+
+	ioutil.WriteFile(file, []byte(`{"registrars": [{"name": "acme-ns", "url": "`+acme.URL+`", "tenants": ["acme"], "concurrency": 3}]}`), 0600)
+	assert.NilError(t, startRegistrars(registrar.Config{Timeout: registrar.Duration(5 * time.Second)}, file))
+	assert.Equal(t, url, tmpURL)
+	assert.Equal(t, registrarFor(c).Client.Timeout, 5*time.Second)
+
+	generate := func(agent, key string) models.RegisteredDevEUIList {
+		request := httptest.NewRequest("GET", "/generate/registrar", nil)
+		request.Header.Set("User-Agent", agent)
+		request.Header.Set("X-API-Key", key)
+		response := httptest.NewRecorder()
+		rt.ServeHTTP(response, request)
+		registered := models.RegisteredDevEUIList{}
+		json.Unmarshal(response.Body.Bytes(), &registered)
+		return registered
+	}
+
+	assert.Equal(t, len(generate("registrar-acme", tenantKey).DevEUIs), 100)
+	m.Lock()
+	assert.Equal(t, requests, int64(100))
+	assert.Equal(t, most <= 3, true)
+	m.Unlock()
+
+	// everyone else stays with the default registrar
+	assert.Equal(t, len(generate("registrar-shared", "").DevEUIs), 100)
+	m.Lock()
+	assert.Equal(t, requests, int64(100))
+	m.Unlock()
+}
+
+func TestSignedRegistrations(t *testing.T) {
+	reset()
+	defer reset()
+
+	tmpURL := url
+	defer func() { url, registrars, mockendpoint.Verifier = tmpURL, nil, nil }()
+	last, _, _ := c.Client.ReadCache(cache.LastUIDKey)
+	defer c.Client.StoreLastDUID(models.LastDevEUI{ShortCode: last})
+
+	verifier, err := signing.NewVerifier(signing.Config{Type: "hmac", KeyID: "mxbcode", Secret: "registrar-secret"})
+	assert.NilError(t, err)
+	mockendpoint.Verifier = verifier
+
+	suite := []struct {
+		testName string
+		auth     *signing.Config
+		want     int
+	}{
+		{"SIGNED - hmac", &signing.Config{Type: "hmac", KeyID: "mxbcode", Secret: "registrar-secret"}, 200},
+		{"SIGNED - wrong secret", &signing.Config{Type: "hmac", KeyID: "mxbcode", Secret: "other-secret"}, 401},
+		{"SIGNED - wrong key id", &signing.Config{Type: "hmac", KeyID: "other", Secret: "registrar-secret"}, 401},
+		{"SIGNED - bearer token", &signing.Config{Type: "bearer", Secret: "registrar-secret"}, 401},
+		{"SIGNED - unsigned", nil, 401},
+	}
+	for i, test := range suite {
+		t.Run(fmt.Sprintf("#%d: %q", i, test.testName), func(t *testing.T) {
+			assert.NilError(t, startRegistrars(registrar.Config{URL: tmpURL, Auth: test.auth}, ""))
+			_, code, err := registerWith(registrars.Default.Client, fmt.Sprintf("5161%d", i), url)
+			assert.NilError(t, err)
+			assert.Equal(t, code, test.want)
+		})
+	}
+
+	// a whole batch end to end
+	assert.NilError(t, startRegistrars(registrar.Config{URL: tmpURL, Auth: suite[0].auth}, ""))
+	request := httptest.NewRequest("GET", "/generate/signed", nil)
+	request.Header.Set("User-Agent", "signed-batch")
+	response := httptest.NewRecorder()
+	rt.ServeHTTP(response, request)
+	registered := models.RegisteredDevEUIList{}
+	json.Unmarshal(response.Body.Bytes(), &registered)
+	assert.Equal(t, len(registered.DevEUIs), 100)
+}
+
+func TestAdaptiveConcurrency(t *testing.T) {
+	reset()
+	defer reset()
+
+	// a provider refusing every tenth request as overloaded
+	var requests, inFlight, most int64
+	m := sync.Mutex{}
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.Lock()
+		requests++
+		refuse := requests%10 == 0
+		if inFlight++; inFlight > most {
+			most = inFlight
+		}
+		m.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		m.Lock()
+		inFlight--
+		m.Unlock()
+		if refuse {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer provider.Close()
+
+	tmpURL := url
+	defer func() { url, registrars = tmpURL, nil }()
+	last, _, _ := c.Client.ReadCache(cache.LastUIDKey)
+	defer c.Client.StoreLastDUID(models.LastDevEUI{ShortCode: last})
+
+	assert.NilError(t, startRegistrars(registrar.Config{URL: provider.URL, Concurrency: 6}, ""))
+
+	request := httptest.NewRequest("GET", "/generate/adaptive", nil)
+	request.Header.Set("User-Agent", "adaptive-concurrency")
+	response := httptest.NewRecorder()
+	rt.ServeHTTP(response, request)
+	registered := models.RegisteredDevEUIList{}
+	json.Unmarshal(response.Body.Bytes(), &registered)
+	assert.Equal(t, len(registered.DevEUIs), 100)
+
+	m.Lock()
+	assert.Equal(t, most <= 6, true)
+	m.Unlock()
+	stats := registrars.Default.Limiter.Stats()
+	assert.Equal(t, stats.Backoffs > 0, true)
+	assert.Equal(t, stats.InFlight, 0)
+
+	request = httptest.NewRequest("GET", "/metrics", nil)
+	response = httptest.NewRecorder()
+	rt.ServeHTTP(response, request)
+	assert.Equal(t, response.Code, 200)
+	assert.Contains(t, response.Body.String(), "# TYPE mxbcode_registrar_concurrency_limit gauge")
+	assert.Contains(t, response.Body.String(), fmt.Sprintf("mxbcode_registrar_concurrency_limit{registrar=\"default\"} %d\n", stats.Limit))
+	assert.Contains(t, response.Body.String(), "mxbcode_registrar_concurrency_max{registrar=\"default\"} 6\n")
+	assert.Contains(t, response.Body.String(), fmt.Sprintf("mxbcode_registrar_backoffs_total{registrar=\"default\"} %d\n", stats.Backoffs))
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	reset()
+	defer reset()
+
+	// a provider that is down until told otherwise
+	var requests, down int64 = 0, 1
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		if atomic.LoadInt64(&down) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer provider.Close()
+
+	tmpURL := url
+	defer func() { url, registrars = tmpURL, nil }()
+	last, _, _ := c.Client.ReadCache(cache.LastUIDKey)
+	defer c.Client.StoreLastDUID(models.LastDevEUI{ShortCode: last})
+
+	assert.NilError(t, startRegistrars(registrar.Config{URL: provider.URL, Cooldown: registrar.Duration(300 * time.Millisecond)}, ""))
+	b := registrars.Default.Breaker
+
+	done := make(chan int)
+	go func() {
+		generated, _, _ := generateScopedBatchIDs(100, c, make(chan bool), gen.GenerateDUIDBatch)
+		done <- generated
+	}()
+
+	for i := 0; i < 100 && b.State() != breaker.Open; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, b.State(), breaker.Open)
+
+	// the batch is paused - nothing is sent and no shortcodes are drawn
+	sent := atomic.LoadInt64(&requests)
+	drawn, _, _ := c.Client.ReadCache(cache.LastUIDKey)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, atomic.LoadInt64(&requests), sent)
+	now, _, _ := c.Client.ReadCache(cache.LastUIDKey)
+	assert.Equal(t, now, drawn)
+
+	call := func(path string) string {
+		response := httptest.NewRecorder()
+		rt.ServeHTTP(response, httptest.NewRequest("GET", path, nil))
+		return response.Body.String()
+	}
+	assert.Contains(t, call("/"), `"default":{"state":"open"`)
+	assert.Contains(t, call("/metrics"), "mxbcode_registrar_breaker_state{registrar=\"default\",state=\"open\"} 1\n")
+	assert.Contains(t, call("/metrics"), "mxbcode_registrar_breaker_trips_total{registrar=\"default\"} 1\n")
+
+	// the provider recovers - a probe closes the breaker and the batch resumes
+	atomic.StoreInt64(&down, 0)
+	select {
+	case generated := <-done:
+		assert.Equal(t, generated, 100)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the batch did not resume")
+	}
+	assert.Equal(t, b.State(), breaker.Closed)
+	assert.Equal(t, atomic.LoadInt64(&requests), 100+sent)
+	assert.Contains(t, call("/"), `"default":{"state":"closed"`)
+}
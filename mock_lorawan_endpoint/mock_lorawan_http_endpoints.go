@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/David-solly/mxbcode/pkg/cache"
+	"github.com/David-solly/mxbcode/pkg/signing"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
@@ -12,6 +13,10 @@ import (
 
 var DB = &cache.Cache{}
 
+// Verifier :
+// Checks the signature of registrations - any request is accepted while nil
+var Verifier signing.Verifier
+
 // GetLorawanRouter :
 // Returns the mock lorawan endpoint http server router
 func GetLorawanRouter(silent bool) *chi.Mux {
@@ -28,8 +33,8 @@ func GetLorawanRouter(silent bool) *chi.Mux {
 
 	r.Use(middleware.Timeout(300 * time.Second))
 	r.Get("/", clearLorawanDatabase)
-	r.Post("/", baseOK)
-	r.Post("/sensor-onboarding-sample", registerEndpoint)
+	r.With(verifySignature).Post("/", baseOK)
+	r.With(verifySignature).Post("/sensor-onboarding-sample", registerEndpoint)
 
 	return r
 
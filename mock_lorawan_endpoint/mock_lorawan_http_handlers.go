@@ -1,6 +1,7 @@
 package mockendpoint
 
 import (
+	"bytes"
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
@@ -37,3 +38,22 @@ func clearLorawanDatabase(w http.ResponseWriter, r *http.Request) {
 func baseOK(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("OK"))
 }
+
+// refuses registrations whose signature doesn't verify
+func verifySignature(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if Verifier == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		p, _ := ioutil.ReadAll(r.Body)
+		if err := Verifier.Verify(r, p); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(p))
+		next.ServeHTTP(w, r)
+	})
+}
@@ -1,13 +1,31 @@
 package main
 
 import (
+	"flag"
 	"log"
 	"net/http"
 
 	mockendpoint "github.com/David-solly/mxbcode/mock_lorawan_endpoint"
+	"github.com/David-solly/mxbcode/pkg/signing"
+)
+
+var (
+	auth   = flag.String("auth", "", "Signature registrations must carry - hmac, bearer or api-key - none if blank")
+	secret = flag.String("secret-file", "", "File holding the HMAC secret, bearer token or API key of -auth")
+	keyID  = flag.String("key-id", "", "Key id HMAC signatures must name - any if blank")
+	header = flag.String("auth-header", "", "Header the API key is sent in - X-API-Key if blank")
 )
 
 func main() {
+	flag.Parse()
+	if *auth != "" {
+		v, err := signing.NewVerifier(signing.Config{Type: *auth, SecretFile: *secret, KeyID: *keyID, Header: *header})
+		if err != nil {
+			log.Fatal(err)
+		}
+		mockendpoint.Verifier = v
+	}
+
 	var errchan = make(chan error)
 	go func() {
 		errchan <- http.ListenAndServe(":8080", mockendpoint.GetLorawanRouter(false))
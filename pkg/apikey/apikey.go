@@ -0,0 +1,250 @@
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/David-solly/mxbcode/pkg/cache"
+	"github.com/David-solly/mxbcode/pkg/models"
+)
+
+// scopes granted to keys
+const (
+	Generate = "generate"
+	Lookup   = "lookup"
+	Export   = "export"
+	Admin    = "admin"
+)
+
+// Scopes : every scope a key can be granted
+var Scopes = []string{Generate, Lookup, Export, Admin}
+
+// KeyPrefix :
+// Prefix of key records in the cache store
+// keyed `APIKEY:<ID>`
+const KeyPrefix = "APIKEY:"
+
+// HashPrefix :
+// Index from the hash of a key to its record
+// keyed `APIKEY-HASH:<SHA256>` - the plain key is never stored
+const HashPrefix = "APIKEY-HASH:"
+
+// the record as stored - the hash never leaves the package
+type record struct {
+	models.APIKey
+	Hash string `json:"hash"`
+}
+
+// ParseScopes :
+// A comma separated list of scopes - unknown scopes are an error
+func ParseScopes(list string) ([]string, error) {
+	scopes := []string{}
+	for _, s := range strings.Split(list, ",") {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if s == "" || contains(scopes, s) {
+			continue
+		}
+		if !contains(Scopes, s) {
+			return nil, fmt.Errorf("unknown scope %q - expected one of %s", s, strings.Join(Scopes, ", "))
+		}
+		scopes = append(scopes, s)
+	}
+	if len(scopes) == 0 {
+		return nil, errors.New("at least one scope is required")
+	}
+	return scopes, nil
+}
+
+// Allows :
+// Reports whether k grants scope - admin grants every scope
+func Allows(k models.APIKey, scope string) bool {
+	return contains(k.Scopes, scope) || contains(k.Scopes, Admin)
+}
+
+// Create :
+// Adds a key granting scopes.
+// The key is returned once and only its hash is kept
+func Create(c cache.Service, name string, scopes []string) (models.APIKey, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return models.APIKey{}, "", errors.New("a key needs a name")
+	}
+	if _, err := ParseScopes(strings.Join(scopes, ",")); err != nil {
+		return models.APIKey{}, "", err
+	}
+
+	id, err := random(4)
+	if err != nil {
+		return models.APIKey{}, "", err
+	}
+	key, err := random(32)
+	if err != nil {
+		return models.APIKey{}, "", err
+	}
+
+	rec := record{APIKey: models.APIKey{ID: id, Name: name, Scopes: scopes, Created: time.Now().UTC()}, Hash: Hash(key)}
+	if err := store(c, rec); err != nil {
+		return models.APIKey{}, "", err
+	}
+	return rec.APIKey, key, nil
+}
+
+// Get :
+// The key with id - nil if it does not exist
+func Get(c cache.Service, id string) (*models.APIKey, error) {
+	rec, err := load(c, id)
+	if err != nil || rec == nil {
+		return nil, err
+	}
+	return &rec.APIKey, nil
+}
+
+// List :
+// Every key including revoked ones ordered by creation
+func List(c cache.Service) ([]models.APIKey, error) {
+	ids, err := c.ListKeys(KeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	list := []models.APIKey{}
+	for _, k := range ids {
+		rec, err := load(c, strings.TrimPrefix(k, KeyPrefix))
+		if err != nil {
+			return nil, err
+		}
+		if rec != nil {
+			list = append(list, rec.APIKey)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+
+	return list, nil
+}
+
+// Authenticate :
+// The live key matching key - nil if it is unknown or revoked
+func Authenticate(c cache.Service, key string) (*models.APIKey, error) {
+	id, found, _ := c.ReadCache(HashPrefix + Hash(key))
+	if !found {
+		return nil, nil
+	}
+	rec, err := load(c, id)
+	if err != nil || rec == nil || rec.Revoked != nil || rec.Hash != Hash(key) {
+		return nil, err
+	}
+	return &rec.APIKey, nil
+}
+
+// Rotate :
+// Replaces the secret of a key keeping its id, name and scopes.
+// The previous secret stops working straight away
+func Rotate(c cache.Service, id string) (models.APIKey, string, error) {
+	rec, err := live(c, id)
+	if err != nil {
+		return models.APIKey{}, "", err
+	}
+
+	key, err := random(32)
+	if err != nil {
+		return models.APIKey{}, "", err
+	}
+	previous := rec.Hash
+	now := time.Now().UTC()
+	rec.Hash, rec.Rotated = Hash(key), &now
+	if err := store(c, *rec); err != nil {
+		return models.APIKey{}, "", err
+	}
+	c.DeleteValue(HashPrefix + previous)
+
+	return rec.APIKey, key, nil
+}
+
+// Revoke :
+// Stops a key from authenticating - the record is kept
+func Revoke(c cache.Service, id string) (models.APIKey, error) {
+	rec, err := live(c, id)
+	if err != nil {
+		return models.APIKey{}, err
+	}
+
+	now := time.Now().UTC()
+	rec.Revoked = &now
+	if err := store(c, *rec); err != nil {
+		return models.APIKey{}, err
+	}
+	c.DeleteValue(HashPrefix + rec.Hash)
+
+	return rec.APIKey, nil
+}
+
+// Hash : the form in which keys are stored
+func Hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// the record of a key that has not been revoked
+func live(c cache.Service, id string) (*record, error) {
+	rec, err := load(c, id)
+	if err != nil {
+		return nil, err
+	}
+	if rec == nil {
+		return nil, fmt.Errorf("API key %q does not exist", id)
+	}
+	if rec.Revoked != nil {
+		return nil, fmt.Errorf("API key %q is revoked", id)
+	}
+	return rec, nil
+}
+
+func load(c cache.Service, id string) (*record, error) {
+	data, found, _ := c.ReadCache(KeyPrefix + strings.ToLower(id))
+	if !found {
+		return nil, nil
+	}
+	rec := record{}
+	if err := json.Unmarshal([]byte(data), &rec); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+func store(c cache.Service, rec record) error {
+	data, _ := json.Marshal(rec)
+	if _, err := c.StoreValue(KeyPrefix+rec.ID, string(data)); err != nil {
+		return err
+	}
+	if rec.Revoked == nil {
+		if _, err := c.StoreValue(HashPrefix+rec.Hash, rec.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// n random bytes hex encoded
+func random(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package apikey
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/David-solly/mxbcode/pkg/cache"
+	"github.com/David-solly/mxbcode/pkg/models"
+
+	"github.com/docker/docker/pkg/testutil/assert"
+)
+
+func TestParseScopes(t *testing.T) {
+	suite := []struct {
+		testName string
+		list     string
+		want     []string
+		err      string
+	}{
+		{"SCOPES - single", "generate", []string{Generate}, ""},
+		{"SCOPES - list", " Lookup, export,lookup", []string{Lookup, Export}, ""},
+		{"SCOPES - unknown", "lookup,root", nil, `unknown scope "root"`},
+		{"SCOPES - empty", " , ", nil, "at least one scope"},
+	}
+	for i, test := range suite {
+		t.Run(fmt.Sprintf("#%d: %q", i, test.testName), func(t *testing.T) {
+			scopes, err := ParseScopes(test.list)
+			if test.err != "" {
+				assert.Error(t, err, test.err)
+				return
+			}
+			assert.NilError(t, err)
+			assert.DeepEqual(t, scopes, test.want)
+		})
+	}
+}
+
+func TestAllows(t *testing.T) {
+	assert.Equal(t, Allows(models.APIKey{Scopes: []string{Lookup}}, Lookup), true)
+	assert.Equal(t, Allows(models.APIKey{Scopes: []string{Lookup}}, Generate), false)
+	assert.Equal(t, Allows(models.APIKey{Scopes: []string{Admin}}, Export), true)
+}
+
+func TestKeys(t *testing.T) {
+	c := cache.Cache{}
+	c.Initialise("", false)
+
+	k, key, err := Create(c.Client, "station-1", []string{Generate, Lookup})
+	assert.NilError(t, err)
+	assert.Equal(t, len(key), 64)
+	assert.Equal(t, len(k.ID), 8)
+
+	_, _, err = Create(c.Client, "  ", []string{Lookup})
+	assert.Error(t, err, "needs a name")
+	_, _, err = Create(c.Client, "station-2", []string{"root"})
+	assert.Error(t, err, "unknown scope")
+
+	t.Run("KEYS authenticate", func(t *testing.T) {
+		found, err := Authenticate(c.Client, key)
+		assert.NilError(t, err)
+		assert.Equal(t, found.Name, "station-1")
+		assert.DeepEqual(t, found.Scopes, []string{Generate, Lookup})
+
+		found, _ = Authenticate(c.Client, "unknown")
+		assert.Equal(t, found == nil, true)
+
+		// only the hash is stored
+		data, _, _ := c.Client.ReadCache(KeyPrefix + k.ID)
+		assert.Contains(t, data, Hash(key))
+		assert.Equal(t, strings.Contains(data, key), false)
+		list, _ := List(c.Client)
+		assert.Equal(t, len(list), 1)
+	})
+
+	t.Run("KEYS rotate", func(t *testing.T) {
+		rotated, fresh, err := Rotate(c.Client, k.ID)
+		assert.NilError(t, err)
+		assert.Equal(t, rotated.ID, k.ID)
+		assert.Equal(t, rotated.Rotated != nil, true)
+		assert.Equal(t, fresh != key, true)
+
+		found, _ := Authenticate(c.Client, key)
+		assert.Equal(t, found == nil, true)
+		found, _ = Authenticate(c.Client, fresh)
+		assert.Equal(t, found.ID, k.ID)
+		key = fresh
+
+		_, _, err = Rotate(c.Client, "00000000")
+		assert.Error(t, err, "does not exist")
+	})
+
+	t.Run("KEYS revoke", func(t *testing.T) {
+		revoked, err := Revoke(c.Client, k.ID)
+		assert.NilError(t, err)
+		assert.Equal(t, revoked.Revoked != nil, true)
+
+		found, _ := Authenticate(c.Client, key)
+		assert.Equal(t, found == nil, true)
+		_, err = Revoke(c.Client, k.ID)
+		assert.Error(t, err, "is revoked")
+		_, _, err = Rotate(c.Client, k.ID)
+		assert.Error(t, err, "is revoked")
+
+		// revoked keys stay on record
+		got, _ := Get(c.Client, k.ID)
+		assert.Equal(t, got.Revoked != nil, true)
+		list, _ := List(c.Client)
+		assert.Equal(t, len(list), 1)
+	})
+}
@@ -0,0 +1,206 @@
+package breaker
+
+import (
+	"sync"
+	"time"
+)
+
+// defaults of a breaker
+const (
+	DefaultWindow       = 20
+	DefaultMinRequests  = 10
+	DefaultFailureRatio = 0.5
+	DefaultCooldown     = 30 * time.Second
+	DefaultProbes       = 1
+)
+
+// State : closed, open or half-open
+type State int
+
+// states of a breaker
+const (
+	Closed State = iota
+	HalfOpen
+	Open
+)
+
+// String : the name of the state
+func (s State) String() string {
+	switch s {
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// Breaker :
+// Stops requests to a provider that is down.
+// While closed the outcome of the last `Window` requests is kept and
+// once `MinRequests` of them are in the breaker opens when `FailureRatio`
+// of them failed - a request failed when it couldn't be sent or the
+// provider answered with a 5xx. An open breaker lets nothing through
+// for `Cooldown` then half opens to let `Probes` requests try the
+// provider. They close it again when all succeed and any failure
+// opens it for another cooldown
+type Breaker struct {
+	Window       int
+	MinRequests  int
+	FailureRatio float64
+	Cooldown     time.Duration
+	Probes       int
+
+	// the current time - replaced in tests
+	Now func() time.Time
+
+	mutex     sync.Mutex
+	state     State
+	outcomes  []bool
+	next      int
+	opened    time.Time
+	probing   int
+	probed    time.Time
+	succeeded int
+	trips     int64
+}
+
+// Stats : the state of a breaker
+type Stats struct {
+	State    string     `json:"state"`
+	Requests int        `json:"requests"`
+	Failures int        `json:"failures"`
+	Trips    int64      `json:"trips"`
+	Opened   *time.Time `json:"opened,omitempty"`
+	RetryAt  *time.Time `json:"retry_at,omitempty"`
+}
+
+// New : a closed breaker with the default thresholds
+func New() *Breaker {
+	return &Breaker{Window: DefaultWindow, MinRequests: DefaultMinRequests, FailureRatio: DefaultFailureRatio,
+		Cooldown: DefaultCooldown, Probes: DefaultProbes, Now: time.Now}
+}
+
+// Wait :
+// How long until a request may be sent - 0 lets one through now.
+// Half open it hands out the probes and holds everything else
+func (b *Breaker) Wait() time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	now := b.Now()
+
+	if b.state == Open {
+		if wait := b.opened.Add(b.Cooldown).Sub(now); wait > 0 {
+			return wait
+		}
+		b.state, b.probing, b.succeeded = HalfOpen, 0, 0
+	}
+	if b.state == HalfOpen {
+		// a probe that was never sent gives its place up after a cooldown
+		if b.probing > 0 && now.Sub(b.probed) > b.Cooldown {
+			b.probing = 0
+		}
+		if b.probing+b.succeeded >= b.Probes {
+			return b.Cooldown / 10
+		}
+		b.probing++
+		b.probed = now
+	}
+	return 0
+}
+
+// RetryIn :
+// How long the breaker stays open - 0 once requests may be tried.
+// Unlike Wait no probe is handed out
+func (b *Breaker) RetryIn() time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.state != Open {
+		return 0
+	}
+	if wait := b.opened.Add(b.Cooldown).Sub(b.Now()); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// Record :
+// Counts the outcome of a request - the status code or the error sending it
+func (b *Breaker) Record(code int, err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	failed := err != nil || code >= 500
+
+	switch b.state {
+	case Open:
+		// sent before the breaker opened
+		return
+
+	case HalfOpen:
+		if b.probing > 0 {
+			b.probing--
+		}
+		if failed {
+			b.open()
+			return
+		}
+		if b.succeeded++; b.succeeded >= b.Probes {
+			b.state, b.outcomes, b.next = Closed, nil, 0
+		}
+		return
+	}
+
+	if len(b.outcomes) < b.Window {
+		b.outcomes = append(b.outcomes, failed)
+	} else {
+		b.outcomes[b.next] = failed
+		b.next = (b.next + 1) % b.Window
+	}
+
+	requests, failures := b.count()
+	if requests >= b.MinRequests && float64(failures) >= b.FailureRatio*float64(requests) {
+		b.open()
+	}
+}
+
+func (b *Breaker) open() {
+	b.state, b.opened = Open, b.Now()
+	b.outcomes, b.next, b.probing, b.succeeded = nil, 0, 0, 0
+	b.trips++
+}
+
+// requests and failures in the window
+func (b *Breaker) count() (int, int) {
+	failures := 0
+	for _, failed := range b.outcomes {
+		if failed {
+			failures++
+		}
+	}
+	return len(b.outcomes), failures
+}
+
+// State : closed, open or half-open
+func (b *Breaker) State() State {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.state
+}
+
+// Stats : the state, the window and how often the breaker opened
+func (b *Breaker) Stats() Stats {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	requests, failures := b.count()
+	s := Stats{State: b.state.String(), Requests: requests, Failures: failures, Trips: b.trips}
+	if b.state != Closed {
+		opened := b.opened
+		s.Opened = &opened
+	}
+	if b.state == Open {
+		retry := b.opened.Add(b.Cooldown)
+		s.RetryAt = &retry
+	}
+	return s
+}
@@ -0,0 +1,108 @@
+package breaker
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/pkg/testutil/assert"
+)
+
+// a breaker on a clock moved by hand
+func manual() (*Breaker, *time.Time) {
+	now := time.Unix(1600000000, 0)
+	b := New()
+	b.Now = func() time.Time { return now }
+	return b, &now
+}
+
+func TestBreakerTrips(t *testing.T) {
+	down := errors.New("connection refused")
+	suite := []struct {
+		testName string
+		codes    []int
+		err      error
+		want     State
+	}{
+		{"CLOSED - healthy", []int{200, 200, 200, 200, 200, 200, 200, 200, 200, 200}, nil, Closed},
+		{"CLOSED - refused devices are not failures", []int{422, 422, 422, 422, 422, 422, 422, 422, 422, 422}, nil, Closed},
+		{"CLOSED - overload is left to the limiter", []int{429, 429, 429, 429, 429, 429, 429, 429, 429, 429}, nil, Closed},
+		{"CLOSED - below the minimum requests", []int{503, 503, 503, 503, 503, 503, 503, 503, 503}, nil, Closed},
+		{"CLOSED - below the failure ratio", []int{503, 200, 503, 200, 503, 200, 503, 200, 200, 200}, nil, Closed},
+		{"OPEN - at the failure ratio", []int{503, 200, 503, 200, 503, 200, 503, 200, 503, 200}, nil, Open},
+		{"OPEN - unreachable", []int{0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, down, Open},
+	}
+	for i, test := range suite {
+		t.Run(fmt.Sprintf("#%d: %q", i, test.testName), func(t *testing.T) {
+			b, _ := manual()
+			for _, code := range test.codes {
+				assert.Equal(t, b.Wait(), time.Duration(0))
+				b.Record(code, test.err)
+			}
+			assert.Equal(t, b.State(), test.want)
+		})
+	}
+
+	t.Run("CLOSED - old outcomes leave the window", func(t *testing.T) {
+		b, _ := manual()
+		for i := 0; i < 4; i++ {
+			b.Record(503, nil)
+		}
+		for i := 0; i < 20; i++ {
+			b.Record(200, nil)
+		}
+		stats := b.Stats()
+		assert.Equal(t, stats.Requests, DefaultWindow)
+		assert.Equal(t, stats.Failures, 0)
+	})
+}
+
+func TestBreakerRecovers(t *testing.T) {
+	b, now := manual()
+	for i := 0; i < DefaultMinRequests; i++ {
+		b.Record(503, nil)
+	}
+	assert.Equal(t, b.State(), Open)
+	assert.Equal(t, b.Wait(), DefaultCooldown)
+	assert.Equal(t, b.RetryIn(), DefaultCooldown)
+	stats := b.Stats()
+	assert.Equal(t, stats.State, "open")
+	assert.Equal(t, *stats.RetryAt, now.Add(DefaultCooldown))
+
+	// a late answer sent before it opened changes nothing
+	b.Record(200, nil)
+	assert.Equal(t, b.State(), Open)
+
+	// after the cooldown one probe goes through and the rest wait
+	*now = now.Add(DefaultCooldown)
+	assert.Equal(t, b.RetryIn(), time.Duration(0))
+	assert.Equal(t, b.State(), Open)
+	assert.Equal(t, b.Wait(), time.Duration(0))
+	assert.Equal(t, b.State(), HalfOpen)
+	assert.Equal(t, b.Wait(), DefaultCooldown/10)
+
+	// a failed probe opens it again
+	b.Record(0, errors.New("connection refused"))
+	assert.Equal(t, b.State(), Open)
+	assert.Equal(t, b.Stats().Trips, int64(2))
+
+	// a successful probe closes it
+	*now = now.Add(DefaultCooldown)
+	assert.Equal(t, b.Wait(), time.Duration(0))
+	b.Record(200, nil)
+	assert.Equal(t, b.State(), Closed)
+	assert.Equal(t, b.Wait(), time.Duration(0))
+
+	t.Run("HALF OPEN - a probe never sent is handed out again", func(t *testing.T) {
+		b, now := manual()
+		for i := 0; i < DefaultMinRequests; i++ {
+			b.Record(503, nil)
+		}
+		*now = now.Add(DefaultCooldown)
+		assert.Equal(t, b.Wait(), time.Duration(0))
+		assert.Equal(t, b.Wait() > 0, true)
+		*now = now.Add(DefaultCooldown + time.Second)
+		assert.Equal(t, b.Wait(), time.Duration(0))
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/David-solly/mxbcode/pkg/models"
 )
@@ -51,4 +52,33 @@ type Service interface {
 	StoreLastDUID(model models.LastDevEUI) (bool, error)
 	StoreDUIDGenResponse(model models.ApiResponseCacheObject) (bool, error)
 	ReadCache(key string) (string, bool, error)
+
+	// durable key/value records used by the allocation features
+	StoreValue(key, value string) (bool, error)
+	DeleteValue(key string) (bool, error)
+	ListKeys(prefix string) ([]string, error)
+
+	// durable queues - popping is atomic
+	PushValues(key string, values ...string) (int64, error)
+	PopValues(key string, count int) ([]string, error)
+	CountValues(key string) (int64, error)
+
+	// expiring values replaced atomically - counters and limits
+	// shared by every replica using the same store
+	SwapValue(key, old, value string, ttl time.Duration) (bool, error)
+}
+
+// Persist :
+// Writes an in-memory store to disk - namespaces persist the store beneath them.
+// Other stores are already durable so nothing is done
+func Persist(s Service) error {
+	switch client := s.(type) {
+	case *MemoryCache:
+		return client.Persist()
+	case *Namespace:
+		return Persist(client.Client)
+	case *Observed:
+		return Persist(client.Client)
+	}
+	return nil
 }
@@ -292,3 +292,246 @@ func TestLastIDCache(t *testing.T) {
 		}
 	})
 }
+
+func TestDurableValues(t *testing.T) {
+	mCache := Cache{}
+	mCache.Initialise("", false)
+
+	rCache := Cache{}
+	rCache.Initialise(globalRedis, useRedis)
+
+	suite := []struct {
+		testName string
+		cache    Cache
+	}{
+		{"VALUES - memory", mCache},
+		{"VALUES - redis", rCache},
+	}
+
+	for i, test := range suite {
+		if !useRedis && test.testName == "VALUES - redis" {
+			fmt.Println("Skipping redis check")
+			continue
+		}
+		t.Run(fmt.Sprintf("#%d - %q", i, test.testName), func(t *testing.T) {
+			for _, k := range []string{"test:b", "test:a", "other:a"} {
+				ok, err := test.cache.Client.StoreValue(k, "value-"+k)
+				assert.NilError(t, err)
+				assert.Equal(t, ok, true)
+			}
+
+			keys, err := test.cache.Client.ListKeys("test:")
+			assert.NilError(t, err)
+			assert.DeepEqual(t, keys, []string{"TEST:A", "TEST:B"})
+
+			v, found, err := test.cache.Client.ReadCache("test:a")
+			assert.NilError(t, err)
+			assert.Equal(t, found, true)
+			assert.Equal(t, v, "value-test:a")
+
+			deleted, err := test.cache.Client.DeleteValue("test:a")
+			assert.NilError(t, err)
+			assert.Equal(t, deleted, true)
+			deleted, _ = test.cache.Client.DeleteValue("test:a")
+			assert.Equal(t, deleted, false)
+
+			keys, _ = test.cache.Client.ListKeys("test:")
+			assert.DeepEqual(t, keys, []string{"TEST:B"})
+			test.cache.Client.DeleteValue("test:b")
+			test.cache.Client.DeleteValue("other:a")
+		})
+	}
+}
+
+func TestNamespace(t *testing.T) {
+	shared := Cache{}
+	shared.Initialise("", false)
+	shared.Client.StoreDUID(models.DevEUI{DevEUI: "FFA45722AA700001", ShortCode: "00001"})
+
+	a := NewNamespace(shared.Client, "t:a:")
+	b := NewNamespace(shared.Client, "t:b:")
+
+	t.Run("NAMESPACE isolation", func(t *testing.T) {
+		for _, ns := range []*Namespace{a, b} {
+			pong, err := ns.Initialise()
+			assert.NilError(t, err)
+			assert.Equal(t, pong, "PONG")
+			// shortcodes are drawn from the shared store - no counter is seeded
+			_, found, _ := ns.ReadCache(LastUIDKey)
+			assert.Equal(t, found, false)
+		}
+
+		a.StoreLastDUID(models.LastDevEUI{ShortCode: "0000a"})
+		a.StoreDUID(models.DevEUI{DevEUI: "aaaaaaaaaaa00001", ShortCode: "00001"})
+		a.StoreValue("RESERVED:00002", "x")
+
+		last, _, _ := a.ReadCache(LastUIDKey)
+		assert.Equal(t, last, "0000A")
+		_, found, _ := b.ReadCache(LastUIDKey)
+		assert.Equal(t, found, false)
+		last, _, _ = shared.Client.ReadCache(LastUIDKey)
+		assert.Equal(t, last != "0000A", true)
+
+		device, _, _ := a.ReadCache("00001")
+		assert.Equal(t, device, "AAAAAAAAAAA00001")
+		_, found, _ = b.ReadCache("00001")
+		assert.Equal(t, found, false)
+		device, _, _ = shared.Client.ReadCache("00001")
+		assert.Equal(t, device, "FFA45722AA700001")
+
+		keys, err := a.ListKeys("RESERVED:")
+		assert.NilError(t, err)
+		assert.DeepEqual(t, keys, []string{"RESERVED:00002"})
+		keys, _ = shared.Client.ListKeys("RESERVED:")
+		assert.Equal(t, len(keys), 0)
+	})
+
+	t.Run("NAMESPACE purge", func(t *testing.T) {
+		b.StoreValue("RESERVED:00003", "x")
+		assert.NilError(t, a.Purge())
+		keys, _ := shared.Client.ListKeys("T:A:")
+		assert.Equal(t, len(keys), 0)
+		keys, _ = shared.Client.ListKeys("T:B:")
+		assert.Equal(t, len(keys), 1)
+		b.Purge()
+	})
+}
+
+func TestObserved(t *testing.T) {
+	shared := Cache{}
+	shared.Initialise("", false)
+	var seen []models.Event
+	o := Observe(NewNamespace(shared.Client, "t:obs:"), "obs", func(e models.Event) { seen = append(seen, e) })
+
+	o.StoreDUID(models.DevEUI{DevEUI: "aaaaaaaaaaa00001", ShortCode: "00001"})
+	o.StoreDUID(models.DevEUI{DevEUI: "AAAAAAAAAAA00001", ShortCode: "00001"})
+	o.StoreDUID(models.DevEUI{DevEUI: "BBBBBBBBBBB00001", ShortCode: "00001"})
+	o.StoreValue("RESERVED:00002", "x")
+
+	assert.Equal(t, len(seen), 3)
+	assert.Equal(t, seen[0].Type, "stored")
+	assert.Equal(t, seen[0].Tenant, "obs")
+	assert.Equal(t, seen[0].DevEUI, "AAAAAAAAAAA00001")
+	assert.Equal(t, seen[1].Type, "stored")
+	assert.Equal(t, seen[2].Type, "conflict")
+	assert.Equal(t, seen[2].Detail, "shortcode held by AAAAAAAAAAA00001")
+
+	device, _, _ := shared.Client.ReadCache("T:OBS:00001")
+	assert.Equal(t, device, "BBBBBBBBBBB00001")
+}
+
+func TestSwapValue(t *testing.T) {
+	c := Cache{}
+	c.Initialise("", false)
+	ns := NewNamespace(c.Client, "t:swap:")
+
+	suite := []struct {
+		testName   string
+		old, value string
+		ttl        time.Duration
+		want       bool
+	}{
+		{"SWAP - create", "", "1", time.Hour, true},
+		{"SWAP - create again", "", "1", time.Hour, false},
+		{"SWAP - stale", "0", "2", time.Hour, false},
+		{"SWAP - current", "1", "2", 50 * time.Millisecond, true},
+	}
+	for i, test := range suite {
+		t.Run(fmt.Sprintf("#%d: %q", i, test.testName), func(t *testing.T) {
+			ok, err := ns.SwapValue("COUNTER", test.old, test.value, test.ttl)
+			assert.NilError(t, err)
+			assert.Equal(t, ok, test.want)
+		})
+	}
+
+	value, _, _ := c.Client.ReadCache("T:SWAP:COUNTER")
+	assert.Equal(t, value, "2")
+
+	// expired values are gone and can be created again
+	time.Sleep(60 * time.Millisecond)
+	_, found, _ := ns.ReadCache("COUNTER")
+	assert.Equal(t, found, false)
+	ok, _ := ns.SwapValue("COUNTER", "", "1", 0)
+	assert.Equal(t, ok, true)
+}
+
+func TestQueues(t *testing.T) {
+	mCache := Cache{}
+	mCache.Initialise("", false)
+
+	rCache := Cache{}
+	rCache.Initialise(globalRedis, useRedis)
+
+	suite := []struct {
+		testName string
+		client   Service
+	}{
+		{"QUEUE - memory", mCache.Client},
+		{"QUEUE - redis", rCache.Client},
+		{"QUEUE - namespace", NewNamespace(mCache.Client, "t:q:")},
+	}
+
+	for i, test := range suite {
+		if !useRedis && test.testName == "QUEUE - redis" {
+			fmt.Println("Skipping redis check")
+			continue
+		}
+		t.Run(fmt.Sprintf("#%d - %q", i, test.testName), func(t *testing.T) {
+			n, err := test.client.PushValues("queue:test", "a", "b", "c")
+			assert.NilError(t, err)
+			assert.Equal(t, n, int64(3))
+			n, _ = test.client.PushValues("queue:test", "d")
+			assert.Equal(t, n, int64(4))
+
+			popped, err := test.client.PopValues("queue:test", 2)
+			assert.NilError(t, err)
+			assert.DeepEqual(t, popped, []string{"a", "b"})
+
+			popped, _ = test.client.PopValues("queue:test", 5)
+			assert.DeepEqual(t, popped, []string{"c", "d"})
+			popped, _ = test.client.PopValues("queue:test", 1)
+			assert.Equal(t, len(popped), 0)
+
+			n, err = test.client.CountValues("queue:test")
+			assert.NilError(t, err)
+			assert.Equal(t, n, int64(0))
+			test.client.DeleteValue("queue:test")
+		})
+	}
+
+	t.Run("QUEUE - concurrent pops never overlap", func(t *testing.T) {
+		values := []string{}
+		for i := 0; i < 200; i++ {
+			values = append(values, fmt.Sprintf("%03d", i))
+		}
+		mCache.Client.PushValues("queue:race", values...)
+
+		seen := make(chan string, 200)
+		done := make(chan bool)
+		for w := 0; w < 10; w++ {
+			go func() {
+				for {
+					popped, _ := mCache.Client.PopValues("queue:race", 3)
+					if len(popped) == 0 {
+						done <- true
+						return
+					}
+					for _, v := range popped {
+						seen <- v
+					}
+				}
+			}()
+		}
+		for w := 0; w < 10; w++ {
+			<-done
+		}
+		close(seen)
+
+		unique := map[string]bool{}
+		for v := range seen {
+			assert.Equal(t, unique[v], false)
+			unique[v] = true
+		}
+		assert.Equal(t, len(unique), 200)
+	})
+}
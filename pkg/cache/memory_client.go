@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -26,11 +27,20 @@ type Store struct {
 	name  string
 	data  map[string]string
 	mutex sync.Mutex
+
+	// keys written with StoreValue
+	// these survive a restart via Persist
+	durable map[string]bool
+
+	// when keys written with SwapValue expire
+	expires map[string]time.Time
 }
 
 func (c MemoryCache) NewClient() *Store {
 	return &Store{name: "Memory store",
-		data: map[string]string{"PING": "PONG", LastUIDKey: "00000"}}
+		data:    map[string]string{"PING": "PONG", LastUIDKey: "00000"},
+		durable: map[string]bool{},
+		expires: map[string]time.Time{}}
 }
 
 func (c *MemoryCache) init() (string, error) {
@@ -43,7 +53,12 @@ func (c *MemoryCache) init() (string, error) {
 			fmt.Print("Reset count - ")
 		} else {
 			fmt.Printf("Restarted ")
-			c.client.data[LastUIDKey] = dta[LastUIDKey]
+			for k, v := range dta {
+				c.client.data[k] = v
+				if k != LastUIDKey {
+					c.client.durable[k] = true
+				}
+			}
 		}
 
 	}
@@ -67,6 +82,7 @@ func (c *MemoryCache) StoreDUID(model models.DevEUI) (bool, error) {
 
 func (c *MemoryCache) ReadCache(key string) (string, bool, error) {
 	c.client.mutex.Lock()
+	c.expire(strings.ToUpper(key))
 	data, k := c.client.data[strings.ToUpper(key)]
 	c.client.mutex.Unlock()
 	if !k {
@@ -101,7 +117,145 @@ func (c *MemoryCache) StoreDUIDGenResponse(model models.ApiResponseCacheObject)
 	return true, nil
 }
 
+// StoreValue :
+// Stores a record that is kept until deleted
+// and written to disk along with the last shortcode on Persist
+func (c *MemoryCache) StoreValue(key, value string) (bool, error) {
+	c.client.mutex.Lock()
+	c.client.data[strings.ToUpper(key)] = value
+	c.client.durable[strings.ToUpper(key)] = true
+	delete(c.client.expires, strings.ToUpper(key))
+	c.client.mutex.Unlock()
+	return true, nil
+}
+
+func (c *MemoryCache) DeleteValue(key string) (bool, error) {
+	c.client.mutex.Lock()
+	defer c.client.mutex.Unlock()
+	_, k := c.client.data[strings.ToUpper(key)]
+	delete(c.client.data, strings.ToUpper(key))
+	delete(c.client.durable, strings.ToUpper(key))
+	delete(c.client.expires, strings.ToUpper(key))
+	return k, nil
+}
+
+// ListKeys :
+// All keys beginning with prefix - sorted
+func (c *MemoryCache) ListKeys(prefix string) ([]string, error) {
+	prefix = strings.ToUpper(prefix)
+	keys := []string{}
+	c.client.mutex.Lock()
+	for k := range c.client.data {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	c.client.mutex.Unlock()
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// PushValues :
+// Appends values to the queue at key
+// queues are held as json arrays so they persist with other values
+func (c *MemoryCache) PushValues(key string, values ...string) (int64, error) {
+	c.client.mutex.Lock()
+	defer c.client.mutex.Unlock()
+	list, err := c.list(key)
+	if err != nil {
+		return 0, err
+	}
+	list = append(list, values...)
+	return int64(len(list)), c.setList(key, list)
+}
+
+// PopValues :
+// Removes up to count values from the front of the queue at key
+func (c *MemoryCache) PopValues(key string, count int) ([]string, error) {
+	c.client.mutex.Lock()
+	defer c.client.mutex.Unlock()
+	list, err := c.list(key)
+	if err != nil {
+		return nil, err
+	}
+	if count < 0 {
+		count = 0
+	}
+	if count > len(list) {
+		count = len(list)
+	}
+	popped := append([]string{}, list[:count]...)
+	return popped, c.setList(key, list[count:])
+}
+
+func (c *MemoryCache) CountValues(key string) (int64, error) {
+	c.client.mutex.Lock()
+	defer c.client.mutex.Unlock()
+	list, err := c.list(key)
+	return int64(len(list)), err
+}
+
+// SwapValue :
+// Stores value only while key still holds old - a blank old
+// means the key must not exist. The value is dropped after ttl,
+// a ttl of 0 keeps it. Swapped values are never persisted
+func (c *MemoryCache) SwapValue(key, old, value string, ttl time.Duration) (bool, error) {
+	key = strings.ToUpper(key)
+	c.client.mutex.Lock()
+	defer c.client.mutex.Unlock()
+
+	c.expire(key)
+	if current, k := c.client.data[key]; (k || old != "") && current != old {
+		return false, nil
+	}
+	c.client.data[key] = value
+	delete(c.client.durable, key)
+	delete(c.client.expires, key)
+	if ttl > 0 {
+		c.client.expires[key] = time.Now().Add(ttl)
+	}
+	return true, nil
+}
+
+// drops key once it has expired - callers hold the mutex
+func (c *MemoryCache) expire(key string) {
+	if at, k := c.client.expires[key]; k && !time.Now().Before(at) {
+		delete(c.client.data, key)
+		delete(c.client.expires, key)
+	}
+}
+
+// callers hold the mutex
+func (c *MemoryCache) list(key string) ([]string, error) {
+	list := []string{}
+	data, k := c.client.data[strings.ToUpper(key)]
+	if !k {
+		return list, nil
+	}
+	if err := json.Unmarshal([]byte(data), &list); err != nil {
+		return nil, fmt.Errorf("%q is not a queue - %v", key, err)
+	}
+	return list, nil
+}
+
+func (c *MemoryCache) setList(key string, list []string) error {
+	data, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	c.client.data[strings.ToUpper(key)] = string(data)
+	c.client.durable[strings.ToUpper(key)] = true
+	return nil
+}
+
 func (c *MemoryCache) Persist() error {
-	data, _ := json.Marshal(map[string]string{LastUIDKey: c.client.data[LastUIDKey]})
+	c.client.mutex.Lock()
+	dta := map[string]string{LastUIDKey: c.client.data[LastUIDKey]}
+	for k := range c.client.durable {
+		dta[k] = c.client.data[k]
+	}
+	c.client.mutex.Unlock()
+
+	data, _ := json.Marshal(dta)
 	return ioutil.WriteFile(persistFile, data, 0777)
 }
@@ -0,0 +1,98 @@
+package cache
+
+import (
+	"strings"
+	"time"
+
+	"github.com/David-solly/mxbcode/pkg/models"
+)
+
+// Namespace :
+// A Service confining every key to a prefix of another Service
+// eg the records of one tenant within a shared store.
+// Shortcodes are never drawn from a namespace - its counter would start
+// from zero like every other - but from a block of the shared store
+type Namespace struct {
+	Prefix string
+	Client Service
+}
+
+// NewNamespace : keys of the returned Service are stored under prefix in c
+func NewNamespace(c Service, prefix string) *Namespace {
+	return &Namespace{Prefix: strings.ToUpper(prefix), Client: c}
+}
+
+// Initialise :
+// Nothing to seed - the shared store is left untouched
+func (n *Namespace) Initialise() (string, error) {
+	return "PONG", nil
+}
+
+func (n *Namespace) StoreDUID(model models.DevEUI) (bool, error) {
+	return n.Client.StoreValue(n.Prefix+model.ShortCode, strings.ToUpper(model.DevEUI))
+}
+
+func (n *Namespace) StoreLastDUID(model models.LastDevEUI) (bool, error) {
+	return n.Client.StoreValue(n.Prefix+LastUIDKey, strings.ToUpper(model.ShortCode))
+}
+
+func (n *Namespace) StoreDUIDGenResponse(model models.ApiResponseCacheObject) (bool, error) {
+	model.Key = n.Prefix + model.Key
+	return n.Client.StoreDUIDGenResponse(model)
+}
+
+func (n *Namespace) ReadCache(key string) (string, bool, error) {
+	return n.Client.ReadCache(n.Prefix + key)
+}
+
+func (n *Namespace) StoreValue(key, value string) (bool, error) {
+	return n.Client.StoreValue(n.Prefix+key, value)
+}
+
+func (n *Namespace) DeleteValue(key string) (bool, error) {
+	return n.Client.DeleteValue(n.Prefix + key)
+}
+
+// ListKeys :
+// Keys beginning with prefix - returned without the namespace prefix
+func (n *Namespace) ListKeys(prefix string) ([]string, error) {
+	keys, err := n.Client.ListKeys(n.Prefix + prefix)
+	if err != nil {
+		return nil, err
+	}
+	for i, k := range keys {
+		keys[i] = strings.TrimPrefix(k, n.Prefix)
+	}
+	return keys, nil
+}
+
+func (n *Namespace) PushValues(key string, values ...string) (int64, error) {
+	return n.Client.PushValues(n.Prefix+key, values...)
+}
+
+func (n *Namespace) PopValues(key string, count int) ([]string, error) {
+	return n.Client.PopValues(n.Prefix+key, count)
+}
+
+func (n *Namespace) CountValues(key string) (int64, error) {
+	return n.Client.CountValues(n.Prefix + key)
+}
+
+func (n *Namespace) SwapValue(key, old, value string, ttl time.Duration) (bool, error) {
+	return n.Client.SwapValue(n.Prefix+key, old, value, ttl)
+}
+
+// Purge :
+// Deletes every key in the namespace
+func (n *Namespace) Purge() error {
+	keys, err := n.Client.ListKeys(n.Prefix)
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if _, err := n.Client.DeleteValue(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package cache
+
+import (
+	"strings"
+	"time"
+
+	"github.com/David-solly/mxbcode/pkg/models"
+)
+
+// Observed :
+// A Service reporting the devices stored through it to Publish.
+// Storing a shortcode that already holds another DevEUI
+// is reported as a `conflict` rather than `stored`
+type Observed struct {
+	Client  Service
+	Tenant  string
+	Publish func(models.Event)
+}
+
+// Observe : c reporting device records to publish - tagged with tenant when set
+func Observe(c Service, tenant string, publish func(models.Event)) *Observed {
+	return &Observed{Client: c, Tenant: tenant, Publish: publish}
+}
+
+func (o *Observed) Initialise() (string, error) {
+	return o.Client.Initialise()
+}
+
+func (o *Observed) StoreDUID(model models.DevEUI) (bool, error) {
+	kind, detail := "stored", ""
+	if held, found, _ := o.Client.ReadCache(model.ShortCode); found && !strings.EqualFold(held, model.DevEUI) {
+		kind, detail = "conflict", "shortcode held by "+held
+	}
+	ok, err := o.Client.StoreDUID(model)
+	if err != nil || o.Publish == nil {
+		return ok, err
+	}
+	o.Publish(models.Event{
+		Type:      kind,
+		Time:      time.Now().UTC(),
+		Tenant:    o.Tenant,
+		DevEUI:    strings.ToUpper(model.DevEUI),
+		ShortCode: strings.ToUpper(model.ShortCode),
+		Detail:    detail,
+	})
+	return ok, nil
+}
+
+func (o *Observed) StoreLastDUID(model models.LastDevEUI) (bool, error) {
+	return o.Client.StoreLastDUID(model)
+}
+
+func (o *Observed) StoreDUIDGenResponse(model models.ApiResponseCacheObject) (bool, error) {
+	return o.Client.StoreDUIDGenResponse(model)
+}
+
+func (o *Observed) ReadCache(key string) (string, bool, error) {
+	return o.Client.ReadCache(key)
+}
+
+func (o *Observed) StoreValue(key, value string) (bool, error) {
+	return o.Client.StoreValue(key, value)
+}
+
+func (o *Observed) DeleteValue(key string) (bool, error) {
+	return o.Client.DeleteValue(key)
+}
+
+func (o *Observed) ListKeys(prefix string) ([]string, error) {
+	return o.Client.ListKeys(prefix)
+}
+
+func (o *Observed) PushValues(key string, values ...string) (int64, error) {
+	return o.Client.PushValues(key, values...)
+}
+
+func (o *Observed) PopValues(key string, count int) ([]string, error) {
+	return o.Client.PopValues(key, count)
+}
+
+func (o *Observed) CountValues(key string) (int64, error) {
+	return o.Client.CountValues(key)
+}
+
+func (o *Observed) SwapValue(key, old, value string, ttl time.Duration) (bool, error) {
+	return o.Client.SwapValue(key, old, value, ttl)
+}
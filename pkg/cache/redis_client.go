@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"os"
 	"regexp"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/David-solly/mxbcode/pkg/models"
 
@@ -94,3 +96,98 @@ func (c *RedisCache) ReadCache(key string) (string, bool, error) {
 	}
 	return data, true, nil
 }
+
+func (c *RedisCache) StoreValue(key, value string) (bool, error) {
+	if c.client == nil {
+		return false, errors.New("Redis client is nil")
+	}
+	if err := c.client.Set(strings.ToUpper(key), value, 0).Err(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (c *RedisCache) DeleteValue(key string) (bool, error) {
+	n, err := c.client.Del(strings.ToUpper(key)).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// ListKeys :
+// All keys beginning with prefix - sorted
+// SCAN is used rather than KEYS to avoid blocking the server
+func (c *RedisCache) ListKeys(prefix string) ([]string, error) {
+	keys := []string{}
+	iter := c.client.Scan(0, strings.ToUpper(prefix)+"*", 100).Iterator()
+	for iter.Next() {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (c *RedisCache) PushValues(key string, values ...string) (int64, error) {
+	if len(values) == 0 {
+		return c.CountValues(key)
+	}
+	args := make([]interface{}, len(values))
+	for i, v := range values {
+		args[i] = v
+	}
+	return c.client.RPush(strings.ToUpper(key), args...).Result()
+}
+
+// PopValues :
+// Removes up to count values from the front of the queue at key
+// the read and trim run in one MULTI so concurrent pops never overlap
+func (c *RedisCache) PopValues(key string, count int) ([]string, error) {
+	if count < 1 {
+		return []string{}, nil
+	}
+	var popped *redis.StringSliceCmd
+	_, err := c.client.TxPipelined(func(pipe redis.Pipeliner) error {
+		popped = pipe.LRange(strings.ToUpper(key), 0, int64(count-1))
+		pipe.LTrim(strings.ToUpper(key), int64(count), -1)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return popped.Val(), nil
+}
+
+// sets KEYS[1] to ARGV[2] only while it holds ARGV[1]
+// ARGV[3] is the time to live in milliseconds - 0 keeps the value
+var swapScript = redis.NewScript(`
+local current = redis.call('GET', KEYS[1])
+if (current == false and ARGV[1] == '') or current == ARGV[1] then
+	if tonumber(ARGV[3]) > 0 then
+		redis.call('SET', KEYS[1], ARGV[2], 'PX', ARGV[3])
+	else
+		redis.call('SET', KEYS[1], ARGV[2])
+	end
+	return 1
+end
+return 0
+`)
+
+// SwapValue :
+// Stores value only while key still holds old - a blank old
+// means the key must not exist. Checked and set in one script
+// so replicas sharing the instance never both succeed
+func (c *RedisCache) SwapValue(key, old, value string, ttl time.Duration) (bool, error) {
+	n, err := swapScript.Run(c.client, []string{strings.ToUpper(key)}, old, value, int64(ttl/time.Millisecond)).Int64()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+func (c *RedisCache) CountValues(key string) (int64, error) {
+	return c.client.LLen(strings.ToUpper(key)).Result()
+}
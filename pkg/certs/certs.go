@@ -0,0 +1,167 @@
+package certs
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultInterval : how often the certificate files are checked for rotation
+const DefaultInterval = time.Second * 10
+
+// Reloader :
+// Serves the certificate in `CertFile` and `KeyFile` reloading it
+// once either file changes so rotated certificates are picked up
+// without a restart. A pair that fails to load - eg the certificate
+// rotated before its key - is retried while the last good one is served
+type Reloader struct {
+	CertFile string
+	KeyFile  string
+	Interval time.Duration
+	Now      func() time.Time
+
+	mutex    sync.Mutex
+	cert     *tls.Certificate
+	modified time.Time
+	checked  time.Time
+}
+
+// NewReloader : loads the certificate pair - failing if it cannot be
+func NewReloader(certFile, keyFile string) (*Reloader, error) {
+	r := &Reloader{CertFile: certFile, KeyFile: keyFile, Interval: DefaultInterval, Now: time.Now}
+	modified, err := r.lastModified()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(modified); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate :
+// The current certificate - set as `tls.Config.GetCertificate`
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.current(), nil
+}
+
+// GetClientCertificate :
+// The current certificate presented by a client -
+// set as `tls.Config.GetClientCertificate`
+func (r *Reloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return r.current(), nil
+}
+
+// the certificate to present - reloaded first when the files changed
+func (r *Reloader) current() *tls.Certificate {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if now := r.Now(); now.Sub(r.checked) >= r.Interval {
+		r.checked = now
+		if modified, err := r.lastModified(); err != nil {
+			log.Printf("tls - checking %s: %v", r.CertFile, err)
+		} else if modified.After(r.modified) {
+			if err := r.load(modified); err != nil {
+				log.Printf("tls - keeping the current certificate: %v", err)
+			} else {
+				log.Printf("tls - reloaded %s", r.CertFile)
+			}
+		}
+	}
+
+	return r.cert
+}
+
+// the later modification time of the pair
+func (r *Reloader) lastModified() (time.Time, error) {
+	modified := time.Time{}
+	for _, f := range []string{r.CertFile, r.KeyFile} {
+		info, err := os.Stat(f)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(modified) {
+			modified = info.ModTime()
+		}
+	}
+	return modified, nil
+}
+
+func (r *Reloader) load(modified time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.CertFile, r.KeyFile)
+	if err != nil {
+		return err
+	}
+	r.cert, r.modified = &cert, modified
+	return nil
+}
+
+// LoadCertPool :
+// The PEM certificates of a CA bundle
+func LoadCertPool(path string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%s holds no PEM certificates", path)
+	}
+	return pool, nil
+}
+
+// SubjectMap :
+// Tenants of client certificates keyed by certificate subject
+type SubjectMap map[string]string
+
+// LoadSubjectMap :
+// Reads a file of `<tenant> <subject>` lines eg `acme CN=station-1,O=Acme`.
+// A subject is the RFC 2253 distinguished name of the certificate or
+// just its `CN=` - blank lines and lines starting with `#` are ignored
+func LoadSubjectMap(path string) (SubjectMap, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	m := SubjectMap{}
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		split := strings.IndexAny(entry, " \t")
+		if split < 0 {
+			return nil, fmt.Errorf("%s:%d: expected a tenant and a subject", path, line)
+		}
+		m[strings.TrimSpace(entry[split:])] = strings.ToLower(entry[:split])
+	}
+
+	return m, scanner.Err()
+}
+
+// Tenant :
+// The tenant of a client certificate - its full subject is
+// looked up before its common name
+func (m SubjectMap) Tenant(cert *x509.Certificate) (string, bool) {
+	if t, k := m[cert.Subject.String()]; k {
+		return t, true
+	}
+	if cert.Subject.CommonName == "" {
+		return "", false
+	}
+	t, k := m["CN="+cert.Subject.CommonName]
+	return t, k
+}
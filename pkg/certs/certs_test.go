@@ -0,0 +1,169 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/pkg/testutil/assert"
+)
+
+// a certificate for cn signed by parent - self signed when parent is nil
+func issue(t *testing.T, cn string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, []byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NilError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn, Organization: []string{"Acme"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  parent == nil,
+		BasicConstraintsValid: true,
+		DNSNames:              []string{cn},
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	assert.NilError(t, err)
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	return cert, key,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// writes data to path dated `at` so rotations are seen
+// even where file times are only kept to the second
+func writeAt(t *testing.T, path string, data []byte, at time.Time) {
+	assert.NilError(t, ioutil.WriteFile(path, data, 0600))
+	assert.NilError(t, os.Chtimes(path, at, at))
+}
+
+func TestReloader(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "certs")
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+
+	_, err := NewReloader(certFile, keyFile)
+	assert.Error(t, err, "no such file")
+
+	start := time.Now().Add(-time.Hour)
+	first, _, certPEM, keyPEM := issue(t, "first", nil, nil)
+	writeAt(t, certFile, certPEM, start)
+	writeAt(t, keyFile, keyPEM, start)
+
+	r, err := NewReloader(certFile, keyFile)
+	assert.NilError(t, err)
+	r.Interval = 0
+
+	serving := func() string {
+		cert, err := r.GetCertificate(nil)
+		assert.NilError(t, err)
+		leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+		return leaf.Subject.CommonName
+	}
+	assert.Equal(t, serving(), first.Subject.CommonName)
+
+	suite := []struct {
+		testName  string
+		cert, key bool
+		want      string
+	}{
+		{"RELOAD - rotated pair", true, true, "rotated-0"},
+		{"RELOAD - certificate ahead of its key", true, false, "rotated-0"},
+		{"RELOAD - key catches up", false, true, "rotated-1"},
+	}
+	var pending []byte
+	for i, test := range suite {
+		t.Run(fmt.Sprintf("#%d: %q", i, test.testName), func(t *testing.T) {
+			at := start.Add(time.Duration(i+1) * time.Minute)
+			_, _, certPEM, keyPEM := issue(t, fmt.Sprintf("rotated-%d", i), nil, nil)
+			if test.cert {
+				writeAt(t, certFile, certPEM, at)
+				pending = keyPEM
+			}
+			if test.key {
+				writeAt(t, keyFile, pending, at)
+			}
+			assert.Equal(t, serving(), test.want)
+		})
+	}
+
+	t.Run("RELOAD - checked once an interval", func(t *testing.T) {
+		now := time.Now()
+		r.Interval, r.Now = time.Minute, func() time.Time { return now }
+		serving()
+		_, _, certPEM, keyPEM := issue(t, "later", nil, nil)
+		writeAt(t, certFile, certPEM, start.Add(time.Hour))
+		writeAt(t, keyFile, keyPEM, start.Add(time.Hour))
+		assert.Equal(t, serving(), "rotated-1")
+
+		now = now.Add(time.Minute)
+		assert.Equal(t, serving(), "later")
+	})
+}
+
+func TestLoadCertPool(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "certs")
+	defer os.RemoveAll(dir)
+
+	ca, caKey, caPEM, _ := issue(t, "factory-ca", nil, nil)
+	client, _, _, _ := issue(t, "station-1", ca, caKey)
+	bundle := filepath.Join(dir, "ca.pem")
+	ioutil.WriteFile(bundle, caPEM, 0600)
+
+	pool, err := LoadCertPool(bundle)
+	assert.NilError(t, err)
+	_, err = client.Verify(x509.VerifyOptions{Roots: pool, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}})
+	assert.NilError(t, err)
+
+	empty := filepath.Join(dir, "empty.pem")
+	ioutil.WriteFile(empty, []byte("not a certificate"), 0600)
+	_, err = LoadCertPool(empty)
+	assert.Error(t, err, "holds no PEM certificates")
+}
+
+func TestSubjectMap(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "certs")
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "tenants")
+	ioutil.WriteFile(path, []byte("# stations\nAcme CN=station-1,O=Acme\n\nglobex\tCN=station-2\n"), 0600)
+	m, err := LoadSubjectMap(path)
+	assert.NilError(t, err)
+
+	suite := []struct {
+		testName string
+		cn       string
+		tenant   string
+		found    bool
+	}{
+		{"SUBJECT - distinguished name", "station-1", "acme", true},
+		{"SUBJECT - common name", "station-2", "globex", true},
+		{"SUBJECT - unmapped", "station-3", "", false},
+	}
+	for i, test := range suite {
+		t.Run(fmt.Sprintf("#%d: %q", i, test.testName), func(t *testing.T) {
+			cert, _, _, _ := issue(t, test.cn, nil, nil)
+			tenant, found := m.Tenant(cert)
+			assert.Equal(t, tenant, test.tenant)
+			assert.Equal(t, found, test.found)
+		})
+	}
+
+	ioutil.WriteFile(path, []byte("acme\n"), 0600)
+	_, err = LoadSubjectMap(path)
+	assert.Error(t, err, "tenants:1: expected a tenant and a subject")
+}
@@ -0,0 +1,157 @@
+package claim
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/David-solly/mxbcode/pkg/cache"
+	"github.com/David-solly/mxbcode/pkg/models"
+)
+
+// KeyPrefix :
+// Prefix of claim records in the cache store
+// records are keyed `CLAIM:<SERIAL>`
+const KeyPrefix = "CLAIM:"
+
+// DevicePrefix :
+// Prefix of the reverse index from DevEUI to serial
+// keyed `CLAIM-DEV:<DEVEUI>`
+const DevicePrefix = "CLAIM-DEV:"
+
+// SourceFunc :
+// Supplies the next registered DevEUI to bind to a serial
+type SourceFunc func() (string, error)
+
+var validSerial = regexp.MustCompile(`^[a-zA-Z0-9._:-]{1,64}$`)
+
+var validDevEUI = regexp.MustCompile(`^[a-fA-F0-9]{16}$`)
+
+// value of a claim record while its device is drawn and registered
+// followed by a nonce so each claimer only finalises its own
+const pendingPrefix = "pending:"
+
+// how long a serial is marked pending while its device is drawn
+// a claimer that died lets the serial be claimed again after this long
+const pendingTTL = 5 * time.Minute
+
+// ErrInProgress :
+// Another claim of the serial is still drawing its device
+var ErrInProgress = errors.New("a claim of this serial is in progress - retry shortly")
+
+// Claim :
+// Binds serial to the next DevEUI from `next` exactly once.
+// Repeating a claim returns the original binding - the bool
+// reports whether this call created it.
+// The serial is marked pending in the store before a device is drawn so
+// claims racing on any replica never both draw one - the loser gets
+// `ErrInProgress` until the binding is written.
+// Serials are matched case-insensitively like every other key in the store
+func Claim(c cache.Service, serial string, next SourceFunc) (models.Claim, bool, error) {
+	if !validSerial.MatchString(serial) {
+		return models.Claim{}, false, fmt.Errorf("invalid serial %q", serial)
+	}
+
+	marker := fmt.Sprintf("%s%d", pendingPrefix, time.Now().UnixNano())
+	marked, err := c.SwapValue(KeyPrefix+serial, "", marker, pendingTTL)
+	if err != nil {
+		return models.Claim{}, false, err
+	}
+	if !marked {
+		existing, err := Get(c, serial)
+		if err == nil && existing == nil {
+			err = ErrInProgress
+		}
+		if err != nil {
+			return models.Claim{}, false, err
+		}
+		return *existing, false, nil
+	}
+
+	cl, err := bind(c, serial, marker, next)
+	if err != nil {
+		// give the serial back unless the mark already expired
+		if current, _, _ := c.ReadCache(KeyPrefix + serial); current == marker {
+			c.DeleteValue(KeyPrefix + serial)
+		}
+		return models.Claim{}, false, err
+	}
+	return cl, true, nil
+}
+
+// draws the device for a serial marked pending with marker and binds it
+func bind(c cache.Service, serial, marker string, next SourceFunc) (models.Claim, error) {
+	deveui, err := next()
+	if err != nil {
+		return models.Claim{}, err
+	}
+	deveui = strings.ToUpper(deveui)
+	if !validDevEUI.MatchString(deveui) {
+		return models.Claim{}, fmt.Errorf("invalid DevEUI %q supplied for serial %q", deveui, serial)
+	}
+
+	// the reverse index is taken atomically so a device is never bound twice
+	indexed, err := c.SwapValue(DevicePrefix+deveui, "", serial, 0)
+	if err != nil {
+		return models.Claim{}, err
+	}
+	if !indexed {
+		owner, _, _ := c.ReadCache(DevicePrefix + deveui)
+		return models.Claim{}, fmt.Errorf("DevEUI %s is already bound to serial %q", deveui, owner)
+	}
+
+	cl := models.Claim{Serial: serial, DevEUI: deveui, ShortCode: deveui[11:], Created: time.Now().UTC()}
+	data, _ := json.Marshal(cl)
+	bound, err := c.SwapValue(KeyPrefix+serial, marker, string(data), 0)
+	if err == nil && !bound {
+		err = fmt.Errorf("claim of serial %q expired before its device was bound", serial)
+	}
+	if err != nil {
+		c.DeleteValue(DevicePrefix + deveui)
+		return models.Claim{}, err
+	}
+
+	// stored again so both records are kept as durably as any other value
+	if _, err := c.StoreValue(DevicePrefix+deveui, serial); err != nil {
+		return models.Claim{}, err
+	}
+	if _, err := c.StoreValue(KeyPrefix+serial, string(data)); err != nil {
+		return models.Claim{}, err
+	}
+	return cl, nil
+}
+
+// Get :
+// The claim made by serial - nil if it has not claimed a device
+// or its claim is still pending
+func Get(c cache.Service, serial string) (*models.Claim, error) {
+	data, found, _ := c.ReadCache(KeyPrefix + serial)
+	if !found || strings.HasPrefix(data, pendingPrefix) {
+		return nil, nil
+	}
+	cl := models.Claim{}
+	if err := json.Unmarshal([]byte(data), &cl); err != nil {
+		return nil, fmt.Errorf("corrupt claim for %q - %v", serial, err)
+	}
+	return &cl, nil
+}
+
+// ForDevEUI :
+// The claim binding deveui to a serial - nil if it is unclaimed
+func ForDevEUI(c cache.Service, deveui string) (*models.Claim, error) {
+	if !validDevEUI.MatchString(deveui) {
+		return nil, fmt.Errorf("invalid DevEUI %q", deveui)
+	}
+	serial, found, _ := c.ReadCache(DevicePrefix + strings.ToUpper(deveui))
+	if !found {
+		return nil, nil
+	}
+	cl, err := Get(c, serial)
+	if err == nil && cl == nil {
+		err = errors.New("claim of serial " + serial + " is missing")
+	}
+	return cl, err
+}
@@ -0,0 +1,149 @@
+package claim
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/David-solly/mxbcode/pkg/cache"
+
+	"github.com/docker/docker/pkg/testutil/assert"
+)
+
+func TestClaims(t *testing.T) {
+	c := cache.Cache{}
+	c.Initialise("", false)
+
+	devices := []string{"00000000001000a1", "00000000001000A2", "00000000001000A2", "0000"}
+	drawn := 0
+	next := func() (string, error) {
+		if drawn == len(devices) {
+			return "", errors.New("no devices left")
+		}
+		drawn++
+		return devices[drawn-1], nil
+	}
+
+	t.Run("CLAIM serials", func(t *testing.T) {
+		suite := []struct {
+			testName string
+			serial   string
+			deveui   string
+			created  bool
+			drawn    int
+			err      string
+		}{
+			{"CLAIM - ", "SN-0001", "00000000001000A1", true, 1, ""},
+			{"CLAIM - repeat", "SN-0001", "00000000001000A1", false, 1, ""},
+			{"CLAIM - repeat lower case", "sn-0001", "00000000001000A1", false, 1, ""},
+			{"CLAIM - second", "SN-0002", "00000000001000A2", true, 2, ""},
+			{"CLAIM - device already bound", "SN-0003", "", false, 3, "already bound to serial \"SN-0002\""},
+			{"CLAIM - invalid device", "SN-0004", "", false, 4, "invalid DevEUI"},
+			{"CLAIM - source exhausted", "SN-0005", "", false, 4, "no devices left"},
+			{"CLAIM - invalid serial", "SN 0006", "", false, 4, "invalid serial"},
+		}
+		for i, test := range suite {
+			t.Run(fmt.Sprintf("#%d: %q", i, test.testName), func(t *testing.T) {
+				cl, created, err := Claim(c.Client, test.serial, next)
+				assert.Equal(t, drawn, test.drawn)
+				if test.err != "" {
+					assert.Error(t, err, test.err)
+					return
+				}
+				assert.NilError(t, err)
+				assert.Equal(t, created, test.created)
+				assert.Equal(t, cl.DevEUI, test.deveui)
+				assert.Equal(t, cl.ShortCode, test.deveui[11:])
+			})
+		}
+	})
+
+	t.Run("LOOKUP claims", func(t *testing.T) {
+		cl, err := Get(c.Client, "SN-0002")
+		assert.NilError(t, err)
+		assert.Equal(t, cl.DevEUI, "00000000001000A2")
+
+		cl, err = Get(c.Client, "SN-0003")
+		assert.NilError(t, err)
+		assert.Equal(t, cl == nil, true)
+
+		cl, err = ForDevEUI(c.Client, "00000000001000a1")
+		assert.NilError(t, err)
+		assert.Equal(t, cl.Serial, "SN-0001")
+
+		cl, err = ForDevEUI(c.Client, "00000000001000A3")
+		assert.NilError(t, err)
+		assert.Equal(t, cl == nil, true)
+
+		_, err = ForDevEUI(c.Client, "A3")
+		assert.Error(t, err, "invalid DevEUI")
+	})
+}
+
+func TestClaimConcurrently(t *testing.T) {
+	c := cache.Cache{}
+	c.Initialise("", false)
+
+	// a slow registration so every claim overlaps the first
+	var drawn int64
+	next := func() (string, error) {
+		n := atomic.AddInt64(&drawn, 1)
+		time.Sleep(10 * time.Millisecond)
+		return fmt.Sprintf("00000000002%05X", n), nil
+	}
+
+	var created, pending int64
+	bound := make(chan string, 20)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cl, isNew, err := Claim(c.Client, "SN-RACE", next)
+			switch {
+			case err == ErrInProgress:
+				atomic.AddInt64(&pending, 1)
+			case err != nil:
+				t.Error(err)
+			default:
+				if isNew {
+					atomic.AddInt64(&created, 1)
+				}
+				bound <- cl.DevEUI
+			}
+		}()
+	}
+	wg.Wait()
+	close(bound)
+
+	assert.Equal(t, atomic.LoadInt64(&drawn), int64(1))
+	assert.Equal(t, created, int64(1))
+	answered := pending
+	for deveui := range bound {
+		assert.Equal(t, deveui, "0000000000200001")
+		answered++
+	}
+	assert.Equal(t, answered, int64(20))
+
+	// once bound every repeat returns the binding
+	cl, isNew, err := Claim(c.Client, "SN-RACE", next)
+	assert.NilError(t, err)
+	assert.Equal(t, isNew, false)
+	assert.Equal(t, cl.DevEUI, "0000000000200001")
+}
+
+func TestClaimFailureReleasesSerial(t *testing.T) {
+	c := cache.Cache{}
+	c.Initialise("", false)
+
+	_, _, err := Claim(c.Client, "SN-RETRY", func() (string, error) { return "", errors.New("registrar down") })
+	assert.Error(t, err, "registrar down")
+
+	cl, created, err := Claim(c.Client, "SN-RETRY", func() (string, error) { return "00000000003000A1", nil })
+	assert.NilError(t, err)
+	assert.Equal(t, created, true)
+	assert.Equal(t, cl.DevEUI, "00000000003000A1")
+}
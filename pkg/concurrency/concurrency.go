@@ -0,0 +1,138 @@
+package concurrency
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// defaults of a limiter
+const (
+	// the limit is multiplied by this when the provider is overloaded
+	DefaultBackoff = 0.5
+
+	// a response this many times slower than usual is a latency spike
+	DefaultTolerance = 3.0
+)
+
+// weight of the latest response in the usual latency
+const smoothing = 0.2
+
+// Limiter :
+// Adapts the requests allowed in flight to how the provider copes - AIMD.
+// Every healthy response adds 1/limit so the limit grows by one a round
+// of requests. A 429, 5xx, failed request or latency spike multiplies it
+// by `Backoff` - once a round as the requests already in flight answer
+// to the same overload. The limit stays between `Min` and `Max`.
+// A spike is a response slower than `Latency` or, without it,
+// `Tolerance` times the usual latency
+type Limiter struct {
+	Min       int
+	Max       int
+	Backoff   float64
+	Tolerance float64
+	Latency   time.Duration
+
+	// the current time - replaced in tests
+	Now func() time.Time
+
+	mutex     sync.Mutex
+	available *sync.Cond
+	limit     float64
+	inFlight  int
+	usual     time.Duration
+	decreased time.Time
+	backoffs  int64
+}
+
+// Stats : the state of a limiter
+type Stats struct {
+	Limit    int           `json:"limit"`
+	Max      int           `json:"max"`
+	InFlight int           `json:"in_flight"`
+	Backoffs int64         `json:"backoffs"`
+	Latency  time.Duration `json:"latency"`
+}
+
+// New :
+// A limiter of at most max requests in flight
+// starting from half of them
+func New(max int) *Limiter {
+	if max < 1 {
+		max = 1
+	}
+	l := &Limiter{Min: 1, Max: max, Backoff: DefaultBackoff, Tolerance: DefaultTolerance, Now: time.Now}
+	l.available = sync.NewCond(&l.mutex)
+	l.limit = math.Max(1, math.Ceil(float64(max)/2))
+	return l
+}
+
+// Acquire :
+// Waits until another request may be sent.
+// The start time is handed back to Release
+func (l *Limiter) Acquire() time.Time {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for l.inFlight >= int(l.limit) {
+		l.available.Wait()
+	}
+	l.inFlight++
+	return l.Now()
+}
+
+// Release :
+// Adapts the limit to the outcome of a request started
+// at `started` - the status code or the error sending it
+func (l *Limiter) Release(started time.Time, code int, err error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	defer l.available.Broadcast()
+	l.inFlight--
+
+	now := l.Now()
+	latency := now.Sub(started)
+	overloaded := err != nil || code == 429 || code >= 500 || l.spike(latency)
+	switch {
+	case err != nil:
+	case l.usual == 0:
+		l.usual = latency
+	default:
+		// a provider that stays slower becomes the usual
+		l.usual = time.Duration(smoothing*float64(latency) + (1-smoothing)*float64(l.usual))
+	}
+
+	if !overloaded {
+		l.limit = math.Min(float64(l.Max), l.limit+1/l.limit)
+		return
+	}
+
+	// requests sent before the last backoff already paid for this overload
+	if started.Before(l.decreased) {
+		return
+	}
+	l.limit = math.Max(float64(l.Min), math.Floor(l.limit*l.Backoff))
+	l.decreased = now
+	l.backoffs++
+}
+
+// latency well above what the provider usually takes
+func (l *Limiter) spike(latency time.Duration) bool {
+	if l.Latency > 0 {
+		return latency > l.Latency
+	}
+	return l.usual > 0 && float64(latency) > l.Tolerance*float64(l.usual)
+}
+
+// Limit : the requests allowed in flight
+func (l *Limiter) Limit() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return int(l.limit)
+}
+
+// Stats : the current limit, requests in flight and backoffs so far
+func (l *Limiter) Stats() Stats {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return Stats{Limit: int(l.limit), Max: l.Max, InFlight: l.inFlight, Backoffs: l.backoffs, Latency: l.usual}
+}
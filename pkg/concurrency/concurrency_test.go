@@ -0,0 +1,130 @@
+package concurrency
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/pkg/testutil/assert"
+)
+
+// a limiter on a clock moved by hand
+func manual(max int) (*Limiter, *time.Time) {
+	now := time.Unix(1600000000, 0)
+	l := New(max)
+	l.Now = func() time.Time { return now }
+	return l, &now
+}
+
+func TestLimiter(t *testing.T) {
+	t.Run("AIMD - starts from half and grows to max", func(t *testing.T) {
+		l, now := manual(10)
+		assert.Equal(t, l.Limit(), 5)
+		for i := 0; i < 200; i++ {
+			started := l.Acquire()
+			*now = now.Add(10 * time.Millisecond)
+			l.Release(started, 200, nil)
+		}
+		assert.Equal(t, l.Limit(), 10)
+		assert.Equal(t, l.Stats().Latency, 10*time.Millisecond)
+	})
+
+	suite := []struct {
+		testName string
+		code     int
+		err      error
+		latency  time.Duration
+		want     int
+	}{
+		{"AIMD - healthy", 200, nil, 10 * time.Millisecond, 8},
+		{"AIMD - refused device", 422, nil, 10 * time.Millisecond, 8},
+		{"AIMD - too many requests", 429, nil, 10 * time.Millisecond, 4},
+		{"AIMD - server error", 503, nil, 10 * time.Millisecond, 4},
+		{"AIMD - request failed", 0, errors.New("connection refused"), 10 * time.Millisecond, 4},
+		{"AIMD - latency spike", 200, nil, 50 * time.Millisecond, 4},
+		{"AIMD - slower but not a spike", 200, nil, 25 * time.Millisecond, 8},
+	}
+	for i, test := range suite {
+		t.Run(fmt.Sprintf("#%d: %q", i, test.testName), func(t *testing.T) {
+			l, now := manual(10)
+			l.limit = 8
+			l.usual = 10 * time.Millisecond
+
+			started := l.Acquire()
+			*now = now.Add(test.latency)
+			l.Release(started, test.code, test.err)
+			assert.Equal(t, l.Limit(), test.want)
+		})
+	}
+
+	t.Run("AIMD - backs off once a round", func(t *testing.T) {
+		l, now := manual(10)
+		l.limit = 8
+
+		// every request in flight answers to the same overload
+		started := []time.Time{}
+		for i := 0; i < 8; i++ {
+			started = append(started, l.Acquire())
+		}
+		*now = now.Add(10 * time.Millisecond)
+		for _, s := range started {
+			l.Release(s, 503, nil)
+		}
+		assert.Equal(t, l.Limit(), 4)
+		assert.Equal(t, l.Stats().Backoffs, int64(1))
+
+		// the next round backs off again down to the minimum
+		for i := 0; i < 2; i++ {
+			s := l.Acquire()
+			*now = now.Add(10 * time.Millisecond)
+			l.Release(s, 429, nil)
+		}
+		assert.Equal(t, l.Limit(), 1)
+		assert.Equal(t, l.Stats().Backoffs, int64(3))
+	})
+
+	t.Run("AIMD - fixed latency threshold", func(t *testing.T) {
+		l, now := manual(10)
+		l.Latency = 100 * time.Millisecond
+		s := l.Acquire()
+		*now = now.Add(90 * time.Millisecond)
+		l.Release(s, 200, nil)
+		assert.Equal(t, l.Stats().Backoffs, int64(0))
+
+		s = l.Acquire()
+		*now = now.Add(110 * time.Millisecond)
+		l.Release(s, 200, nil)
+		assert.Equal(t, l.Stats().Backoffs, int64(1))
+	})
+}
+
+func TestLimiterBoundsInFlight(t *testing.T) {
+	l := New(4)
+	var inFlight, most int
+	m := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			started := l.Acquire()
+			m.Lock()
+			if inFlight++; inFlight > most {
+				most = inFlight
+			}
+			m.Unlock()
+
+			time.Sleep(time.Millisecond)
+
+			m.Lock()
+			inFlight--
+			m.Unlock()
+			l.Release(started, 200, nil)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, most <= 4, true)
+	assert.Equal(t, l.Stats().InFlight, 0)
+}
@@ -0,0 +1,166 @@
+package events
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/David-solly/mxbcode/pkg/models"
+)
+
+// event types
+const (
+	Generated  = "generated"
+	Registered = "registered"
+	Failed     = "failed"
+	Stored     = "stored"
+	Conflict   = "conflict"
+)
+
+// Dropped :
+// Notice to a subscriber that fell behind - Count events were lost.
+// Never published
+const Dropped = "dropped"
+
+// Types : every event type that is published
+var Types = []string{Generated, Registered, Failed, Stored, Conflict}
+
+// DefaultBuffer : events held for a subscriber before new ones are dropped
+const DefaultBuffer = 256
+
+// Filter :
+// Selects the events a subscriber receives
+// empty fields match everything - Shared narrows to the shared namespace
+type Filter struct {
+	Types  []string
+	Tenant string
+	Shared bool
+}
+
+// ParseTypes :
+// A comma separated list of event types - unknown types are an error
+func ParseTypes(list string) ([]string, error) {
+	var types []string
+	for _, t := range strings.Split(list, ",") {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" {
+			continue
+		}
+		if !known(t) {
+			return nil, fmt.Errorf("unknown event type %q - expected one of %s", t, strings.Join(Types, ", "))
+		}
+		types = append(types, t)
+	}
+	return types, nil
+}
+
+func known(t string) bool {
+	for _, k := range Types {
+		if k == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Match : reports whether e passes the filter
+func (f Filter) Match(e models.Event) bool {
+	if f.Tenant != "" && !strings.EqualFold(f.Tenant, e.Tenant) {
+		return false
+	}
+	if f.Shared && e.Tenant != "" {
+		return false
+	}
+	if len(f.Types) == 0 {
+		return true
+	}
+	for _, t := range f.Types {
+		if t == e.Type {
+			return true
+		}
+	}
+	return false
+}
+
+// Bus :
+// Fans published events out to subscribers.
+// Publishing never blocks - a subscriber that falls behind
+// loses events rather than stalling provisioning
+type Bus struct {
+	mutex sync.RWMutex
+	subs  map[*Subscription]struct{}
+}
+
+// NewBus : a bus without subscribers
+func NewBus() *Bus {
+	return &Bus{subs: map[*Subscription]struct{}{}}
+}
+
+// Subscription :
+// Events matching the filter arrive on C until Close is called
+type Subscription struct {
+	C <-chan models.Event
+
+	c       chan models.Event
+	filter  Filter
+	bus     *Bus
+	dropped int64
+	once    sync.Once
+}
+
+// Subscribe :
+// Receives the events matching f - buffer of 0 uses DefaultBuffer
+func (b *Bus) Subscribe(f Filter, buffer int) *Subscription {
+	if buffer < 1 {
+		buffer = DefaultBuffer
+	}
+	c := make(chan models.Event, buffer)
+	s := &Subscription{C: c, c: c, filter: f, bus: b}
+	b.mutex.Lock()
+	b.subs[s] = struct{}{}
+	b.mutex.Unlock()
+	return s
+}
+
+// Publish : delivers e to every matching subscriber
+func (b *Bus) Publish(e models.Event) {
+	if b == nil {
+		return
+	}
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	for s := range b.subs {
+		if !s.filter.Match(e) {
+			continue
+		}
+		select {
+		case s.c <- e:
+		default:
+			atomic.AddInt64(&s.dropped, 1)
+		}
+	}
+}
+
+// Subscribers : the number of open subscriptions
+func (b *Bus) Subscribers() int {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return len(b.subs)
+}
+
+// Dropped :
+// Events lost because the subscriber fell behind since the last call
+func (s *Subscription) Dropped() int64 {
+	return atomic.SwapInt64(&s.dropped, 0)
+}
+
+// Close : stops delivery and closes C
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		s.bus.mutex.Lock()
+		delete(s.bus.subs, s)
+		s.bus.mutex.Unlock()
+		close(s.c)
+	})
+}
@@ -0,0 +1,70 @@
+package events
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/David-solly/mxbcode/pkg/models"
+	"github.com/docker/docker/pkg/testutil/assert"
+)
+
+func TestFilter(t *testing.T) {
+	suite := []struct {
+		testName string
+		filter   Filter
+		event    models.Event
+		want     bool
+	}{
+		{"FILTER - empty matches all", Filter{}, models.Event{Type: Stored, Tenant: "acme"}, true},
+		{"FILTER - type match", Filter{Types: []string{Registered, Failed}}, models.Event{Type: Failed}, true},
+		{"FILTER - type miss", Filter{Types: []string{Registered}}, models.Event{Type: Generated}, false},
+		{"FILTER - tenant match", Filter{Tenant: "ACME"}, models.Event{Type: Stored, Tenant: "acme"}, true},
+		{"FILTER - tenant miss", Filter{Tenant: "acme"}, models.Event{Type: Stored}, false},
+		{"FILTER - shared match", Filter{Shared: true}, models.Event{Type: Stored}, true},
+		{"FILTER - shared miss", Filter{Shared: true}, models.Event{Type: Stored, Tenant: "acme"}, false},
+	}
+	for i, test := range suite {
+		t.Run(fmt.Sprintf("#%d: %q", i, test.testName), func(t *testing.T) {
+			assert.Equal(t, test.filter.Match(test.event), test.want)
+		})
+	}
+}
+
+func TestParseTypes(t *testing.T) {
+	types, err := ParseTypes(" Registered,failed,, ")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, types, []string{Registered, Failed})
+
+	_, err = ParseTypes("registered,exploded")
+	assert.Error(t, err, `unknown event type "exploded"`)
+}
+
+func TestBus(t *testing.T) {
+	b := NewBus()
+	all := b.Subscribe(Filter{}, 0)
+	slow := b.Subscribe(Filter{Types: []string{Registered}}, 1)
+	assert.Equal(t, b.Subscribers(), 2)
+
+	b.Publish(models.Event{Type: Generated, Count: 2})
+	b.Publish(models.Event{Type: Registered, DevEUI: "00000000000ABCDE"})
+	b.Publish(models.Event{Type: Registered, DevEUI: "00000000000ABCDF"})
+
+	assert.Equal(t, (<-all.C).Type, Generated)
+	assert.Equal(t, (<-all.C).DevEUI, "00000000000ABCDE")
+	assert.Equal(t, (<-all.C).DevEUI, "00000000000ABCDF")
+	assert.Equal(t, all.Dropped(), int64(0))
+
+	// the slow subscriber kept the first and lost the second
+	assert.Equal(t, (<-slow.C).DevEUI, "00000000000ABCDE")
+	assert.Equal(t, slow.Dropped(), int64(1))
+	assert.Equal(t, slow.Dropped(), int64(0))
+
+	slow.Close()
+	slow.Close()
+	_, open := <-slow.C
+	assert.Equal(t, open, false)
+	assert.Equal(t, b.Subscribers(), 1)
+
+	var nilBus *Bus
+	nilBus.Publish(models.Event{Type: Stored})
+}
@@ -0,0 +1,156 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/David-solly/mxbcode/pkg/models"
+)
+
+// Row :
+// A device as it is exported - Keys is nil unless
+// root keys were asked for and are held
+type Row struct {
+	models.Device
+	Serial string
+	Keys   *models.RootKeys
+}
+
+// Options :
+// How devices are written. Columns picks the fields of the csv
+// and ndjson formats. The remaining fields fill in what the
+// network server import needs beyond the device itself
+type Options struct {
+	Format          string
+	Columns         []string
+	ApplicationID   string
+	DeviceProfileID string
+	FrequencyPlan   string
+}
+
+// the value of every column of a row
+var columns = map[string]func(r Row) string{
+	"deveui":    func(r Row) string { return r.DevEUI },
+	"shortcode": func(r Row) string { return r.ShortCode },
+	"batch":     func(r Row) string { return r.Batch },
+	"registered": func(r Row) string {
+		if r.Registered.IsZero() {
+			return ""
+		}
+		return r.Registered.UTC().Format(time.RFC3339)
+	},
+	"serial":  func(r Row) string { return r.Serial },
+	"joineui": func(r Row) string { return keyField(r, func(k *models.RootKeys) string { return k.JoinEUI }) },
+	"appkey":  func(r Row) string { return keyField(r, func(k *models.RootKeys) string { return k.AppKey }) },
+	"nwkkey":  func(r Row) string { return keyField(r, func(k *models.RootKeys) string { return k.NwkKey }) },
+}
+
+// DefaultColumns : the csv and ndjson fields unless others are picked
+var DefaultColumns = []string{"deveui", "shortcode", "batch", "registered", "serial"}
+
+// KeyColumns : fields holding root key material
+var KeyColumns = []string{"joineui", "appkey", "nwkkey"}
+
+// writers of each format
+var formats = map[string]func(w io.Writer, rows []Row, opts Options) error{
+	"csv":        writeCSV,
+	"ndjson":     writeNDJSON,
+	"chirpstack": writeChirpStack,
+	"tts":        writeTTS,
+}
+
+// Formats : the supported formats sorted by name
+func Formats() []string {
+	names := []string{}
+	for n := range formats {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ContentType : media type of a format
+func ContentType(format string) string {
+	switch format {
+	case "ndjson", "tts":
+		return "application/x-ndjson"
+	}
+	return "text/csv"
+}
+
+// Validate : checks the format and columns are known
+func (o Options) Validate() error {
+	if _, k := formats[o.Format]; !k {
+		return fmt.Errorf("unknown export format %q - one of %s", o.Format, strings.Join(Formats(), ", "))
+	}
+	for _, c := range o.Columns {
+		if _, k := columns[c]; !k {
+			return fmt.Errorf("unknown export column %q", c)
+		}
+	}
+	return nil
+}
+
+// ParseColumns : a comma separated list of columns - blank for the defaults
+func ParseColumns(s string) []string {
+	list := []string{}
+	for _, c := range strings.Split(s, ",") {
+		if c = strings.ToLower(strings.TrimSpace(c)); c != "" {
+			list = append(list, c)
+		}
+	}
+	return list
+}
+
+// Write : the rows in the format of opts
+func Write(w io.Writer, rows []Row, opts Options) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	if len(opts.Columns) == 0 {
+		opts.Columns = DefaultColumns
+	}
+	return formats[opts.Format](w, rows, opts)
+}
+
+// header row followed by a line per device
+func writeCSV(w io.Writer, rows []Row, opts Options) error {
+	cw := csv.NewWriter(w)
+	cw.Write(opts.Columns)
+	for _, r := range rows {
+		record := []string{}
+		for _, c := range opts.Columns {
+			record = append(record, columns[c](r))
+		}
+		cw.Write(record)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// a json object per line - fields ordered by name
+func writeNDJSON(w io.Writer, rows []Row, opts Options) error {
+	enc := json.NewEncoder(w)
+	for _, r := range rows {
+		obj := map[string]string{}
+		for _, c := range opts.Columns {
+			obj[c] = columns[c](r)
+		}
+		if err := enc.Encode(obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func keyField(r Row, field func(k *models.RootKeys) string) string {
+	if r.Keys == nil {
+		return ""
+	}
+	return field(r.Keys)
+}
@@ -0,0 +1,95 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/David-solly/mxbcode/pkg/models"
+
+	"github.com/docker/docker/pkg/testutil/assert"
+)
+
+var rows = []Row{
+	{Device: models.Device{DevEUI: "000000000000A0A1", ShortCode: "0A0A1", Batch: "b1", Registered: time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)}, Serial: "LINE-1"},
+	{Device: models.Device{DevEUI: "000000000000A0A2", ShortCode: "0A0A2"}, Keys: &models.RootKeys{DevEUI: "000000000000A0A2", JoinEUI: "70B3D57ED0000000", AppKey: "000102030405060708090A0B0C0D0E0F"}},
+	{Device: models.Device{DevEUI: "000000000000A0A3", ShortCode: "0A0A3"}, Keys: &models.RootKeys{DevEUI: "000000000000A0A3", JoinEUI: "70B3D57ED0000000", AppKey: "000102030405060708090A0B0C0D0E0F", NwkKey: "0F0E0D0C0B0A09080706050403020100"}},
+}
+
+func TestWrite(t *testing.T) {
+	suite := []struct {
+		testName string
+		opts     Options
+		want     []string
+		err      string
+	}{
+		{"CSV - default columns", Options{Format: "csv"}, []string{
+			"deveui,shortcode,batch,registered,serial",
+			"000000000000A0A1,0A0A1,b1,2026-10-19T12:00:00Z,LINE-1",
+			"000000000000A0A2,0A0A2,,,",
+		}, ""},
+		{"CSV - columns", Options{Format: "csv", Columns: ParseColumns(" shortcode , AppKey,")}, []string{
+			"shortcode,appkey",
+			"0A0A1,",
+			"0A0A2,000102030405060708090A0B0C0D0E0F",
+		}, ""},
+		{"NDJSON - columns", Options{Format: "ndjson", Columns: []string{"deveui", "joineui"}}, []string{
+			`{"deveui":"000000000000A0A1","joineui":""}`,
+			`{"deveui":"000000000000A0A2","joineui":"70B3D57ED0000000"}`,
+		}, ""},
+		{"CHIRPSTACK - 1.0 appkey in nwk_key", Options{Format: "chirpstack", ApplicationID: "app", DeviceProfileID: "prof"}, []string{
+			"dev_eui,join_eui,nwk_key,app_key,name,description,application_id,device_profile_id",
+			"000000000000a0a1,0000000000000000,,,0A0A1,\"serial LINE-1, batch b1\",app,prof",
+			"000000000000a0a2,70b3d57ed0000000,000102030405060708090a0b0c0d0e0f,,0A0A2,,app,prof",
+			"000000000000a0a3,70b3d57ed0000000,0f0e0d0c0b0a09080706050403020100,000102030405060708090a0b0c0d0e0f,0A0A3,,app,prof",
+		}, ""},
+		{"FORMAT - unknown", Options{Format: "xml"}, nil, "one of chirpstack, csv, ndjson, tts"},
+		{"COLUMN - unknown", Options{Format: "csv", Columns: []string{"colour"}}, nil, "unknown export column"},
+	}
+	for i, test := range suite {
+		t.Run(fmt.Sprintf("#%d: %q", i, test.testName), func(t *testing.T) {
+			buf := bytes.Buffer{}
+			err := Write(&buf, rows, test.opts)
+			if test.err != "" {
+				assert.Error(t, err, test.err)
+				return
+			}
+			assert.NilError(t, err)
+			lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+			for j, want := range test.want {
+				assert.Equal(t, lines[j], want)
+			}
+		})
+	}
+}
+
+func TestWriteTTS(t *testing.T) {
+	buf := bytes.Buffer{}
+	assert.NilError(t, Write(&buf, rows, Options{Format: "tts", ApplicationID: "factory"}))
+
+	devices := []ttsDevice{}
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		d := ttsDevice{}
+		assert.NilError(t, dec.Decode(&d))
+		devices = append(devices, d)
+	}
+	assert.Equal(t, len(devices), 3)
+
+	assert.Equal(t, devices[0].IDs.DeviceID, "eui-000000000000a0a1")
+	assert.Equal(t, devices[0].IDs.JoinEUI, "0000000000000000")
+	assert.Equal(t, devices[0].IDs.ApplicationIDs.ApplicationID, "factory")
+	assert.Equal(t, devices[0].Attributes["serial"], "LINE-1")
+	assert.Equal(t, devices[0].FrequencyPlanID, DefaultFrequencyPlan)
+	assert.Equal(t, devices[0].RootKeys == nil, true)
+
+	assert.Equal(t, devices[1].LoRaWANVersion, "MAC_V1_0_3")
+	assert.Equal(t, devices[1].RootKeys.AppKey.Key, "000102030405060708090A0B0C0D0E0F")
+	assert.Equal(t, devices[1].RootKeys.NwkKey == nil, true)
+
+	assert.Equal(t, devices[2].LoRaWANVersion, "MAC_V1_1")
+	assert.Equal(t, devices[2].RootKeys.NwkKey.Key, "0F0E0D0C0B0A09080706050403020100")
+}
@@ -0,0 +1,121 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// JoinEUI of devices exported without root keys
+const blankJoinEUI = "0000000000000000"
+
+// DefaultFrequencyPlan : the TTS frequency plan unless another is given
+const DefaultFrequencyPlan = "EU_863_870_TTN"
+
+// columns of a ChirpStack bulk device import
+var chirpStackHeader = []string{"dev_eui", "join_eui", "nwk_key", "app_key", "name", "description", "application_id", "device_profile_id"}
+
+// ChirpStack keeps the AppKey of a LoRaWAN 1.0 device in nwk_key
+// app_key is only set for LoRaWAN 1.1 devices that have both
+func writeChirpStack(w io.Writer, rows []Row, opts Options) error {
+	cw := csv.NewWriter(w)
+	cw.Write(chirpStackHeader)
+	for _, r := range rows {
+		joinEUI, nwkKey, appKey := blankJoinEUI, "", ""
+		if r.Keys != nil {
+			joinEUI, nwkKey = r.Keys.JoinEUI, r.Keys.AppKey
+			if r.Keys.NwkKey != "" {
+				nwkKey, appKey = r.Keys.NwkKey, r.Keys.AppKey
+			}
+		}
+		cw.Write([]string{strings.ToLower(r.DevEUI), strings.ToLower(joinEUI), strings.ToLower(nwkKey), strings.ToLower(appKey), r.ShortCode, description(r), opts.ApplicationID, opts.DeviceProfileID})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// an end device of The Things Stack as read by `ttn-lw-cli end-devices create`
+type ttsDevice struct {
+	IDs struct {
+		DeviceID       string `json:"device_id"`
+		DevEUI         string `json:"dev_eui"`
+		JoinEUI        string `json:"join_eui"`
+		ApplicationIDs *struct {
+			ApplicationID string `json:"application_id"`
+		} `json:"application_ids,omitempty"`
+	} `json:"ids"`
+	Name              string            `json:"name"`
+	Description       string            `json:"description,omitempty"`
+	Attributes        map[string]string `json:"attributes,omitempty"`
+	LoRaWANVersion    string            `json:"lorawan_version"`
+	LoRaWANPHYVersion string            `json:"lorawan_phy_version"`
+	FrequencyPlanID   string            `json:"frequency_plan_id"`
+	SupportsJoin      bool              `json:"supports_join"`
+	RootKeys          *ttsRootKeys      `json:"root_keys,omitempty"`
+}
+
+type ttsRootKeys struct {
+	AppKey *ttsKey `json:"app_key,omitempty"`
+	NwkKey *ttsKey `json:"nwk_key,omitempty"`
+}
+
+type ttsKey struct {
+	Key string `json:"key"`
+}
+
+// one TTS end device per line - a LoRaWAN 1.1 device when it holds an NwkKey
+func writeTTS(w io.Writer, rows []Row, opts Options) error {
+	plan := opts.FrequencyPlan
+	if plan == "" {
+		plan = DefaultFrequencyPlan
+	}
+
+	enc := json.NewEncoder(w)
+	for _, r := range rows {
+		d := ttsDevice{Name: r.ShortCode, Description: description(r), FrequencyPlanID: plan, SupportsJoin: true}
+		d.IDs.DeviceID = "eui-" + strings.ToLower(r.DevEUI)
+		d.IDs.DevEUI = r.DevEUI
+		d.IDs.JoinEUI = blankJoinEUI
+		if opts.ApplicationID != "" {
+			d.IDs.ApplicationIDs = &struct {
+				ApplicationID string `json:"application_id"`
+			}{opts.ApplicationID}
+		}
+
+		d.Attributes = map[string]string{"shortcode": r.ShortCode}
+		if r.Batch != "" {
+			d.Attributes["batch"] = r.Batch
+		}
+		if r.Serial != "" {
+			d.Attributes["serial"] = r.Serial
+		}
+
+		d.LoRaWANVersion, d.LoRaWANPHYVersion = "MAC_V1_0_3", "PHY_V1_0_3_REV_A"
+		if r.Keys != nil {
+			d.IDs.JoinEUI = r.Keys.JoinEUI
+			d.RootKeys = &ttsRootKeys{AppKey: &ttsKey{r.Keys.AppKey}}
+			if r.Keys.NwkKey != "" {
+				d.LoRaWANVersion, d.LoRaWANPHYVersion = "MAC_V1_1", "PHY_V1_1_REV_B"
+				d.RootKeys.NwkKey = &ttsKey{r.Keys.NwkKey}
+			}
+		}
+
+		if err := enc.Encode(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// the serial and batch of a device for the network server
+func description(r Row) string {
+	parts := []string{}
+	if r.Serial != "" {
+		parts = append(parts, "serial "+r.Serial)
+	}
+	if r.Batch != "" {
+		parts = append(parts, "batch "+r.Batch)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,155 @@
+package generator
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Blocklist :
+// Shortcodes the generator must never hand out.
+// Entries are either exact hex values eg `DEAD0`
+// or regular expressions wrapped in slashes eg `/^0FACE$/`
+// patterns are matched against the upper case, zero padded shortcode
+type Blocklist struct {
+	mutex    sync.Mutex
+	exact    map[int64]bool
+	patterns []*regexp.Regexp
+
+	// every blocked value in the ID space - sorted
+	// built lazily so accounting does not rescan the space
+	expanded []int64
+	dirty    bool
+}
+
+// DefaultBlocklist :
+// The blocklist consulted by `GenerateDUIDBatch` and `Stats`
+// empty unless loaded from the commandline
+var DefaultBlocklist = NewBlocklist()
+
+// NewBlocklist : an empty blocklist
+func NewBlocklist() *Blocklist {
+	return &Blocklist{exact: map[int64]bool{}}
+}
+
+// LoadBlocklist :
+// Reads a blocklist file - one entry per line
+// blank lines and lines starting with `#` are ignored
+func LoadBlocklist(path string) (*Blocklist, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	b := NewBlocklist()
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		if err := b.Add(entry); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+	}
+
+	return b, scanner.Err()
+}
+
+// Add :
+// Adds an exact shortcode or a `/pattern/` to the blocklist
+func (b *Blocklist) Add(entry string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if len(entry) > 2 && strings.HasPrefix(entry, "/") && strings.HasSuffix(entry, "/") {
+		re, err := regexp.Compile("(?i)" + entry[1:len(entry)-1])
+		if err != nil {
+			return fmt.Errorf("invalid blocklist pattern %q - %v", entry, err)
+		}
+		b.patterns = append(b.patterns, re)
+		b.dirty = true
+		return nil
+	}
+
+	v, err := parseHex(entry)
+	if err != nil {
+		return err
+	}
+	b.exact[v] = true
+	b.dirty = true
+	return nil
+}
+
+// Blocked :
+// Reports whether the shortcode value may not be issued
+func (b *Blocklist) Blocked(v int64) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.blocked(v)
+}
+
+func (b *Blocklist) blocked(v int64) bool {
+	if b.exact[v] {
+		return true
+	}
+	if len(b.patterns) == 0 {
+		return false
+	}
+	sc := formatShortcode(v)
+	for _, re := range b.patterns {
+		if re.MatchString(sc) {
+			return true
+		}
+	}
+	return false
+}
+
+// CountBetween :
+// Number of blocked shortcodes within the inclusive range from - to
+func (b *Blocklist) CountBetween(from, to int64) int64 {
+	if to < from {
+		return 0
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.dirty || b.expanded == nil {
+		b.expand()
+	}
+	lo := sort.Search(len(b.expanded), func(i int) bool { return b.expanded[i] >= from })
+	hi := sort.Search(len(b.expanded), func(i int) bool { return b.expanded[i] > to })
+	return int64(hi - lo)
+}
+
+// walk the whole ID space once
+// patterns make it impossible to count any other way
+func (b *Blocklist) expand() {
+	b.expanded = []int64{}
+	if len(b.patterns) == 0 {
+		for v := range b.exact {
+			b.expanded = append(b.expanded, v)
+		}
+		sort.Slice(b.expanded, func(i, j int) bool { return b.expanded[i] < b.expanded[j] })
+	} else {
+		for v := int64(0); v <= shortcodeLimit; v++ {
+			if b.blocked(v) {
+				b.expanded = append(b.expanded, v)
+			}
+		}
+	}
+	b.dirty = false
+}
+
+// zero padded upper case shortcode
+func formatShortcode(v int64) string {
+	return strings.ToUpper(fmt.Sprintf("%05s", strconv.FormatInt(v, 16)))
+}
@@ -0,0 +1,274 @@
+package generator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/David-solly/mxbcode/pkg/cache"
+	"github.com/David-solly/mxbcode/pkg/models"
+)
+
+// BlockKeyPrefix :
+// Prefix of block records in the cache store
+// records are keyed `BLOCK:<OWNER>`
+const BlockKeyPrefix = "BLOCK:"
+
+// BlockCounterPrefix :
+// Prefix of the counter of each block
+// used in place of `LastUIDKey` when generating inside the block
+const BlockCounterPrefix = "BLOCK-LAST:"
+
+// BlockHeldPrefix :
+// Prefix of the queue of shortcodes given back to each block
+// drawn but never sent - they are issued again before the counter moves on
+const BlockHeldPrefix = "BLOCK-HELD:"
+
+// long enough for `tenant-` and a tenant name
+var validOwner = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,40}$`)
+
+// AllocateBlock :
+// Carves a contiguous range out of the shortcode space for owner.
+// Either from - to is supplied or `size` shortcodes are taken from the
+// highest free range - clear of blocks and reservations.
+// Blocks always lie ahead of the global counter, which steps over them.
+// Owners are case insensitive and stored in lower case.
+// The range is checked and stored under the allocation lock
+func AllocateBlock(c cache.Service, owner, from, to string, size int64) (models.Block, error) {
+	if !validOwner.MatchString(owner) {
+		return models.Block{}, fmt.Errorf("invalid block owner %q", owner)
+	}
+	owner = strings.ToLower(owner)
+
+	var start, end int64
+	var err error
+	if from != "" {
+		if to == "" {
+			return models.Block{}, errors.New("a block needs both from and to")
+		}
+		if start, err = parseHex(from); err != nil {
+			return models.Block{}, err
+		}
+		if end, err = parseHex(to); err != nil {
+			return models.Block{}, err
+		}
+		if end < start {
+			return models.Block{}, fmt.Errorf("block range %q-%q is reversed", from, to)
+		}
+	} else if size < 1 {
+		return models.Block{}, errors.New("a block needs a size or a from - to range")
+	}
+
+	b := models.Block{Owner: owner, Created: time.Now().UTC()}
+	err = withAllocationLock(c, func() error {
+		if held, err := BlockFor(c, owner); err != nil || held != nil {
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("%q already holds block %s-%s", owner, held.From, held.To)
+		}
+
+		last, _, err := c.ReadCache(cache.LastUIDKey)
+		if err != nil {
+			return err
+		}
+		current, err := parseHex(last)
+		if err != nil {
+			return err
+		}
+
+		if from == "" {
+			if start, end, err = highestFree(c, size); err != nil {
+				return err
+			}
+		}
+		if start <= current {
+			return fmt.Errorf("insufficient unallocated space for a block of %d ahead of the counter (%s)", end-start+1, formatShortcode(current))
+		}
+		if err := checkFree(c, start, end); err != nil {
+			return err
+		}
+
+		b.From, b.To = formatShortcode(start), formatShortcode(end)
+		data, _ := json.Marshal(b)
+		if _, err := c.StoreValue(BlockCounterPrefix+owner, formatShortcode(start-1)); err != nil {
+			return err
+		}
+		_, err = c.StoreValue(BlockKeyPrefix+owner, string(data))
+		return err
+	})
+	if err != nil {
+		return models.Block{}, err
+	}
+
+	return blockStatus(c, b)
+}
+
+// the highest range of size shortcodes clear of blocks and reservations
+// the caller checks it lies ahead of the counter
+func highestFree(c cache.Service, size int64) (int64, int64, error) {
+	s, err := newSkipList(c)
+	if err != nil {
+		return 0, 0, err
+	}
+	taken := append(s.reserved, s.allocated...)
+
+	end := int64(shortcodeLimit)
+	for moved := true; moved; {
+		moved = false
+		for _, r := range taken {
+			if end-size+1 <= r[1] && end >= r[0] {
+				end, moved = r[0]-1, true
+			}
+		}
+	}
+	return end - size + 1, end, nil
+}
+
+// Blocks :
+// Every allocated block ordered by shortcode
+// along with how far each owner has got through it
+func Blocks(c cache.Service) ([]models.Block, error) {
+	keys, err := c.ListKeys(BlockKeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	list := []models.Block{}
+	for _, k := range keys {
+		if !validOwner.MatchString(strings.TrimPrefix(k, BlockKeyPrefix)) {
+			continue // not a block record
+		}
+		data, found, _ := c.ReadCache(k)
+		if !found {
+			continue
+		}
+		b := models.Block{}
+		if err := json.Unmarshal([]byte(data), &b); err != nil {
+			return nil, fmt.Errorf("corrupt block %q - %v", k, err)
+		}
+		if b, err = blockStatus(c, b); err != nil {
+			return nil, err
+		}
+		list = append(list, b)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].From < list[j].From })
+
+	return list, nil
+}
+
+// BlockFor :
+// The block allocated to owner - nil if there is none
+func BlockFor(c cache.Service, owner string) (*models.Block, error) {
+	data, found, _ := c.ReadCache(BlockKeyPrefix + owner)
+	if !found {
+		return nil, nil
+	}
+	b := models.Block{}
+	if err := json.Unmarshal([]byte(data), &b); err != nil {
+		return nil, fmt.Errorf("corrupt block for %q - %v", owner, err)
+	}
+	b, err := blockStatus(c, b)
+	return &b, err
+}
+
+// GenerateBlockBatch :
+// Generate `count` uid's from the block allocated to owner
+// using the owners counter rather than the global one.
+// Shortcodes given back to the block are issued first.
+// The counter is advanced under the allocation lock so
+// concurrent batches of one owner never share a shortcode
+func GenerateBlockBatch(count int, c cache.Service, owner string) (*[]*models.DevEUI, int, error) {
+	if count < 1 {
+		return nil, 0, errors.New("Minimum request is 1")
+	}
+
+	if count > DefaultMaxToGenerate {
+		return nil, 0, fmt.Errorf("Too many requested - Maximum %d", DefaultMaxToGenerate)
+	}
+
+	ids := []*models.DevEUI{}
+	var skipped int
+	err := withAllocationLock(c, func() error {
+		b, err := BlockFor(c, owner)
+		if err != nil {
+			return err
+		}
+		if b == nil {
+			return fmt.Errorf("no block allocated to %q", owner)
+		}
+
+		if int64(count) > b.Remaining {
+			return fmt.Errorf("block %s-%s of %q exhausted - insufficient ID space (%d) remaining to generate (%d) IDs", b.From, b.To, owner, b.Remaining, count)
+		}
+
+		held, err := c.PopValues(BlockHeldPrefix+b.Owner, count)
+		if err != nil {
+			return err
+		}
+		for _, sc := range held {
+			v := models.DevEUI{ShortCode: strings.ToLower(sc)}
+			generateBarcodeTrunk(&v)
+			ids = append(ids, &v)
+		}
+		if len(ids) == count {
+			return nil
+		}
+
+		// only the blocklist applies inside a block
+		// reservations and other blocks can never overlap it
+		start, _ := parseHex(b.Last)
+		drawn, n := drawBatch(count-len(ids), start, &skipList{}, func(shortcode string) {
+			c.StoreValue(BlockCounterPrefix+b.Owner, shortcode)
+		})
+		ids, skipped = append(ids, drawn...), n
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return &ids, skipped, nil
+}
+
+// ForBlock :
+// A BatchFunc drawing from the block allocated to owner
+func ForBlock(owner string) BatchFunc {
+	return func(count int, c cache.Service) (*[]*models.DevEUI, int, error) {
+		return GenerateBlockBatch(count, c, owner)
+	}
+}
+
+// fill in the position of the owners counter
+func blockStatus(c cache.Service, b models.Block) (models.Block, error) {
+	lo, hi := blockBounds(b)
+	last, found, _ := c.ReadCache(BlockCounterPrefix + b.Owner)
+	if !found {
+		last = formatShortcode(lo - 1)
+	}
+	current, err := parseHex(last)
+	if err != nil {
+		return b, fmt.Errorf("corrupt counter for block of %q - %v", b.Owner, err)
+	}
+
+	held, err := c.CountValues(BlockHeldPrefix + b.Owner)
+	if err != nil {
+		return b, err
+	}
+
+	b.Last = formatShortcode(current)
+	b.Issued = current - lo + 1 - DefaultBlocklist.CountBetween(lo, current) - held
+	b.Remaining = hi - current - DefaultBlocklist.CountBetween(current+1, hi) + held
+	b.Exhausted = b.Remaining <= 0
+	return b, nil
+}
+
+func blockBounds(b models.Block) (int64, int64) {
+	lo, _ := parseHex(b.From)
+	hi, _ := parseHex(b.To)
+	return lo, hi
+}
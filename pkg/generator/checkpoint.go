@@ -0,0 +1,130 @@
+package generator
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/David-solly/mxbcode/pkg/cache"
+	"github.com/David-solly/mxbcode/pkg/models"
+)
+
+// Checkpointed :
+// How the shortcodes of an unfinished batch were given back
+type Checkpointed struct {
+	// queued to be issued again by the block they came from
+	Requeued int64
+
+	// reserved outside of blocks so they can be claimed
+	Held []models.Reservation
+}
+
+// Rewind :
+// Moves the counter a batch drew shortcodes from back before them.
+// The counter is the block holding the shortcodes or else the global one.
+// Nothing is rewound once anything was drawn after them or when they are not
+// one run up to the counter - false is returned and the caller checkpoints
+// them instead
+func Rewind(c cache.Service, shortcodes []string) (rewound bool, err error) {
+	values, err := sortedShortcodes(shortcodes)
+	if err != nil || len(values) == 0 {
+		return false, err
+	}
+	for i := 1; i < len(values); i++ {
+		// only the blocklist may leave gaps - anything else was issued
+		if gap := values[i] - values[i-1] - 1; gap != DefaultBlocklist.CountBetween(values[i-1]+1, values[i]-1) {
+			return false, nil
+		}
+	}
+	first, last := values[0], values[len(values)-1]
+
+	err = withAllocationLock(c, func() error {
+		b, err := blockHolding(c, first)
+		if err != nil {
+			return err
+		}
+		key := cache.LastUIDKey
+		if b != nil {
+			key = BlockCounterPrefix + b.Owner
+		}
+
+		current, found, _ := c.ReadCache(key)
+		if !found {
+			return nil
+		}
+		if v, err := parseHex(current); err != nil || v != last {
+			return err
+		}
+
+		if b != nil {
+			_, err = c.StoreValue(key, formatShortcode(first-1))
+		} else {
+			_, err = c.StoreLastDUID(models.LastDevEUI{ShortCode: formatShortcode(first - 1)})
+		}
+		rewound = err == nil
+		return err
+	})
+
+	return rewound, err
+}
+
+// Checkpoint :
+// Gives back shortcodes a batch drew but never sent to the provider.
+// Those of a block are queued to be issued again by the block - tenants and
+// owners get them back through their own counter - and the rest are held
+// as reservations labelled label
+func Checkpoint(c cache.Service, shortcodes []string, label string) (checkpointed Checkpointed, err error) {
+	values, err := sortedShortcodes(shortcodes)
+	if err != nil || len(values) == 0 {
+		return checkpointed, err
+	}
+
+	err = withAllocationLock(c, func() error {
+		b, err := blockHolding(c, values[0])
+		if err != nil {
+			return err
+		}
+		if b == nil {
+			checkpointed.Held, err = hold(c, values, label)
+			return err
+		}
+
+		requeued := make([]string, len(values))
+		for i, v := range values {
+			requeued[i] = formatShortcode(v)
+		}
+		if _, err := c.PushValues(BlockHeldPrefix+b.Owner, requeued...); err != nil {
+			return err
+		}
+		checkpointed.Requeued = int64(len(requeued))
+		return nil
+	})
+
+	return checkpointed, err
+}
+
+// the block v lies in - nil outside of every block
+func blockHolding(c cache.Service, v int64) (*models.Block, error) {
+	blocks, err := Blocks(c)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range blocks {
+		if lo, hi := blockBounds(b); v >= lo && v <= hi {
+			return &b, nil
+		}
+	}
+	return nil, nil
+}
+
+func sortedShortcodes(shortcodes []string) ([]int64, error) {
+	values := make([]int64, 0, len(shortcodes))
+	for _, sc := range shortcodes {
+		v, err := parseHex(strings.TrimSpace(sc))
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	return values, nil
+}
@@ -6,6 +6,7 @@ import (
 	"math/rand"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/David-solly/mxbcode/pkg/cache"
@@ -41,52 +42,125 @@ const DefaultMaxToRegister = 100
 
 // GenerateDUIDBatch :
 // Generate `count` uid's and stores them in `c` when done
-func GenerateDUIDBatch(count int, c cache.Service) (*[]*models.DevEUI, error) {
+// shortcodes on the `DefaultBlocklist` or reserved ahead of the counter
+// are stepped over and the number skipped is returned alongside the batch.
+// The skip list is built and the counter advanced under the allocation lock
+// so a range reserved meanwhile is never issued
+func GenerateDUIDBatch(count int, c cache.Service) (*[]*models.DevEUI, int, error) {
 	if count < 1 {
-		return nil, errors.New("Minimum request is 1")
+		return nil, 0, errors.New("Minimum request is 1")
 	}
 
 	if count > DefaultMaxToGenerate {
-		return nil, fmt.Errorf("Too many requested - Maximum %d", DefaultMaxToGenerate)
+		return nil, 0, fmt.Errorf("Too many requested - Maximum %d", DefaultMaxToGenerate)
 	}
 
-	// read last incremented hexcode of previous operation
-	// - prevents database full table scan
-	last, _, err := c.ReadCache(cache.LastUIDKey)
+	var ids []*models.DevEUI
+	var skipped int
+	err := withAllocationLock(c, func() error {
+		// read last incremented hexcode of previous operation
+		// - prevents database full table scan
+		last, _, err := c.ReadCache(cache.LastUIDKey)
+		if err != nil {
+			return err
+		}
+
+		// ensure hex value matches our criteria
+		start, err := parseHex(last)
+		if err != nil {
+			return err
+		}
+
+		skip, err := newSkipList(c)
+		if err != nil {
+			return err
+		}
+
+		// check that the desired range is within physical limits
+		// 5 digit HEX code generation
+		// maximum possible unique device lookups
+		// 1048576 == (16^5)
+		//
+		// check for overflow errors
+		// blocked and reserved values are never issued by the counter
+		// so do not count towards the space remaining
+		remaining := shortcodeLimit - start - skip.countBetween(start+1, shortcodeLimit)
+		if int64(count) > remaining {
+			return fmt.Errorf("insufficient ID space (%d) remaining to generate (%d) IDs", remaining, count)
+		}
+
+		ids, skipped = drawBatch(count, start, skip, func(shortcode string) {
+			c.StoreLastDUID(models.LastDevEUI{ShortCode: shortcode})
+		})
+		return nil
+	})
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
-	// ensure hex value matches our criteria
-	start, err := parseHex(last)
-	if err != nil {
-		return nil, err
-	}
+	return &ids, skipped, nil
+}
 
-	// check that the desired range is within physical limits
-	// 5 digit HEX code generation
-	// maximum possible unique device lookups
-	// 1048576 == (16^5)
-	//
-	// check for overflow errors
-	// hex math to range to 100
-	batchShorts := start + int64(count)
-	if batchShorts > shortcodeLimit {
-		return nil, fmt.Errorf("insufficient ID space (%d) remaining to generate (%d) IDs", (shortcodeLimit - start), count)
-	}
+// BatchFunc :
+// Generates a batch of DevEUIs from one of the counters
+// `GenerateDUIDBatch` draws from the global counter
+type BatchFunc func(count int, c cache.Service) (*[]*models.DevEUI, int, error)
 
+// draw `count` shortcodes following start stepping over the skip list
+// store records each issued shortcode as the new position of the counter
+func drawBatch(count int, start int64, skip *skipList, store func(shortcode string)) ([]*models.DevEUI, int) {
 	// build devEUI struct list
-	ids := make([]*models.DevEUI, count)
+	ids := make([]*models.DevEUI, 0, count)
+	skipped := 0
 	rand.Seed(time.Now().UnixNano())
-	for i := range ids {
-		v := models.DevEUI{ShortCode: fmt.Sprintf("%05s", strconv.FormatInt(start+int64(i+1), 16))}
+	for next := start + 1; len(ids) < count; next++ {
+		if skip.skip(next) {
+			skipped++
+			continue
+		}
+		v := models.DevEUI{ShortCode: fmt.Sprintf("%05s", strconv.FormatInt(next, 16))}
 		generateBarcodeTrunk(&v)
-		c.StoreLastDUID(models.LastDevEUI{ShortCode: v.ShortCode})
-		ids[i] = &v
+		store(v.ShortCode)
+		ids = append(ids, &v)
+
+	}
+
+	return ids, skipped
+}
 
+// Stats :
+// Accounting of the shortcode ID space
+// blocked and reserved values are reported separately
+// and are never counted as issued or remaining
+func Stats(c cache.Service) (models.SpaceStats, error) {
+	last, _, err := c.ReadCache(cache.LastUIDKey)
+	if err != nil {
+		return models.SpaceStats{}, err
+	}
+
+	current, err := parseHex(last)
+	if err != nil {
+		return models.SpaceStats{}, err
+	}
+
+	skip, err := newSkipList(c)
+	if err != nil {
+		return models.SpaceStats{}, err
 	}
 
-	return &ids, nil
+	behind := skip.countBetween(1, current)
+	ahead := skip.countBetween(current+1, shortcodeLimit)
+
+	return models.SpaceStats{
+		Last:      strings.ToUpper(fmt.Sprintf("%05s", last)),
+		Capacity:  shortcodeLimit - behind - ahead,
+		Issued:    current - behind,
+		Skipped:   behind,
+		Remaining: shortcodeLimit - current - ahead,
+		Blocked:   DefaultBlocklist.CountBetween(1, shortcodeLimit),
+		Reserved:  skip.reservedCount(),
+		Allocated: skip.allocatedCount(),
+	}, nil
 }
 
 // ensure hex value matches our criteria
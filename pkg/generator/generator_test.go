@@ -5,7 +5,9 @@ import (
 	"os"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/David-solly/mxbcode/pkg/cache"
 	"github.com/David-solly/mxbcode/pkg/models"
@@ -73,7 +75,7 @@ func TestGenerateBatchValues(t *testing.T) {
 			}
 
 			t.Run(fmt.Sprintf("#%d: %q", i, test.testName), func(t *testing.T) {
-				ids, err := GenerateDUIDBatch(test.want, c.Client)
+				ids, _, err := GenerateDUIDBatch(test.want, c.Client)
 				last, _, _ := c.Client.ReadCache(cache.LastUIDKey)
 				assert.Equal(t, last, test.lastShortcode)
 
@@ -100,7 +102,609 @@ func BenchmarkGenerateIDS(b *testing.B) {
 	cache.Initialise("", false)
 	b.Run("GENERATE BATCH", func(b *testing.B) {
 		for i := 0; i < b.N; i++ {
-			_, _ = GenerateDUIDBatch(100, cache.Client)
+			_, _, _ = GenerateDUIDBatch(100, cache.Client)
 		}
 	})
 }
+
+func TestBlocklistSkipped(t *testing.T) {
+	defer func(b *Blocklist) { DefaultBlocklist = b }(DefaultBlocklist)
+	DefaultBlocklist = NewBlocklist()
+	for _, entry := range []string{"00002", "0000a", "/^0000[4-6]$/"} {
+		assert.NilError(t, DefaultBlocklist.Add(entry))
+	}
+
+	t.Run("GENERATE around blocked shortcodes", func(t *testing.T) {
+		resetCache()
+		ids, skipped, err := GenerateDUIDBatch(6, c.Client)
+		assert.NilError(t, err)
+		assert.Equal(t, skipped, 5)
+
+		got := []string{}
+		for _, id := range *ids {
+			got = append(got, strings.ToUpper(id.ShortCode))
+		}
+		assert.DeepEqual(t, got, []string{"00001", "00003", "00007", "00008", "00009", "0000B"})
+	})
+
+	t.Run("STATS account for blocked shortcodes", func(t *testing.T) {
+		stats, err := Stats(c.Client)
+		assert.NilError(t, err)
+		assert.Equal(t, stats.Last, "0000B")
+		assert.Equal(t, stats.Issued, int64(6))
+		assert.Equal(t, stats.Skipped, int64(5))
+		assert.Equal(t, stats.Blocked, int64(5))
+		assert.Equal(t, stats.Remaining, int64(shortcodeLimit-11))
+		assert.Equal(t, stats.Capacity, stats.Issued+stats.Remaining)
+	})
+
+	t.Run("BLOCKLIST invalid entries", func(t *testing.T) {
+		assert.Error(t, DefaultBlocklist.Add("XYZ"), "invalid hexcode")
+		assert.Error(t, DefaultBlocklist.Add("/[/"), "invalid blocklist pattern")
+	})
+
+	t.Run("INSUFFICIENT space excludes blocked shortcodes", func(t *testing.T) {
+		c.Client.StoreLastDUID(models.LastDevEUI{ShortCode: "ffffa"})
+		assert.NilError(t, DefaultBlocklist.Add("FFFFC"))
+		_, _, err := GenerateDUIDBatch(5, c.Client)
+		assert.Error(t, err, "insufficient ID space (4) remaining to generate (5) IDs")
+		resetCache()
+	})
+}
+
+func TestReservations(t *testing.T) {
+	resetCache()
+	defer func() {
+		for _, k := range []string{"00005", "00010", "00100"} {
+			Release(c.Client, k)
+		}
+		for _, k := range []string{"00011", "0001C"} {
+			Unclaim(c.Client, k)
+		}
+		resetCache()
+	}()
+
+	t.Run("RESERVE shortcodes ahead of the counter", func(t *testing.T) {
+		suite := []struct {
+			testName string
+			from, to string
+			err      string
+		}{
+			{"RESERVE - single", "00005", "", ""},
+			{"RESERVE - range", "00010", "00013", ""},
+			{"RESERVE - range", "00100", "001ff", ""},
+			{"RESERVE - overlap", "00012", "00020", "overlaps reservation 00010-00013"},
+			{"RESERVE - reversed", "00030", "00020", "is reversed"},
+			{"RESERVE - passed", "00000", "", "has already been passed by the counter"},
+			{"RESERVE - invalid", "XYZ", "", "invalid hexcode"},
+		}
+		for i, test := range suite {
+			t.Run(fmt.Sprintf("#%d: %q", i, test.testName), func(t *testing.T) {
+				r, err := Reserve(c.Client, test.from, test.to, "test")
+				if test.err != "" {
+					assert.Error(t, err, test.err)
+				} else {
+					assert.NilError(t, err)
+					assert.Equal(t, r.From, strings.ToUpper(test.from))
+				}
+			})
+		}
+
+		list, err := Reservations(c.Client)
+		assert.NilError(t, err)
+		assert.Equal(t, len(list), 3)
+	})
+
+	t.Run("GENERATE skips reserved shortcodes", func(t *testing.T) {
+		ids, skipped, err := GenerateDUIDBatch(20, c.Client)
+		assert.NilError(t, err)
+		assert.Equal(t, skipped, 5)
+		for _, id := range *ids {
+			r, _ := ReservationFor(c.Client, id.ShortCode)
+			assert.Equal(t, r == nil, true)
+		}
+
+		stats, err := Stats(c.Client)
+		assert.NilError(t, err)
+		assert.Equal(t, stats.Reserved, int64(261))
+		assert.Equal(t, stats.Issued, int64(20))
+		assert.Equal(t, stats.Remaining, int64(shortcodeLimit-0x19-256))
+	})
+
+	t.Run("CLAIM reserved shortcodes", func(t *testing.T) {
+		dev, err := ClaimReserved(c.Client, "00011")
+		assert.NilError(t, err)
+		assert.Equal(t, strings.ToUpper(dev.DevEUI[11:]), "00011")
+
+		c.Client.StoreDUID(*dev)
+		_, err = ClaimReserved(c.Client, "00011")
+		assert.Error(t, err, "has already been claimed")
+		_, err = ClaimReserved(c.Client, "00020")
+		assert.Error(t, err, "is not reserved")
+	})
+
+	t.Run("HOLD issued shortcodes", func(t *testing.T) {
+		ids, _, err := GenerateDUIDBatch(6, c.Client)
+		assert.NilError(t, err)
+		unsent := []string{}
+		for _, id := range (*ids)[1:] {
+			unsent = append(unsent, id.ShortCode)
+		}
+		c.Client.StoreDUID(*(*ids)[3])
+
+		held, err := Hold(c.Client, unsent, "unfinished batch")
+		defer func() {
+			for _, r := range held {
+				Release(c.Client, r.From)
+			}
+		}()
+		assert.NilError(t, err)
+		assert.Equal(t, len(held), 2)
+		assert.Equal(t, held[0].From+"-"+held[0].To, "0001B-0001C")
+		assert.Equal(t, held[1].From+"-"+held[1].To, "0001E-0001F")
+
+		_, err = ClaimReserved(c.Client, "0001C")
+		assert.NilError(t, err)
+		_, err = Hold(c.Client, []string{"0001C"}, "again")
+		assert.Error(t, err, "overlaps reservation 0001B-0001C")
+	})
+
+	t.Run("RELEASE reservations", func(t *testing.T) {
+		r, err := Release(c.Client, "00012")
+		assert.NilError(t, err)
+		assert.Equal(t, r.From, "00010")
+		_, err = Release(c.Client, "00012")
+		assert.Error(t, err, "is not reserved")
+	})
+}
+
+// a store slow to list so racing allocations interleave
+type slowStore struct {
+	cache.Service
+}
+
+func (s slowStore) ListKeys(prefix string) ([]string, error) {
+	time.Sleep(time.Millisecond)
+	return s.Service.ListKeys(prefix)
+}
+
+func TestReserveConcurrently(t *testing.T) {
+	resetCache()
+	defer resetCache()
+	store := slowStore{c.Client}
+
+	// overlapping ranges raced from every side - only one is stored
+	var won []models.Reservation
+	var m sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r, err := Reserve(store, formatShortcode(int64(0x300+i)), formatShortcode(int64(0x320+i)), "race")
+			if err == nil {
+				m.Lock()
+				won = append(won, r)
+				m.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	for _, r := range won {
+		defer Release(c.Client, r.From)
+	}
+
+	assert.Equal(t, len(won), 1)
+	list, _ := Reservations(c.Client)
+	assert.Equal(t, len(list), 1)
+}
+
+// a store running hook the first time reservations are listed
+type hookStore struct {
+	cache.Service
+	once sync.Once
+	hook func()
+}
+
+func (s *hookStore) ListKeys(prefix string) ([]string, error) {
+	keys, err := s.Service.ListKeys(prefix)
+	if prefix == ReservedKeyPrefix {
+		s.once.Do(s.hook)
+	}
+	return keys, err
+}
+
+func TestGenerateWhileReserving(t *testing.T) {
+	resetCache()
+	defer resetCache()
+
+	// a range reserved once the batch has listed the reservations
+	// waits for the batch rather than being issued by it
+	var wg sync.WaitGroup
+	store := &hookStore{Service: c.Client}
+	store.hook = func() {
+		wg.Add(1)
+		done := make(chan bool)
+		go func() {
+			defer wg.Done()
+			Reserve(c.Client, "00002", "00003", "race")
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	ids, _, err := GenerateDUIDBatch(5, store)
+	assert.NilError(t, err)
+	wg.Wait()
+	defer Release(c.Client, "00002")
+
+	for _, id := range *ids {
+		r, _ := ReservationFor(c.Client, id.ShortCode)
+		assert.Equal(t, r == nil, true)
+	}
+}
+
+func TestClaimReservedConcurrently(t *testing.T) {
+	resetCache()
+	Reserve(c.Client, "00500", "", "race")
+	defer Release(c.Client, "00500")
+	defer c.Client.DeleteValue(ReservedClaimPrefix + "00500")
+
+	claimed := 0
+	var m sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := ClaimReserved(c.Client, "00500"); err == nil {
+				m.Lock()
+				claimed++
+				m.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, claimed, 1)
+
+	// a claim whose registration failed is given back
+	assert.NilError(t, Unclaim(c.Client, "00500"))
+	dev, err := ClaimReserved(c.Client, "00500")
+	assert.NilError(t, err)
+	assert.Equal(t, strings.ToUpper(dev.ShortCode), "00500")
+}
+
+func TestBlocks(t *testing.T) {
+	resetCache()
+	defer func() {
+		for _, owner := range []string{"factory-a", "factory-b", "factory-c", "factory-e"} {
+			c.Client.DeleteValue(BlockKeyPrefix + owner)
+			c.Client.DeleteValue(BlockCounterPrefix + owner)
+		}
+		Release(c.Client, "00040")
+		Release(c.Client, "FFFE8")
+		resetCache()
+	}()
+	Reserve(c.Client, "00040", "", "")
+
+	t.Run("ALLOCATE blocks", func(t *testing.T) {
+		suite := []struct {
+			testName string
+			owner    string
+			from, to string
+			size     int64
+			want     string
+			err      string
+		}{
+			{"ALLOCATE - size", "factory-a", "", "", 16, "FFFF0", ""},
+			{"ALLOCATE - size below", "factory-b", "", "", 4, "FFFEC", ""},
+			{"ALLOCATE - range", "factory-c", "00010", "00013", 0, "00010", ""},
+			{"ALLOCATE - owner taken", "factory-a", "", "", 4, "", "already holds block FFFF0-FFFFF"},
+			{"ALLOCATE - owner taken in another case", "Factory-A", "", "", 4, "", "\"factory-a\" already holds block FFFF0-FFFFF"},
+			{"ALLOCATE - overlap", "factory-d", "00012", "00020", 0, "", "overlaps block 00010-00013 allocated to \"factory-c\""},
+			{"ALLOCATE - reservation", "factory-d", "00030", "00040", 0, "", "overlaps reservation 00040-00040"},
+			{"ALLOCATE - passed", "factory-d", "00000", "00004", 0, "", "insufficient unallocated space"},
+			{"ALLOCATE - owner", "factory d", "", "", 4, "", "invalid block owner"},
+			{"ALLOCATE - nothing", "factory-d", "", "", 0, "", "needs a size"},
+		}
+		for i, test := range suite {
+			t.Run(fmt.Sprintf("#%d: %q", i, test.testName), func(t *testing.T) {
+				b, err := AllocateBlock(c.Client, test.owner, test.from, test.to, test.size)
+				if test.err != "" {
+					assert.Error(t, err, test.err)
+				} else {
+					assert.NilError(t, err)
+					assert.Equal(t, b.From, test.want)
+					assert.Equal(t, b.Issued, int64(0))
+				}
+			})
+		}
+
+		_, err := Reserve(c.Client, "FFFF4", "", "")
+		assert.Error(t, err, "overlaps block FFFF0-FFFFF")
+	})
+
+	t.Run("GENERATE inside blocks", func(t *testing.T) {
+		ids, _, err := GenerateBlockBatch(3, c.Client, "factory-c")
+		assert.NilError(t, err)
+		assert.Equal(t, strings.ToUpper((*ids)[2].ShortCode), "00012")
+
+		ids, _, err = ForBlock("factory-c")(1, c.Client)
+		assert.NilError(t, err)
+		assert.Equal(t, strings.ToUpper((*ids)[0].ShortCode), "00013")
+
+		b, _ := BlockFor(c.Client, "factory-c")
+		assert.Equal(t, b.Exhausted, true)
+		assert.Equal(t, b.Issued, int64(4))
+		_, _, err = GenerateBlockBatch(1, c.Client, "factory-c")
+		assert.Error(t, err, "block 00010-00013 of \"factory-c\" exhausted - insufficient ID space (0) remaining")
+
+		_, _, err = GenerateBlockBatch(1, c.Client, "factory-z")
+		assert.Error(t, err, "no block allocated to \"factory-z\"")
+	})
+
+	t.Run("GENERATE globally steps over blocks", func(t *testing.T) {
+		ids, skipped, err := GenerateDUIDBatch(20, c.Client)
+		assert.NilError(t, err)
+		assert.Equal(t, skipped, 4)
+		assert.Equal(t, strings.ToUpper((*ids)[19].ShortCode), "00018")
+
+		stats, _ := Stats(c.Client)
+		assert.Equal(t, stats.Allocated, int64(24))
+		assert.Equal(t, stats.Remaining, int64(shortcodeLimit-0x18-21))
+
+		list, _ := Blocks(c.Client)
+		assert.Equal(t, len(list), 3)
+		assert.Equal(t, list[0].Owner, "factory-c")
+	})
+
+	t.Run("ALLOCATE sized blocks clear of reservations", func(t *testing.T) {
+		Reserve(c.Client, "FFFE8", "", "")
+		b, err := AllocateBlock(c.Client, "Factory-E", "", "", 4)
+		assert.NilError(t, err)
+		assert.Equal(t, b.From+"-"+b.To, "FFFE4-FFFE7")
+		assert.Equal(t, b.Owner, "factory-e")
+	})
+}
+
+func TestAllocateBlocksConcurrently(t *testing.T) {
+	resetCache()
+	owners := []string{}
+	for i := 0; i < 10; i++ {
+		owners = append(owners, fmt.Sprintf("line-%d", i))
+	}
+	defer func() {
+		for _, owner := range owners {
+			c.Client.DeleteValue(BlockKeyPrefix + owner)
+			c.Client.DeleteValue(BlockCounterPrefix + owner)
+		}
+		resetCache()
+	}()
+	store := slowStore{c.Client}
+
+	// every owner gets a range of its own
+	var wg sync.WaitGroup
+	for _, owner := range append(owners, "LINE-0", "Line-0") {
+		wg.Add(1)
+		go func(owner string) {
+			defer wg.Done()
+			AllocateBlock(store, owner, "", "", 16)
+		}(owner)
+	}
+	wg.Wait()
+
+	blocks, err := Blocks(c.Client)
+	assert.NilError(t, err)
+	assert.Equal(t, len(blocks), 10)
+	for i := 1; i < len(blocks); i++ {
+		_, hi := blockBounds(blocks[i-1])
+		lo, _ := blockBounds(blocks[i])
+		assert.Equal(t, hi < lo, true)
+	}
+}
+
+// a store slow to read and write so racing batches interleave
+type slowReads struct {
+	cache.Service
+}
+
+func (s slowReads) ReadCache(key string) (string, bool, error) {
+	time.Sleep(time.Millisecond)
+	return s.Service.ReadCache(key)
+}
+
+func (s slowReads) StoreValue(key, value string) (bool, error) {
+	time.Sleep(100 * time.Microsecond)
+	return s.Service.StoreValue(key, value)
+}
+
+func TestGenerateBlockBatchConcurrently(t *testing.T) {
+	resetCache()
+	defer func() {
+		c.Client.DeleteValue(BlockKeyPrefix + "line-r")
+		c.Client.DeleteValue(BlockCounterPrefix + "line-r")
+		resetCache()
+	}()
+	_, err := AllocateBlock(c.Client, "line-r", "", "", 0x400)
+	assert.NilError(t, err)
+	store := slowReads{c.Client}
+
+	// parallel batches of one owner never share a shortcode
+	issued := map[string]int{}
+	var m sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ids, _, err := GenerateBlockBatch(20, store, "line-r")
+			if err != nil {
+				return
+			}
+			m.Lock()
+			for _, id := range *ids {
+				issued[id.ShortCode]++
+			}
+			m.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, len(issued), 200)
+	b, _ := BlockFor(c.Client, "line-r")
+	assert.Equal(t, b.Issued, int64(200))
+}
+
+func TestRewind(t *testing.T) {
+	resetCache()
+	defer func() {
+		c.Client.DeleteValue(BlockKeyPrefix + "line-w")
+		c.Client.DeleteValue(BlockCounterPrefix + "line-w")
+		resetCache()
+	}()
+	_, err := AllocateBlock(c.Client, "line-w", "", "", 16)
+	assert.NilError(t, err)
+
+	shortcodes := func(ids *[]*models.DevEUI) []string {
+		list := []string{}
+		for _, id := range (*ids)[2:] {
+			list = append(list, id.ShortCode)
+		}
+		return list
+	}
+
+	t.Run("REWIND the counter a batch drew from", func(t *testing.T) {
+		global, _, _ := GenerateDUIDBatch(5, c.Client)
+		block, _, _ := GenerateBlockBatch(5, c.Client, "line-w")
+
+		// the block is rewound without touching the global counter
+		rewound, err := Rewind(c.Client, shortcodes(block))
+		assert.NilError(t, err)
+		assert.Equal(t, rewound, true)
+		last, _, _ := c.Client.ReadCache(cache.LastUIDKey)
+		assert.Equal(t, strings.ToUpper(last), "00005")
+		b, _ := BlockFor(c.Client, "line-w")
+		assert.Equal(t, b.Issued, int64(2))
+
+		rewound, err = Rewind(c.Client, shortcodes(global))
+		assert.NilError(t, err)
+		assert.Equal(t, rewound, true)
+		last, _, _ = c.Client.ReadCache(cache.LastUIDKey)
+		assert.Equal(t, strings.ToUpper(last), "00002")
+	})
+
+	t.Run("REWIND nothing once another batch drew after", func(t *testing.T) {
+		first, _, _ := GenerateBlockBatch(5, c.Client, "line-w")
+		GenerateBlockBatch(1, c.Client, "line-w")
+
+		rewound, err := Rewind(c.Client, shortcodes(first))
+		assert.NilError(t, err)
+		assert.Equal(t, rewound, false)
+		b, _ := BlockFor(c.Client, "line-w")
+		assert.Equal(t, b.Issued, int64(8))
+	})
+}
+
+func TestCheckpoint(t *testing.T) {
+	resetCache()
+	defer func() {
+		c.Client.DeleteValue(BlockKeyPrefix + "line-k")
+		c.Client.DeleteValue(BlockCounterPrefix + "line-k")
+		c.Client.DeleteValue(BlockHeldPrefix + "line-k")
+		resetCache()
+	}()
+	_, err := AllocateBlock(c.Client, "line-k", "", "", 16)
+	assert.NilError(t, err)
+
+	t.Run("CHECKPOINT global shortcodes as reservations", func(t *testing.T) {
+		checkpointed, err := Checkpoint(c.Client, []string{"00004", "00003"}, "unfinished batch k")
+		assert.NilError(t, err)
+		assert.Equal(t, checkpointed.Requeued, int64(0))
+		assert.Equal(t, len(checkpointed.Held), 1)
+		assert.Equal(t, checkpointed.Held[0].From+"-"+checkpointed.Held[0].To, "00003-00004")
+		Release(c.Client, "00003")
+	})
+
+	t.Run("CHECKPOINT block shortcodes back into the block", func(t *testing.T) {
+		first, _, _ := GenerateBlockBatch(6, c.Client, "line-k")
+		unsent := []string{}
+		for _, id := range (*first)[2:4] {
+			unsent = append(unsent, id.ShortCode)
+		}
+
+		checkpointed, err := Checkpoint(c.Client, unsent, "unfinished batch k")
+		assert.NilError(t, err)
+		assert.Equal(t, checkpointed.Requeued, int64(2))
+		assert.Equal(t, len(checkpointed.Held), 0)
+		b, _ := BlockFor(c.Client, "line-k")
+		assert.Equal(t, b.Issued, int64(4))
+		assert.Equal(t, b.Remaining, int64(12))
+
+		// the next batch of the block reissues them first
+		next, _, err := GenerateBlockBatch(3, c.Client, "line-k")
+		assert.NilError(t, err)
+		for i, sc := range unsent {
+			assert.Equal(t, strings.ToUpper((*next)[i].ShortCode), strings.ToUpper(sc))
+		}
+		last, _ := parseHex((*first)[5].ShortCode)
+		assert.Equal(t, strings.ToUpper((*next)[2].ShortCode), formatShortcode(last+1))
+		b, _ = BlockFor(c.Client, "line-k")
+		assert.Equal(t, b.Issued, int64(7))
+
+		// requeued and freshly drawn shortcodes are no run to rewind over
+		reissued := []string{}
+		for _, id := range *next {
+			reissued = append(reissued, id.ShortCode)
+		}
+		rewound, err := Rewind(c.Client, reissued)
+		assert.NilError(t, err)
+		assert.Equal(t, rewound, false)
+		b, _ = BlockFor(c.Client, "line-k")
+		assert.Equal(t, b.Issued, int64(7))
+	})
+}
+
+func TestProtectShortcodes(t *testing.T) {
+	resetCache()
+	defer func() {
+		for _, k := range []string{"00005", "00010", "00020", "00030"} {
+			Release(c.Client, k)
+		}
+		c.Client.DeleteValue(BlockKeyPrefix + "factory-i")
+		resetCache()
+	}()
+	c.Client.StoreLastDUID(models.LastDevEUI{ShortCode: "00008"})
+	Reserve(c.Client, "00030", "", "")
+	AllocateBlock(c.Client, "factory-i", "00040", "0004F", 0)
+
+	t.Run("RESERVE imported shortcodes", func(t *testing.T) {
+		last, reserved, err := ProtectShortcodes(c.Client, []string{"00012", "00005", "00010", "00011", "00020", "00030", "00041"}, false)
+		assert.NilError(t, err)
+		assert.Equal(t, last, "00008")
+		assert.Equal(t, len(reserved), 2)
+		assert.Equal(t, reserved[0].From+"-"+reserved[0].To, "00010-00012")
+		assert.Equal(t, reserved[1].Label, ImportLabel)
+
+		held, err := HeldBy(c.Client, "00011")
+		assert.NilError(t, err)
+		assert.Equal(t, held, "")
+		held, _ = HeldBy(c.Client, "00030")
+		assert.Equal(t, held, "reservation 00030-00030")
+		held, _ = HeldBy(c.Client, "00042")
+		assert.Equal(t, held, "block 00040-0004F allocated to \"factory-i\"")
+	})
+
+	t.Run("ADVANCE past imported shortcodes", func(t *testing.T) {
+		last, reserved, err := ProtectShortcodes(c.Client, []string{"00025", "00023", "00001"}, true)
+		assert.NilError(t, err)
+		assert.Equal(t, last, "00025")
+		assert.Equal(t, len(reserved), 0)
+
+		last, _, err = ProtectShortcodes(c.Client, []string{"00024"}, true)
+		assert.NilError(t, err)
+		assert.Equal(t, last, "00025")
+	})
+}
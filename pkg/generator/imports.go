@@ -0,0 +1,100 @@
+package generator
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/David-solly/mxbcode/pkg/cache"
+	"github.com/David-solly/mxbcode/pkg/models"
+)
+
+// ImportLabel : label of the reservations protecting imported shortcodes
+const ImportLabel = "imported"
+
+// ProtectShortcodes :
+// Keeps the counter from ever issuing shortcodes of devices registered elsewhere.
+// Shortcodes behind the counter are already safe. Those ahead are either
+// reserved - consecutive shortcodes share one reservation - or the counter
+// is advanced past the highest of them, giving up everything in between.
+// Shortcodes already reserved, blocked or inside a block are left alone
+func ProtectShortcodes(c cache.Service, shortcodes []string, advance bool) (string, []models.Reservation, error) {
+	last, _, err := c.ReadCache(cache.LastUIDKey)
+	if err != nil {
+		return "", nil, err
+	}
+	current, err := parseHex(last)
+	if err != nil {
+		return "", nil, err
+	}
+	skip, err := newSkipList(c)
+	if err != nil {
+		return "", nil, err
+	}
+
+	ahead := []int64{}
+	for _, sc := range shortcodes {
+		v, err := parseHex(sc)
+		if err != nil {
+			return "", nil, err
+		}
+		if v > current && !skip.skip(v) {
+			ahead = append(ahead, v)
+		}
+	}
+	sort.Slice(ahead, func(i, j int) bool { return ahead[i] < ahead[j] })
+
+	reserved := []models.Reservation{}
+	if len(ahead) == 0 {
+		return formatShortcode(current), reserved, nil
+	}
+
+	if advance {
+		top := formatShortcode(ahead[len(ahead)-1])
+		if _, err := c.StoreLastDUID(models.LastDevEUI{ShortCode: top}); err != nil {
+			return "", nil, err
+		}
+		return top, reserved, nil
+	}
+
+	for i := 0; i < len(ahead); {
+		j := i
+		for j+1 < len(ahead) && ahead[j+1] <= ahead[j]+1 {
+			j++
+		}
+		r, err := Reserve(c, formatShortcode(ahead[i]), formatShortcode(ahead[j]), ImportLabel)
+		if err != nil {
+			return "", reserved, err
+		}
+		reserved = append(reserved, r)
+		i = j + 1
+	}
+	return formatShortcode(current), reserved, nil
+}
+
+// HeldBy :
+// What holds shortcode back from import - another reservation
+// or the block of an owner. Blank when nothing does
+func HeldBy(c cache.Service, shortcode string) (string, error) {
+	v, err := parseHex(shortcode)
+	if err != nil {
+		return "", err
+	}
+	r, err := ReservationFor(c, shortcode)
+	if err != nil {
+		return "", err
+	}
+	if r != nil && r.Label != ImportLabel {
+		return fmt.Sprintf("reservation %s-%s", r.From, r.To), nil
+	}
+
+	blocks, err := Blocks(c)
+	if err != nil {
+		return "", err
+	}
+	for _, b := range blocks {
+		if lo, hi := blockBounds(b); v >= lo && v <= hi {
+			return fmt.Sprintf("block %s-%s allocated to %q", b.From, b.To, b.Owner), nil
+		}
+	}
+	return "", nil
+}
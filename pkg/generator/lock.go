@@ -0,0 +1,54 @@
+package generator
+
+import (
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/David-solly/mxbcode/pkg/cache"
+)
+
+// AllocationLockKey :
+// Held while reservations and blocks are checked against each other and stored
+// so two allocations can never both find the same range free
+const AllocationLockKey = "ALLOCATION-LOCK"
+
+const (
+	// a holder that died is let go after this long
+	allocationLockTTL = 10 * time.Second
+
+	// how long an allocation waits for the lock
+	allocationLockWait = 5 * time.Second
+)
+
+// tells the holders of the lock apart
+var lockTokens int64
+
+// ErrAllocationContended : the allocation lock was held for too long
+var ErrAllocationContended = errors.New("shortcode allocation is contended - try again")
+
+// runs allocate while holding the allocation lock of the store.
+// The lock is a value swapped in atomically so replicas sharing
+// redis are serialised as well as requests in one process
+func withAllocationLock(c cache.Service, allocate func() error) error {
+	token := strconv.FormatInt(time.Now().UnixNano(), 36) + "-" + strconv.FormatInt(atomic.AddInt64(&lockTokens, 1), 36)
+	deadline := time.Now().Add(allocationLockWait)
+	for {
+		ok, err := c.SwapValue(AllocationLockKey, "", token, allocationLockTTL)
+		if err != nil {
+			return err
+		}
+		if ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			return ErrAllocationContended
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	// only our own token is let go - it may have expired and been taken since
+	defer c.SwapValue(AllocationLockKey, token, "", time.Millisecond)
+
+	return allocate()
+}
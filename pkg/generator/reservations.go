@@ -0,0 +1,249 @@
+package generator
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/David-solly/mxbcode/pkg/cache"
+	"github.com/David-solly/mxbcode/pkg/models"
+)
+
+// ReservedKeyPrefix :
+// Prefix of reservation records in the cache store
+// records are keyed `RESERVED:<FROM>:<TO>`
+const ReservedKeyPrefix = "RESERVED:"
+
+// ReservedClaimPrefix :
+// Marks a reserved shortcode as claimed while it is registered
+// keyed `RESERVED-CLAIM:<SHORTCODE>` - the stored device marks it after
+const ReservedClaimPrefix = "RESERVED-CLAIM:"
+
+// how long a claim is marked while its device is registered
+// a claimer that died lets the shortcode be claimed again after this long
+const claimTTL = 5 * time.Minute
+
+// Reserve :
+// Holds back the inclusive range from - to so the sequential
+// generator never issues it. `to` may be blank to reserve a single shortcode.
+// Only shortcodes ahead of the counter can be reserved.
+// The range is checked and stored under the allocation lock
+func Reserve(c cache.Service, from, to, label string) (models.Reservation, error) {
+	if to == "" {
+		to = from
+	}
+	start, err := parseHex(from)
+	if err != nil {
+		return models.Reservation{}, err
+	}
+	end, err := parseHex(to)
+	if err != nil {
+		return models.Reservation{}, err
+	}
+	if end < start {
+		return models.Reservation{}, fmt.Errorf("reservation range %q-%q is reversed", from, to)
+	}
+
+	r := models.Reservation{From: formatShortcode(start), To: formatShortcode(end), Label: label, Created: time.Now().UTC()}
+	err = withAllocationLock(c, func() error {
+		last, _, err := c.ReadCache(cache.LastUIDKey)
+		if err != nil {
+			return err
+		}
+		current, err := parseHex(last)
+		if err != nil {
+			return err
+		}
+		if start <= current {
+			return fmt.Errorf("shortcode %s has already been passed by the counter (%s)", formatShortcode(start), formatShortcode(current))
+		}
+
+		if err := checkFree(c, start, end); err != nil {
+			return err
+		}
+
+		data, _ := json.Marshal(r)
+		_, err = c.StoreValue(reservationKey(r), string(data))
+		return err
+	})
+	if err != nil {
+		return models.Reservation{}, err
+	}
+
+	return r, nil
+}
+
+// Hold :
+// Reserves shortcodes the counter has already issued but that never
+// reached the provider, eg a batch cut short by shutdown. Contiguous
+// shortcodes share a reservation and any stored since are left out.
+// Held shortcodes are claimed like any other reservation
+func Hold(c cache.Service, shortcodes []string, label string) (held []models.Reservation, err error) {
+	values := []int64{}
+	for _, sc := range shortcodes {
+		v, err := parseHex(sc)
+		if err != nil {
+			return nil, err
+		}
+		if _, found, _ := c.ReadCache(formatShortcode(v)); found {
+			continue // registered after all
+		}
+		values = append(values, v)
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	err = withAllocationLock(c, func() error {
+		held, err = hold(c, values, label)
+		return err
+	})
+
+	return held, err
+}
+
+// reserves the sorted values - contiguous ones sharing a reservation
+// callers hold the allocation lock
+func hold(c cache.Service, values []int64, label string) ([]models.Reservation, error) {
+	held := []models.Reservation{}
+	for i := 0; i < len(values); {
+		j := i
+		for j+1 < len(values) && values[j+1] <= values[j]+1 {
+			j++
+		}
+		if err := checkFree(c, values[i], values[j]); err != nil {
+			return held, err
+		}
+
+		r := models.Reservation{From: formatShortcode(values[i]), To: formatShortcode(values[j]), Label: label, Created: time.Now().UTC()}
+		data, _ := json.Marshal(r)
+		if _, err := c.StoreValue(reservationKey(r), string(data)); err != nil {
+			return held, err
+		}
+		held = append(held, r)
+		i = j + 1
+	}
+	return held, nil
+}
+
+// Reservations :
+// Every reservation in the store ordered by shortcode
+func Reservations(c cache.Service) ([]models.Reservation, error) {
+	keys, err := c.ListKeys(ReservedKeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	list := []models.Reservation{}
+	for _, k := range keys {
+		data, found, _ := c.ReadCache(k)
+		if !found {
+			continue // released since listing
+		}
+		r := models.Reservation{}
+		if err := json.Unmarshal([]byte(data), &r); err != nil {
+			return nil, fmt.Errorf("corrupt reservation %q - %v", k, err)
+		}
+		list = append(list, r)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].From < list[j].From })
+
+	return list, nil
+}
+
+// ReservationFor :
+// The reservation holding shortcode - nil if it is not reserved
+func ReservationFor(c cache.Service, shortcode string) (*models.Reservation, error) {
+	v, err := parseHex(shortcode)
+	if err != nil {
+		return nil, err
+	}
+	list, err := Reservations(c)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range list {
+		if lo, hi := reservationBounds(r); v >= lo && v <= hi {
+			return &r, nil
+		}
+	}
+	return nil, nil
+}
+
+// Release :
+// Removes the reservation holding shortcode
+// any shortcodes already claimed from it stay registered
+func Release(c cache.Service, shortcode string) (models.Reservation, error) {
+	r, err := ReservationFor(c, shortcode)
+	if err != nil {
+		return models.Reservation{}, err
+	}
+	if r == nil {
+		return models.Reservation{}, fmt.Errorf("shortcode %s is not reserved", shortcode)
+	}
+	if _, err := c.DeleteValue(reservationKey(*r)); err != nil {
+		return models.Reservation{}, err
+	}
+	return *r, nil
+}
+
+// ClaimReserved :
+// Builds the DevEUI for a reserved shortcode and marks it claimed.
+// The mark is swapped in atomically so only one of two racing claims
+// gets the shortcode. The caller registers the DevEUI with the LoRaWAN
+// provider and stores it - a stored device marks the shortcode as claimed
+// from then on - or gives the shortcode back with Unclaim if that fails
+func ClaimReserved(c cache.Service, shortcode string) (*models.DevEUI, error) {
+	v, err := parseHex(shortcode)
+	if err != nil {
+		return nil, err
+	}
+	r, err := ReservationFor(c, shortcode)
+	if err != nil {
+		return nil, err
+	}
+	if r == nil {
+		return nil, fmt.Errorf("shortcode %s is not reserved", formatShortcode(v))
+	}
+	if _, found, _ := c.ReadCache(formatShortcode(v)); found {
+		return nil, fmt.Errorf("shortcode %s has already been claimed", formatShortcode(v))
+	}
+
+	dev := models.DevEUI{ShortCode: fmt.Sprintf("%05s", strconv.FormatInt(v, 16))}
+	generateBarcodeTrunk(&dev)
+
+	claimed, err := c.SwapValue(ReservedClaimPrefix+formatShortcode(v), "", strings.ToUpper(dev.DevEUI), claimTTL)
+	if err != nil {
+		return nil, err
+	}
+	if !claimed {
+		return nil, fmt.Errorf("shortcode %s has already been claimed", formatShortcode(v))
+	}
+	// stored while it was being marked
+	if _, found, _ := c.ReadCache(formatShortcode(v)); found {
+		return nil, fmt.Errorf("shortcode %s has already been claimed", formatShortcode(v))
+	}
+	return &dev, nil
+}
+
+// Unclaim :
+// Gives back a reserved shortcode whose DevEUI could not be registered
+func Unclaim(c cache.Service, shortcode string) error {
+	v, err := parseHex(shortcode)
+	if err != nil {
+		return err
+	}
+	_, err = c.DeleteValue(ReservedClaimPrefix + formatShortcode(v))
+	return err
+}
+
+func reservationKey(r models.Reservation) string {
+	return ReservedKeyPrefix + r.From + ":" + r.To
+}
+
+func reservationBounds(r models.Reservation) (int64, int64) {
+	lo, _ := parseHex(r.From)
+	hi, _ := parseHex(r.To)
+	return lo, hi
+}
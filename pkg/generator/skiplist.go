@@ -0,0 +1,114 @@
+package generator
+
+import (
+	"fmt"
+
+	"github.com/David-solly/mxbcode/pkg/cache"
+)
+
+// skipList :
+// Shortcodes a counter steps over -
+// the blocklist plus every reserved range and allocated block
+type skipList struct {
+	reserved  [][2]int64
+	allocated [][2]int64
+}
+
+// the skip list of the global counter
+// blocks have their own counters so the global one steps over them
+func newSkipList(c cache.Service) (*skipList, error) {
+	reservations, err := Reservations(c)
+	if err != nil {
+		return nil, err
+	}
+	blocks, err := Blocks(c)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &skipList{}
+	for _, r := range reservations {
+		lo, hi := reservationBounds(r)
+		s.reserved = append(s.reserved, [2]int64{lo, hi})
+	}
+	for _, b := range blocks {
+		lo, hi := blockBounds(b)
+		s.allocated = append(s.allocated, [2]int64{lo, hi})
+	}
+	return s, nil
+}
+
+// reserved ranges and blocks never overlap
+// so they can be treated as one list
+func (s *skipList) ranges() [][2]int64 {
+	return append(append([][2]int64{}, s.reserved...), s.allocated...)
+}
+
+func (s *skipList) skip(v int64) bool {
+	for _, r := range s.ranges() {
+		if v >= r[0] && v <= r[1] {
+			return true
+		}
+	}
+	return DefaultBlocklist.Blocked(v)
+}
+
+// number of skipped shortcodes within the inclusive range from - to
+// shortcodes both blocked and reserved are only counted once
+func (s *skipList) countBetween(from, to int64) int64 {
+	n := DefaultBlocklist.CountBetween(from, to)
+	for _, r := range s.ranges() {
+		lo, hi := r[0], r[1]
+		if lo < from {
+			lo = from
+		}
+		if hi > to {
+			hi = to
+		}
+		if lo > hi {
+			continue
+		}
+		n += (hi - lo + 1) - DefaultBlocklist.CountBetween(lo, hi)
+	}
+	return n
+}
+
+func (s *skipList) reservedCount() int64 {
+	return rangeTotal(s.reserved)
+}
+
+func (s *skipList) allocatedCount() int64 {
+	return rangeTotal(s.allocated)
+}
+
+func rangeTotal(ranges [][2]int64) int64 {
+	n := int64(0)
+	for _, r := range ranges {
+		n += r[1] - r[0] + 1
+	}
+	return n
+}
+
+// ensure start - end does not overlap a reservation or an allocated block
+func checkFree(c cache.Service, start, end int64) error {
+	reservations, err := Reservations(c)
+	if err != nil {
+		return err
+	}
+	for _, r := range reservations {
+		if lo, hi := reservationBounds(r); start <= hi && end >= lo {
+			return fmt.Errorf("range %s-%s overlaps reservation %s-%s", formatShortcode(start), formatShortcode(end), r.From, r.To)
+		}
+	}
+
+	blocks, err := Blocks(c)
+	if err != nil {
+		return err
+	}
+	for _, b := range blocks {
+		if lo, hi := blockBounds(b); start <= hi && end >= lo {
+			return fmt.Errorf("range %s-%s overlaps block %s-%s allocated to %q", formatShortcode(start), formatShortcode(end), b.From, b.To, b.Owner)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/David-solly/mxbcode/pkg/models"
+)
+
+// Record :
+// A device provisioned elsewhere. Keys and serial are optional
+type Record struct {
+	Line    int    `json:"line"`
+	DevEUI  string `json:"deveui"`
+	JoinEUI string `json:"joineui,omitempty"`
+	AppKey  string `json:"appkey,omitempty"`
+	NwkKey  string `json:"nwkkey,omitempty"`
+	Serial  string `json:"serial,omitempty"`
+}
+
+// ShortCode : the last 5 digits of the DevEUI
+func (r Record) ShortCode() string {
+	return r.DevEUI[len(r.DevEUI)-5:]
+}
+
+// HasKeys : reports whether root keys came with the device
+func (r Record) HasKeys() bool {
+	return r.AppKey != ""
+}
+
+// kinds of problem
+const (
+	Invalid   = "invalid"
+	Duplicate = "duplicate"
+	Conflict  = "conflict"
+)
+
+// Problem :
+// A line that was not imported and why.
+// Duplicates repeat a device already known - conflicts
+// claim a shortcode or serial that belongs to another device
+type Problem struct {
+	Line   int    `json:"line"`
+	DevEUI string `json:"deveui,omitempty"`
+	Kind   string `json:"kind"`
+	Reason string `json:"reason"`
+}
+
+// Report :
+// The outcome of an import. Last is the counter once the
+// imported shortcodes are protected - Reserved the reservations made
+type Report struct {
+	Batch      string               `json:"batch"`
+	Imported   int                  `json:"imported"`
+	Keys       int                  `json:"keys"`
+	Serials    int                  `json:"serials"`
+	Duplicates int                  `json:"duplicates"`
+	Conflicts  int                  `json:"conflicts"`
+	Invalid    int                  `json:"invalid"`
+	Last       string               `json:"last"`
+	Reserved   []models.Reservation `json:"reserved"`
+	Problems   []Problem            `json:"problems"`
+}
+
+// Add : records a problem and counts it by kind
+func (r *Report) Add(p Problem) {
+	switch p.Kind {
+	case Duplicate:
+		r.Duplicates++
+	case Conflict:
+		r.Conflicts++
+	default:
+		r.Invalid++
+	}
+	r.Problems = append(r.Problems, p)
+}
+
+var (
+	validEUI    = regexp.MustCompile(`^[0-9A-F]{16}$`)
+	validKey    = regexp.MustCompile(`^[0-9A-F]{32}$`)
+	validSerial = regexp.MustCompile(`^[a-zA-Z0-9._:-]{1,64}$`)
+)
+
+// header names accepted for each field
+// the export columns and the network server spellings
+var aliases = map[string]string{
+	"deveui": "deveui", "dev_eui": "deveui",
+	"joineui": "joineui", "join_eui": "joineui", "appeui": "joineui", "app_eui": "joineui",
+	"appkey": "appkey", "app_key": "appkey",
+	"nwkkey": "nwkkey", "nwk_key": "nwkkey",
+	"serial": "serial",
+}
+
+// Read :
+// The devices of a csv file with a header row or of ndjson.
+// A blank format is told from the first character - `{` for ndjson.
+// Invalid lines and devices repeated within the file are returned as
+// problems - the first occurrence of a DevEUI or shortcode is kept
+func Read(r io.Reader, format string) ([]Record, []Problem, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	if format == "" {
+		format = "csv"
+		if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+			format = "ndjson"
+		}
+	}
+
+	var records []Record
+	switch format {
+	case "csv":
+		records, err = readCSV(data)
+	case "ndjson":
+		records, err = readNDJSON(data)
+	default:
+		return nil, nil, fmt.Errorf("unknown import format %q - csv or ndjson", format)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	valid := []Record{}
+	problems := []Problem{}
+	devices := map[string]int{}
+	shortcodes := map[string]Record{}
+	for _, rec := range records {
+		rec = normalise(rec)
+		if reason := validate(rec); reason != "" {
+			problems = append(problems, Problem{Line: rec.Line, DevEUI: rec.DevEUI, Kind: Invalid, Reason: reason})
+			continue
+		}
+		if line, k := devices[rec.DevEUI]; k {
+			problems = append(problems, Problem{Line: rec.Line, DevEUI: rec.DevEUI, Kind: Duplicate, Reason: fmt.Sprintf("duplicate of line %d", line)})
+			continue
+		}
+		if other, k := shortcodes[rec.ShortCode()]; k {
+			problems = append(problems, Problem{Line: rec.Line, DevEUI: rec.DevEUI, Kind: Conflict, Reason: fmt.Sprintf("shortcode %s conflicts with %s on line %d", rec.ShortCode(), other.DevEUI, other.Line)})
+			continue
+		}
+		devices[rec.DevEUI] = rec.Line
+		shortcodes[rec.ShortCode()] = rec
+		valid = append(valid, rec)
+	}
+	return valid, problems, nil
+}
+
+func readCSV(data []byte) ([]Record, error) {
+	cr := csv.NewReader(bytes.NewReader(data))
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("invalid csv header - %v", err)
+	}
+	fields := map[string]int{}
+	for i, h := range header {
+		if f, k := aliases[strings.ToLower(strings.TrimSpace(h))]; k {
+			fields[f] = i
+		}
+	}
+	if _, k := fields["deveui"]; !k {
+		return nil, fmt.Errorf("csv header has no deveui column")
+	}
+
+	records := []Record{}
+	for line := 2; ; line++ {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		get := func(f string) string {
+			if i, k := fields[f]; k && i < len(row) {
+				return row[i]
+			}
+			return ""
+		}
+		records = append(records, Record{Line: line, DevEUI: get("deveui"), JoinEUI: get("joineui"), AppKey: get("appkey"), NwkKey: get("nwkkey"), Serial: get("serial")})
+	}
+	return records, nil
+}
+
+func readNDJSON(data []byte) ([]Record, error) {
+	records := []Record{}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" {
+			continue
+		}
+		obj := map[string]string{}
+		if err := json.Unmarshal([]byte(text), &obj); err != nil {
+			return nil, fmt.Errorf("line %d is not a json object of strings - %v", line, err)
+		}
+		rec := Record{Line: line}
+		for k, v := range obj {
+			switch aliases[strings.ToLower(k)] {
+			case "deveui":
+				rec.DevEUI = v
+			case "joineui":
+				rec.JoinEUI = v
+			case "appkey":
+				rec.AppKey = v
+			case "nwkkey":
+				rec.NwkKey = v
+			case "serial":
+				rec.Serial = v
+			}
+		}
+		records = append(records, rec)
+	}
+	return records, sc.Err()
+}
+
+func normalise(r Record) Record {
+	r.DevEUI = strings.ToUpper(strings.TrimSpace(r.DevEUI))
+	r.JoinEUI = strings.ToUpper(strings.TrimSpace(r.JoinEUI))
+	r.AppKey = strings.ToUpper(strings.TrimSpace(r.AppKey))
+	r.NwkKey = strings.ToUpper(strings.TrimSpace(r.NwkKey))
+	r.Serial = strings.TrimSpace(r.Serial)
+	// a LoRaWAN 1.0 device only has the AppKey - ChirpStack files carry it as nwk_key
+	if r.AppKey == "" {
+		r.AppKey, r.NwkKey = r.NwkKey, ""
+	}
+	return r
+}
+
+// the reason a record cannot be imported - blank when it can
+func validate(r Record) string {
+	switch {
+	case !validEUI.MatchString(r.DevEUI):
+		return fmt.Sprintf("invalid DevEUI %q", r.DevEUI)
+	case r.JoinEUI != "" && !validEUI.MatchString(r.JoinEUI):
+		return fmt.Sprintf("invalid JoinEUI %q", r.JoinEUI)
+	case r.AppKey != "" && !validKey.MatchString(r.AppKey):
+		return "invalid AppKey"
+	case r.NwkKey != "" && !validKey.MatchString(r.NwkKey):
+		return "invalid NwkKey"
+	case r.AppKey != "" && r.JoinEUI == "":
+		return "root keys without a JoinEUI"
+	case r.Serial != "" && !validSerial.MatchString(r.Serial):
+		return fmt.Sprintf("invalid serial %q", r.Serial)
+	}
+	return ""
+}
@@ -0,0 +1,69 @@
+package importer
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/pkg/testutil/assert"
+)
+
+func TestRead(t *testing.T) {
+	suite := []struct {
+		testName string
+		format   string
+		data     string
+		devices  []string
+		problems []string
+		err      string
+	}{
+		{"CSV - export columns", "", "deveui,shortcode,serial\n000000000000a0a1,0A0A1,LINE-1\n000000000000A0A2,0A0A2,\n", []string{"000000000000A0A1", "000000000000A0A2"}, []string{}, ""},
+		{"CSV - chirpstack header", "csv", "dev_eui,join_eui,nwk_key\n000000000000a0a1,70b3d57ed0000000,000102030405060708090a0b0c0d0e0f\n", []string{"000000000000A0A1"}, []string{}, ""},
+		{"CSV - invalid lines", "csv", "deveui,appkey,joineui\nxyz,,\n000000000000A0A2,0011,70B3D57ED0000000\n000000000000A0A3,000102030405060708090A0B0C0D0E0F,\n", []string{}, []string{"invalid:invalid DevEUI", "invalid:invalid AppKey", "invalid:root keys without a JoinEUI"}, ""},
+		{"CSV - duplicates and conflicts", "csv", "deveui\n000000000000A0A1\n000000000000a0a1\n0000000000F0A0A1\n", []string{"000000000000A0A1"}, []string{"duplicate:duplicate of line 2", "conflict:shortcode 0A0A1 conflicts with 000000000000A0A1 on line 2"}, ""},
+		{"CSV - no deveui", "csv", "eui\n000000000000A0A1\n", nil, nil, "csv header has no deveui column"},
+		{"NDJSON - detected", "", "{\"DevEUI\":\"000000000000A0A1\",\"AppEUI\":\"70B3D57ED0000000\",\"AppKey\":\"000102030405060708090A0B0C0D0E0F\"}\n\n{\"deveui\":\"000000000000A0A2\",\"serial\":\"bad serial\"}\n", []string{"000000000000A0A1"}, []string{"invalid:invalid serial"}, ""},
+		{"NDJSON - not an object", "ndjson", "[1]\n", nil, nil, "line 1 is not a json object"},
+		{"FORMAT - unknown", "xml", "", nil, nil, "unknown import format"},
+	}
+	for i, test := range suite {
+		t.Run(fmt.Sprintf("#%d: %q", i, test.testName), func(t *testing.T) {
+			records, problems, err := Read(strings.NewReader(test.data), test.format)
+			if test.err != "" {
+				assert.Error(t, err, test.err)
+				return
+			}
+			assert.NilError(t, err)
+
+			devices := []string{}
+			for _, r := range records {
+				devices = append(devices, r.DevEUI)
+			}
+			assert.DeepEqual(t, devices, test.devices)
+			assert.Equal(t, len(problems), len(test.problems))
+			for j, p := range problems {
+				assert.Contains(t, p.Kind+":"+p.Reason, test.problems[j])
+			}
+		})
+	}
+}
+
+func TestReport(t *testing.T) {
+	r := Report{}
+	r.Add(Problem{Kind: Duplicate})
+	r.Add(Problem{Kind: Conflict})
+	r.Add(Problem{Kind: Conflict})
+	r.Add(Problem{Kind: Invalid})
+	assert.Equal(t, r.Duplicates, 1)
+	assert.Equal(t, r.Conflicts, 2)
+	assert.Equal(t, r.Invalid, 1)
+	assert.Equal(t, len(r.Problems), 4)
+}
+
+func TestReadAppKey(t *testing.T) {
+	records, _, err := Read(strings.NewReader("dev_eui,join_eui,nwk_key,app_key\n000000000000a0a1,70b3d57ed0000000,000102030405060708090a0b0c0d0e0f,\n"), "csv")
+	assert.NilError(t, err)
+	assert.Equal(t, records[0].AppKey, "000102030405060708090A0B0C0D0E0F")
+	assert.Equal(t, records[0].NwkKey, "")
+	assert.Equal(t, records[0].HasKeys(), true)
+}
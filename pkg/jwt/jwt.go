@@ -0,0 +1,267 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// signing algorithms
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+)
+
+// DefaultLeeway : clock skew allowed when checking `exp` and `nbf`
+const DefaultLeeway = 30 * time.Second
+
+var encoding = base64.RawURLEncoding
+
+// Key :
+// A key tokens are verified with - a []byte secret for HS256,
+// an *rsa.PublicKey for RS256 or a P-256 *ecdsa.PublicKey for ES256.
+// ID matches the `kid` of the token header when both are set
+type Key struct {
+	ID        string
+	Algorithm string
+	Key       interface{}
+}
+
+// Claims : the payload of a verified token
+type Claims map[string]interface{}
+
+// String : a string claim - blank when missing or not a string
+func (c Claims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+// Strings :
+// A claim holding a list - either a json array of strings
+// or a space separated string as `scope` is in OAuth 2.0
+func (c Claims) Strings(name string) []string {
+	switch v := c[name].(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		list := []string{}
+		for _, s := range v {
+			if s, k := s.(string); k {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+// Time : a NumericDate claim - false when missing or not a number
+func (c Claims) Time(name string) (time.Time, bool) {
+	f, k := c[name].(float64)
+	if !k {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(f), 0), true
+}
+
+// Verifier :
+// Checks the signature of a token against Keys then its claims.
+// `exp` is required, `nbf` checked when present, and `iss` and `aud`
+// must match Issuer and Audience when those are set
+type Verifier struct {
+	Keys     []Key
+	Issuer   string
+	Audience string
+	Leeway   time.Duration
+
+	// the current time - replaced in tests
+	Now func() time.Time
+}
+
+// NewVerifier : a verifier of tokens signed by keys allowing DefaultLeeway
+func NewVerifier(keys []Key, issuer, audience string) (*Verifier, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("at least one token key is required")
+	}
+	for _, k := range keys {
+		if err := checkKey(k); err != nil {
+			return nil, err
+		}
+	}
+	return &Verifier{Keys: keys, Issuer: issuer, Audience: audience, Leeway: DefaultLeeway, Now: time.Now}, nil
+}
+
+// Verify : the claims of a valid token
+func (v *Verifier) Verify(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	if err := decode(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed token header - %v", err)
+	}
+	signature, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed token signature")
+	}
+
+	// the key decides the algorithm - a token can't pick a weaker one
+	signed, tried := []byte(parts[0]+"."+parts[1]), false
+	for _, k := range v.Keys {
+		if k.Algorithm != header.Alg || (header.Kid != "" && k.ID != "" && k.ID != header.Kid) {
+			continue
+		}
+		tried = true
+		if verify(k, signed, signature) {
+			claims := Claims{}
+			if err := decode(parts[1], &claims); err != nil {
+				return nil, fmt.Errorf("malformed token claims - %v", err)
+			}
+			return claims, v.check(claims)
+		}
+	}
+	if !tried {
+		return nil, fmt.Errorf("no key to verify %q tokens with", header.Alg)
+	}
+	return nil, errors.New("invalid token signature")
+}
+
+// the registered claims of a correctly signed token
+func (v *Verifier) check(c Claims) error {
+	now := v.Now()
+	exp, k := c.Time("exp")
+	if !k {
+		return errors.New("token has no expiry")
+	}
+	if now.After(exp.Add(v.Leeway)) {
+		return errors.New("token has expired")
+	}
+	if nbf, k := c.Time("nbf"); k && now.Add(v.Leeway).Before(nbf) {
+		return errors.New("token is not valid yet")
+	}
+	if v.Issuer != "" && c.String("iss") != v.Issuer {
+		return fmt.Errorf("token issuer %q is not trusted", c.String("iss"))
+	}
+	if v.Audience != "" {
+		aud := c.Strings("aud")
+		for _, a := range aud {
+			if a == v.Audience {
+				return nil
+			}
+		}
+		return fmt.Errorf("token is not intended for %q", v.Audience)
+	}
+	return nil
+}
+
+// Sign :
+// A token carrying claims signed with a private key - a []byte
+// secret for HS256, an *rsa.PrivateKey or an *ecdsa.PrivateKey
+func Sign(alg, kid string, key interface{}, claims Claims) (string, error) {
+	header := map[string]string{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	h, _ := json.Marshal(header)
+	p, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := encoding.EncodeToString(h) + "." + encoding.EncodeToString(p)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch k := key.(type) {
+	case []byte:
+		if alg != HS256 {
+			return "", fmt.Errorf("a secret can't sign %q tokens", alg)
+		}
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		if alg != RS256 {
+			return "", fmt.Errorf("an RSA key can't sign %q tokens", alg)
+		}
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:]); err != nil {
+			return "", err
+		}
+	case *ecdsa.PrivateKey:
+		if alg != ES256 {
+			return "", fmt.Errorf("an EC key can't sign %q tokens", alg)
+		}
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			return "", err
+		}
+		// JWS signatures are r and s padded to 32 bytes each
+		signature = make([]byte, 64)
+		rb, sb := r.Bytes(), s.Bytes()
+		copy(signature[32-len(rb):32], rb)
+		copy(signature[64-len(sb):], sb)
+	default:
+		return "", fmt.Errorf("unsupported signing key %T", key)
+	}
+
+	return signed + "." + encoding.EncodeToString(signature), nil
+}
+
+func verify(k Key, signed, signature []byte) bool {
+	digest := sha256.Sum256(signed)
+	switch key := k.Key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write(signed)
+		return hmac.Equal(mac.Sum(nil), signature)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+	case *ecdsa.PublicKey:
+		if len(signature) != 64 {
+			return false
+		}
+		r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(key, digest[:], r, s)
+	}
+	return false
+}
+
+// the algorithm of a key has to suit its type
+func checkKey(k Key) error {
+	ok := false
+	switch key := k.Key.(type) {
+	case []byte:
+		ok = k.Algorithm == HS256 && len(key) > 0
+	case *rsa.PublicKey:
+		ok = k.Algorithm == RS256
+	case *ecdsa.PublicKey:
+		ok = k.Algorithm == ES256 && key.Curve.Params().Name == "P-256"
+	}
+	if !ok {
+		return fmt.Errorf("key %q can't verify %q tokens", k.ID, k.Algorithm)
+	}
+	return nil
+}
+
+func decode(part string, v interface{}) error {
+	data, err := encoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
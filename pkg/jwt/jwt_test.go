@@ -0,0 +1,164 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/pkg/testutil/assert"
+)
+
+var now = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func TestVerify(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	v, err := NewVerifier([]Key{
+		{ID: "hs", Algorithm: HS256, Key: secret},
+		{ID: "rs", Algorithm: RS256, Key: &rsaKey.PublicKey},
+		{ID: "es", Algorithm: ES256, Key: &ecKey.PublicKey},
+	}, "https://id.example.com", "mxbcode")
+	assert.NilError(t, err)
+	v.Now = func() time.Time { return now }
+
+	claims := func(extra Claims) Claims {
+		c := Claims{"iss": "https://id.example.com", "aud": "mxbcode", "sub": "station-1", "exp": now.Add(time.Hour).Unix()}
+		for k, val := range extra {
+			c[k] = val
+		}
+		return c
+	}
+	sign := func(alg, kid string, key interface{}, c Claims) string {
+		token, err := Sign(alg, kid, key, c)
+		assert.NilError(t, err)
+		return token
+	}
+
+	suite := []struct {
+		testName string
+		token    string
+		err      string
+	}{
+		{"VERIFY - HS256", sign(HS256, "hs", secret, claims(nil)), ""},
+		{"VERIFY - RS256", sign(RS256, "rs", rsaKey, claims(nil)), ""},
+		{"VERIFY - ES256", sign(ES256, "es", ecKey, claims(nil)), ""},
+		{"VERIFY - no kid", sign(ES256, "", ecKey, claims(nil)), ""},
+		{"VERIFY - audience list", sign(HS256, "hs", secret, claims(Claims{"aud": []string{"other", "mxbcode"}})), ""},
+		{"VERIFY - within leeway", sign(HS256, "hs", secret, claims(Claims{"exp": now.Add(-10 * time.Second).Unix()})), ""},
+		{"VERIFY - expired", sign(HS256, "hs", secret, claims(Claims{"exp": now.Add(-time.Minute).Unix()})), "token has expired"},
+		{"VERIFY - no expiry", sign(HS256, "hs", secret, claims(Claims{"exp": nil})), "token has no expiry"},
+		{"VERIFY - not before", sign(HS256, "hs", secret, claims(Claims{"nbf": now.Add(time.Minute).Unix()})), "not valid yet"},
+		{"VERIFY - issuer", sign(HS256, "hs", secret, claims(Claims{"iss": "https://evil.example.com"})), "is not trusted"},
+		{"VERIFY - audience", sign(HS256, "hs", secret, claims(Claims{"aud": "other"})), "not intended for"},
+		{"VERIFY - wrong key", sign(ES256, "es", otherKey, claims(nil)), "invalid token signature"},
+		{"VERIFY - wrong kid", sign(ES256, "rs", ecKey, claims(nil)), "no key to verify"},
+		{"VERIFY - tampered", tamper(sign(HS256, "hs", secret, claims(nil))), "invalid token signature"},
+		{"VERIFY - none", unsigned(claims(nil)), `no key to verify "none"`},
+		// a public key used as an HMAC secret
+		{"VERIFY - algorithm confusion", sign(HS256, "rs", x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey), claims(nil)), "no key to verify"},
+		{"VERIFY - malformed", "abc.def", "malformed token"},
+	}
+	for i, test := range suite {
+		t.Run(fmt.Sprintf("#%d: %q", i, test.testName), func(t *testing.T) {
+			c, err := v.Verify(test.token)
+			if test.err != "" {
+				assert.Error(t, err, test.err)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, c.String("sub"), "station-1")
+		})
+	}
+}
+
+func TestClaims(t *testing.T) {
+	c := Claims{}
+	json.Unmarshal([]byte(`{"scope":"generate lookup","scp":["admin",1],"exp":1767268800}`), &c)
+	assert.DeepEqual(t, c.Strings("scope"), []string{"generate", "lookup"})
+	assert.DeepEqual(t, c.Strings("scp"), []string{"admin"})
+	assert.Equal(t, len(c.Strings("missing")), 0)
+	exp, k := c.Time("exp")
+	assert.Equal(t, k, true)
+	assert.Equal(t, exp.UTC(), now)
+}
+
+func TestKeys(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	b := func(i *big.Int) string { return encoding.EncodeToString(i.Bytes()) }
+
+	jwks := fmt.Sprintf(`{"keys":[
+		{"kty":"oct","kid":"hs","k":%q},
+		{"kty":"RSA","kid":"rs","alg":"RS256","use":"sig","n":%q,"e":"AQAB"},
+		{"kty":"EC","kid":"es","crv":"P-256","x":%q,"y":%q},
+		{"kty":"RSA","kid":"enc","use":"enc","n":%q,"e":"AQAB"},
+		{"kty":"RSA","kid":"ps","alg":"PS256","n":%q,"e":"AQAB"}
+	]}`, encoding.EncodeToString([]byte("secret")), b(rsaKey.N), b(ecKey.X), b(ecKey.Y), b(rsaKey.N), b(rsaKey.N))
+
+	keys, err := ParseJWKS([]byte(jwks))
+	assert.NilError(t, err)
+	assert.Equal(t, len(keys), 3)
+	assert.Equal(t, keys[1].Key.(*rsa.PublicKey).E, 65537)
+	assert.Equal(t, keys[2].Algorithm, ES256)
+
+	_, err = ParseJWKS([]byte(`{"keys":[{"kty":"EC","crv":"P-256","x":"AA","y":"AA"}]}`))
+	assert.Error(t, err, "not on P-256")
+	_, err = ParseJWKS([]byte(`{"keys":[]}`))
+	assert.Error(t, err, "holds no")
+
+	dir, _ := ioutil.TempDir("", "jwt")
+	defer os.RemoveAll(dir)
+
+	der, _ := x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+	pemFile := filepath.Join(dir, "es.pem")
+	ioutil.WriteFile(pemFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600)
+	keys, err = LoadKeys(pemFile)
+	assert.NilError(t, err)
+	assert.Equal(t, keys[0].Algorithm, ES256)
+
+	jwksFile := filepath.Join(dir, "jwks.json")
+	ioutil.WriteFile(jwksFile, []byte(jwks), 0600)
+	keys, err = LoadKeys(jwksFile)
+	assert.NilError(t, err)
+	assert.Equal(t, len(keys), 3)
+
+	secretFile := filepath.Join(dir, "secret")
+	ioutil.WriteFile(secretFile, []byte(" shared secret \n"), 0600)
+	k, err := LoadSecret(secretFile)
+	assert.NilError(t, err)
+	assert.Equal(t, string(k.Key.([]byte)), "shared secret")
+
+	_, err = NewVerifier([]Key{{Algorithm: RS256, Key: []byte("secret")}}, "", "")
+	assert.Error(t, err, "can't verify")
+}
+
+// flips the subject of a signed token
+func tamper(token string) string {
+	parts := strings.Split(token, ".")
+	c := Claims{}
+	decode(parts[1], &c)
+	c["sub"] = "admin"
+	p, _ := json.Marshal(c)
+	return parts[0] + "." + encoding.EncodeToString(p) + "." + parts[2]
+}
+
+func unsigned(c Claims) string {
+	h, _ := json.Marshal(map[string]string{"alg": "none"})
+	p, _ := json.Marshal(c)
+	return encoding.EncodeToString(h) + "." + encoding.EncodeToString(p) + "."
+}
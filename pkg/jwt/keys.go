@@ -0,0 +1,174 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strings"
+)
+
+// a JSON Web Key - RFC 7517
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	K   string `json:"k"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// ParseJWKS :
+// The verification keys of a JSON Web Key Set.
+// Keys for encryption or other algorithms are left out
+func ParseJWKS(data []byte) ([]Key, error) {
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS - %v", err)
+	}
+
+	keys := []Key{}
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.key()
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWKS key %d - %v", i, err)
+		}
+		if key.Algorithm == "" {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("the JWKS holds no HS256, RS256 or ES256 signing keys")
+	}
+	return keys, nil
+}
+
+func (k jwk) key() (Key, error) {
+	b := func(s string) ([]byte, error) { return encoding.DecodeString(s) }
+	supported := func(alg string) string {
+		if k.Alg == "" || k.Alg == alg {
+			return alg
+		}
+		return ""
+	}
+
+	switch k.Kty {
+	case "oct":
+		secret, err := b(k.K)
+		if err != nil || len(secret) == 0 {
+			return Key{}, errors.New("invalid secret")
+		}
+		return Key{ID: k.Kid, Algorithm: supported(HS256), Key: secret}, nil
+
+	case "RSA":
+		n, errN := b(k.N)
+		e, errE := b(k.E)
+		if errN != nil || errE != nil || len(n) == 0 || len(e) == 0 || len(e) > 4 {
+			return Key{}, errors.New("invalid RSA modulus or exponent")
+		}
+		exp := 0
+		for _, v := range e {
+			exp = exp<<8 | int(v)
+		}
+		return Key{ID: k.Kid, Algorithm: supported(RS256), Key: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exp}}, nil
+
+	case "EC":
+		if k.Crv != "P-256" {
+			return Key{}, nil
+		}
+		x, errX := b(k.X)
+		y, errY := b(k.Y)
+		if errX != nil || errY != nil {
+			return Key{}, errors.New("invalid EC point")
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return Key{}, errors.New("EC point is not on P-256")
+		}
+		return Key{ID: k.Kid, Algorithm: supported(ES256), Key: pub}, nil
+	}
+	return Key{}, nil
+}
+
+// ParsePublicKey :
+// An RSA or P-256 public key in PEM - a PUBLIC KEY block or a certificate
+func ParsePublicKey(data []byte) (Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return Key{}, errors.New("no PEM block found")
+	}
+
+	var pub interface{}
+	var err error
+	switch block.Type {
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+			pub = cert.PublicKey
+		}
+	case "RSA PUBLIC KEY":
+		pub, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		pub, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return Key{}, err
+	}
+
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		return Key{Algorithm: RS256, Key: key}, nil
+	case *ecdsa.PublicKey:
+		k := Key{Algorithm: ES256, Key: key}
+		return k, checkKey(k)
+	}
+	return Key{}, fmt.Errorf("unsupported public key %T", pub)
+}
+
+// LoadKeys :
+// The keys in a file - a JWKS when it holds json, otherwise a PEM public key
+func LoadKeys(path string) ([]Key, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "{") {
+		return ParseJWKS(data)
+	}
+	k, err := ParsePublicKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s - %v", path, err)
+	}
+	return []Key{k}, nil
+}
+
+// LoadSecret :
+// An HS256 key from a file holding the shared secret
+// surrounding whitespace is ignored
+func LoadSecret(path string) (Key, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Key{}, err
+	}
+	secret := strings.TrimSpace(string(data))
+	if secret == "" {
+		return Key{}, fmt.Errorf("%s holds no secret", path)
+	}
+	return Key{Algorithm: HS256, Key: []byte(secret)}, nil
+}
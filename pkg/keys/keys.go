@@ -0,0 +1,266 @@
+package keys
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/David-solly/mxbcode/pkg/cache"
+	"github.com/David-solly/mxbcode/pkg/models"
+)
+
+// KeyPrefix :
+// Prefix of the sealed root keys of each device
+// records are keyed `KEYS:<DEVEUI>`
+const KeyPrefix = "KEYS:"
+
+// AuditPrefix :
+// Prefix of the audit trail of exported keys
+// keyed `KEYS-AUDIT:<UNIX NANOS>:<RANDOM>` so they list in time order
+const AuditPrefix = "KEYS-AUDIT:"
+
+var validEUI = regexp.MustCompile(`^[a-fA-F0-9]{16}$`)
+
+// Options :
+// How root keys are generated.
+// A blank JoinEUI draws a random one per device
+type Options struct {
+	JoinEUI   string
+	LoRaWAN11 bool
+}
+
+// Vault :
+// Seals root keys with AES-GCM under a master key before they reach the
+// cache store. The DevEUI is authenticated with the keys so a record
+// cannot be moved to another device
+type Vault struct {
+	Client cache.Service
+	KeyID  string
+
+	aead cipher.AEAD
+}
+
+// a sealed record as held in the store
+type sealed struct {
+	KeyID string `json:"kid"`
+	Data  string `json:"data"`
+}
+
+// ParseMasterKey :
+// A hex encoded AES-128, 192 or 256 master key
+func ParseMasterKey(s string) ([]byte, error) {
+	return parseAESKey(s, "master key")
+}
+
+// LoadMasterKey : reads a hex encoded master key from a file
+func LoadMasterKey(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseMasterKey(string(data))
+}
+
+// NewVault :
+// A vault sealing keys stored in c under master
+func NewVault(c cache.Service, master []byte) (*Vault, error) {
+	block, err := aes.NewCipher(master)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	// identifies the master key without revealing it
+	sum := sha256.Sum256(master)
+	return &Vault{Client: c, KeyID: strings.ToUpper(hex.EncodeToString(sum[:4])), aead: aead}, nil
+}
+
+// Generate :
+// Draws fresh root keys for deveui from crypto/rand
+func Generate(deveui string, opts Options) (models.RootKeys, error) {
+	if !validEUI.MatchString(deveui) {
+		return models.RootKeys{}, fmt.Errorf("invalid DevEUI %q", deveui)
+	}
+
+	k := models.RootKeys{DevEUI: strings.ToUpper(deveui), JoinEUI: strings.ToUpper(opts.JoinEUI)}
+	if k.JoinEUI == "" {
+		eui, err := random(8)
+		if err != nil {
+			return models.RootKeys{}, err
+		}
+		k.JoinEUI = eui
+	} else if !validEUI.MatchString(k.JoinEUI) {
+		return models.RootKeys{}, fmt.Errorf("invalid JoinEUI %q", opts.JoinEUI)
+	}
+
+	var err error
+	if k.AppKey, err = random(16); err != nil {
+		return models.RootKeys{}, err
+	}
+	if opts.LoRaWAN11 {
+		if k.NwkKey, err = random(16); err != nil {
+			return models.RootKeys{}, err
+		}
+	}
+	return k, nil
+}
+
+// Provision :
+// Generates and stores the root keys of deveui
+// keys already held for the device are kept rather than replaced
+func (v *Vault) Provision(deveui string, opts Options) (models.RootKeys, error) {
+	if existing, err := v.Load(deveui); err != nil || existing != nil {
+		if err != nil {
+			return models.RootKeys{}, err
+		}
+		return *existing, nil
+	}
+
+	k, err := Generate(deveui, opts)
+	if err != nil {
+		return models.RootKeys{}, err
+	}
+	return k, v.Store(k)
+}
+
+// Store : seals and stores root keys
+func (v *Vault) Store(k models.RootKeys) error {
+	plain, _ := json.Marshal(k)
+	nonce := make([]byte, v.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	ct := v.aead.Seal(nonce, nonce, plain, []byte(strings.ToUpper(k.DevEUI)))
+
+	data, _ := json.Marshal(sealed{KeyID: v.KeyID, Data: base64.StdEncoding.EncodeToString(ct)})
+	_, err := v.Client.StoreValue(KeyPrefix+k.DevEUI, string(data))
+	return err
+}
+
+// Load :
+// Unseals the root keys of deveui - nil if none are held
+func (v *Vault) Load(deveui string) (*models.RootKeys, error) {
+	if !validEUI.MatchString(deveui) {
+		return nil, fmt.Errorf("invalid DevEUI %q", deveui)
+	}
+	deveui = strings.ToUpper(deveui)
+	data, found, _ := v.Client.ReadCache(KeyPrefix + deveui)
+	if !found {
+		return nil, nil
+	}
+
+	s := sealed{}
+	if err := json.Unmarshal([]byte(data), &s); err != nil {
+		return nil, fmt.Errorf("corrupt keys for %s - %v", deveui, err)
+	}
+	if s.KeyID != v.KeyID {
+		return nil, fmt.Errorf("keys for %s are sealed under master key %s not %s", deveui, s.KeyID, v.KeyID)
+	}
+	ct, err := base64.StdEncoding.DecodeString(s.Data)
+	if err != nil || len(ct) < v.aead.NonceSize() {
+		return nil, fmt.Errorf("corrupt keys for %s", deveui)
+	}
+	n := v.aead.NonceSize()
+	plain, err := v.aead.Open(nil, ct[:n], ct[n:], []byte(deveui))
+	if err != nil {
+		return nil, fmt.Errorf("keys for %s failed authentication", deveui)
+	}
+
+	k := models.RootKeys{}
+	if err := json.Unmarshal(plain, &k); err != nil {
+		return nil, fmt.Errorf("corrupt keys for %s - %v", deveui, err)
+	}
+	return &k, nil
+}
+
+// Export :
+// Unseals the root keys of deveui for actor
+// every export is written to the audit trail - even a failed one
+func (v *Vault) Export(deveui, actor, remote string) (*models.RootKeys, error) {
+	k, err := v.Load(deveui)
+	action := "export"
+	switch {
+	case err != nil:
+		action = "export-failed"
+	case k == nil:
+		action = "export-missing"
+	}
+
+	if aerr := Audit(v.Client, models.KeyAudit{Action: action, Actor: actor, Remote: remote, DevEUI: strings.ToUpper(deveui)}); aerr != nil {
+		return nil, aerr // keys never leave without a trail
+	}
+	return k, err
+}
+
+// Audit : appends an entry to the audit trail
+func Audit(c cache.Service, entry models.KeyAudit) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+	suffix, err := random(4)
+	if err != nil {
+		return err
+	}
+	data, _ := json.Marshal(entry)
+	_, err = c.StoreValue(fmt.Sprintf("%s%020d:%s", AuditPrefix, entry.Time.UnixNano(), suffix), string(data))
+	return err
+}
+
+// AuditLog : the audit trail oldest first
+func AuditLog(c cache.Service) ([]models.KeyAudit, error) {
+	keys, err := c.ListKeys(AuditPrefix)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+
+	list := []models.KeyAudit{}
+	for _, k := range keys {
+		data, found, _ := c.ReadCache(k)
+		if !found {
+			continue
+		}
+		entry := models.KeyAudit{}
+		if err := json.Unmarshal([]byte(data), &entry); err != nil {
+			return nil, fmt.Errorf("corrupt audit entry %q - %v", k, err)
+		}
+		list = append(list, entry)
+	}
+	return list, nil
+}
+
+// a hex encoded AES-128, 192 or 256 key
+func parseAESKey(s, what string) ([]byte, error) {
+	key, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s - %v", what, err)
+	}
+	switch len(key) {
+	case 16, 24, 32:
+		return key, nil
+	}
+	return nil, fmt.Errorf("invalid %s length %d - must be 16, 24 or 32 bytes", what, len(key))
+}
+
+// n random bytes upper case hex encoded
+func random(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.New("unable to read random bytes - " + err.Error())
+	}
+	return strings.ToUpper(hex.EncodeToString(b)), nil
+}
@@ -0,0 +1,207 @@
+package keys
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/David-solly/mxbcode/pkg/cache"
+	"github.com/David-solly/mxbcode/pkg/models"
+
+	"github.com/docker/docker/pkg/testutil/assert"
+)
+
+const testMaster = "000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F"
+
+func TestMasterKey(t *testing.T) {
+	suite := []struct {
+		testName string
+		key      string
+		err      string
+	}{
+		{"MASTER - AES-256", testMaster, ""},
+		{"MASTER - AES-128", testMaster[:32], ""},
+		{"MASTER - whitespace", " " + testMaster + "\n", ""},
+		{"MASTER - short", testMaster[:30], "invalid master key length 15"},
+		{"MASTER - not hex", "xyz", "invalid master key"},
+	}
+	for i, test := range suite {
+		t.Run(fmt.Sprintf("#%d: %q", i, test.testName), func(t *testing.T) {
+			_, err := ParseMasterKey(test.key)
+			if test.err != "" {
+				assert.Error(t, err, test.err)
+				return
+			}
+			assert.NilError(t, err)
+		})
+	}
+}
+
+func TestVault(t *testing.T) {
+	c := cache.Cache{}
+	c.Initialise("", false)
+	master, _ := ParseMasterKey(testMaster)
+	v, err := NewVault(c.Client, master)
+	assert.NilError(t, err)
+
+	t.Run("GENERATE keys", func(t *testing.T) {
+		suite := []struct {
+			testName string
+			deveui   string
+			opts     Options
+			joinEUI  string
+			nwkKey   bool
+			err      string
+		}{
+			{"GENERATE - 1.0.x", "00000000000000a1", Options{}, "", false, ""},
+			{"GENERATE - fixed JoinEUI", "00000000000000A2", Options{JoinEUI: "70b3d57ed0000001"}, "70B3D57ED0000001", false, ""},
+			{"GENERATE - 1.1", "00000000000000A3", Options{LoRaWAN11: true}, "", true, ""},
+			{"GENERATE - invalid DevEUI", "A3", Options{}, "", false, "invalid DevEUI"},
+			{"GENERATE - invalid JoinEUI", "00000000000000A4", Options{JoinEUI: "70"}, "", false, "invalid JoinEUI"},
+		}
+		for i, test := range suite {
+			t.Run(fmt.Sprintf("#%d: %q", i, test.testName), func(t *testing.T) {
+				k, err := v.Provision(test.deveui, test.opts)
+				if test.err != "" {
+					assert.Error(t, err, test.err)
+					return
+				}
+				assert.NilError(t, err)
+				assert.Equal(t, k.DevEUI, strings.ToUpper(test.deveui))
+				assert.Equal(t, len(k.JoinEUI), 16)
+				assert.Equal(t, len(k.AppKey), 32)
+				assert.Equal(t, k.NwkKey != "", test.nwkKey)
+				if test.joinEUI != "" {
+					assert.Equal(t, k.JoinEUI, test.joinEUI)
+				}
+
+				// nothing readable is stored
+				raw, _, _ := c.Client.ReadCache(KeyPrefix + test.deveui)
+				assert.Equal(t, strings.Contains(raw, k.AppKey), false)
+
+				loaded, err := v.Load(test.deveui)
+				assert.NilError(t, err)
+				assert.DeepEqual(t, *loaded, k)
+
+				// provisioning again keeps the original keys
+				again, err := v.Provision(test.deveui, test.opts)
+				assert.NilError(t, err)
+				assert.Equal(t, again.AppKey, k.AppKey)
+			})
+		}
+	})
+
+	t.Run("SEALED keys", func(t *testing.T) {
+		other, _ := NewVault(c.Client, master[:16])
+		_, err := other.Load("00000000000000A1")
+		assert.Error(t, err, "sealed under master key")
+
+		// a record moved to another device fails authentication
+		raw, _, _ := c.Client.ReadCache(KeyPrefix + "00000000000000A1")
+		c.Client.StoreValue(KeyPrefix+"00000000000000B1", raw)
+		_, err = v.Load("00000000000000B1")
+		assert.Error(t, err, "failed authentication")
+
+		k, err := v.Load("00000000000000C1")
+		assert.NilError(t, err)
+		assert.Equal(t, k == nil, true)
+	})
+
+	t.Run("EXPORT keys", func(t *testing.T) {
+		k, err := v.Export("00000000000000A2", "tester", "127.0.0.1")
+		assert.NilError(t, err)
+		assert.Equal(t, k.JoinEUI, "70B3D57ED0000001")
+
+		k, err = v.Export("00000000000000C1", "tester", "")
+		assert.NilError(t, err)
+		assert.Equal(t, k == nil, true)
+
+		log, err := AuditLog(c.Client)
+		assert.NilError(t, err)
+		assert.Equal(t, len(log), 2)
+		assert.Equal(t, log[0].Action, "export")
+		assert.Equal(t, log[0].DevEUI, "00000000000000A2")
+		assert.Equal(t, log[0].Actor, "tester")
+		assert.Equal(t, log[1].Action, "export-missing")
+	})
+}
+
+func TestKeyWrap(t *testing.T) {
+	// RFC 3394 section 4 test vectors
+	suite := []struct {
+		testName string
+		kek      string
+		key      string
+		wrapped  string
+	}{
+		{"WRAP - 128 bit key, 128 bit KEK", "000102030405060708090A0B0C0D0E0F", "00112233445566778899AABBCCDDEEFF", "1FA68B0A8112B447AEF34BD8FB5A7B829D3E862371D2CFE5"},
+		{"WRAP - 128 bit key, 192 bit KEK", "000102030405060708090A0B0C0D0E0F1011121314151617", "00112233445566778899AABBCCDDEEFF", "96778B25AE6CA435F92B5B97C050AED2468AB8A17AD84E5D"},
+		{"WRAP - 128 bit key, 256 bit KEK", testMaster, "00112233445566778899AABBCCDDEEFF", "64E8C3F9CE0F5BA263E9777905818A2A93C8191E7D6E8AE7"},
+		{"WRAP - 256 bit key, 256 bit KEK", testMaster, "00112233445566778899AABBCCDDEEFF000102030405060708090A0B0C0D0E0F", "28C9F404C4B810F4CBCCB35CFB87F8263F5786E2D80ED326CBC7F0E71A99F43BFB988B9B7A02DD21"},
+	}
+	for i, test := range suite {
+		t.Run(fmt.Sprintf("#%d: %q", i, test.testName), func(t *testing.T) {
+			kek, _ := hex.DecodeString(test.kek)
+			key, _ := hex.DecodeString(test.key)
+
+			wrapped, err := Wrap(kek, key)
+			assert.NilError(t, err)
+			assert.Equal(t, strings.ToUpper(hex.EncodeToString(wrapped)), test.wrapped)
+
+			plain, err := Unwrap(kek, wrapped)
+			assert.NilError(t, err)
+			assert.DeepEqual(t, plain, key)
+
+			wrapped[len(wrapped)-1] ^= 1
+			_, err = Unwrap(kek, wrapped)
+			assert.Equal(t, err, ErrUnwrap)
+		})
+	}
+
+	_, err := Wrap(make([]byte, 16), make([]byte, 12))
+	assert.Error(t, err, "multiple of 8 bytes")
+}
+
+func TestManifest(t *testing.T) {
+	c := cache.Cache{}
+	c.Initialise("", false)
+	master, _ := ParseMasterKey(testMaster)
+	v, _ := NewVault(c.Client, master)
+	kek, _ := ParseMasterKey("00112233445566778899AABBCCDDEEFF")
+
+	a, _ := v.Provision("00000000000000A1", Options{})
+	b, _ := v.Provision("00000000000000A2", Options{LoRaWAN11: true})
+
+	m, err := v.WrapManifest(nil, kek, "js-eu1", "tester", "")
+	assert.NilError(t, err)
+	assert.Equal(t, m.KEKLabel, "js-eu1")
+	assert.Equal(t, m.Algorithm, WrapAlgorithm)
+	assert.Equal(t, len(m.Devices), 2)
+	assert.Equal(t, m.Devices[0].JoinEUI, a.JoinEUI)
+	assert.Equal(t, len(m.Devices[0].AppKey), 48)
+	assert.Equal(t, m.Devices[0].NwkKey, "")
+	assert.Equal(t, strings.Contains(m.Devices[1].AppKey, b.AppKey), false)
+
+	// round trip
+	list, err := UnwrapManifest(m, kek)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, list, []models.RootKeys{a, b})
+
+	_, err = UnwrapManifest(m, master)
+	assert.Error(t, err, "integrity check mismatch")
+
+	m, err = v.WrapManifest([]string{"00000000000000a2"}, kek, "js-eu1", "tester", "")
+	assert.NilError(t, err)
+	assert.Equal(t, len(m.Devices), 1)
+
+	_, err = v.WrapManifest([]string{"00000000000000A3"}, kek, "js-eu1", "tester", "")
+	assert.Error(t, err, "no keys are held for device 00000000000000A3")
+
+	_, err = v.WrapManifest(nil, kek, "../etc", "tester", "")
+	assert.Error(t, err, "invalid KEK label")
+
+	log, _ := AuditLog(c.Client)
+	assert.Equal(t, len(log), 3)
+	assert.Equal(t, log[0].Action, "wrap:js-eu1")
+}
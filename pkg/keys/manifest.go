@@ -0,0 +1,156 @@
+package keys
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/David-solly/mxbcode/pkg/models"
+)
+
+// WrapAlgorithm : how the keys in a manifest are wrapped
+const WrapAlgorithm = "AES-KW-RFC3394"
+
+// KEKExtension :
+// Key encryption keys are read from `<label>.kek` files
+// holding the hex encoded key
+const KEKExtension = ".kek"
+
+var validLabel = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// LoadKEK : reads a hex encoded key encryption key from a file
+func LoadKEK(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseAESKey(string(data), "KEK")
+}
+
+// KEKFile :
+// The file holding the KEK named label within dir
+func KEKFile(dir, label string) (string, error) {
+	if !validLabel.MatchString(label) {
+		return "", fmt.Errorf("invalid KEK label %q", label)
+	}
+	return filepath.Join(dir, label+KEKExtension), nil
+}
+
+// Devices :
+// Every device the vault holds keys for ordered by DevEUI
+func (v *Vault) Devices() ([]string, error) {
+	keys, err := v.Client.ListKeys(KeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+	list := []string{}
+	for _, k := range keys {
+		list = append(list, strings.TrimPrefix(k, KeyPrefix))
+	}
+	sort.Strings(list)
+	return list, nil
+}
+
+// WrapManifest :
+// Wraps the root keys of deveuis under kek for a join server.
+// No deveuis wraps every device in the vault.
+// Each device is written to the audit trail as it is wrapped
+func (v *Vault) WrapManifest(deveuis []string, kek []byte, label, actor, remote string) (models.KeyManifest, error) {
+	if !validLabel.MatchString(label) {
+		return models.KeyManifest{}, fmt.Errorf("invalid KEK label %q", label)
+	}
+	if len(deveuis) == 0 {
+		all, err := v.Devices()
+		if err != nil {
+			return models.KeyManifest{}, err
+		}
+		deveuis = all
+	}
+
+	m := models.KeyManifest{KEKLabel: label, Algorithm: WrapAlgorithm, Created: time.Now().UTC(), Devices: []models.WrappedKeys{}}
+	for _, d := range deveuis {
+		k, err := v.Load(d)
+		if err != nil {
+			return models.KeyManifest{}, err
+		}
+		if k == nil {
+			return models.KeyManifest{}, fmt.Errorf("no keys are held for device %s", strings.ToUpper(d))
+		}
+
+		w, err := wrapKeys(*k, kek)
+		if err != nil {
+			return models.KeyManifest{}, err
+		}
+		if err := Audit(v.Client, models.KeyAudit{Action: "wrap:" + label, Actor: actor, Remote: remote, DevEUI: k.DevEUI}); err != nil {
+			return models.KeyManifest{}, err
+		}
+		m.Devices = append(m.Devices, w)
+	}
+	return m, nil
+}
+
+// UnwrapManifest :
+// Recovers the plain root keys of a manifest with its KEK
+// used by join server tooling and round trip tests
+func UnwrapManifest(m models.KeyManifest, kek []byte) ([]models.RootKeys, error) {
+	if m.Algorithm != WrapAlgorithm {
+		return nil, fmt.Errorf("unsupported wrap algorithm %q", m.Algorithm)
+	}
+
+	list := []models.RootKeys{}
+	for _, w := range m.Devices {
+		k := models.RootKeys{DevEUI: w.DevEUI, JoinEUI: w.JoinEUI}
+		var err error
+		if k.AppKey, err = unwrapHex(kek, w.AppKey); err != nil {
+			return nil, fmt.Errorf("AppKey of %s - %v", w.DevEUI, err)
+		}
+		if w.NwkKey != "" {
+			if k.NwkKey, err = unwrapHex(kek, w.NwkKey); err != nil {
+				return nil, fmt.Errorf("NwkKey of %s - %v", w.DevEUI, err)
+			}
+		}
+		list = append(list, k)
+	}
+	return list, nil
+}
+
+func wrapKeys(k models.RootKeys, kek []byte) (models.WrappedKeys, error) {
+	w := models.WrappedKeys{DevEUI: k.DevEUI, JoinEUI: k.JoinEUI}
+	var err error
+	if w.AppKey, err = wrapHex(kek, k.AppKey); err != nil {
+		return w, err
+	}
+	if k.NwkKey != "" {
+		w.NwkKey, err = wrapHex(kek, k.NwkKey)
+	}
+	return w, err
+}
+
+func wrapHex(kek []byte, key string) (string, error) {
+	plain, err := hex.DecodeString(key)
+	if err != nil {
+		return "", err
+	}
+	wrapped, err := Wrap(kek, plain)
+	if err != nil {
+		return "", err
+	}
+	return strings.ToUpper(hex.EncodeToString(wrapped)), nil
+}
+
+func unwrapHex(kek []byte, wrapped string) (string, error) {
+	data, err := hex.DecodeString(wrapped)
+	if err != nil {
+		return "", err
+	}
+	plain, err := Unwrap(kek, data)
+	if err != nil {
+		return "", err
+	}
+	return strings.ToUpper(hex.EncodeToString(plain)), nil
+}
@@ -0,0 +1,89 @@
+package label
+
+import (
+	"fmt"
+)
+
+// bar and space widths of each Code 128 symbol value
+// every symbol is 11 modules wide - the stop symbol 13
+var code128Patterns = [...]string{
+	"212222", "222122", "222221", "121223", "121322", "131222", "122213", "122312", "132212", "221213",
+	"221312", "231212", "112232", "122132", "122231", "113222", "123122", "123221", "223211", "221132",
+	"221231", "213212", "223112", "312131", "311222", "321122", "321221", "312212", "322112", "322211",
+	"212123", "212321", "232121", "111323", "131123", "131321", "112313", "132113", "132311", "211313",
+	"231113", "231311", "112133", "112331", "132131", "113123", "113321", "133121", "313121", "211331",
+	"231131", "213113", "213311", "213131", "311123", "311321", "331121", "312113", "312311", "332111",
+	"314111", "221411", "431111", "111224", "111422", "121124", "121421", "141122", "141221", "112214",
+	"112412", "122114", "122411", "142112", "142211", "241211", "221114", "413111", "241112", "134111",
+	"111242", "121142", "121241", "114212", "124112", "124211", "411212", "421112", "421211", "212141",
+	"214121", "412121", "111143", "111341", "131141", "114113", "114311", "411113", "411311", "113141",
+	"114131", "311141", "411131", "211412", "211214", "211232", "2331112",
+}
+
+const (
+	startB = 104
+	startC = 105
+	stop   = 106
+)
+
+// Code128 :
+// The bars of text as a Code 128 barcode - alternating bar and space
+// widths in modules starting with a bar. Even length digit strings use
+// the dense code set C, anything else printable ASCII code set B
+func Code128(text string) ([]int, error) {
+	if text == "" {
+		return nil, fmt.Errorf("nothing to encode")
+	}
+
+	values := []int{}
+	if isDigitPairs(text) {
+		values = append(values, startC)
+		for i := 0; i < len(text); i += 2 {
+			values = append(values, int(text[i]-'0')*10+int(text[i+1]-'0'))
+		}
+	} else {
+		values = append(values, startB)
+		for i := 0; i < len(text); i++ {
+			if text[i] < 32 || text[i] > 126 {
+				return nil, fmt.Errorf("character %q cannot be encoded in Code 128 set B", text[i])
+			}
+			values = append(values, int(text[i])-32)
+		}
+	}
+
+	// weighted modulo 103 check symbol
+	sum := values[0]
+	for i, v := range values[1:] {
+		sum += v * (i + 1)
+	}
+	values = append(values, sum%103, stop)
+
+	widths := []int{}
+	for _, v := range values {
+		for _, w := range code128Patterns[v] {
+			widths = append(widths, int(w-'0'))
+		}
+	}
+	return widths, nil
+}
+
+// Modules : total width of the bars in modules
+func Modules(widths []int) int {
+	n := 0
+	for _, w := range widths {
+		n += w
+	}
+	return n
+}
+
+func isDigitPairs(s string) bool {
+	if len(s)%2 != 0 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,94 @@
+package label
+
+import (
+	"strings"
+)
+
+// Label :
+// One device to print - the DevEUI as a barcode and text
+// with the shortcode large underneath
+type Label struct {
+	ShortCode string `json:"shortcode"`
+	DevEUI    string `json:"deveui"`
+}
+
+const (
+	// blank space inside the edge of each label
+	padding = 2.0
+
+	// light modules either side of the bars
+	quietModules = 10
+
+	// width of a monospaced character as a share of its size
+	charWidth = 0.6
+)
+
+// a filled bar - millimetres from the top left of the page
+type bar struct {
+	x, y, w, h float64
+}
+
+// text centred on x with its baseline at y
+type caption struct {
+	x, y, size float64
+	text       string
+}
+
+// everything drawn on one sheet
+type page struct {
+	bars     []bar
+	captions []caption
+}
+
+// lay the labels out over as many pages as they need
+func (t Template) layout(labels []Label) ([]page, error) {
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+
+	pages := make([]page, t.Pages(len(labels)))
+	w, h := t.LabelSize()
+	for i, l := range labels {
+		p := &pages[i/t.PerPage()]
+		x0, y0 := t.origin(i % t.PerPage())
+
+		deveui := strings.ToUpper(l.DevEUI)
+		widths, err := Code128(deveui)
+		if err != nil {
+			return nil, err
+		}
+
+		// bars across the top half
+		module := (w - 2*padding) / float64(Modules(widths)+2*quietModules)
+		barHeight := h*0.5 - padding
+		x := x0 + padding + quietModules*module
+		for j, bw := range widths {
+			if j%2 == 0 {
+				p.bars = append(p.bars, bar{x: x, y: y0 + padding, w: float64(bw) * module, h: barHeight})
+			}
+			x += float64(bw) * module
+		}
+
+		// the DevEUI in small type under the bars
+		// the shortcode as large as fits below it
+		centre := x0 + w/2
+		small := fit(deveui, w-2*padding, h*0.14)
+		p.captions = append(p.captions, caption{x: centre, y: y0 + padding + barHeight + small*1.1, size: small, text: deveui})
+
+		shortcode := strings.ToUpper(l.ShortCode)
+		large := fit(shortcode, w-2*padding, h*0.28)
+		p.captions = append(p.captions, caption{x: centre, y: y0 + h - padding - large*0.2, size: large, text: shortcode})
+	}
+	return pages, nil
+}
+
+// the largest type size up to max with text no wider than width
+func fit(text string, width, max float64) float64 {
+	if text == "" {
+		return max
+	}
+	if s := width / (float64(len(text)) * charWidth); s < max {
+		return s
+	}
+	return max
+}
@@ -0,0 +1,130 @@
+package label
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/pkg/testutil/assert"
+)
+
+func TestCode128(t *testing.T) {
+	// every symbol is 11 modules and unique
+	seen := map[string]bool{}
+	for v, p := range code128Patterns {
+		want := 11
+		if v == stop {
+			want = 13
+		}
+		n := 0
+		for _, w := range p {
+			n += int(w - '0')
+		}
+		if n != want || seen[p] {
+			t.Errorf("pattern %d %q is invalid", v, p)
+		}
+		seen[p] = true
+	}
+
+	suite := []struct {
+		testName string
+		text     string
+		start    string
+		check    int
+		modules  int
+	}{
+		{"CODE128 - set B", "PJJ123C", code128Patterns[startB], 55, 11*9 + 13},
+		{"CODE128 - hex DevEUI", "0004A30B001C0530", code128Patterns[startB], -1, 11*18 + 13},
+		{"CODE128 - set C digits", "0000000000000001", code128Patterns[startC], -1, 11*10 + 13},
+		{"CODE128 - odd digits use set B", "123", code128Patterns[startB], -1, 11*5 + 13},
+	}
+	for i, test := range suite {
+		t.Run(fmt.Sprintf("#%d: %q", i, test.testName), func(t *testing.T) {
+			widths, err := Code128(test.text)
+			assert.NilError(t, err)
+			assert.Equal(t, Modules(widths), test.modules)
+
+			pattern := ""
+			for _, w := range widths {
+				pattern += strconv.Itoa(w)
+			}
+			assert.Equal(t, strings.HasPrefix(pattern, test.start), true)
+			assert.Equal(t, strings.HasSuffix(pattern, code128Patterns[stop]), true)
+			if test.check >= 0 {
+				assert.Equal(t, pattern[len(pattern)-7-6:len(pattern)-7], code128Patterns[test.check])
+			}
+		})
+	}
+
+	_, err := Code128("")
+	assert.Error(t, err, "nothing to encode")
+	_, err = Code128("é")
+	assert.Error(t, err, "cannot be encoded")
+}
+
+func TestTemplates(t *testing.T) {
+	for name, tmpl := range Templates {
+		assert.NilError(t, tmpl.Validate())
+		w, h := tmpl.LabelSize()
+		x, y := tmpl.origin(tmpl.PerPage() - 1)
+		assert.Equal(t, fmt.Sprintf("%s %.1f %.1f", name, x+w+tmpl.MarginRight, y+h+tmpl.MarginBottom), fmt.Sprintf("%s %.1f %.1f", name, tmpl.PageWidth, tmpl.PageHeight))
+	}
+
+	_, err := TemplateFor("A3")
+	assert.Error(t, err, "one of a4, letter")
+	tmpl, err := TemplateFor("Letter")
+	assert.NilError(t, err)
+	assert.Equal(t, tmpl.PerPage(), 30)
+
+	tmpl.Columns = 20
+	assert.Error(t, tmpl.Validate(), "too small")
+	tmpl.Columns = 0
+	assert.Error(t, tmpl.Validate(), "at least one row")
+}
+
+func TestRender(t *testing.T) {
+	labels := []Label{}
+	for i := 1; i <= 30; i++ {
+		sc := fmt.Sprintf("%05X", i)
+		labels = append(labels, Label{ShortCode: sc, DevEUI: "00000000000" + sc})
+	}
+	tmpl, _ := TemplateFor("a4")
+
+	pages, err := tmpl.SVG(labels)
+	assert.NilError(t, err)
+	assert.Equal(t, len(pages), 2)
+	assert.Contains(t, string(pages[0]), `width="210mm" height="297mm"`)
+	assert.Equal(t, strings.Count(string(pages[0]), "<text"), 48)
+	assert.Equal(t, strings.Count(string(pages[1]), "<text"), 12)
+	assert.Contains(t, string(pages[1]), ">0001E</text>")
+
+	doc, err := tmpl.PDF(labels)
+	assert.NilError(t, err)
+	pdf := string(doc)
+	assert.Equal(t, strings.HasPrefix(pdf, "%PDF-1.4\n"), true)
+	assert.Equal(t, strings.HasSuffix(pdf, "%%EOF\n"), true)
+	assert.Contains(t, pdf, "/Count 2")
+	assert.Contains(t, pdf, "(0001E) Tj")
+
+	// every cross reference entry points at its object
+	xref := regexp.MustCompile(`(?s)xref\n0 (\d+)\n(.*)trailer`).FindStringSubmatch(pdf)
+	n, _ := strconv.Atoi(xref[1])
+	assert.Equal(t, n, 3+2*2+1)
+	for i, entry := range strings.Split(strings.TrimSpace(xref[2]), "\n")[1:] {
+		off, _ := strconv.Atoi(entry[:10])
+		assert.Equal(t, strings.HasPrefix(pdf[off:], fmt.Sprintf("%d 0 obj", i+1)), true)
+	}
+	start := regexp.MustCompile(`startxref\n(\d+)`).FindStringSubmatch(pdf)
+	off, _ := strconv.Atoi(start[1])
+	assert.Equal(t, strings.HasPrefix(pdf[off:], "xref"), true)
+
+	// stream lengths match their content
+	for _, m := range regexp.MustCompile(`(?s)/Length (\d+) >>\nstream\n(.*?)endstream`).FindAllStringSubmatch(pdf, -1) {
+		assert.Equal(t, m[1], strconv.Itoa(len(m[2])))
+	}
+
+	_, err = tmpl.PDF([]Label{{ShortCode: "00001", DevEUI: "é"}})
+	assert.Error(t, err, "cannot be encoded")
+}
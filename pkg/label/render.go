@@ -0,0 +1,100 @@
+package label
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+// points in a millimetre
+const pt = 72 / 25.4
+
+// SVG :
+// One SVG document per sheet sized in millimetres for printing at 100%
+func (t Template) SVG(labels []Label) ([][]byte, error) {
+	pages, err := t.layout(labels)
+	if err != nil {
+		return nil, err
+	}
+
+	docs := [][]byte{}
+	for _, p := range pages {
+		buf := bytes.Buffer{}
+		fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" version="1.1" width="%smm" height="%smm" viewBox="0 0 %s %s">`, num(t.PageWidth), num(t.PageHeight), num(t.PageWidth), num(t.PageHeight))
+		buf.WriteString(`<rect width="100%" height="100%" fill="#FFFFFF"/><path fill="#000000" d="`)
+		for _, b := range p.bars {
+			fmt.Fprintf(&buf, "M%s %sh%sv%sh-%sz", num(b.x), num(b.y), num(b.w), num(b.h), num(b.w))
+		}
+		buf.WriteString(`"/>`)
+		for _, c := range p.captions {
+			fmt.Fprintf(&buf, `<text x="%s" y="%s" font-family="Courier New, Courier, monospace" font-size="%s" text-anchor="middle">`, num(c.x), num(c.y), num(c.size))
+			xml.EscapeText(&buf, []byte(c.text))
+			buf.WriteString(`</text>`)
+		}
+		buf.WriteString(`</svg>`)
+		docs = append(docs, buf.Bytes())
+	}
+	return docs, nil
+}
+
+// PDF :
+// The sheets as a PDF document - bars are filled rectangles
+// and text uses the built in Courier font so nothing is embedded
+func (t Template) PDF(labels []Label) ([]byte, error) {
+	pages, err := t.layout(labels)
+	if err != nil {
+		return nil, err
+	}
+
+	// objects 1 catalog, 2 page tree, 3 font
+	// then a page and its content stream for each sheet
+	objects := []string{"", "", "<< /Type /Font /Subtype /Type1 /BaseFont /Courier >>"}
+	kids := []string{}
+	for _, p := range pages {
+		content := bytes.Buffer{}
+		content.WriteString("0 g\n")
+		for _, b := range p.bars {
+			fmt.Fprintf(&content, "%s %s %s %s re f\n", num(b.x*pt), num((t.PageHeight-b.y-b.h)*pt), num(b.w*pt), num(b.h*pt))
+		}
+		for _, c := range p.captions {
+			width := float64(len(c.text)) * charWidth * c.size
+			fmt.Fprintf(&content, "BT /F1 %s Tf %s %s Td (%s) Tj ET\n", num(c.size*pt), num((c.x-width/2)*pt), num((t.PageHeight-c.y)*pt), pdfEscape(c.text))
+		}
+
+		contentID := len(objects) + 2
+		kids = append(kids, fmt.Sprintf("%d 0 R", len(objects)+1))
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>", num(t.PageWidth*pt), num(t.PageHeight*pt), contentID),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()),
+		)
+	}
+	objects[0] = "<< /Type /Catalog /Pages 2 0 R >>"
+	objects[1] = fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(kids))
+
+	buf := bytes.Buffer{}
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, o := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, o)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return buf.Bytes(), nil
+}
+
+// compact decimal - at most 3 places without trailing zeros
+func num(f float64) string {
+	s := strings.TrimRight(fmt.Sprintf("%.3f", f), "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+// escape the characters special inside a PDF string
+func pdfEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `(`, `\(`, `)`, `\)`).Replace(s)
+}
@@ -0,0 +1,85 @@
+package label
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Template :
+// A sheet of labels - every measurement in millimetres.
+// Labels fill the space inside the margins in a grid
+// with Gap between neighbouring labels
+type Template struct {
+	Name         string  `json:"name"`
+	PageWidth    float64 `json:"page_width"`
+	PageHeight   float64 `json:"page_height"`
+	Columns      int     `json:"columns"`
+	Rows         int     `json:"rows"`
+	MarginTop    float64 `json:"margin_top"`
+	MarginBottom float64 `json:"margin_bottom"`
+	MarginLeft   float64 `json:"margin_left"`
+	MarginRight  float64 `json:"margin_right"`
+	Gap          float64 `json:"gap"`
+}
+
+// Templates :
+// Built in sheets - 24 labels to an A4 page and 30 to a Letter page
+var Templates = map[string]Template{
+	"a4":     {Name: "a4", PageWidth: 210, PageHeight: 297, Columns: 3, Rows: 8, MarginTop: 13.5, MarginBottom: 13.5, MarginLeft: 7, MarginRight: 7, Gap: 2.5},
+	"letter": {Name: "letter", PageWidth: 215.9, PageHeight: 279.4, Columns: 3, Rows: 10, MarginTop: 12.7, MarginBottom: 12.7, MarginLeft: 4.8, MarginRight: 4.8, Gap: 3.2},
+}
+
+// TemplateFor :
+// The named built in template - case insensitive
+func TemplateFor(name string) (Template, error) {
+	t, k := Templates[strings.ToLower(name)]
+	if !k {
+		names := []string{}
+		for n := range Templates {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return Template{}, fmt.Errorf("unknown label template %q - one of %s", name, strings.Join(names, ", "))
+	}
+	return t, nil
+}
+
+// Validate : checks the labels fit on the page
+func (t Template) Validate() error {
+	if t.Columns < 1 || t.Rows < 1 {
+		return fmt.Errorf("template %q needs at least one row and column", t.Name)
+	}
+	if t.MarginTop < 0 || t.MarginBottom < 0 || t.MarginLeft < 0 || t.MarginRight < 0 || t.Gap < 0 {
+		return fmt.Errorf("template %q has a negative margin", t.Name)
+	}
+	if w, h := t.LabelSize(); w < 20 || h < 10 {
+		return fmt.Errorf("labels of template %q are too small (%.1f x %.1f mm) - at least 20 x 10 mm", t.Name, w, h)
+	}
+	return nil
+}
+
+// PerPage : labels on each sheet
+func (t Template) PerPage() int {
+	return t.Columns * t.Rows
+}
+
+// LabelSize : width and height of each label
+func (t Template) LabelSize() (float64, float64) {
+	w := (t.PageWidth - t.MarginLeft - t.MarginRight - float64(t.Columns-1)*t.Gap) / float64(t.Columns)
+	h := (t.PageHeight - t.MarginTop - t.MarginBottom - float64(t.Rows-1)*t.Gap) / float64(t.Rows)
+	return w, h
+}
+
+// the top left corner of the label in slot i of a page
+// filled left to right then top to bottom
+func (t Template) origin(i int) (float64, float64) {
+	w, h := t.LabelSize()
+	col, row := i%t.Columns, i/t.Columns
+	return t.MarginLeft + float64(col)*(w+t.Gap), t.MarginTop + float64(row)*(h+t.Gap)
+}
+
+// Pages : the number of sheets needed for n labels
+func (t Template) Pages(n int) int {
+	return (n + t.PerPage() - 1) / t.PerPage()
+}
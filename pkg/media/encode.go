@@ -0,0 +1,282 @@
+package media
+
+import (
+	"encoding"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Table :
+// Values shaped differently when written as rows eg a batch that
+// is one object in JSON and XML but one row per device in CSV
+type Table interface {
+	Rows() interface{}
+}
+
+// Encode :
+// Writes v as mediaType. Fields are named after their json tags in every
+// format. CSV and NDJSON write a row per element of a slice - a single value
+// is one row. XML wraps v in a <response> element and slice elements in <item>
+func Encode(w io.Writer, mediaType string, v interface{}) error {
+	switch mediaType {
+	case JSON, ProblemJSON:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	case NDJSON:
+		return writeNDJSON(w, rows(v))
+	case CSV:
+		return writeCSV(w, rows(v))
+	case XML:
+		return writeXML(w, v)
+	}
+	return fmt.Errorf("unsupported media type %q", mediaType)
+}
+
+// the elements of v written one per row
+func rows(v interface{}) []reflect.Value {
+	if t, k := v.(Table); k {
+		v = t.Rows()
+	}
+	rv := indirect(reflect.ValueOf(v))
+	if !rv.IsValid() {
+		return nil
+	}
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return []reflect.Value{rv}
+	}
+	list := make([]reflect.Value, rv.Len())
+	for i := range list {
+		list[i] = indirect(rv.Index(i))
+	}
+	return list
+}
+
+func writeNDJSON(w io.Writer, list []reflect.Value) error {
+	for _, rv := range list {
+		data, err := json.Marshal(rv.Interface())
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(append(data, '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeCSV(w io.Writer, list []reflect.Value) error {
+	cw := csv.NewWriter(w)
+	if len(list) == 0 {
+		cw.Flush()
+		return cw.Error()
+	}
+
+	var header []string
+	var record func(rv reflect.Value) []string
+	switch first := list[0]; {
+	case first.Kind() == reflect.Struct && !isText(first):
+		fs := fields(first.Type())
+		for _, f := range fs {
+			header = append(header, f.name)
+		}
+		record = func(rv reflect.Value) []string {
+			row := make([]string, len(fs))
+			for i, f := range fs {
+				row[i] = cell(rv.FieldByIndex(f.index))
+			}
+			return row
+		}
+	case first.Kind() == reflect.Map:
+		seen := map[string]bool{}
+		for _, rv := range list {
+			for _, k := range rv.MapKeys() {
+				if name := fmt.Sprint(k.Interface()); !seen[name] {
+					seen[name] = true
+					header = append(header, name)
+				}
+			}
+		}
+		sort.Strings(header)
+		record = func(rv reflect.Value) []string {
+			row := make([]string, len(header))
+			for i, name := range header {
+				row[i] = cell(rv.MapIndex(reflect.ValueOf(name).Convert(rv.Type().Key())))
+			}
+			return row
+		}
+	default:
+		header = []string{"value"}
+		record = func(rv reflect.Value) []string { return []string{cell(rv)} }
+	}
+
+	cw.Write(header)
+	for _, rv := range list {
+		cw.Write(record(rv))
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeXML(w io.Writer, v interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	if err := element(enc, "response", reflect.ValueOf(v)); err != nil {
+		return err
+	}
+	return enc.Flush()
+}
+
+// encodes rv as the element name
+func element(enc *xml.Encoder, name string, rv reflect.Value) error {
+	rv = indirect(rv)
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+
+	switch {
+	case !rv.IsValid():
+	case isText(rv) || isScalar(rv):
+		if err := enc.EncodeToken(xml.CharData(cell(rv))); err != nil {
+			return err
+		}
+	case rv.Kind() == reflect.Struct:
+		for _, f := range fields(rv.Type()) {
+			fv := rv.FieldByIndex(f.index)
+			if f.omitEmpty && fv.IsZero() {
+				continue
+			}
+			if err := element(enc, f.name, fv); err != nil {
+				return err
+			}
+		}
+	case rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			if err := element(enc, "item", rv.Index(i)); err != nil {
+				return err
+			}
+		}
+	case rv.Kind() == reflect.Map:
+		keys := rv.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface()) })
+		for _, k := range keys {
+			entry := xml.StartElement{Name: xml.Name{Local: "entry"}, Attr: []xml.Attr{{Name: xml.Name{Local: "key"}, Value: fmt.Sprint(k.Interface())}}}
+			if err := enc.EncodeToken(entry); err != nil {
+				return err
+			}
+			if err := enc.EncodeToken(xml.CharData(cell(rv.MapIndex(k)))); err != nil {
+				return err
+			}
+			if err := enc.EncodeToken(entry.End()); err != nil {
+				return err
+			}
+		}
+	}
+	return enc.EncodeToken(start.End())
+}
+
+type field struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+// the exported fields of a struct named as encoding/json names them
+// fields of embedded structs are promoted
+func fields(t reflect.Type) []field {
+	list := []field{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := strings.Split(f.Tag.Get("json"), ",")
+		if f.PkgPath != "" || tag[0] == "-" {
+			continue
+		}
+		if f.Anonymous && tag[0] == "" && f.Type.Kind() == reflect.Struct {
+			for _, inner := range fields(f.Type) {
+				inner.index = append([]int{i}, inner.index...)
+				list = append(list, inner)
+			}
+			continue
+		}
+
+		name := tag[0]
+		if name == "" {
+			name = f.Name
+		}
+		omitEmpty := false
+		for _, opt := range tag[1:] {
+			omitEmpty = omitEmpty || opt == "omitempty"
+		}
+		list = append(list, field{name: name, index: []int{i}, omitEmpty: omitEmpty})
+	}
+	return list
+}
+
+// the text of a single value - structures are written as json
+func cell(rv reflect.Value) string {
+	rv = indirect(rv)
+	switch {
+	case !rv.IsValid():
+		return ""
+	case isText(rv):
+		if rv.IsZero() {
+			return ""
+		}
+		text, _ := rv.Interface().(encoding.TextMarshaler).MarshalText()
+		return string(text)
+	}
+
+	switch rv.Kind() {
+	case reflect.String:
+		return rv.String()
+	case reflect.Bool:
+		return strconv.FormatBool(rv.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(rv.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(rv.Float(), 'f', -1, 64)
+	}
+	data, _ := json.Marshal(rv.Interface())
+	return string(data)
+}
+
+var textMarshaler = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+// values such as times that write themselves as text
+func isText(rv reflect.Value) bool {
+	return rv.Type().Implements(textMarshaler)
+}
+
+func isScalar(rv reflect.Value) bool {
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map, reflect.Struct:
+		return false
+	}
+	return true
+}
+
+// follows pointers and interfaces to the value held
+func indirect(rv reflect.Value) reflect.Value {
+	for rv.IsValid() && (rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface) {
+		if rv.IsNil() {
+			return reflect.Value{}
+		}
+		rv = rv.Elem()
+	}
+	return rv
+}
@@ -0,0 +1,87 @@
+package media
+
+import (
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// media types the API can answer with
+const (
+	JSON        = "application/json"
+	CSV         = "text/csv"
+	NDJSON      = "application/x-ndjson"
+	XML         = "application/xml"
+	ProblemJSON = "application/problem+json"
+)
+
+// Types : every media type Negotiate may pick - JSON first as the default
+var Types = []string{JSON, CSV, NDJSON, XML}
+
+// other names clients send for the same types
+var aliases = map[string]string{
+	"text/json":             JSON,
+	"application/csv":       CSV,
+	"application/jsonl":     NDJSON,
+	"application/ndjson":    NDJSON,
+	"application/jsonlines": NDJSON,
+	"text/xml":              XML,
+}
+
+type accepted struct {
+	mediaType string
+	q         float64
+}
+
+// Negotiate :
+// The media type answering an Accept header - JSON when the header is blank
+// or allows anything. Types are ranked by q-value then by the order the client
+// listed them. The bool is false when nothing acceptable can be sent
+func Negotiate(accept string) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return JSON, true
+	}
+
+	ranges := []accepted{}
+	for _, part := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, k := params["q"]; k {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+		if a, k := aliases[mt]; k {
+			mt = a
+		}
+		ranges = append(ranges, accepted{mediaType: mt, q: q})
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+
+	for _, r := range ranges {
+		for _, t := range Types {
+			if matches(r.mediaType, t) {
+				return t, true
+			}
+		}
+	}
+	return "", false
+}
+
+// reports whether the media range eg `text/*` covers t
+func matches(mediaRange, t string) bool {
+	if mediaRange == "*/*" || mediaRange == t {
+		return true
+	}
+	if strings.HasSuffix(mediaRange, "/*") {
+		return strings.HasPrefix(t, strings.TrimSuffix(mediaRange, "*"))
+	}
+	return false
+}
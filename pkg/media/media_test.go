@@ -0,0 +1,93 @@
+package media
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/pkg/testutil/assert"
+)
+
+func TestNegotiate(t *testing.T) {
+	suite := []struct {
+		testName string
+		accept   string
+		want     string
+		ok       bool
+	}{
+		{"ACCEPT - blank", "", JSON, true},
+		{"ACCEPT - anything", "*/*", JSON, true},
+		{"ACCEPT - csv", "text/csv", CSV, true},
+		{"ACCEPT - alias", "text/xml", XML, true},
+		{"ACCEPT - q-values", "application/json;q=0.5, application/x-ndjson", NDJSON, true},
+		{"ACCEPT - order", "application/xml, text/csv", XML, true},
+		{"ACCEPT - wildcard subtype", "image/png, text/*;q=0.8", CSV, true},
+		{"ACCEPT - refused", "application/json;q=0, text/html", "", false},
+		{"ACCEPT - unsupported", "image/png", "", false},
+	}
+	for i, test := range suite {
+		t.Run(fmt.Sprintf("#%d: %q", i, test.testName), func(t *testing.T) {
+			got, ok := Negotiate(test.accept)
+			assert.Equal(t, ok, test.ok)
+			assert.Equal(t, got, test.want)
+		})
+	}
+}
+
+type item struct {
+	Name    string    `json:"name"`
+	Count   int       `json:"count,omitempty"`
+	Tags    []string  `json:"tags,omitempty"`
+	Created time.Time `json:"created"`
+	hidden  string
+}
+
+type batch struct {
+	Items []item `json:"items"`
+}
+
+func (b batch) Rows() interface{} { return b.Items }
+
+func TestEncode(t *testing.T) {
+	created := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	list := []item{{Name: "a, b", Count: 2, Tags: []string{"x"}, Created: created}, {Name: "c"}}
+
+	suite := []struct {
+		testName  string
+		mediaType string
+		v         interface{}
+		want      string
+		err       string
+	}{
+		{"JSON", JSON, list[1], `{"name":"c","created":"0001-01-01T00:00:00Z"}`, ""},
+		{"CSV - slice", CSV, list, "name,count,tags,created\n\"a, b\",2,\"[\"\"x\"\"]\",2026-10-19T12:00:00Z\nc,0,null,\n", ""},
+		{"CSV - single value", CSV, &list[1], "name,count,tags,created\nc,0,null,\n", ""},
+		{"CSV - table", CSV, batch{Items: list[1:]}, "name,count,tags,created\nc,0,null,\n", ""},
+		{"CSV - map", CSV, map[string]int{"b": 2, "a": 1}, "a,b\n1,2\n", ""},
+		{"CSV - scalars", CSV, []string{"x", "y"}, "value\nx\ny\n", ""},
+		{"NDJSON - table", NDJSON, batch{Items: list}, `{"name":"a, b","count":2,"tags":["x"],"created":"2026-10-19T12:00:00Z"}` + "\n" + `{"name":"c","created":"0001-01-01T00:00:00Z"}` + "\n", ""},
+		{"XML - struct", XML, batch{Items: list}, `<?xml version="1.0" encoding="UTF-8"?>` + "\n" + `<response><items><item><name>a, b</name><count>2</count><tags><item>x</item></tags><created>2026-10-19T12:00:00Z</created></item><item><name>c</name><created></created></item></items></response>`, ""},
+		{"XML - map", XML, map[string]string{"deveui": "<0>"}, `<?xml version="1.0" encoding="UTF-8"?>` + "\n" + `<response><entry key="deveui">&lt;0&gt;</entry></response>`, ""},
+		{"TYPE - unsupported", "text/html", list, "", "unsupported media type"},
+	}
+	for i, test := range suite {
+		t.Run(fmt.Sprintf("#%d: %q", i, test.testName), func(t *testing.T) {
+			buf := bytes.Buffer{}
+			err := Encode(&buf, test.mediaType, test.v)
+			if test.err != "" {
+				assert.Error(t, err, test.err)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, buf.String(), test.want)
+		})
+	}
+}
+
+func TestProblem(t *testing.T) {
+	p := NewProblem(404, "shortcode - 0BEEF is Not Found")
+	assert.Equal(t, p.Type, "about:blank")
+	assert.Equal(t, p.Title, "Not Found")
+	assert.Equal(t, p.Status, 404)
+}
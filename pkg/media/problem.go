@@ -0,0 +1,18 @@
+package media
+
+import "net/http"
+
+// Problem :
+// An RFC 7807 problem detail. The type is about:blank
+// so the title is the text of the HTTP status
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// NewProblem : the problem answering a request with status
+func NewProblem(status int, detail string) Problem {
+	return Problem{Type: "about:blank", Title: http.StatusText(status), Status: status, Detail: detail}
+}
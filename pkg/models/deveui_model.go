@@ -14,6 +14,63 @@ type LastDevEUI struct {
 
 type RegisteredDevEUIList struct {
 	DevEUIs []string `json:"deveuis,omitempty"`
+	Skipped int      `json:"skipped,omitempty"`
+	Batch   string   `json:"batch,omitempty"`
+}
+
+// Rows : one BatchDevEUI per generated device for tabular formats
+func (l RegisteredDevEUIList) Rows() interface{} {
+	rows := make([]BatchDevEUI, len(l.DevEUIs))
+	for i, d := range l.DevEUIs {
+		rows[i] = BatchDevEUI{DevEUI: d, ShortCode: d[len(d)-5:], Batch: l.Batch}
+	}
+	return rows
+}
+
+// BatchDevEUI :
+// A generated DevEUI and the batch it was generated in
+type BatchDevEUI struct {
+	DevEUI    string `json:"deveui"`
+	ShortCode string `json:"shortcode"`
+	Batch     string `json:"batch,omitempty"`
+}
+
+// BatchEvent :
+// One line of a streamed batch - a `device` confirmed by the provider,
+// a registration that `failed`, periodic `progress`, an `error` ending
+// the batch early and finally `done`
+type BatchEvent struct {
+	Event     string         `json:"event"`
+	DevEUI    string         `json:"deveui,omitempty"`
+	ShortCode string         `json:"shortcode,omitempty"`
+	Error     string         `json:"error,omitempty"`
+	Progress  *BatchProgress `json:"progress,omitempty"`
+}
+
+// BatchProgress :
+// How far a batch has got - InFlight are being registered now
+type BatchProgress struct {
+	Batch      string `json:"batch,omitempty"`
+	Requested  int64  `json:"requested"`
+	Registered int64  `json:"registered"`
+	Failed     int64  `json:"failed"`
+	InFlight   int64  `json:"inflight"`
+	Skipped    int    `json:"skipped"`
+	Cancelled  bool   `json:"cancelled,omitempty"`
+}
+
+// Event :
+// Something that happened to a device while provisioning - published
+// on the event bus and pushed to `/events` subscribers
+type Event struct {
+	Type      string    `json:"type"`
+	Time      time.Time `json:"time"`
+	Tenant    string    `json:"tenant,omitempty"`
+	Batch     string    `json:"batch,omitempty"`
+	DevEUI    string    `json:"deveui,omitempty"`
+	ShortCode string    `json:"shortcode,omitempty"`
+	Count     int       `json:"count,omitempty"`
+	Detail    string    `json:"detail,omitempty"`
 }
 
 type ResponseObject struct {
@@ -27,3 +84,120 @@ type ApiResponseCacheObject struct {
 	Response string
 	Timeout  time.Duration
 }
+
+// SpaceStats :
+// Accounting of the 5 digit shortcode ID space
+type SpaceStats struct {
+	Last      string `json:"last"`
+	Capacity  int64  `json:"capacity"`
+	Issued    int64  `json:"issued"`
+	Skipped   int64  `json:"skipped"`
+	Remaining int64  `json:"remaining"`
+	Blocked   int64  `json:"blocked"`
+	Reserved  int64  `json:"reserved"`
+	Allocated int64  `json:"allocated"`
+}
+
+// Reservation :
+// A shortcode or inclusive range of shortcodes held back
+// from the sequential generator
+type Reservation struct {
+	From    string    `json:"from"`
+	To      string    `json:"to"`
+	Label   string    `json:"label,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+// Block :
+// A contiguous range of shortcodes allocated to an owner eg a factory
+// generation inside the block uses the owners own counter
+type Block struct {
+	Owner     string    `json:"owner"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Last      string    `json:"last"`
+	Issued    int64     `json:"issued"`
+	Remaining int64     `json:"remaining"`
+	Exhausted bool      `json:"exhausted"`
+	Created   time.Time `json:"created"`
+}
+
+// Tenant :
+// A team sharing the deployment with its own namespace
+// of devices and idempotency cache drawing from a block of shortcodes
+// Quota caps the devices it may generate - 0 is unlimited
+type Tenant struct {
+	Name    string    `json:"name"`
+	Quota   int64     `json:"quota,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+// APIKey :
+// An access key and the scopes it grants - only the hash of the key is kept.
+// Revoked keys are kept for the record but no longer authenticate
+type APIKey struct {
+	ID      string     `json:"id"`
+	Name    string     `json:"name"`
+	Scopes  []string   `json:"scopes"`
+	Created time.Time  `json:"created"`
+	Rotated *time.Time `json:"rotated,omitempty"`
+	Revoked *time.Time `json:"revoked,omitempty"`
+}
+
+// Claim :
+// A hardware serial number bound to a registered DevEUI
+type Claim struct {
+	Serial    string    `json:"serial"`
+	DevEUI    string    `json:"deveui"`
+	ShortCode string    `json:"shortcode"`
+	Created   time.Time `json:"created"`
+}
+
+// RootKeys :
+// OTAA root keys of a device - hex encoded
+// NwkKey is only issued for LoRaWAN 1.1 devices
+type RootKeys struct {
+	DevEUI  string `json:"deveui"`
+	JoinEUI string `json:"joineui"`
+	AppKey  string `json:"appkey"`
+	NwkKey  string `json:"nwkkey,omitempty"`
+}
+
+// KeyAudit :
+// A record of root keys leaving the store
+type KeyAudit struct {
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"`
+	Actor  string    `json:"actor"`
+	Remote string    `json:"remote,omitempty"`
+	DevEUI string    `json:"deveui"`
+}
+
+// KeyManifest :
+// Root keys of a set of devices wrapped for a join server
+// under the key encryption key named by KEKLabel
+type KeyManifest struct {
+	KEKLabel  string        `json:"kek_label"`
+	Algorithm string        `json:"algorithm"`
+	Created   time.Time     `json:"created"`
+	Devices   []WrappedKeys `json:"devices"`
+}
+
+// WrappedKeys :
+// The root keys of one device - AppKey and NwkKey are wrapped and hex encoded
+type WrappedKeys struct {
+	DevEUI  string `json:"deveui"`
+	JoinEUI string `json:"joineui"`
+	AppKey  string `json:"appkey"`
+	NwkKey  string `json:"nwkkey,omitempty"`
+}
+
+// Device :
+// The registration record of a device - the batch it was
+// generated in and when the provider accepted it
+type Device struct {
+	DevEUI     string    `json:"deveui"`
+	ShortCode  string    `json:"shortcode"`
+	Batch      string    `json:"batch,omitempty"`
+	Registered time.Time `json:"registered"`
+}
@@ -0,0 +1,141 @@
+package pool
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/David-solly/mxbcode/pkg/cache"
+	gen "github.com/David-solly/mxbcode/pkg/generator"
+)
+
+// Key :
+// Queue of generated and registered DevEUIs ready to hand out
+const Key = "POOL:DEVEUIS"
+
+// DefaultInterval : how often the pool is checked when nothing is allocated
+const DefaultInterval = time.Second * 30
+
+// FillFunc :
+// Generates and registers up to n devices returning their DevEUIs
+type FillFunc func(n int) ([]string, error)
+
+// Pool :
+// Keeps `Size` registered DevEUIs in the cache store ready for instant
+// allocation - topped up with `Fill` whenever it falls below `LowWater`
+type Pool struct {
+	Client   cache.Service
+	Size     int64
+	LowWater int64
+	Fill     FillFunc
+	Interval time.Duration
+
+	wake chan struct{}
+}
+
+// New :
+// A pool of `size` devices topped up when it falls below `low`
+// a low water mark of 0 defaults to half the size
+func New(c cache.Service, size, low int64, fill FillFunc) (*Pool, error) {
+	if size < 1 {
+		return nil, errors.New("pool size must be at least 1")
+	}
+	if low <= 0 {
+		low = size / 2
+	}
+	if low > size {
+		return nil, fmt.Errorf("low water mark (%d) is above the pool size (%d)", low, size)
+	}
+	return &Pool{Client: c, Size: size, LowWater: low, Fill: fill, Interval: DefaultInterval, wake: make(chan struct{}, 1)}, nil
+}
+
+// Allocate :
+// Pops up to n devices from the pool - never blocks on registration.
+// The replenisher is woken so the pool is topped up in the background
+func (p *Pool) Allocate(n int) ([]string, error) {
+	if n < 1 {
+		return nil, errors.New("Minimum request is 1")
+	}
+	if n > gen.DefaultMaxToGenerate {
+		return nil, fmt.Errorf("Too many requested - Maximum %d", gen.DefaultMaxToGenerate)
+	}
+
+	devices, err := p.Client.PopValues(Key, n)
+	p.Wake()
+	return devices, err
+}
+
+// Available : number of devices ready to allocate
+func (p *Pool) Available() (int64, error) {
+	return p.Client.CountValues(Key)
+}
+
+// Wake : asks the replenisher to check the pool now
+func (p *Pool) Wake() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Replenish :
+// Tops the pool back up to `Size` once it is below the low water mark.
+// Devices are generated in batches of at most `DefaultMaxToGenerate`
+// returns the number of devices added
+func (p *Pool) Replenish() (int, error) {
+	available, err := p.Available()
+	if err != nil {
+		return 0, err
+	}
+	if available >= p.LowWater {
+		return 0, nil
+	}
+
+	added := 0
+	for missing := p.Size - available; missing > 0; missing = p.Size - available - int64(added) {
+		n := int(missing)
+		if n > gen.DefaultMaxToGenerate {
+			n = gen.DefaultMaxToGenerate
+		}
+
+		devices, err := p.Fill(n)
+		if len(devices) > 0 {
+			if _, perr := p.Client.PushValues(Key, devices...); perr != nil {
+				return added, perr
+			}
+			added += len(devices)
+		}
+		if err != nil {
+			return added, err
+		}
+		if len(devices) == 0 {
+			return added, errors.New("pool replenishment generated no devices")
+		}
+	}
+
+	return added, nil
+}
+
+// Run :
+// Replenishes the pool whenever it is woken or `Interval` passes
+// until stop is closed or signalled
+func (p *Pool) Run(stop <-chan bool) {
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+
+	for {
+		if added, err := p.Replenish(); err != nil {
+			log.Printf("device pool - replenishment failed after %d devices: %v", added, err)
+		} else if added > 0 {
+			log.Printf("device pool - added %d devices", added)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-p.wake:
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package pool
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/David-solly/mxbcode/pkg/cache"
+
+	"github.com/docker/docker/pkg/testutil/assert"
+)
+
+// fake registration - hands out sequential DevEUIs
+// and records the size of every batch requested
+type filler struct {
+	next    int
+	batches []int
+	fail    bool
+}
+
+func (f *filler) fill(n int) ([]string, error) {
+	f.batches = append(f.batches, n)
+	if f.fail {
+		return nil, errors.New("provider unavailable")
+	}
+	devices := []string{}
+	for i := 0; i < n; i++ {
+		f.next++
+		devices = append(devices, fmt.Sprintf("AAAAAAAAAAA%05X", f.next))
+	}
+	return devices, nil
+}
+
+func TestNewPool(t *testing.T) {
+	c := cache.Cache{}
+	c.Initialise("", false)
+
+	suite := []struct {
+		testName  string
+		size, low int64
+		wantLow   int64
+		err       string
+	}{
+		{"NEW - default low water", 10, 0, 5, ""},
+		{"NEW - low water", 10, 8, 8, ""},
+		{"NEW - empty", 0, 0, 0, "pool size must be at least 1"},
+		{"NEW - low above size", 10, 11, 0, "above the pool size"},
+	}
+	for i, test := range suite {
+		t.Run(fmt.Sprintf("#%d: %q", i, test.testName), func(t *testing.T) {
+			p, err := New(c.Client, test.size, test.low, (&filler{}).fill)
+			if test.err != "" {
+				assert.Error(t, err, test.err)
+			} else {
+				assert.NilError(t, err)
+				assert.Equal(t, p.LowWater, test.wantLow)
+			}
+		})
+	}
+}
+
+func TestReplenishAndAllocate(t *testing.T) {
+	c := cache.Cache{}
+	c.Initialise("", false)
+	defer c.Client.DeleteValue(Key)
+
+	f := &filler{}
+	p, _ := New(c.Client, 250, 100, f.fill)
+
+	t.Run("REPLENISH fills in batches of 100", func(t *testing.T) {
+		added, err := p.Replenish()
+		assert.NilError(t, err)
+		assert.Equal(t, added, 250)
+		assert.DeepEqual(t, f.batches, []int{100, 100, 50})
+	})
+
+	t.Run("ALLOCATE pops in order", func(t *testing.T) {
+		devices, err := p.Allocate(3)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, devices, []string{"AAAAAAAAAAA00001", "AAAAAAAAAAA00002", "AAAAAAAAAAA00003"})
+
+		_, err = p.Allocate(0)
+		assert.Error(t, err, "Minimum request is 1")
+		_, err = p.Allocate(101)
+		assert.Error(t, err, "Too many requested")
+	})
+
+	t.Run("REPLENISH waits for the low water mark", func(t *testing.T) {
+		added, _ := p.Replenish()
+		assert.Equal(t, added, 0)
+
+		p.Allocate(100)
+		p.Allocate(50)
+		available, _ := p.Available()
+		assert.Equal(t, available, int64(97))
+
+		added, _ = p.Replenish()
+		assert.Equal(t, added, 153)
+		available, _ = p.Available()
+		assert.Equal(t, available, int64(250))
+	})
+
+	t.Run("REPLENISH reports failures", func(t *testing.T) {
+		p.Allocate(100)
+		p.Allocate(100)
+		f.fail = true
+		_, err := p.Replenish()
+		assert.Error(t, err, "provider unavailable")
+		f.fail = false
+	})
+}
+
+func TestRun(t *testing.T) {
+	c := cache.Cache{}
+	c.Initialise("", false)
+	defer c.Client.DeleteValue(Key)
+
+	p, _ := New(c.Client, 10, 5, (&filler{}).fill)
+	stop := make(chan bool)
+	go p.Run(stop)
+	defer close(stop)
+
+	// allocation wakes the replenisher
+	waitFor(t, p, 10)
+	p.Allocate(8)
+	waitFor(t, p, 10)
+}
+
+func waitFor(t *testing.T, p *Pool, want int64) {
+	for i := 0; i < 100; i++ {
+		if n, _ := p.Available(); n == want {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	n, _ := p.Available()
+	t.Fatalf("pool holds %d devices, want %d", n, want)
+}
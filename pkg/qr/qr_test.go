@@ -0,0 +1,193 @@
+package qr
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/pkg/testutil/assert"
+)
+
+func TestReedSolomon(t *testing.T) {
+	// worked examples of ISO 18004 and the HELLO WORLD tutorial symbol
+	suite := []struct {
+		testName string
+		data     string
+		ecc      string
+	}{
+		{"RS - 01234567 1-M", "10200C566180EC11EC11EC11EC11EC11", "A524D4C1ED36C7872C55"},
+		{"RS - HELLO WORLD 1-M", "205B0B78D172DC4D4340EC11EC11EC11", "C4232777EBD7E7E25D17"},
+	}
+	for i, test := range suite {
+		t.Run(fmt.Sprintf("#%d: %q", i, test.testName), func(t *testing.T) {
+			data, _ := hex.DecodeString(test.data)
+			ecc := rsRemainder(data, rsDivisor(10))
+			assert.Equal(t, strings.ToUpper(hex.EncodeToString(ecc)), test.ecc)
+		})
+	}
+}
+
+func TestDataCodewords(t *testing.T) {
+	suite := []struct {
+		testName string
+		text     string
+		level    Level
+		version  int
+		data     string
+	}{
+		{"DATA - alphanumeric", "HELLO WORLD", Medium, 1, "205B0B78D172DC4D4340EC11EC11EC11"},
+		{"DATA - bytes", "hi", Low, 1, "40268690EC11EC11EC11EC11EC11EC11EC11EC"},
+		{"DATA - larger version", strings.Repeat("A", 100), Medium, 5, ""},
+	}
+	for i, test := range suite {
+		t.Run(fmt.Sprintf("#%d: %q", i, test.testName), func(t *testing.T) {
+			version, data, err := dataCodewords(test.text, test.level)
+			assert.NilError(t, err)
+			assert.Equal(t, version, test.version)
+			assert.Equal(t, len(data), numDataCodewords(version, test.level))
+			if test.data != "" {
+				assert.Equal(t, strings.ToUpper(hex.EncodeToString(data)), test.data)
+			}
+		})
+	}
+
+	_, _, err := dataCodewords(strings.Repeat("x", 3000), High)
+	assert.Error(t, err, "too long")
+}
+
+func TestFormatAndVersion(t *testing.T) {
+	assert.Equal(t, fmt.Sprintf("%015b", formatInfo(Low, 0)), "111011111000100")
+	assert.Equal(t, fmt.Sprintf("%015b", formatInfo(Medium, 0)), "101010000010010")
+	assert.Equal(t, fmt.Sprintf("%015b", formatInfo(Quartile, 0)), "011010101011111")
+	assert.Equal(t, fmt.Sprintf("%015b", formatInfo(High, 0)), "001011010001001")
+	assert.Equal(t, fmt.Sprintf("%018b", versionInfo(7)), "000111110010010100")
+	assert.Equal(t, fmt.Sprintf("%018b", versionInfo(40)), "101000110001101001")
+
+	// every version holds as many codewords as the modules allow
+	for v := 1; v <= 40; v++ {
+		for l := Low; l <= High; l++ {
+			if numDataCodewords(v, l) <= 0 {
+				t.Errorf("version %d level %d has no data capacity", v, l)
+			}
+		}
+	}
+	// the function patterns leave exactly the data modules free
+	for v := 1; v <= 40; v++ {
+		c := newCode(v, Low)
+		c.drawFunctionPatterns()
+		free := 0
+		for y := 0; y < c.Size; y++ {
+			for x := 0; x < c.Size; x++ {
+				if !c.isFunction[y][x] {
+					free++
+				}
+			}
+		}
+		if free != numRawDataModules(v) {
+			t.Errorf("version %d leaves %d modules free, want %d", v, free, numRawDataModules(v))
+		}
+	}
+	assert.Equal(t, numDataCodewords(1, Medium), 16)
+	assert.Equal(t, numDataCodewords(10, High), 122)
+	assert.Equal(t, numDataCodewords(40, Low), 2956)
+	assert.DeepEqual(t, alignmentPositions(7), []int{6, 22, 38})
+	assert.DeepEqual(t, alignmentPositions(32), []int{6, 34, 60, 86, 112, 138})
+}
+
+func TestEncode(t *testing.T) {
+	suite := []struct {
+		testName string
+		text     string
+		level    Level
+		version  int
+	}{
+		{"ENCODE - TR005 payload", "LW:D0:70B3D57ED0000001:0004A30B001C0530:AABB1122", Medium, 3},
+		{"ENCODE - with serial", "LW:D0:70B3D57ED0000001:0004A30B001C0530:AABB1122:SLINE1-0001", Medium, 3},
+		{"ENCODE - bytes", "https://example.com/devices/0004a30b001c0530", Quartile, 4},
+		{"ENCODE - version info", strings.Repeat("0123456789", 20), High, 11},
+	}
+	for i, test := range suite {
+		t.Run(fmt.Sprintf("#%d: %q", i, test.testName), func(t *testing.T) {
+			c, err := Encode(test.text, test.level)
+			assert.NilError(t, err)
+			assert.Equal(t, c.Version, test.version)
+			assert.Equal(t, c.Size, test.version*4+17)
+
+			// the format information reads back as the chosen level and mask
+			read := 0
+			for i := 0; i < 8; i++ {
+				if c.Dark(c.Size-1-i, 8) {
+					read |= 1 << uint(i)
+				}
+			}
+			for i := 8; i < 15; i++ {
+				if c.Dark(8, c.Size-15+i) {
+					read |= 1 << uint(i)
+				}
+			}
+			assert.Equal(t, read, formatInfo(test.level, c.Mask))
+
+			// unmasking and reading the zigzag returns the codewords
+			_, data, _ := dataCodewords(test.text, test.level)
+			want := c.addECCAndInterleave(data)
+			assert.DeepEqual(t, readCodewords(c, len(want)), want)
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	c, err := Encode("LW:D0:70B3D57ED0000001:0004A30B001C0530:AABB1122", Medium)
+	assert.NilError(t, err)
+
+	data, err := c.PNG(4)
+	assert.NilError(t, err)
+	img, err := png.Decode(bytes.NewReader(data))
+	assert.NilError(t, err)
+	side := (c.Size + QuietZone*2) * 4
+	assert.Equal(t, img.Bounds().Dx(), side)
+
+	// top left finder starts after the quiet zone
+	r, _, _, _ := img.At(QuietZone*4, QuietZone*4).RGBA()
+	assert.Equal(t, r, uint32(0))
+	r, _, _, _ = img.At(QuietZone*4-1, QuietZone*4).RGBA()
+	assert.Equal(t, r, uint32(0xFFFF))
+
+	svg := string(c.SVG(4))
+	assert.Contains(t, svg, fmt.Sprintf(`viewBox="0 0 %d %d"`, c.Size+8, c.Size+8))
+	assert.Contains(t, svg, "M4 4h7v1h-7z") // finder top edge
+	assert.Equal(t, strings.HasSuffix(svg, "</svg>"), true)
+}
+
+// read n codewords back out of the symbol
+// walking column pairs right to left independently of drawCodewords
+func readCodewords(c *Code, n int) []byte {
+	out := make([]byte, n)
+	i := 0
+	upward := true
+	for col := c.Size - 1; col > 0 && i < n*8; col -= 2 {
+		if col == 6 {
+			col--
+		}
+		for k := 0; k < c.Size; k++ {
+			y := k
+			if upward {
+				y = c.Size - 1 - k
+			}
+			for _, x := range []int{col, col - 1} {
+				if c.isFunction[y][x] || i >= n*8 {
+					continue
+				}
+				v := c.modules[y][x] != maskBit(c.Mask, x, y)
+				if v {
+					out[i/8] |= 0x80 >> uint(i%8)
+				}
+				i++
+			}
+		}
+		upward = !upward
+	}
+	return out
+}
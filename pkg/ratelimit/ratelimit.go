@@ -0,0 +1,248 @@
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/David-solly/mxbcode/pkg/cache"
+)
+
+// BucketPrefix :
+// Prefix of token buckets in the cache store
+// keyed `RATE:<CLIENT>` and dropped once full again
+const BucketPrefix = "RATE:"
+
+// QuotaPrefix :
+// Prefix of daily quota counters in the cache store
+// keyed `QUOTA:<CLIENT>:<YYYY-MM-DD>` and dropped an hour after the day
+const QuotaPrefix = "QUOTA:"
+
+// how long a quota counter outlives its day so a late refund finds it
+const quotaGrace = time.Hour
+
+// the day of a quota counter
+const dayFormat = "2006-01-02"
+
+// how many times a swap is retried while other requests race for the same client
+const maxAttempts = 20
+
+// ErrContended : the store kept changing under every attempt
+var ErrContended = errors.New("rate limit store is contended - try again")
+
+// Bucket :
+// Token buckets per client refilled at Rate tokens a second up to Burst.
+// Buckets live in the cache store so every replica sharing it
+// draws from the same bucket
+type Bucket struct {
+	Client cache.Service
+	Rate   float64
+	Burst  int64
+
+	// the current time - replaced in tests
+	Now func() time.Time
+}
+
+// NewBucket : buckets refilled at rate a second - a burst of 0 is one second worth
+func NewBucket(c cache.Service, rate float64, burst int64) (*Bucket, error) {
+	if rate <= 0 {
+		return nil, fmt.Errorf("invalid rate %v - must be above 0", rate)
+	}
+	if burst < 0 {
+		return nil, fmt.Errorf("invalid burst %d", burst)
+	}
+	if burst == 0 {
+		burst = int64(math.Ceil(rate))
+	}
+	return &Bucket{Client: c, Rate: rate, Burst: burst, Now: time.Now}, nil
+}
+
+// Take :
+// Takes a token from the bucket of client.
+// When none is left the wait until the next one is returned
+func (b *Bucket) Take(client string) (bool, time.Duration, error) {
+	key := BucketPrefix + client
+	// an untouched bucket is full once this long has passed
+	ttl := time.Duration(float64(b.Burst)/b.Rate*float64(time.Second)) + time.Second
+
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		now := b.Now()
+		old, tokens := b.tokens(key, now)
+		if tokens < 1 {
+			return false, b.wait(tokens), nil
+		}
+
+		value := strconv.FormatFloat(tokens-1, 'f', -1, 64) + "|" + strconv.FormatInt(now.UnixNano(), 10)
+		ok, err := b.Client.SwapValue(key, old, value, ttl)
+		if err != nil {
+			return false, 0, err
+		}
+		if ok {
+			return true, 0, nil
+		}
+	}
+	return false, 0, ErrContended
+}
+
+// Peek :
+// Whether the bucket of client has a token left without taking it.
+// When none is left the wait until the next one is returned
+func (b *Bucket) Peek(client string) (bool, time.Duration) {
+	_, tokens := b.tokens(BucketPrefix+client, b.Now())
+	if tokens < 1 {
+		return false, b.wait(tokens)
+	}
+	return true, 0
+}
+
+// the stored bucket and the tokens in it by now - full when not stored
+func (b *Bucket) tokens(key string, now time.Time) (string, float64) {
+	old, found, _ := b.Client.ReadCache(key)
+	if !found {
+		return "", float64(b.Burst)
+	}
+	tokens := float64(b.Burst)
+	if t, last, ok := parseBucket(old); ok {
+		tokens = math.Min(tokens, t+now.Sub(last).Seconds()*b.Rate)
+	}
+	return old, tokens
+}
+
+// how long until the bucket holds a whole token again
+func (b *Bucket) wait(tokens float64) time.Duration {
+	return time.Duration((1 - tokens) / b.Rate * float64(time.Second))
+}
+
+// `tokens|unix nanoseconds` of the last take
+func parseBucket(value string) (float64, time.Time, bool) {
+	parts := strings.Split(value, "|")
+	if len(parts) != 2 {
+		return 0, time.Time{}, false
+	}
+	tokens, err1 := strconv.ParseFloat(parts[0], 64)
+	nanos, err2 := strconv.ParseInt(parts[1], 10, 64)
+	if err1 != nil || err2 != nil {
+		return 0, time.Time{}, false
+	}
+	return tokens, time.Unix(0, nanos), true
+}
+
+// Quota :
+// At most Limit units per client each UTC day - eg DevEUIs generated.
+// Counters live in the cache store so every replica shares them
+type Quota struct {
+	Client cache.Service
+	Limit  int64
+
+	// the current time - replaced in tests
+	Now func() time.Time
+}
+
+// NewQuota : limit units per client a day
+func NewQuota(c cache.Service, limit int64) (*Quota, error) {
+	if limit < 1 {
+		return nil, fmt.Errorf("invalid daily quota %d - must be at least 1", limit)
+	}
+	return &Quota{Client: c, Limit: limit, Now: time.Now}, nil
+}
+
+// Reserve :
+// Takes up to n units from what is left of the quota of client today.
+// The day charged is returned for Refund - a batch may settle after midnight.
+// Nothing granted comes with the wait until the quota resets
+func (q *Quota) Reserve(client string, n int64) (int64, string, time.Duration, error) {
+	var granted int64
+	day := q.Now().UTC().Format(dayFormat)
+	err := q.update(client, day, func(used int64) int64 {
+		granted = q.Limit - used
+		if granted > n {
+			granted = n
+		}
+		if granted < 0 {
+			granted = 0
+		}
+		return used + granted
+	})
+	if err != nil || granted > 0 {
+		return granted, day, 0, err
+	}
+	return 0, day, q.reset(), nil
+}
+
+// Refund :
+// Gives back units reserved on day but not used.
+// Nothing is given back once the counter of the day is dropped
+func (q *Quota) Refund(client, day string, n int64) error {
+	if n <= 0 {
+		return nil
+	}
+	return q.update(client, day, func(used int64) int64 {
+		if used < n {
+			return 0
+		}
+		return used - n
+	})
+}
+
+// Used : units of the quota of client taken today
+func (q *Quota) Used(client string) (int64, error) {
+	value, found, _ := q.Client.ReadCache(q.key(client, q.Now().UTC().Format(dayFormat)))
+	if !found {
+		return 0, nil
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// swaps in the count returned by apply for the count used on day
+func (q *Quota) update(client, day string, apply func(used int64) int64) error {
+	start, err := time.Parse(dayFormat, day)
+	if err != nil {
+		return fmt.Errorf("invalid quota day %q - %v", day, err)
+	}
+	// kept an hour past the day so a late refund finds it
+	ttl := start.Add(24*time.Hour + quotaGrace).Sub(q.Now())
+	if ttl <= 0 {
+		return nil
+	}
+
+	key := q.key(client, day)
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		old, found, _ := q.Client.ReadCache(key)
+		used := int64(0)
+		if found {
+			n, err := strconv.ParseInt(old, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid quota counter %q - %v", key, err)
+			}
+			used = n
+		} else {
+			old = ""
+		}
+
+		next := apply(used)
+		if next == used {
+			return nil
+		}
+		ok, err := q.Client.SwapValue(key, old, strconv.FormatInt(next, 10), ttl)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+	}
+	return ErrContended
+}
+
+func (q *Quota) key(client, day string) string {
+	return QuotaPrefix + client + ":" + day
+}
+
+// how long until the quota resets at midnight UTC
+func (q *Quota) reset() time.Duration {
+	now := q.Now().UTC()
+	return now.Truncate(24 * time.Hour).Add(24 * time.Hour).Sub(now)
+}
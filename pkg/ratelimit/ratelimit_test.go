@@ -0,0 +1,163 @@
+package ratelimit
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/David-solly/mxbcode/pkg/cache"
+
+	"github.com/docker/docker/pkg/testutil/assert"
+)
+
+func TestBucket(t *testing.T) {
+	c := cache.Cache{}
+	c.Initialise("", false)
+
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	b, err := NewBucket(c.Client, 2, 3)
+	assert.NilError(t, err)
+	b.Now = func() time.Time { return now }
+
+	suite := []struct {
+		testName string
+		advance  time.Duration
+		client   string
+		want     bool
+		wait     time.Duration
+	}{
+		{"BUCKET - burst 1", 0, "ip:10.0.0.1", true, 0},
+		{"BUCKET - burst 2", 0, "ip:10.0.0.1", true, 0},
+		{"BUCKET - burst 3", 0, "ip:10.0.0.1", true, 0},
+		{"BUCKET - empty", 0, "ip:10.0.0.1", false, 500 * time.Millisecond},
+		{"BUCKET - other client", 0, "ip:10.0.0.2", true, 0},
+		{"BUCKET - partly refilled", 250 * time.Millisecond, "ip:10.0.0.1", false, 250 * time.Millisecond},
+		{"BUCKET - refilled", 250 * time.Millisecond, "ip:10.0.0.1", true, 0},
+		{"BUCKET - empty again", 0, "ip:10.0.0.1", false, 500 * time.Millisecond},
+		{"BUCKET - never above burst", time.Hour, "ip:10.0.0.1", true, 0},
+	}
+	for i, test := range suite {
+		t.Run(fmt.Sprintf("#%d: %q", i, test.testName), func(t *testing.T) {
+			now = now.Add(test.advance)
+			ok, wait, err := b.Take(test.client)
+			assert.NilError(t, err)
+			assert.Equal(t, ok, test.want)
+			assert.Equal(t, wait, test.wait)
+		})
+	}
+
+	// peeking takes nothing
+	for i := 0; i < 3; i++ {
+		ok, _ := b.Peek("ip:10.0.0.3")
+		assert.Equal(t, ok, true)
+		b.Take("ip:10.0.0.3")
+	}
+	ok, wait := b.Peek("ip:10.0.0.3")
+	assert.Equal(t, ok, false)
+	assert.Equal(t, wait, 500*time.Millisecond)
+
+	_, err = NewBucket(c.Client, 0, 1)
+	assert.Error(t, err, "invalid rate")
+	b, _ = NewBucket(c.Client, 2.5, 0)
+	assert.Equal(t, b.Burst, int64(3))
+}
+
+func TestBucketShared(t *testing.T) {
+	c := cache.Cache{}
+	c.Initialise("", false)
+
+	// replicas sharing a store never hand out more than the burst
+	var taken int64
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		b, _ := NewBucket(c.Client, 0.001, 25)
+		for j := 0; j < 10; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if ok, _, err := b.Take("key:shared"); ok && err == nil {
+					atomic.AddInt64(&taken, 1)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	assert.Equal(t, taken, int64(25))
+}
+
+func TestQuota(t *testing.T) {
+	c := cache.Cache{}
+	c.Initialise("", false)
+
+	now := time.Date(2026, 1, 1, 18, 0, 0, 0, time.UTC)
+	q, err := NewQuota(c.Client, 250)
+	assert.NilError(t, err)
+	q.Now = func() time.Time { return now }
+
+	suite := []struct {
+		testName string
+		reserve  int64
+		refund   int64
+		granted  int64
+		used     int64
+	}{
+		{"QUOTA - first batch", 100, 0, 100, 100},
+		{"QUOTA - partly used", 100, 40, 100, 160},
+		{"QUOTA - trimmed", 100, 0, 90, 250},
+		{"QUOTA - used up", 100, 0, 0, 250},
+	}
+	for i, test := range suite {
+		t.Run(fmt.Sprintf("#%d: %q", i, test.testName), func(t *testing.T) {
+			granted, day, wait, err := q.Reserve("key:station", test.reserve)
+			assert.NilError(t, err)
+			assert.Equal(t, granted, test.granted)
+			assert.Equal(t, day, "2026-01-01")
+			if granted == 0 {
+				assert.Equal(t, wait, 6*time.Hour)
+			}
+			assert.NilError(t, q.Refund("key:station", day, test.refund))
+			used, _ := q.Used("key:station")
+			assert.Equal(t, used, test.used)
+		})
+	}
+
+	// a new day starts afresh
+	now = now.Add(7 * time.Hour)
+	granted, _, _, _ := q.Reserve("key:station", 100)
+	assert.Equal(t, granted, int64(100))
+
+	_, err = NewQuota(c.Client, 0)
+	assert.Error(t, err, "invalid daily quota")
+}
+
+func TestQuotaRefundAfterMidnight(t *testing.T) {
+	c := cache.Cache{}
+	c.Initialise("", false)
+
+	now := time.Date(2026, 1, 1, 23, 59, 0, 0, time.UTC)
+	q, _ := NewQuota(c.Client, 100)
+	q.Now = func() time.Time { return now }
+
+	// a batch reserved before midnight settles after it
+	granted, day, _, err := q.Reserve("key:station", 100)
+	assert.NilError(t, err)
+	assert.Equal(t, granted, int64(100))
+	now = now.Add(2 * time.Minute)
+	assert.NilError(t, q.Refund("key:station", day, 60))
+
+	// the refund goes back to the day charged - not the new one
+	used, _, _ := c.Client.ReadCache(QuotaPrefix + "key:station:2026-01-01")
+	assert.Equal(t, used, "40")
+	used, _, _ = c.Client.ReadCache(QuotaPrefix + "key:station:2026-01-02")
+	assert.Equal(t, used, "")
+	granted, _, _, _ = q.Reserve("key:station", 100)
+	assert.Equal(t, granted, int64(100))
+
+	// past the grace hour the counter is dropped and nothing is given back
+	now = now.Add(2 * time.Hour)
+	assert.NilError(t, q.Refund("key:station", day, 40))
+	used, _, _ = c.Client.ReadCache(QuotaPrefix + "key:station:2026-01-01")
+	assert.Equal(t, used, "40")
+}
@@ -0,0 +1,248 @@
+package registrar
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/David-solly/mxbcode/pkg/breaker"
+	"github.com/David-solly/mxbcode/pkg/certs"
+	"github.com/David-solly/mxbcode/pkg/concurrency"
+	"github.com/David-solly/mxbcode/pkg/signing"
+)
+
+// defaults of a registrar missing the setting
+const (
+	DefaultConcurrency    = 10
+	DefaultConnectTimeout = 10 * time.Second
+	DefaultTimeout        = 30 * time.Second
+)
+
+// DefaultName : the registrar of the shared namespace and unlisted tenants
+const DefaultName = "default"
+
+// Duration :
+// A time.Duration written as a string in config files eg "5s"
+type Duration time.Duration
+
+// UnmarshalJSON : parses a duration string
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	s := ""
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("durations are strings eg \"5s\" - %v", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalJSON : writes the duration string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Config :
+// Where devices are registered and how the registrar is reached.
+// `CA` verifies the registrar in place of the system roots, `Cert`
+// and `Key` are the client certificate it requires. Without `Proxy`
+// the `HTTPS_PROXY` environment is used. `Concurrency` caps the
+// requests in flight and sizes the connection pool to match - below it
+// the limit adapts to how the registrar copes. `Latency` is the response
+// time it counts as overloaded. Once `FailureRatio` of the recent requests
+// fail the registrar is left alone for `Cooldown`. `Auth` signs every
+// request sent to it
+type Config struct {
+	Name           string   `json:"name"`
+	URL            string   `json:"url"`
+	Tenants        []string `json:"tenants,omitempty"`
+	CA             string   `json:"ca,omitempty"`
+	Cert           string   `json:"cert,omitempty"`
+	Key            string   `json:"key,omitempty"`
+	Proxy          string   `json:"proxy,omitempty"`
+	ConnectTimeout Duration `json:"connect_timeout,omitempty"`
+	Timeout        Duration `json:"timeout,omitempty"`
+	Concurrency    int      `json:"concurrency,omitempty"`
+	Latency        Duration `json:"latency,omitempty"`
+	FailureRatio   float64  `json:"failure_ratio,omitempty"`
+	Cooldown       Duration `json:"cooldown,omitempty"`
+
+	Auth *signing.Config `json:"auth,omitempty"`
+}
+
+// Registrar :
+// A registration endpoint with the HTTP client configured to reach it
+// the limiter of the requests sent to it and the breaker stopping
+// them while it is down
+type Registrar struct {
+	Config
+	Client  *http.Client
+	Limiter *concurrency.Limiter
+	Breaker *breaker.Breaker
+}
+
+// New :
+// Builds the client of a registrar filling in defaults
+func New(cfg Config) (*Registrar, error) {
+	if cfg.Name == "" {
+		cfg.Name = DefaultName
+	}
+	if cfg.URL != "" {
+		if u, err := neturl.Parse(cfg.URL); err != nil || u.Host == "" {
+			return nil, fmt.Errorf("registrar %q - invalid url %q", cfg.Name, cfg.URL)
+		}
+	}
+	if cfg.Concurrency < 1 {
+		cfg.Concurrency = DefaultConcurrency
+	}
+	if cfg.ConnectTimeout <= 0 {
+		cfg.ConnectTimeout = Duration(DefaultConnectTimeout)
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = Duration(DefaultTimeout)
+	}
+	if cfg.FailureRatio <= 0 {
+		cfg.FailureRatio = breaker.DefaultFailureRatio
+	}
+	if cfg.Cooldown <= 0 {
+		cfg.Cooldown = Duration(breaker.DefaultCooldown)
+	}
+	if (cfg.Cert == "") != (cfg.Key == "") {
+		return nil, fmt.Errorf("registrar %q - a client certificate needs both cert and key", cfg.Name)
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.CA != "" {
+		pool, err := certs.LoadCertPool(cfg.CA)
+		if err != nil {
+			return nil, fmt.Errorf("registrar %q - %v", cfg.Name, err)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.Cert != "" {
+		// rotated client certificates are picked up like the servers own
+		r, err := certs.NewReloader(cfg.Cert, cfg.Key)
+		if err != nil {
+			return nil, fmt.Errorf("registrar %q - %v", cfg.Name, err)
+		}
+		tlsConfig.GetClientCertificate = r.GetClientCertificate
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if cfg.Proxy != "" {
+		u, err := neturl.Parse(cfg.Proxy)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("registrar %q - invalid proxy %q", cfg.Name, cfg.Proxy)
+		}
+		proxy = http.ProxyURL(u)
+	}
+
+	connect := time.Duration(cfg.ConnectTimeout)
+	dialer := &net.Dialer{Timeout: connect, KeepAlive: 30 * time.Second}
+	transport := &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dialer.DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   connect,
+		MaxIdleConns:          cfg.Concurrency,
+		MaxIdleConnsPerHost:   cfg.Concurrency,
+		MaxConnsPerHost:       cfg.Concurrency,
+		IdleConnTimeout:       90 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+
+	var roundTripper http.RoundTripper = transport
+	if cfg.Auth != nil {
+		signer, err := signing.New(*cfg.Auth)
+		if err != nil {
+			return nil, fmt.Errorf("registrar %q - %v", cfg.Name, err)
+		}
+		roundTripper = &signing.Transport{Base: transport, Signer: signer}
+	}
+
+	limiter := concurrency.New(cfg.Concurrency)
+	limiter.Latency = time.Duration(cfg.Latency)
+	b := breaker.New()
+	b.FailureRatio, b.Cooldown = cfg.FailureRatio, time.Duration(cfg.Cooldown)
+
+	return &Registrar{Config: cfg, Client: &http.Client{Transport: roundTripper, Timeout: time.Duration(cfg.Timeout)},
+		Limiter: limiter, Breaker: b}, nil
+}
+
+// Load :
+// Reads a json file of registrar configs - `{"registrars": [...]}`
+func Load(path string) ([]Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	file := struct {
+		Registrars []Config `json:"registrars"`
+	}{}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s - %v", path, err)
+	}
+	return file.Registrars, nil
+}
+
+// Set :
+// The registrars devices are sent to - each tenant
+// to the registrar listing it and everyone else to `Default`
+type Set struct {
+	Default  *Registrar
+	byTenant map[string]*Registrar
+}
+
+// NewSet :
+// Routes tenants to the registrars listing them.
+// A tenant may only be listed by one registrar
+func NewSet(def *Registrar, others ...*Registrar) (*Set, error) {
+	s := &Set{Default: def, byTenant: map[string]*Registrar{}}
+	for _, r := range others {
+		if len(r.Tenants) == 0 {
+			return nil, fmt.Errorf("registrar %q serves no tenants", r.Name)
+		}
+		if r.URL == "" {
+			return nil, fmt.Errorf("registrar %q has no url", r.Name)
+		}
+		for _, t := range r.Tenants {
+			t = strings.ToLower(t)
+			if other, k := s.byTenant[t]; k {
+				return nil, fmt.Errorf("tenant %q is served by both %q and %q", t, other.Name, r.Name)
+			}
+			s.byTenant[t] = r
+		}
+	}
+	return s, nil
+}
+
+// All : the default registrar followed by the others by name
+func (s *Set) All() []*Registrar {
+	all := []*Registrar{}
+	seen := map[*Registrar]bool{}
+	for _, r := range s.byTenant {
+		if !seen[r] {
+			seen[r] = true
+			all = append(all, r)
+		}
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+	return append([]*Registrar{s.Default}, all...)
+}
+
+// For : the registrar of a tenant - blank for the shared namespace
+func (s *Set) For(tenant string) *Registrar {
+	if r, k := s.byTenant[strings.ToLower(tenant)]; k {
+		return r
+	}
+	return s.Default
+}
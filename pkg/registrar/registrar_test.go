@@ -0,0 +1,211 @@
+package registrar
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/David-solly/mxbcode/pkg/breaker"
+	"github.com/David-solly/mxbcode/pkg/signing"
+	"github.com/docker/docker/pkg/testutil/assert"
+)
+
+// a client certificate for cn signed by a new CA
+// returns the CA and the PEM certificate and key
+func clientCertificate(t *testing.T, cn string) (*x509.Certificate, []byte, []byte) {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "registrar-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	assert.NilError(t, err)
+	ca, _ = x509.ParseCertificate(der)
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err = x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	assert.NilError(t, err)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	return ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func post(r *Registrar) (int, error) {
+	resp, err := r.Client.Post(r.URL, "application/json", strings.NewReader(`{"deveui":"FFFF1"}`))
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+func TestNew(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "registrar")
+	defer os.RemoveAll(dir)
+	write := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		ioutil.WriteFile(path, data, 0600)
+		return path
+	}
+
+	ca, certPEM, keyPEM := clientCertificate(t, "mxbcode")
+	clients := x509.NewCertPool()
+	clients.AddCert(ca)
+
+	// a registrar requiring client certificates from ca
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clients}
+	srv.StartTLS()
+	defer srv.Close()
+
+	serverCA := write("server-ca.pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}))
+	cert, key := write("client.crt", certPEM), write("client.key", keyPEM)
+
+	suite := []struct {
+		testName string
+		cfg      Config
+		err      string
+		want     int
+	}{
+		{"CLIENT - mutual tls", Config{URL: srv.URL, CA: serverCA, Cert: cert, Key: key}, "", 200},
+		{"CLIENT - unknown registrar CA", Config{URL: srv.URL, Cert: cert, Key: key}, "certificate", 0},
+		{"CLIENT - no client certificate", Config{URL: srv.URL, CA: serverCA}, "certificate", 0},
+		{"CLIENT - cert without key", Config{URL: srv.URL, Cert: cert}, "needs both cert and key", 0},
+		{"CLIENT - missing CA", Config{URL: srv.URL, CA: filepath.Join(dir, "missing.pem")}, "no such file", 0},
+		{"CLIENT - invalid url", Config{URL: "registrar"}, "invalid url", 0},
+		{"CLIENT - invalid proxy", Config{URL: srv.URL, Proxy: "::"}, "invalid proxy", 0},
+		{"CLIENT - unknown signing", Config{URL: srv.URL, Auth: &signing.Config{Type: "basic", Secret: "s"}}, `registrar "default" - unknown signing type`, 0},
+	}
+	for i, test := range suite {
+		t.Run(fmt.Sprintf("#%d: %q", i, test.testName), func(t *testing.T) {
+			code := 0
+			r, err := New(test.cfg)
+			if err == nil {
+				code, err = post(r)
+			}
+			if test.err != "" {
+				assert.Error(t, err, test.err)
+			} else {
+				assert.NilError(t, err)
+			}
+			assert.Equal(t, code, test.want)
+		})
+	}
+
+	t.Run("CLIENT - defaults", func(t *testing.T) {
+		r, err := New(Config{URL: srv.URL})
+		assert.NilError(t, err)
+		assert.Equal(t, r.Name, DefaultName)
+		assert.Equal(t, r.Concurrency, DefaultConcurrency)
+		assert.Equal(t, r.Client.Timeout, DefaultTimeout)
+		transport := r.Client.Transport.(*http.Transport)
+		assert.Equal(t, transport.MaxConnsPerHost, DefaultConcurrency)
+		assert.Equal(t, transport.MaxIdleConnsPerHost, DefaultConcurrency)
+		assert.Equal(t, transport.TLSHandshakeTimeout, DefaultConnectTimeout)
+		assert.Equal(t, r.Limiter.Stats().Max, DefaultConcurrency)
+		assert.Equal(t, r.Breaker.Cooldown, breaker.DefaultCooldown)
+		assert.Equal(t, r.Breaker.FailureRatio, breaker.DefaultFailureRatio)
+	})
+
+	t.Run("CLIENT - signed", func(t *testing.T) {
+		r, err := New(Config{URL: srv.URL, Auth: &signing.Config{Type: "bearer", Secret: "t1"}})
+		assert.NilError(t, err)
+		transport := r.Client.Transport.(*signing.Transport)
+		assert.Equal(t, transport.Base.(*http.Transport).MaxConnsPerHost, DefaultConcurrency)
+	})
+}
+
+func TestProxyAndTimeout(t *testing.T) {
+	proxied := make(chan string, 1)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied <- r.URL.String()
+	}))
+	defer proxy.Close()
+
+	r, err := New(Config{URL: "http://registrar.invalid/sensor-onboarding", Proxy: proxy.URL})
+	assert.NilError(t, err)
+	code, err := post(r)
+	assert.NilError(t, err)
+	assert.Equal(t, code, 200)
+	assert.Equal(t, <-proxied, "http://registrar.invalid/sensor-onboarding")
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer slow.Close()
+
+	r, err = New(Config{URL: slow.URL, Timeout: Duration(50 * time.Millisecond)})
+	assert.NilError(t, err)
+	_, err = post(r)
+	assert.Error(t, err, "Client.Timeout exceeded")
+}
+
+func TestLoadAndSet(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "registrar")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "registrars.json")
+
+	ioutil.WriteFile(path, []byte(`{"registrars": [
+		{"name": "acme-ns", "url": "https://ns.acme.example/register", "tenants": ["Acme"], "timeout": "5s", "concurrency": 4, "cooldown": "1m"},
+		{"name": "globex-ns", "url": "https://ns.globex.example/register", "tenants": ["globex", "initech"]}
+	]}`), 0600)
+	configs, err := Load(path)
+	assert.NilError(t, err)
+	assert.Equal(t, len(configs), 2)
+	assert.Equal(t, time.Duration(configs[0].Timeout), 5*time.Second)
+
+	def, _ := New(Config{URL: "https://ns.example/register"})
+	acme, _ := New(configs[0])
+	globex, _ := New(configs[1])
+	set, err := NewSet(def, acme, globex)
+	assert.NilError(t, err)
+	assert.Equal(t, set.For("acme").Name, "acme-ns")
+	assert.Equal(t, set.For("acme").Client.Transport.(*http.Transport).MaxConnsPerHost, 4)
+	assert.Equal(t, set.For("initech").Name, "globex-ns")
+	assert.Equal(t, set.For("hooli").Name, DefaultName)
+	assert.Equal(t, set.For("").Name, DefaultName)
+	assert.Equal(t, set.For("acme").Limiter.Stats().Max, 4)
+	assert.Equal(t, set.For("acme").Breaker.Cooldown, time.Minute)
+	names := []string{}
+	for _, r := range set.All() {
+		names = append(names, r.Name)
+	}
+	assert.DeepEqual(t, names, []string{DefaultName, "acme-ns", "globex-ns"})
+
+	_, err = NewSet(def, acme, acme)
+	assert.Error(t, err, `tenant "acme" is served by both "acme-ns" and "acme-ns"`)
+	_, err = NewSet(def, def)
+	assert.Error(t, err, `registrar "default" serves no tenants`)
+
+	ioutil.WriteFile(path, []byte(`{"registrars": [{"name": "acme-ns", "timeout": 5}]}`), 0600)
+	_, err = Load(path)
+	assert.Error(t, err, "durations are strings")
+}
@@ -0,0 +1,147 @@
+package registry
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/David-solly/mxbcode/pkg/cache"
+	"github.com/David-solly/mxbcode/pkg/models"
+)
+
+// KeyPrefix :
+// Prefix of device registration records in the cache store
+// records are keyed `DEVICE:<DEVEUI>`
+const KeyPrefix = "DEVICE:"
+
+// devices registered before records were kept
+// are only known by their 5 digit shortcode key
+var shortcodeKey = regexp.MustCompile(`^[0-9A-F]{5}$`)
+
+// Filter :
+// Narrows a listing to one batch and a registration window.
+// Blank fields match every device
+type Filter struct {
+	Batch string
+	Since time.Time
+	Until time.Time
+}
+
+// NewBatchID :
+// Identifies a generated batch - the UTC start time
+// followed by random hex so concurrent batches differ
+func NewBatchID() string {
+	b := make([]byte, 3)
+	rand.Read(b)
+	return time.Now().UTC().Format("20060102T150405") + "-" + hex.EncodeToString(b)
+}
+
+// Record : stores the registration record of a device
+func Record(c cache.Service, d models.Device) error {
+	d.DevEUI = strings.ToUpper(d.DevEUI)
+	d.ShortCode = strings.ToUpper(d.ShortCode)
+	if d.Registered.IsZero() {
+		d.Registered = time.Now().UTC()
+	}
+	data, _ := json.Marshal(d)
+	_, err := c.StoreValue(KeyPrefix+d.DevEUI, string(data))
+	return err
+}
+
+// Get :
+// The record of deveui - nil if none is kept
+func Get(c cache.Service, deveui string) (*models.Device, error) {
+	data, found, _ := c.ReadCache(KeyPrefix + strings.ToUpper(deveui))
+	if !found {
+		return nil, nil
+	}
+	d := models.Device{}
+	if err := json.Unmarshal([]byte(data), &d); err != nil {
+		return nil, fmt.Errorf("corrupt device record %s - %v", deveui, err)
+	}
+	return &d, nil
+}
+
+// List :
+// Every device matching the filter ordered by DevEUI.
+// Devices stored without a record have no batch or registration
+// time so they are only listed by an empty filter
+func List(c cache.Service, f Filter) ([]models.Device, error) {
+	keys, err := c.ListKeys(KeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	list := []models.Device{}
+	seen := map[string]bool{}
+	for _, k := range keys {
+		d, err := Get(c, strings.TrimPrefix(k, KeyPrefix))
+		if err != nil {
+			return nil, err
+		}
+		if d == nil {
+			continue
+		}
+		seen[d.DevEUI] = true
+		if f.matches(*d) {
+			list = append(list, *d)
+		}
+	}
+
+	if f.empty() {
+		all, err := c.ListKeys("")
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range all {
+			if !shortcodeKey.MatchString(k) {
+				continue
+			}
+			deveui, found, _ := c.ReadCache(k)
+			if found && !seen[strings.ToUpper(deveui)] {
+				list = append(list, models.Device{DevEUI: strings.ToUpper(deveui), ShortCode: k})
+			}
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].DevEUI < list[j].DevEUI })
+	return list, nil
+}
+
+func (f Filter) empty() bool {
+	return f.Batch == "" && f.Since.IsZero() && f.Until.IsZero()
+}
+
+func (f Filter) matches(d models.Device) bool {
+	if f.Batch != "" && !strings.EqualFold(f.Batch, d.Batch) {
+		return false
+	}
+	if !f.Since.IsZero() && d.Registered.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !d.Registered.Before(f.Until) {
+		return false
+	}
+	return true
+}
+
+// ParseTime :
+// A filter bound given as RFC 3339 or a plain `2006-01-02` date in UTC
+func ParseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q - RFC 3339 or 2006-01-02", s)
+	}
+	return t, nil
+}
@@ -0,0 +1,73 @@
+package registry
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/David-solly/mxbcode/pkg/cache"
+	"github.com/David-solly/mxbcode/pkg/models"
+
+	"github.com/docker/docker/pkg/testutil/assert"
+)
+
+func TestList(t *testing.T) {
+	c := cache.Cache{}
+	c.Initialise("", false)
+
+	day := func(d int) time.Time { return time.Date(2026, 10, d, 12, 0, 0, 0, time.UTC) }
+	assert.NilError(t, Record(c.Client, models.Device{DevEUI: "000000000000a0a1", ShortCode: "0a0a1", Batch: "b1", Registered: day(1)}))
+	assert.NilError(t, Record(c.Client, models.Device{DevEUI: "000000000000A0A2", ShortCode: "0A0A2", Batch: "b1", Registered: day(2)}))
+	assert.NilError(t, Record(c.Client, models.Device{DevEUI: "000000000000A0A3", ShortCode: "0A0A3", Batch: "b2", Registered: day(3)}))
+
+	// stored before records were kept
+	c.Client.StoreDUID(models.DevEUI{DevEUI: "000000000000A0A0", ShortCode: "0A0A0"})
+
+	suite := []struct {
+		testName string
+		filter   Filter
+		want     []string
+	}{
+		{"LIST - every device", Filter{}, []string{"0A0A0", "0A0A1", "0A0A2", "0A0A3"}},
+		{"LIST - batch", Filter{Batch: "B1"}, []string{"0A0A1", "0A0A2"}},
+		{"LIST - since", Filter{Since: day(2)}, []string{"0A0A2", "0A0A3"}},
+		{"LIST - until is exclusive", Filter{Until: day(2)}, []string{"0A0A1"}},
+		{"LIST - batch and window", Filter{Batch: "b1", Since: day(2), Until: day(3)}, []string{"0A0A2"}},
+		{"LIST - nothing", Filter{Batch: "b3"}, []string{}},
+	}
+	for i, test := range suite {
+		t.Run(fmt.Sprintf("#%d: %q", i, test.testName), func(t *testing.T) {
+			list, err := List(c.Client, test.filter)
+			assert.NilError(t, err)
+			got := []string{}
+			for _, d := range list {
+				got = append(got, d.ShortCode)
+			}
+			assert.DeepEqual(t, got, test.want)
+		})
+	}
+
+	d, err := Get(c.Client, "000000000000a0a1")
+	assert.NilError(t, err)
+	assert.Equal(t, d.DevEUI, "000000000000A0A1")
+	assert.Equal(t, d.Registered.Equal(day(1)), true)
+	d, _ = Get(c.Client, "000000000000A0A0")
+	assert.Equal(t, d == nil, true)
+}
+
+func TestParseTime(t *testing.T) {
+	tm, err := ParseTime("2026-10-19")
+	assert.NilError(t, err)
+	assert.Equal(t, tm.Equal(time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)), true)
+
+	tm, err = ParseTime("2026-10-19T13:00:00+01:00")
+	assert.NilError(t, err)
+	assert.Equal(t, tm.Equal(time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)), true)
+
+	tm, err = ParseTime("")
+	assert.NilError(t, err)
+	assert.Equal(t, tm.IsZero(), true)
+
+	_, err = ParseTime("yesterday")
+	assert.Error(t, err, "invalid time")
+}
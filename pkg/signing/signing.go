@@ -0,0 +1,289 @@
+package signing
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// headers of HMAC signed requests
+const (
+	HeaderKeyID     = "X-Signature-Key-Id"
+	HeaderTimestamp = "X-Signature-Timestamp"
+	HeaderSignature = "X-Signature"
+)
+
+// DefaultSkew : how far the timestamp of a signed request may be from the clock
+const DefaultSkew = 5 * time.Minute
+
+// DefaultHeader : the header an API key is sent in
+const DefaultHeader = "X-API-Key"
+
+// Signer :
+// Adds credentials to an outbound request. The body is
+// passed separately as the request body may only be read once
+type Signer interface {
+	Sign(r *http.Request, body []byte) error
+}
+
+// Verifier :
+// Checks the credentials of an inbound request
+type Verifier interface {
+	Verify(r *http.Request, body []byte) error
+}
+
+// ErrUnsigned : the request carries no credentials
+var ErrUnsigned = errors.New("request is not signed")
+
+// HMAC :
+// Signs with HMAC-SHA256 over the method, path, timestamp and body
+// of the request. The hex signature and the unix timestamp are sent
+// in headers alongside `KeyID` naming the secret when it is set
+type HMAC struct {
+	KeyID  string
+	Secret []byte
+	Skew   time.Duration
+	Now    func() time.Time
+}
+
+// NewHMAC : an HMAC signer allowing DefaultSkew
+func NewHMAC(keyID string, secret []byte) *HMAC {
+	return &HMAC{KeyID: keyID, Secret: secret, Skew: DefaultSkew, Now: time.Now}
+}
+
+// the signature of a request made at timestamp
+func (h *HMAC) signature(method, path, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, h.Secret)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n", strings.ToUpper(method), path, timestamp)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sign : adds the timestamp and signature headers
+func (h *HMAC) Sign(r *http.Request, body []byte) error {
+	timestamp := strconv.FormatInt(h.Now().Unix(), 10)
+	if h.KeyID != "" {
+		r.Header.Set(HeaderKeyID, h.KeyID)
+	}
+	r.Header.Set(HeaderTimestamp, timestamp)
+	r.Header.Set(HeaderSignature, h.signature(r.Method, r.URL.RequestURI(), timestamp, body))
+	return nil
+}
+
+// Verify : checks the signature and that the timestamp is recent
+func (h *HMAC) Verify(r *http.Request, body []byte) error {
+	timestamp, signature := r.Header.Get(HeaderTimestamp), r.Header.Get(HeaderSignature)
+	if timestamp == "" || signature == "" {
+		return ErrUnsigned
+	}
+	if h.KeyID != "" && r.Header.Get(HeaderKeyID) != h.KeyID {
+		return fmt.Errorf("unknown signing key %q", r.Header.Get(HeaderKeyID))
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid signature timestamp %q", timestamp)
+	}
+	if skew := h.Now().Sub(time.Unix(seconds, 0)); skew > h.Skew || skew < -h.Skew {
+		return fmt.Errorf("signature timestamp is %v from the clock", skew)
+	}
+
+	want := h.signature(r.Method, r.URL.RequestURI(), timestamp, body)
+	if !hmac.Equal([]byte(signature), []byte(want)) {
+		return errors.New("signature does not match")
+	}
+	return nil
+}
+
+// Bearer :
+// Sends a token in `Authorization: Bearer`
+type Bearer struct {
+	Token string
+}
+
+// Sign : adds the authorization header
+func (b *Bearer) Sign(r *http.Request, body []byte) error {
+	r.Header.Set("Authorization", "Bearer "+b.Token)
+	return nil
+}
+
+// Verify : checks the token
+func (b *Bearer) Verify(r *http.Request, body []byte) error {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return ErrUnsigned
+	}
+	if subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(b.Token)) != 1 {
+		return errors.New("bearer token does not match")
+	}
+	return nil
+}
+
+// APIKey :
+// Sends a key in `Header` - DefaultHeader when blank
+type APIKey struct {
+	Header string
+	Key    string
+}
+
+func (a *APIKey) header() string {
+	if a.Header == "" {
+		return DefaultHeader
+	}
+	return a.Header
+}
+
+// Sign : adds the key header
+func (a *APIKey) Sign(r *http.Request, body []byte) error {
+	r.Header.Set(a.header(), a.Key)
+	return nil
+}
+
+// Verify : checks the key
+func (a *APIKey) Verify(r *http.Request, body []byte) error {
+	key := r.Header.Get(a.header())
+	if key == "" {
+		return ErrUnsigned
+	}
+	if subtle.ConstantTimeCompare([]byte(key), []byte(a.Key)) != 1 {
+		return errors.New("API key does not match")
+	}
+	return nil
+}
+
+// Config :
+// How requests are signed. The secret - an HMAC secret, bearer
+// token or API key - is given inline or read from `SecretFile`
+type Config struct {
+	Type       string `json:"type"`
+	KeyID      string `json:"key_id,omitempty"`
+	Secret     string `json:"secret,omitempty"`
+	SecretFile string `json:"secret_file,omitempty"`
+	Header     string `json:"header,omitempty"`
+}
+
+// Factory : builds a signer from its config and resolved secret
+type Factory func(cfg Config, secret []byte) (Signer, error)
+
+var (
+	mutex     sync.RWMutex
+	factories = map[string]Factory{
+		"hmac": func(cfg Config, secret []byte) (Signer, error) {
+			return NewHMAC(cfg.KeyID, secret), nil
+		},
+		"bearer": func(cfg Config, secret []byte) (Signer, error) {
+			return &Bearer{Token: string(secret)}, nil
+		},
+		"api-key": func(cfg Config, secret []byte) (Signer, error) {
+			return &APIKey{Header: cfg.Header, Key: string(secret)}, nil
+		},
+	}
+)
+
+// Register :
+// Adds a signer type - replacing any of the same name
+func Register(name string, f Factory) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	factories[name] = f
+}
+
+// Types : the signer types registered - sorted
+func Types() []string {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	names := []string{}
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New :
+// The signer of a config
+func New(cfg Config) (Signer, error) {
+	mutex.RLock()
+	f, k := factories[cfg.Type]
+	mutex.RUnlock()
+	if !k {
+		return nil, fmt.Errorf("unknown signing type %q - expected one of %s", cfg.Type, strings.Join(Types(), ", "))
+	}
+
+	secret := []byte(cfg.Secret)
+	switch {
+	case cfg.Secret != "" && cfg.SecretFile != "":
+		return nil, errors.New("give the signing secret inline or in a file - not both")
+	case cfg.SecretFile != "":
+		data, err := ioutil.ReadFile(cfg.SecretFile)
+		if err != nil {
+			return nil, err
+		}
+		secret = bytes.TrimSpace(data)
+	}
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("%s signing needs a secret", cfg.Type)
+	}
+
+	return f(cfg, secret)
+}
+
+// NewVerifier :
+// The verifier matching the signer of a config
+func NewVerifier(cfg Config) (Verifier, error) {
+	s, err := New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	v, k := s.(Verifier)
+	if !k {
+		return nil, fmt.Errorf("%s signatures can't be verified", cfg.Type)
+	}
+	return v, nil
+}
+
+// Transport :
+// Signs every request before sending it with `Base`
+type Transport struct {
+	Base   http.RoundTripper
+	Signer Signer
+}
+
+// RoundTrip : signs a copy of the request and sends it
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	signed := r.Clone(r.Context())
+	body := []byte{}
+	if r.Body != nil && r.Body != http.NoBody {
+		data, err := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = data
+		signed.Body = ioutil.NopCloser(bytes.NewReader(body))
+		signed.GetBody = func() (io.ReadCloser, error) { return ioutil.NopCloser(bytes.NewReader(body)), nil }
+		signed.ContentLength = int64(len(body))
+	}
+
+	if err := t.Signer.Sign(signed, body); err != nil {
+		return nil, err
+	}
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(signed)
+}
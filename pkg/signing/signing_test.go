@@ -0,0 +1,190 @@
+package signing
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/pkg/testutil/assert"
+)
+
+const body = `{"deveui":"FFFF1"}`
+
+func request() *http.Request {
+	return httptest.NewRequest("POST", "http://registrar.example/sensor-onboarding-sample?x=1", strings.NewReader(body))
+}
+
+func TestHMAC(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	clock := func() time.Time { return now }
+	signer := &HMAC{KeyID: "k1", Secret: []byte("secret"), Now: clock}
+
+	suite := []struct {
+		testName string
+		verifier *HMAC
+		change   func(r *http.Request) []byte
+		err      string
+	}{
+		{"HMAC - valid", &HMAC{KeyID: "k1", Secret: []byte("secret"), Skew: time.Minute, Now: clock}, nil, ""},
+		{"HMAC - any key id", &HMAC{Secret: []byte("secret"), Skew: time.Minute, Now: clock}, nil, ""},
+		{"HMAC - wrong secret", &HMAC{Secret: []byte("other"), Skew: time.Minute, Now: clock}, nil, "does not match"},
+		{"HMAC - wrong key id", &HMAC{KeyID: "k2", Secret: []byte("secret"), Skew: time.Minute, Now: clock}, nil, `unknown signing key "k1"`},
+		{"HMAC - tampered body", &HMAC{Secret: []byte("secret"), Skew: time.Minute, Now: clock},
+			func(r *http.Request) []byte { return []byte(`{"deveui":"FFFF2"}`) }, "does not match"},
+		{"HMAC - tampered path", &HMAC{Secret: []byte("secret"), Skew: time.Minute, Now: clock},
+			func(r *http.Request) []byte { r.URL.Path = "/other"; return []byte(body) }, "does not match"},
+		{"HMAC - tampered method", &HMAC{Secret: []byte("secret"), Skew: time.Minute, Now: clock},
+			func(r *http.Request) []byte { r.Method = "PUT"; return []byte(body) }, "does not match"},
+		{"HMAC - stale", &HMAC{Secret: []byte("secret"), Skew: time.Minute, Now: func() time.Time { return now.Add(2 * time.Minute) }},
+			nil, "from the clock"},
+		{"HMAC - unsigned", &HMAC{Secret: []byte("secret"), Skew: time.Minute, Now: clock},
+			func(r *http.Request) []byte { r.Header.Del(HeaderSignature); return []byte(body) }, ErrUnsigned.Error()},
+	}
+	for i, test := range suite {
+		t.Run(fmt.Sprintf("#%d: %q", i, test.testName), func(t *testing.T) {
+			r := request()
+			assert.NilError(t, signer.Sign(r, []byte(body)))
+			assert.Equal(t, r.Header.Get(HeaderKeyID), "k1")
+			assert.Equal(t, r.Header.Get(HeaderTimestamp), "1600000000")
+
+			received := []byte(body)
+			if test.change != nil {
+				received = test.change(r)
+			}
+			err := test.verifier.Verify(r, received)
+			if test.err != "" {
+				assert.Error(t, err, test.err)
+			} else {
+				assert.NilError(t, err)
+			}
+		})
+	}
+}
+
+func TestTokens(t *testing.T) {
+	suite := []struct {
+		testName string
+		signer   Signer
+		verifier Verifier
+		header   string
+		err      string
+	}{
+		{"BEARER - valid", &Bearer{Token: "t1"}, &Bearer{Token: "t1"}, "Authorization", ""},
+		{"BEARER - wrong token", &Bearer{Token: "t1"}, &Bearer{Token: "t2"}, "Authorization", "does not match"},
+		{"API KEY - valid", &APIKey{Key: "k1"}, &APIKey{Key: "k1"}, DefaultHeader, ""},
+		{"API KEY - custom header", &APIKey{Header: "X-Registrar-Key", Key: "k1"}, &APIKey{Header: "X-Registrar-Key", Key: "k1"}, "X-Registrar-Key", ""},
+		{"API KEY - wrong header", &APIKey{Key: "k1"}, &APIKey{Header: "X-Registrar-Key", Key: "k1"}, DefaultHeader, ErrUnsigned.Error()},
+		{"API KEY - wrong key", &APIKey{Key: "k1"}, &APIKey{Key: "k2"}, DefaultHeader, "does not match"},
+	}
+	for i, test := range suite {
+		t.Run(fmt.Sprintf("#%d: %q", i, test.testName), func(t *testing.T) {
+			r := request()
+			assert.NilError(t, test.signer.Sign(r, []byte(body)))
+			assert.Equal(t, r.Header.Get(test.header) != "", true)
+			err := test.verifier.Verify(r, []byte(body))
+			if test.err != "" {
+				assert.Error(t, err, test.err)
+			} else {
+				assert.NilError(t, err)
+			}
+		})
+	}
+}
+
+type static string
+
+func (s static) Sign(r *http.Request, body []byte) error {
+	r.Header.Set("X-Static", string(s))
+	return nil
+}
+
+func TestNew(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "signing")
+	defer os.RemoveAll(dir)
+	secretFile := filepath.Join(dir, "secret")
+	ioutil.WriteFile(secretFile, []byte("from-file\n"), 0600)
+
+	suite := []struct {
+		testName string
+		cfg      Config
+		err      string
+	}{
+		{"NEW - inline secret", Config{Type: "hmac", Secret: "s"}, ""},
+		{"NEW - secret file", Config{Type: "bearer", SecretFile: secretFile}, ""},
+		{"NEW - api key", Config{Type: "api-key", Secret: "s", Header: "X-Key"}, ""},
+		{"NEW - unknown type", Config{Type: "basic", Secret: "s"}, `unknown signing type "basic"`},
+		{"NEW - no secret", Config{Type: "hmac"}, "needs a secret"},
+		{"NEW - both secrets", Config{Type: "hmac", Secret: "s", SecretFile: secretFile}, "not both"},
+		{"NEW - missing file", Config{Type: "hmac", SecretFile: filepath.Join(dir, "missing")}, "no such file"},
+	}
+	for i, test := range suite {
+		t.Run(fmt.Sprintf("#%d: %q", i, test.testName), func(t *testing.T) {
+			_, err := New(test.cfg)
+			if test.err != "" {
+				assert.Error(t, err, test.err)
+			} else {
+				assert.NilError(t, err)
+			}
+		})
+	}
+
+	t.Run("NEW - secret file is trimmed", func(t *testing.T) {
+		s, err := New(Config{Type: "bearer", SecretFile: secretFile})
+		assert.NilError(t, err)
+		assert.Equal(t, s.(*Bearer).Token, "from-file")
+	})
+
+	t.Run("NEW - registered type", func(t *testing.T) {
+		Register("static", func(cfg Config, secret []byte) (Signer, error) { return static(secret), nil })
+		defer func() {
+			mutex.Lock()
+			delete(factories, "static")
+			mutex.Unlock()
+		}()
+		assert.DeepEqual(t, Types(), []string{"api-key", "bearer", "hmac", "static"})
+
+		s, err := New(Config{Type: "static", Secret: "s"})
+		assert.NilError(t, err)
+		assert.Equal(t, s.(static), static("s"))
+		_, err = NewVerifier(Config{Type: "static", Secret: "s"})
+		assert.Error(t, err, "can't be verified")
+	})
+}
+
+func TestTransport(t *testing.T) {
+	verifier := NewHMAC("k1", []byte("secret"))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, _ := ioutil.ReadAll(r.Body)
+		if err := verifier.Verify(r, p); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		w.Write(p)
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: &Transport{Signer: NewHMAC("k1", []byte("secret"))}}
+	resp, err := client.Post(srv.URL+"/sensor-onboarding-sample", "application/json", strings.NewReader(body))
+	assert.NilError(t, err)
+	p, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, resp.StatusCode, 200)
+	assert.Equal(t, string(p), body)
+
+	client = &http.Client{Transport: &Transport{Signer: NewHMAC("k1", []byte("other"))}}
+	resp, err = client.Post(srv.URL+"/sensor-onboarding-sample", "application/json", strings.NewReader(body))
+	assert.NilError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, resp.StatusCode, 401)
+
+	resp, err = http.Post(srv.URL, "application/json", strings.NewReader(body))
+	assert.NilError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, resp.StatusCode, 401)
+}
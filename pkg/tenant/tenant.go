@@ -0,0 +1,355 @@
+package tenant
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/David-solly/mxbcode/pkg/cache"
+	"github.com/David-solly/mxbcode/pkg/generator"
+	"github.com/David-solly/mxbcode/pkg/models"
+)
+
+// KeyPrefix :
+// Prefix of tenant records in the shared store
+// records are keyed `TENANT:<NAME>`
+const KeyPrefix = "TENANT:"
+
+// APIKeyPrefix :
+// Prefix of the hashed API keys identifying a tenant
+// keyed `TENANT-KEY:<SHA256>` - the plain key is never stored
+const APIKeyPrefix = "TENANT-KEY:"
+
+// NamespacePrefix :
+// Prefix of every record belonging to a tenant
+// `T:<NAME>:` followed by the usual key
+const NamespacePrefix = "T:"
+
+// BlockOwnerPrefix :
+// Owner of the block of the shared shortcode space each tenant draws from
+// `tenant-<NAME>` - admins can't allocate or generate from these blocks
+const BlockOwnerPrefix = "tenant-"
+
+// DefaultBlockSize :
+// Shortcodes allocated to a tenant without a quota
+const DefaultBlockSize = 0x4000
+
+// DevicesKey :
+// Counter of the devices a tenant registered or is generating
+// kept in its namespace so the quota is checked without listing them
+const DevicesKey = "DEVICES"
+
+// how many times the counter is swapped while other requests race for it
+const maxAttempts = 20
+
+// ErrContended : the device counter kept changing under every attempt
+var ErrContended = errors.New("tenant device counter is contended - try again")
+
+var validName = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+// device records are keyed by their 5 digit shortcode
+var deviceKey = regexp.MustCompile(`^[0-9A-F]{5}$`)
+
+// Create :
+// Adds a tenant with a block of the shared shortcode space sized to its quota
+// so its DevEUIs never collide with other tenants or the global counter.
+// A tenant created again carries on through the block it held.
+// The API key identifying the tenant is returned once and only its hash is kept
+func Create(c cache.Service, name string, quota int64) (models.Tenant, string, error) {
+	name = strings.ToLower(name)
+	if !validName.MatchString(name) {
+		return models.Tenant{}, "", fmt.Errorf("invalid tenant name %q", name)
+	}
+	if quota < 0 {
+		return models.Tenant{}, "", fmt.Errorf("invalid quota %d", quota)
+	}
+	if t, err := Get(c, name); err != nil || t != nil {
+		if err != nil {
+			return models.Tenant{}, "", err
+		}
+		return models.Tenant{}, "", fmt.Errorf("tenant %q already exists", name)
+	}
+
+	key, err := NewAPIKey()
+	if err != nil {
+		return models.Tenant{}, "", err
+	}
+
+	b, err := Block(c, name)
+	if err != nil {
+		return models.Tenant{}, "", err
+	}
+	if b == nil {
+		size := quota
+		if size == 0 {
+			size = DefaultBlockSize
+		}
+		if _, err := generator.AllocateBlock(c, BlockOwnerPrefix+name, "", "", size); err != nil {
+			return models.Tenant{}, "", err
+		}
+	}
+
+	t := models.Tenant{Name: name, Quota: quota, Created: time.Now().UTC()}
+	data, _ := json.Marshal(t)
+	if _, err := c.StoreValue(APIKeyPrefix+HashAPIKey(key), name); err != nil {
+		return models.Tenant{}, "", err
+	}
+	if _, err := c.StoreValue(KeyPrefix+name, string(data)); err != nil {
+		return models.Tenant{}, "", err
+	}
+
+	return t, key, nil
+}
+
+// Get :
+// The named tenant - nil if it does not exist
+func Get(c cache.Service, name string) (*models.Tenant, error) {
+	data, found, _ := c.ReadCache(KeyPrefix + strings.ToLower(name))
+	if !found {
+		return nil, nil
+	}
+	t := models.Tenant{}
+	if err := json.Unmarshal([]byte(data), &t); err != nil {
+		return nil, fmt.Errorf("corrupt tenant %q - %v", name, err)
+	}
+	return &t, nil
+}
+
+// List :
+// Every tenant ordered by name
+func List(c cache.Service) ([]models.Tenant, error) {
+	keys, err := c.ListKeys(KeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	list := []models.Tenant{}
+	for _, k := range keys {
+		t, err := Get(c, strings.TrimPrefix(k, KeyPrefix))
+		if err != nil {
+			return nil, err
+		}
+		if t != nil {
+			list = append(list, *t)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	return list, nil
+}
+
+// Delete :
+// Removes the tenant, its API keys and every record in its namespace.
+// Its block stays allocated - the shortcodes in it were already issued
+func Delete(c cache.Service, name string) error {
+	name = strings.ToLower(name)
+	t, err := Get(c, name)
+	if err != nil {
+		return err
+	}
+	if t == nil {
+		return fmt.Errorf("tenant %q does not exist", name)
+	}
+
+	keys, err := c.ListKeys(APIKeyPrefix)
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if owner, _, _ := c.ReadCache(k); owner == name {
+			c.DeleteValue(k)
+		}
+	}
+
+	if err := Namespace(c, name).Purge(); err != nil {
+		return err
+	}
+	_, err = c.DeleteValue(KeyPrefix + name)
+	return err
+}
+
+// ForAPIKey :
+// The tenant identified by an API key - nil if the key is unknown
+func ForAPIKey(c cache.Service, key string) (*models.Tenant, error) {
+	name, found, _ := c.ReadCache(APIKeyPrefix + HashAPIKey(key))
+	if !found {
+		return nil, nil
+	}
+	return Get(c, name)
+}
+
+// DeviceCount :
+// Number of devices registered in the tenants namespace - listed one by one.
+// Shortcodes refused by the provider do not count towards the quota
+func DeviceCount(c cache.Service, name string) (int64, error) {
+	keys, err := Namespace(c, name).ListKeys("")
+	if err != nil {
+		return 0, err
+	}
+	n := int64(0)
+	for _, k := range keys {
+		if deviceKey.MatchString(k) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// ReserveDevices :
+// Takes up to n devices from what is left of the tenants quota - 0 once it is used up.
+// Tenants without a quota are granted n and counted all the same.
+// Devices that aren't registered are given back with ReleaseDevices
+func ReserveDevices(c cache.Service, t models.Tenant, n int64) (int64, error) {
+	var granted int64
+	err := updateDevices(c, t.Name, func(devices int64) int64 {
+		granted = n
+		if t.Quota > 0 && t.Quota-devices < granted {
+			granted = t.Quota - devices
+		}
+		if granted < 0 {
+			granted = 0
+		}
+		return devices + granted
+	})
+	if err != nil {
+		return 0, err
+	}
+	return granted, nil
+}
+
+// ReleaseDevices :
+// Gives back devices reserved but not registered
+func ReleaseDevices(c cache.Service, name string, n int64) error {
+	if n <= 0 {
+		return nil
+	}
+	return updateDevices(c, name, func(devices int64) int64 {
+		if devices < n {
+			return 0
+		}
+		return devices - n
+	})
+}
+
+// swaps in the count returned by apply for the devices of the tenant.
+// A missing counter - a new tenant or a store that doesn't keep
+// swapped values across restarts - starts from the devices registered
+func updateDevices(c cache.Service, name string, apply func(devices int64) int64) error {
+	ns := Namespace(c, name)
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		old, found, _ := ns.ReadCache(DevicesKey)
+		var devices int64
+		if found {
+			n, err := strconv.ParseInt(old, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid device counter of tenant %q - %v", name, err)
+			}
+			devices = n
+		} else {
+			n, err := DeviceCount(c, name)
+			if err != nil {
+				return err
+			}
+			old, devices = "", n
+		}
+
+		next := apply(devices)
+		if found && next == devices {
+			return nil
+		}
+		ok, err := ns.SwapValue(DevicesKey, old, strconv.FormatInt(next, 10), 0)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+	}
+	return ErrContended
+}
+
+// Block :
+// The block of the shared shortcode space the named tenant draws from
+// nil if it has none
+func Block(c cache.Service, name string) (*models.Block, error) {
+	return generator.BlockFor(c, BlockOwnerPrefix+strings.ToLower(name))
+}
+
+// Batch :
+// A BatchFunc drawing from the block of the named tenant in the shared
+// store c - the devices are kept in whichever store the batch is for
+func Batch(c cache.Service, name string) generator.BatchFunc {
+	owner := BlockOwnerPrefix + strings.ToLower(name)
+	return func(count int, _ cache.Service) (*[]*models.DevEUI, int, error) {
+		return generator.GenerateBlockBatch(count, c, owner)
+	}
+}
+
+// OwnsBlock :
+// Whether the block owner is a tenant - only the tenant generates from it
+func OwnsBlock(owner string) bool {
+	return strings.HasPrefix(strings.ToLower(owner), BlockOwnerPrefix)
+}
+
+// Namespace :
+// The records of the named tenant within the shared store
+func Namespace(c cache.Service, name string) *cache.Namespace {
+	return cache.NewNamespace(c, NamespacePrefix+strings.ToLower(name)+":")
+}
+
+// NameOf :
+// The tenant whose namespace s is - blank for the shared store
+func NameOf(s cache.Service) string {
+	switch c := s.(type) {
+	case *cache.Observed:
+		if c.Tenant != "" {
+			return c.Tenant
+		}
+		return NameOf(c.Client)
+	case *cache.Namespace:
+		if strings.HasPrefix(c.Prefix, NamespacePrefix) {
+			return strings.ToLower(strings.TrimSuffix(strings.TrimPrefix(c.Prefix, NamespacePrefix), ":"))
+		}
+	}
+	return ""
+}
+
+// Shared :
+// The store beneath a tenants namespace - where counters and blocks live.
+// Any other store is returned as is
+func Shared(s cache.Service) cache.Service {
+	switch c := s.(type) {
+	case *cache.Observed:
+		if inner := Shared(c.Client); inner != c.Client {
+			return inner
+		}
+	case *cache.Namespace:
+		if strings.HasPrefix(c.Prefix, NamespacePrefix) {
+			return c.Client
+		}
+	}
+	return s
+}
+
+// NewAPIKey : 32 random bytes hex encoded
+func NewAPIKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// HashAPIKey : the form in which API keys are stored
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
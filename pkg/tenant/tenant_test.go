@@ -0,0 +1,206 @@
+package tenant
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/David-solly/mxbcode/pkg/cache"
+	"github.com/David-solly/mxbcode/pkg/generator"
+	"github.com/David-solly/mxbcode/pkg/models"
+
+	"github.com/docker/docker/pkg/testutil/assert"
+)
+
+func TestTenants(t *testing.T) {
+	c := cache.Cache{}
+	c.Initialise("", false)
+
+	keys := map[string]string{}
+	t.Run("CREATE tenants", func(t *testing.T) {
+		suite := []struct {
+			testName string
+			name     string
+			quota    int64
+			err      string
+		}{
+			{"CREATE - ", "acme", 0, ""},
+			{"CREATE - upper case", "Beta", 500, ""},
+			{"CREATE - duplicate", "ACME", 0, "tenant \"acme\" already exists"},
+			{"CREATE - invalid", "a b", 0, "invalid tenant name"},
+			{"CREATE - quota", "gamma", -1, "invalid quota"},
+		}
+		for i, test := range suite {
+			t.Run(fmt.Sprintf("#%d: %q", i, test.testName), func(t *testing.T) {
+				tn, key, err := Create(c.Client, test.name, test.quota)
+				if test.err != "" {
+					assert.Error(t, err, test.err)
+					return
+				}
+				assert.NilError(t, err)
+				assert.Equal(t, len(key), 64)
+				assert.Equal(t, tn.Quota, test.quota)
+				keys[tn.Name] = key
+
+				_, found, _ := c.Client.ReadCache(APIKeyPrefix + key)
+				assert.Equal(t, found, false)
+			})
+		}
+
+		list, err := List(c.Client)
+		assert.NilError(t, err)
+		assert.Equal(t, len(list), 2)
+		assert.Equal(t, list[1].Name, "beta")
+	})
+
+	t.Run("IDENTIFY tenants by API key", func(t *testing.T) {
+		tn, err := ForAPIKey(c.Client, keys["beta"])
+		assert.NilError(t, err)
+		assert.Equal(t, tn.Name, "beta")
+
+		tn, err = ForAPIKey(c.Client, "not-a-key")
+		assert.NilError(t, err)
+		assert.Equal(t, tn == nil, true)
+	})
+
+	t.Run("BLOCKS are disjoint and namespaces separate", func(t *testing.T) {
+		acme, err := Block(c.Client, "acme")
+		assert.NilError(t, err)
+		assert.Equal(t, acme.Remaining, int64(DefaultBlockSize))
+		beta, _ := Block(c.Client, "BETA")
+		assert.Equal(t, beta.Owner, BlockOwnerPrefix+"beta")
+		assert.Equal(t, beta.To < acme.From, true)
+
+		// tenants draw from their block and the global counter steps over it
+		ids, _, err := Batch(c.Client, "beta")(3, Namespace(c.Client, "beta"))
+		assert.NilError(t, err)
+		assert.Equal(t, strings.ToUpper((*ids)[0].ShortCode), beta.From)
+		global, _, _ := generator.GenerateDUIDBatch(1, c.Client)
+		assert.Equal(t, (*global)[0].ShortCode < beta.From, true)
+
+		Namespace(c.Client, "acme").StoreDUID(models.DevEUI{DevEUI: "aaaaaaaaaaa00001", ShortCode: "00001"})
+		_, found, _ := Namespace(c.Client, "beta").ReadCache("00001")
+		assert.Equal(t, found, false)
+	})
+
+	t.Run("DELETE tenants", func(t *testing.T) {
+		assert.NilError(t, Delete(c.Client, "acme"))
+		assert.Error(t, Delete(c.Client, "acme"), "does not exist")
+
+		tn, _ := ForAPIKey(c.Client, keys["acme"])
+		assert.Equal(t, tn == nil, true)
+		leftover, _ := c.Client.ListKeys(NamespacePrefix + "acme:")
+		assert.Equal(t, len(leftover), 0)
+
+		// the block outlives the tenant and is carried on when it is created again
+		b, _ := Block(c.Client, "acme")
+		assert.Equal(t, b != nil, true)
+		_, _, err := Create(c.Client, "acme", 0)
+		assert.NilError(t, err)
+		again, _ := Block(c.Client, "acme")
+		assert.Equal(t, again.From, b.From)
+		Delete(c.Client, "acme")
+		Delete(c.Client, "beta")
+	})
+}
+
+func TestDeviceCount(t *testing.T) {
+	c := cache.Cache{}
+	c.Initialise("", false)
+	Create(c.Client, "acme", 10)
+	defer Delete(c.Client, "acme")
+
+	ns := Namespace(c.Client, "acme")
+	ns.StoreLastDUID(models.LastDevEUI{ShortCode: "00010"})
+	ns.StoreDUID(models.DevEUI{DevEUI: "aaaaaaaaaaa00003", ShortCode: "00003"})
+	ns.StoreDUID(models.DevEUI{DevEUI: "aaaaaaaaaaa00007", ShortCode: "00007"})
+	ns.StoreValue("RESERVED:00020:00020", "{}")
+
+	n, err := DeviceCount(c.Client, "acme")
+	assert.NilError(t, err)
+	assert.Equal(t, n, int64(2))
+}
+
+func TestReserveDevices(t *testing.T) {
+	c := cache.Cache{}
+	c.Initialise("", false)
+	tn, _, _ := Create(c.Client, "acme", 10)
+	defer Delete(c.Client, "acme")
+
+	// the counter starts from the devices already registered
+	ns := Namespace(c.Client, "acme")
+	ns.StoreDUID(models.DevEUI{DevEUI: "aaaaaaaaaaa00003", ShortCode: "00003"})
+	ns.StoreDUID(models.DevEUI{DevEUI: "aaaaaaaaaaa00007", ShortCode: "00007"})
+
+	suite := []struct {
+		testName string
+		reserve  int64
+		release  int64
+		want     int64
+		devices  string
+	}{
+		{"RESERVE - within the quota", 5, 0, 5, "7"},
+		{"RESERVE - trimmed to what is left", 5, 0, 3, "10"},
+		{"RESERVE - used up", 1, 0, 0, "10"},
+		{"RESERVE - after a release", 4, 2, 2, "10"},
+	}
+	for i, test := range suite {
+		t.Run(fmt.Sprintf("#%d: %q", i, test.testName), func(t *testing.T) {
+			assert.NilError(t, ReleaseDevices(c.Client, "acme", test.release))
+			granted, err := ReserveDevices(c.Client, tn, test.reserve)
+			assert.NilError(t, err)
+			assert.Equal(t, granted, test.want)
+			devices, _, _ := ns.ReadCache(DevicesKey)
+			assert.Equal(t, devices, test.devices)
+		})
+	}
+}
+
+func TestReserveDevicesConcurrently(t *testing.T) {
+	c := cache.Cache{}
+	c.Initialise("", false)
+	tn, _, _ := Create(c.Client, "acme", 25)
+	defer Delete(c.Client, "acme")
+
+	var total int64
+	m := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			granted, err := ReserveDevices(c.Client, tn, 3)
+			if err != nil {
+				return
+			}
+			m.Lock()
+			total += granted
+			m.Unlock()
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, total, int64(25))
+}
+
+func TestNameOf(t *testing.T) {
+	c := cache.Cache{}
+	c.Initialise("", false)
+
+	suite := []struct {
+		testName string
+		store    cache.Service
+		want     string
+	}{
+		{"NAME - shared store", c.Client, ""},
+		{"NAME - namespace", Namespace(c.Client, "Acme"), "acme"},
+		{"NAME - observed namespace", cache.Observe(Namespace(c.Client, "acme"), "", nil), "acme"},
+		{"NAME - observed with tenant", cache.Observe(c.Client, "globex", nil), "globex"},
+		{"NAME - other namespace", cache.NewNamespace(c.Client, "pool:"), ""},
+	}
+	for i, test := range suite {
+		t.Run(fmt.Sprintf("#%d: %q", i, test.testName), func(t *testing.T) {
+			assert.Equal(t, NameOf(test.store), test.want)
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package tr005
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Prefix :
+// Start of a LoRa Alliance TR005 onboarding payload
+// `LW` schema, `D0` version 0 of the device onboarding format
+const Prefix = "LW:D0:"
+
+var (
+	validEUI     = regexp.MustCompile(`^[0-9A-F]{16}$`)
+	validProfile = regexp.MustCompile(`^[0-9A-F]{8}$`)
+
+	// optional fields share the alphanumeric QR character set
+	// so payloads stay in the compact mode
+	validField = regexp.MustCompile(`^[0-9A-Z $%*+\-./]*$`)
+)
+
+// Payload :
+// The fields of a TR005 QR code.
+// ProfileID is the 4 hex digit VendorID followed by the 4 hex digit
+// VendorProfileID. Optional fields are left out of the payload when blank
+type Payload struct {
+	JoinEUI      string `json:"joineui"`
+	DevEUI       string `json:"deveui"`
+	ProfileID    string `json:"profile_id"`
+	OwnerToken   string `json:"owner_token,omitempty"`
+	SerialNumber string `json:"serial,omitempty"`
+	Proprietary  string `json:"proprietary,omitempty"`
+}
+
+// Validate : checks every field can be carried by the payload
+func (p Payload) Validate() error {
+	if !validEUI.MatchString(p.JoinEUI) {
+		return fmt.Errorf("invalid JoinEUI %q", p.JoinEUI)
+	}
+	if !validEUI.MatchString(p.DevEUI) {
+		return fmt.Errorf("invalid DevEUI %q", p.DevEUI)
+	}
+	if !validProfile.MatchString(p.ProfileID) {
+		return fmt.Errorf("invalid ProfileID %q - VendorID and VendorProfileID as 8 hex digits", p.ProfileID)
+	}
+	for name, v := range map[string]string{"owner token": p.OwnerToken, "serial": p.SerialNumber, "proprietary": p.Proprietary} {
+		if !validField.MatchString(v) {
+			return fmt.Errorf("invalid %s %q", name, v)
+		}
+	}
+	return nil
+}
+
+// New :
+// A payload for the device with hex fields and the serial upper cased
+func New(joinEUI, devEUI, profileID, serial string) (Payload, error) {
+	p := Payload{
+		JoinEUI:      strings.ToUpper(joinEUI),
+		DevEUI:       strings.ToUpper(devEUI),
+		ProfileID:    strings.ToUpper(profileID),
+		SerialNumber: strings.ToUpper(serial),
+	}
+	return p, p.Validate()
+}
+
+// String :
+// The payload text - `LW:D0:<JoinEUI>:<DevEUI>:<ProfileID>`
+// followed by `:O<owner token>`, `:S<serial>` and `:P<proprietary>` when set
+func (p Payload) String() string {
+	s := Prefix + p.JoinEUI + ":" + p.DevEUI + ":" + p.ProfileID
+	if p.OwnerToken != "" {
+		s += ":O" + p.OwnerToken
+	}
+	if p.SerialNumber != "" {
+		s += ":S" + p.SerialNumber
+	}
+	if p.Proprietary != "" {
+		s += ":P" + p.Proprietary
+	}
+	return s
+}
+
+// Parse : reads a payload scanned from a QR code
+func Parse(s string) (Payload, error) {
+	if !strings.HasPrefix(s, Prefix) {
+		return Payload{}, errors.New("not a TR005 payload")
+	}
+	fields := strings.Split(strings.TrimPrefix(s, Prefix), ":")
+	if len(fields) < 3 {
+		return Payload{}, errors.New("TR005 payload is missing its JoinEUI, DevEUI or ProfileID")
+	}
+
+	p := Payload{JoinEUI: fields[0], DevEUI: fields[1], ProfileID: fields[2]}
+	for _, f := range fields[3:] {
+		if f == "" {
+			continue
+		}
+		switch f[0] {
+		case 'O':
+			p.OwnerToken = f[1:]
+		case 'S':
+			p.SerialNumber = f[1:]
+		case 'P':
+			p.Proprietary = f[1:]
+		case 'C':
+			// checksum - recomputed by the network server
+		default:
+			return Payload{}, fmt.Errorf("unknown TR005 field %q", f)
+		}
+	}
+	return p, p.Validate()
+}
@@ -0,0 +1,57 @@
+package tr005
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/docker/docker/pkg/testutil/assert"
+)
+
+func TestPayload(t *testing.T) {
+	suite := []struct {
+		testName string
+		joinEUI  string
+		devEUI   string
+		profile  string
+		serial   string
+		want     string
+		err      string
+	}{
+		{"PAYLOAD - ", "70b3d57ed0000001", "0004a30b001c0530", "aabb1122", "", "LW:D0:70B3D57ED0000001:0004A30B001C0530:AABB1122", ""},
+		{"PAYLOAD - serial", "70B3D57ED0000001", "0004A30B001C0530", "AABB1122", "line1-0001", "LW:D0:70B3D57ED0000001:0004A30B001C0530:AABB1122:SLINE1-0001", ""},
+		{"PAYLOAD - invalid JoinEUI", "70B3", "0004A30B001C0530", "AABB1122", "", "", "invalid JoinEUI"},
+		{"PAYLOAD - invalid DevEUI", "70B3D57ED0000001", "XYZ", "AABB1122", "", "", "invalid DevEUI"},
+		{"PAYLOAD - invalid profile", "70B3D57ED0000001", "0004A30B001C0530", "AABB", "", "", "invalid ProfileID"},
+		{"PAYLOAD - invalid serial", "70B3D57ED0000001", "0004A30B001C0530", "AABB1122", "A:B", "", "invalid serial"},
+	}
+	for i, test := range suite {
+		t.Run(fmt.Sprintf("#%d: %q", i, test.testName), func(t *testing.T) {
+			p, err := New(test.joinEUI, test.devEUI, test.profile, test.serial)
+			if test.err != "" {
+				assert.Error(t, err, test.err)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, p.String(), test.want)
+
+			parsed, err := Parse(p.String())
+			assert.NilError(t, err)
+			assert.Equal(t, parsed, p)
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	p, err := Parse("LW:D0:70B3D57ED0000001:0004A30B001C0530:AABB1122:OTOKEN1:SSN42:PX:CAF01")
+	assert.NilError(t, err)
+	assert.Equal(t, p.OwnerToken, "TOKEN1")
+	assert.Equal(t, p.SerialNumber, "SN42")
+	assert.Equal(t, p.Proprietary, "X")
+
+	_, err = Parse("LW:D1:70B3D57ED0000001")
+	assert.Error(t, err, "not a TR005 payload")
+	_, err = Parse("LW:D0:70B3D57ED0000001:0004A30B001C0530")
+	assert.Error(t, err, "missing")
+	_, err = Parse("LW:D0:70B3D57ED0000001:0004A30B001C0530:AABB1122:ZX")
+	assert.Error(t, err, "unknown TR005 field")
+}
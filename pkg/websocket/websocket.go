@@ -0,0 +1,368 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"sync"
+)
+
+// frame opcodes - RFC 6455 section 5.2
+const (
+	OpContinuation = 0x0
+	OpText         = 0x1
+	OpBinary       = 0x2
+	OpClose        = 0x8
+	OpPing         = 0x9
+	OpPong         = 0xA
+)
+
+// close status codes - RFC 6455 section 7.4.1
+const (
+	CloseNormal        = 1000
+	CloseGoingAway     = 1001
+	CloseProtocolError = 1002
+	CloseTooBig        = 1009
+)
+
+// MaxMessageSize : the largest message read before the connection is closed
+const MaxMessageSize = 1 << 20
+
+// appended to the client key to prove the server speaks websocket
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// ErrClosed : the peer closed the connection
+var ErrClosed = errors.New("websocket closed")
+
+// HandshakeError :
+// An upgrade refused before the connection was taken over
+// Status is the HTTP status to answer with
+type HandshakeError struct {
+	Status  int
+	Message string
+}
+
+func (e HandshakeError) Error() string { return e.Message }
+
+// Conn :
+// A websocket connection. Writes may come from several goroutines
+// but only one goroutine may read
+type Conn struct {
+	conn   net.Conn
+	br     *bufio.Reader
+	client bool
+
+	wmu    sync.Mutex
+	closed bool
+}
+
+// Accept : the Sec-WebSocket-Accept answering a client key
+func Accept(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// IsUpgrade : reports whether r asks to switch to websocket
+func IsUpgrade(r *http.Request) bool {
+	return headerHas(r.Header, "Connection", "upgrade") && headerHas(r.Header, "Upgrade", "websocket")
+}
+
+// Upgrade :
+// Completes the server handshake and takes over the connection.
+// A HandshakeError is returned without anything written when
+// the request is not a valid version 13 upgrade
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if r.Method != http.MethodGet || !IsUpgrade(r) {
+		return nil, HandshakeError{http.StatusBadRequest, "not a websocket upgrade request"}
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, HandshakeError{http.StatusUpgradeRequired, "unsupported websocket version - 13 is required"}
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if nonce, err := base64.StdEncoding.DecodeString(key); err != nil || len(nonce) != 16 {
+		return nil, HandshakeError{http.StatusBadRequest, "invalid Sec-WebSocket-Key"}
+	}
+	hj, k := w.(http.Hijacker)
+	if !k {
+		return nil, HandshakeError{http.StatusInternalServerError, "connection cannot be taken over"}
+	}
+
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	response := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: " + Accept(key) + "\r\n\r\n"
+	if _, err := conn.Write([]byte(response)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &Conn{conn: conn, br: rw.Reader}, nil
+}
+
+// Dial :
+// Opens a client connection to a ws:// or wss:// url
+// http and https urls are accepted for the same
+func Dial(rawurl string, header http.Header) (*Conn, error) {
+	u, err := neturl.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	secure := u.Scheme == "wss" || u.Scheme == "https"
+	host := u.Host
+	if u.Port() == "" {
+		host += map[bool]string{false: ":80", true: ":443"}[secure]
+	}
+
+	var conn net.Conn
+	if secure {
+		conn, err = tls.Dial("tcp", host, &tls.Config{ServerName: u.Hostname()})
+	} else {
+		conn, err = net.Dial("tcp", host)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	u.Scheme = map[bool]string{false: "http", true: "https"}[secure]
+	req, _ := http.NewRequest(http.MethodGet, u.String(), nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		conn.Close()
+		return nil, HandshakeError{resp.StatusCode, fmt.Sprintf("websocket refused - %s %s", resp.Status, strings.TrimSpace(string(body)))}
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != Accept(key) {
+		conn.Close()
+		return nil, errors.New("invalid Sec-WebSocket-Accept from server")
+	}
+	return &Conn{conn: conn, br: br, client: true}, nil
+}
+
+// WriteMessage : sends data as a single frame
+func (c *Conn) WriteMessage(op byte, data []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+	return c.writeFrame(op, data)
+}
+
+// WriteText : sends a text message
+func (c *Conn) WriteText(data []byte) error {
+	return c.WriteMessage(OpText, data)
+}
+
+// Ping : asks the peer to answer with a pong
+func (c *Conn) Ping(data []byte) error {
+	return c.WriteMessage(OpPing, data)
+}
+
+// Close : sends a close frame with code and reason then closes the connection
+func (c *Conn) Close(code int, reason string) error {
+	c.wmu.Lock()
+	if !c.closed {
+		c.writeFrame(OpClose, closePayload(code, reason))
+		c.closed = true
+	}
+	c.wmu.Unlock()
+	return c.conn.Close()
+}
+
+// ReadMessage :
+// The next text or binary message - fragments are joined.
+// Pings are answered and pongs dropped along the way.
+// ErrClosed is returned once the peer closes the connection
+func (c *Conn) ReadMessage() (byte, []byte, error) {
+	var op byte
+	var message []byte
+	for {
+		fin, frameOp, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch frameOp {
+		case OpPing:
+			if err := c.WriteMessage(OpPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case OpPong:
+			continue
+		case OpClose:
+			code := CloseNormal
+			if len(payload) >= 2 {
+				code = int(binary.BigEndian.Uint16(payload))
+			}
+			c.Close(code, "")
+			return 0, nil, ErrClosed
+		case OpContinuation:
+			if message == nil {
+				return 0, nil, c.fail(CloseProtocolError, "continuation without a message")
+			}
+		case OpText, OpBinary:
+			if message != nil {
+				return 0, nil, c.fail(CloseProtocolError, "message interrupted by another")
+			}
+			op, message = frameOp, []byte{}
+		default:
+			return 0, nil, c.fail(CloseProtocolError, fmt.Sprintf("unknown opcode %d", frameOp))
+		}
+
+		if len(message)+len(payload) > MaxMessageSize {
+			return 0, nil, c.fail(CloseTooBig, "message too big")
+		}
+		message = append(message, payload...)
+		if fin {
+			return op, message, nil
+		}
+	}
+}
+
+// closes the connection for a protocol violation
+func (c *Conn) fail(code int, reason string) error {
+	c.Close(code, reason)
+	return errors.New("websocket " + reason)
+}
+
+func (c *Conn) writeFrame(op byte, data []byte) error {
+	header := []byte{0x80 | op, 0}
+	switch n := len(data); {
+	case n < 126:
+		header[1] = byte(n)
+	case n <= 0xFFFF:
+		header[1] = 126
+		header = append(header, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(n))
+	default:
+		header[1] = 127
+		header = append(header, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(header[2:], uint64(n))
+	}
+
+	// only clients mask their frames
+	if c.client {
+		header[1] |= 0x80
+		mask := make([]byte, 4)
+		rand.Read(mask)
+		header = append(header, mask...)
+		masked := make([]byte, len(data))
+		for i := range data {
+			masked[i] = data[i] ^ mask[i%4]
+		}
+		data = masked
+	}
+
+	if _, err := c.conn.Write(append(header, data...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *Conn) readFrame() (bool, byte, []byte, error) {
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(c.br, head); err != nil {
+		return false, 0, nil, err
+	}
+	fin, op := head[0]&0x80 != 0, head[0]&0x0F
+	masked, n := head[1]&0x80 != 0, uint64(head[1]&0x7F)
+
+	if head[0]&0x70 != 0 {
+		return false, 0, nil, c.fail(CloseProtocolError, "reserved bits set")
+	}
+	// clients must mask - servers must not
+	if masked == c.client {
+		return false, 0, nil, c.fail(CloseProtocolError, "frame masking is wrong")
+	}
+
+	switch n {
+	case 126:
+		ext := make([]byte, 2)
+		if _, err := io.ReadFull(c.br, ext); err != nil {
+			return false, 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		if _, err := io.ReadFull(c.br, ext); err != nil {
+			return false, 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext)
+	}
+	if op >= OpClose && (n > 125 || !fin) {
+		return false, 0, nil, c.fail(CloseProtocolError, "invalid control frame")
+	}
+	if n > MaxMessageSize {
+		return false, 0, nil, c.fail(CloseTooBig, "message too big")
+	}
+
+	mask := make([]byte, 4)
+	if masked {
+		if _, err := io.ReadFull(c.br, mask); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return fin, op, payload, nil
+}
+
+func closePayload(code int, reason string) []byte {
+	p := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(p, uint16(code))
+	return append(p, reason...)
+}
+
+// reports whether a comma separated header lists token
+func headerHas(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package websocket
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/pkg/testutil/assert"
+)
+
+func TestAccept(t *testing.T) {
+	// sample handshake from RFC 6455 section 1.3
+	assert.Equal(t, Accept("dGhlIHNhbXBsZSBub25jZQ=="), "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=")
+}
+
+func echoServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			http.Error(w, err.Error(), err.(HandshakeError).Status)
+			return
+		}
+		for {
+			op, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.WriteMessage(op, data)
+		}
+	}))
+}
+
+func TestEcho(t *testing.T) {
+	ts := echoServer(t)
+	defer ts.Close()
+
+	conn, err := Dial(strings.Replace(ts.URL, "http", "ws", 1), nil)
+	assert.NilError(t, err)
+	defer conn.Close(CloseNormal, "")
+
+	suite := []struct {
+		testName string
+		op       byte
+		size     int
+	}{
+		{"FRAME - empty", OpText, 0},
+		{"FRAME - short", OpText, 125},
+		{"FRAME - 16 bit length", OpBinary, 126},
+		{"FRAME - 16 bit max", OpBinary, 0xFFFF},
+		{"FRAME - 64 bit length", OpBinary, 0x10000},
+	}
+	for i, test := range suite {
+		t.Run(fmt.Sprintf("#%d: %q", i, test.testName), func(t *testing.T) {
+			data := bytes.Repeat([]byte("x"), test.size)
+			assert.NilError(t, conn.WriteMessage(test.op, data))
+			op, got, err := conn.ReadMessage()
+			assert.NilError(t, err)
+			assert.Equal(t, op, test.op)
+			assert.Equal(t, len(got), test.size)
+		})
+	}
+
+	// pongs are swallowed and the next message still arrives
+	assert.NilError(t, conn.Ping([]byte("hi")))
+	assert.NilError(t, conn.WriteText([]byte("after ping")))
+	_, got, err := conn.ReadMessage()
+	assert.NilError(t, err)
+	assert.Equal(t, string(got), "after ping")
+}
+
+func TestClose(t *testing.T) {
+	closed := make(chan error, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		assert.NilError(t, err)
+		_, _, err = conn.ReadMessage()
+		closed <- err
+	}))
+	defer ts.Close()
+
+	conn, err := Dial(ts.URL, nil)
+	assert.NilError(t, err)
+	conn.Close(CloseGoingAway, "bye")
+	assert.Equal(t, <-closed, ErrClosed)
+	assert.Equal(t, conn.WriteText([]byte("late")), ErrClosed)
+}
+
+func TestHandshake(t *testing.T) {
+	ts := echoServer(t)
+	defer ts.Close()
+
+	suite := []struct {
+		testName string
+		header   map[string]string
+		status   int
+	}{
+		{"HANDSHAKE - plain GET", map[string]string{}, http.StatusBadRequest},
+		{"HANDSHAKE - old version", map[string]string{"Connection": "Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Version": "8", "Sec-WebSocket-Key": "dGhlIHNhbXBsZSBub25jZQ=="}, http.StatusUpgradeRequired},
+		{"HANDSHAKE - short key", map[string]string{"Connection": "keep-alive, Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Version": "13", "Sec-WebSocket-Key": "c2hvcnQ="}, http.StatusBadRequest},
+	}
+	for i, test := range suite {
+		t.Run(fmt.Sprintf("#%d: %q", i, test.testName), func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+			for k, v := range test.header {
+				req.Header.Set(k, v)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			assert.NilError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, resp.StatusCode, test.status)
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package zpl
+
+import (
+	"net"
+	"time"
+)
+
+// DefaultPort : the raw print port of Zebra printers
+const DefaultPort = "9100"
+
+// Send :
+// Writes the job to a printer over a raw TCP connection.
+// The address takes the default port when it has none
+func Send(addr string, job []byte, timeout time.Duration) error {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, DefaultPort)
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	conn.SetWriteDeadline(time.Now().Add(timeout))
+	_, err = conn.Write(job)
+	return err
+}
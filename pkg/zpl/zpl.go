@@ -0,0 +1,85 @@
+package zpl
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"text/template"
+)
+
+// Fields :
+// What a layout can print for one device.
+// QR holds the TR005 onboarding payload
+type Fields struct {
+	DevEUI    string
+	ShortCode string
+	Serial    string
+	QR        string
+}
+
+// DefaultLayout :
+// A 2 x 1 inch label at 203 dpi - the QR code on the left
+// with the shortcode large and the DevEUI and serial beside it.
+// Every value is printed with ^FH so `escape` keeps ^ ~ and _ literal
+const DefaultLayout = `^XA
+^CI28
+^PW406
+^LL203
+^FO10,15^BQN,2,4^FH^FDMA,{{escape .QR}}^FS
+^FO200,25^A0N,48,48^FH^FD{{escape .ShortCode}}^FS
+^FO200,90^A0N,22,22^FH^FD{{escape .DevEUI}}^FS
+{{- if .Serial}}
+^FO200,125^A0N,22,22^FH^FDS/N {{escape .Serial}}^FS
+{{- end}}
+^XZ
+`
+
+// Layout : a parsed label layout
+type Layout struct {
+	tmpl *template.Template
+}
+
+// Parse :
+// A `text/template` layout executed once per label with Fields as its data.
+// The layout should hold a whole label from ^XA to ^XZ
+func Parse(text string) (*Layout, error) {
+	t, err := template.New("label").Funcs(template.FuncMap{"escape": Escape}).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ZPL layout - %v", err)
+	}
+	return &Layout{tmpl: t}, nil
+}
+
+// Load : parses the layout held in a file
+func Load(path string) (*Layout, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(string(data))
+}
+
+// Default : the built in layout
+func Default() *Layout {
+	l, _ := Parse(DefaultLayout)
+	return l
+}
+
+// Render : the labels one after the other as a single ZPL job
+func (l *Layout) Render(labels []Fields) ([]byte, error) {
+	buf := bytes.Buffer{}
+	for _, f := range labels {
+		if err := l.tmpl.Execute(&buf, f); err != nil {
+			return nil, fmt.Errorf("rendering the label of %s - %v", f.DevEUI, err)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// Escape :
+// Field data safe to print after ^FH - the command prefixes
+// and the hex indicator itself are sent as `_` and two hex digits
+func Escape(s string) string {
+	return strings.NewReplacer("_", "_5F", "^", "_5E", "~", "_7E").Replace(s)
+}
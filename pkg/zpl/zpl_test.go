@@ -0,0 +1,84 @@
+package zpl
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/pkg/testutil/assert"
+)
+
+func TestRender(t *testing.T) {
+	labels := []Fields{
+		{DevEUI: "00000000000ABCDE", ShortCode: "ABCDE", QR: "LW:D0:0000000000000000:00000000000ABCDE:00000000"},
+		{DevEUI: "00000000000ABCDF", ShortCode: "ABCDF", Serial: "LINE_1^2", QR: "LW:D0:0000000000000000:00000000000ABCDF:00000000:SLINE_1^2"},
+	}
+
+	job, err := Default().Render(labels)
+	assert.NilError(t, err)
+	zpl := string(job)
+	assert.Equal(t, strings.Count(zpl, "^XA"), 2)
+	assert.Equal(t, strings.Count(zpl, "^XZ"), 2)
+	assert.Contains(t, zpl, "^FDMA,LW:D0:0000000000000000:00000000000ABCDE:00000000^FS")
+	assert.Contains(t, zpl, "^FDABCDE^FS")
+	assert.Contains(t, zpl, "^FDS/N LINE_5F1_5E2^FS")
+	assert.Equal(t, strings.Count(zpl, "S/N"), 1)
+
+	suite := []struct {
+		testName string
+		layout   string
+		want     string
+		err      string
+	}{
+		{"LAYOUT - custom", "^XA^FO0,0^FD{{.ShortCode}}-{{.DevEUI}}^FS^XZ", "^XA^FO0,0^FDABCDE-00000000000ABCDE^FS^XZ", ""},
+		{"LAYOUT - syntax", "^XA{{.ShortCode", "", "invalid ZPL layout"},
+		{"LAYOUT - unknown field", "^XA{{.Colour}}^XZ", "", "can't evaluate field Colour"},
+	}
+	for i, test := range suite {
+		t.Run(fmt.Sprintf("#%d: %q", i, test.testName), func(t *testing.T) {
+			l, err := Parse(test.layout)
+			if err == nil {
+				job, err = l.Render(labels[:1])
+			}
+			if test.err != "" {
+				assert.Error(t, err, test.err)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, string(job), test.want)
+		})
+	}
+}
+
+func TestSend(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	defer ln.Close()
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		data, _ := ioutil.ReadAll(conn)
+		conn.Close()
+		received <- string(data)
+	}()
+
+	assert.NilError(t, Send(ln.Addr().String(), []byte("^XA^XZ"), time.Second))
+	select {
+	case data := <-received:
+		assert.Equal(t, data, "^XA^XZ")
+	case <-time.After(time.Second):
+		t.Fatal("printer received nothing")
+	}
+
+	// nothing listening
+	addr := ln.Addr().String()
+	ln.Close()
+	assert.Error(t, Send(addr, []byte("^XA^XZ"), time.Second), "refused")
+}
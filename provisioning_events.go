@@ -0,0 +1,29 @@
+package main
+
+import (
+	"time"
+
+	"github.com/David-solly/mxbcode/pkg/cache"
+	"github.com/David-solly/mxbcode/pkg/events"
+	"github.com/David-solly/mxbcode/pkg/models"
+	"github.com/David-solly/mxbcode/pkg/tenant"
+)
+
+// eventBus :
+// Provisioning events of every batch and store
+// pushed to `/events` subscribers
+var eventBus = events.NewBus()
+
+// publishes e stamped with the time and the tenant owning cc
+func publish(cc cache.Service, e models.Event) {
+	e.Time = time.Now().UTC()
+	if e.Tenant == "" {
+		e.Tenant = tenant.NameOf(cc)
+	}
+	eventBus.Publish(e)
+}
+
+// cc reporting the devices stored through it on the event bus
+func observe(cc cache.Service, tenantName string) cache.Service {
+	return cache.Observe(cc, tenantName, eventBus.Publish)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"github.com/David-solly/mxbcode/pkg/cache"
+	"github.com/David-solly/mxbcode/pkg/registrar"
+	"github.com/David-solly/mxbcode/pkg/tenant"
+)
+
+// registrars devices are sent to
+// while nil every batch is registered at `url` through `unconfigured`
+var registrars *registrar.Set
+
+// limits and breaks the requests to `url` while there are no registrars
+var unconfigured = func() *registrar.Registrar {
+	r, _ := registrar.New(registrar.Config{})
+	return r
+}()
+
+// configure the registration clients from the commandline.
+// def is the registrar of the shared namespace - a registrar named
+// "default" in the file replaces it. The others serve the tenants they list
+func startRegistrars(def registrar.Config, file string) error {
+	configs := []registrar.Config{}
+	if file != "" {
+		list, err := registrar.Load(file)
+		if err != nil {
+			return err
+		}
+		configs = list
+	}
+
+	others := []*registrar.Registrar{}
+	for _, cfg := range configs {
+		if cfg.Name == registrar.DefaultName {
+			def = cfg
+			continue
+		}
+		r, err := registrar.New(cfg)
+		if err != nil {
+			return err
+		}
+		others = append(others, r)
+	}
+
+	if def.URL == "" {
+		def.URL = url
+	}
+	d, err := registrar.New(def)
+	if err != nil {
+		return err
+	}
+	set, err := registrar.NewSet(d, others...)
+	if err != nil {
+		return err
+	}
+
+	registrars = set
+	url = d.URL
+	return nil
+}
+
+// the registrar of devices generated in the namespace of c
+// the default registrar sends to `url`
+func registrarFor(c cache.Cache) *registrar.Registrar {
+	r := unconfigured
+	if registrars != nil {
+		r = registrars.For(tenant.NameOf(c.Client))
+		if r != registrars.Default {
+			return r
+		}
+	}
+	d := *r
+	d.URL = url
+	return &d
+}
+
+// every registrar - the default first
+func allRegistrars() []*registrar.Registrar {
+	if registrars == nil {
+		return []*registrar.Registrar{unconfigured}
+	}
+	return registrars.All()
+}
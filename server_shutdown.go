@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/David-solly/mxbcode/pkg/cache"
+	gen "github.com/David-solly/mxbcode/pkg/generator"
+	"github.com/David-solly/mxbcode/pkg/models"
+	"github.com/David-solly/mxbcode/pkg/tenant"
+)
+
+// how long running batches are given to finish on shutdown
+var shutdownTimeout = 30 * time.Second
+
+// every batch being generated by the process
+var batches = newBatchTracker()
+
+// batchTracker :
+// Counts the batches in progress so shutdown can wait for them.
+// Once `drain` is called batches stop sending new registrations,
+// wait for the requests in flight and hold the shortcodes they drew
+// but never sent so the counter moving past them loses nothing
+type batchTracker struct {
+	wg       sync.WaitGroup
+	draining chan struct{}
+	once     sync.Once
+
+	running, finished, checkpointed, held int64
+}
+
+func newBatchTracker() *batchTracker {
+	return &batchTracker{draining: make(chan struct{})}
+}
+
+func (b *batchTracker) start() {
+	atomic.AddInt64(&b.running, 1)
+	b.wg.Add(1)
+}
+
+func (b *batchTracker) done() {
+	atomic.AddInt64(&b.running, -1)
+	atomic.AddInt64(&b.finished, 1)
+	b.wg.Done()
+}
+
+// closed once batches are told to stop
+func (b *batchTracker) drained() <-chan struct{} {
+	return b.draining
+}
+
+func (b *batchTracker) stopping() bool {
+	select {
+	case <-b.draining:
+		return true
+	default:
+		return false
+	}
+}
+
+func (b *batchTracker) drain() {
+	b.once.Do(func() { close(b.draining) })
+}
+
+// waits for every running batch to return
+func (b *batchTracker) wait() {
+	b.wg.Wait()
+}
+
+// give back the shortcodes of a batch cut short by shutdown.
+// Those of the global counter are reserved under the batch so they can be
+// claimed later and those of a block - an owners or a tenants - are queued
+// to be issued again by the block. Either way in the store the counters
+// live in rather than a tenants namespace
+func (b *batchTracker) checkpoint(c cache.Cache, unsent []*models.DevEUI, batch string) {
+	if len(unsent) == 0 {
+		return
+	}
+	shortcodes := make([]string, len(unsent))
+	for i, d := range unsent {
+		shortcodes[i] = strings.ToUpper(d.ShortCode)
+	}
+
+	checkpointed, err := gen.Checkpoint(tenant.Shared(c.Client), shortcodes, "unfinished batch "+batch)
+	atomic.AddInt64(&b.checkpointed, 1)
+	for _, r := range checkpointed.Held {
+		log.Printf("batch %s - holding unregistered shortcodes %s-%s", batch, r.From, r.To)
+		from, _ := strconv.ParseInt(r.From, 16, 64)
+		to, _ := strconv.ParseInt(r.To, 16, 64)
+		atomic.AddInt64(&b.held, to-from+1)
+	}
+	if checkpointed.Requeued > 0 {
+		log.Printf("batch %s - %d unregistered shortcodes given back to their block", batch, checkpointed.Requeued)
+		atomic.AddInt64(&b.held, checkpointed.Requeued)
+	}
+	if err != nil {
+		log.Printf("batch %s - could not hold every unregistered shortcode: %v", batch, err)
+	}
+}
+
+// serve `handler` on addr until interrupted - over HTTPS
+// when tlsConfig is set - then shut down within shutdownTimeout
+func serve(addr string, handler http.Handler, tlsConfig *tls.Config) error {
+	srv := &http.Server{Addr: addr, Handler: handler, TLSConfig: tlsConfig}
+	srv.RegisterOnShutdown(closeEventFeeds)
+
+	failed := make(chan error, 1)
+	go func() {
+		var err error
+		if tlsConfig != nil {
+			// the certificate comes from tlsConfig.GetCertificate
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != http.ErrServerClosed {
+			failed <- err
+		}
+	}()
+
+	select {
+	case err := <-failed:
+		return err
+	case <-interrupt:
+	}
+
+	shutdownServer(srv, shutdownTimeout)
+	return nil
+}
+
+// Stops accepting requests and lets those running finish.
+// Batches still running at the deadline are drained and checkpointed,
+// then the store is persisted and a summary logged
+func shutdownServer(srv *http.Server, timeout time.Duration) {
+	running := atomic.LoadInt64(&batches.running)
+	finished := atomic.LoadInt64(&batches.finished)
+	log.Printf("shutting down - server - waiting up to %v for %d running batches", timeout, running)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("shutting down - deadline passed - draining batches: %v", err)
+	}
+
+	// batches outside of requests - eg the pool - are drained too
+	batches.drain()
+	batches.wait()
+
+	if err := cache.Persist(c.Client); err != nil {
+		log.Printf("shutting down - persisting the store failed: %v", err)
+	}
+
+	checkpointed := atomic.LoadInt64(&batches.checkpointed)
+	log.Printf("shutting down - %d batches finished, %d checkpointed holding %d shortcodes",
+		atomic.LoadInt64(&batches.finished)-finished-checkpointed, checkpointed, atomic.LoadInt64(&batches.held))
+}
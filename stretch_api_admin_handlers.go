@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	gen "github.com/David-solly/mxbcode/pkg/generator"
+	"github.com/go-chi/chi"
+)
+
+// reservation request body
+// `to` may be left out to reserve a single shortcode
+type reservationRequest struct {
+	From  string `json:"from"`
+	To    string `json:"to,omitempty"`
+	Label string `json:"label,omitempty"`
+}
+
+// ReserveHTTPHandler : holds back a shortcode or range
+// ahead of the counter for vanity or internal use
+func ReserveHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	req := reservationRequest{}
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	reservation, err := gen.Reserve(RequestCache.Client, req.From, req.To, req.Label)
+	if err != nil {
+		writeProblem(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	data, _ := json.Marshal(reservation)
+	write(w, data, http.StatusCreated)
+}
+
+// ListReservationsHTTPHandler : every reservation ordered by shortcode
+func ListReservationsHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	mediaType, ok := negotiate(w, r)
+	if !ok {
+		return
+	}
+
+	list, err := gen.Reservations(RequestCache.Client)
+	if err != nil {
+		writeProblem(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respond(w, mediaType, list, http.StatusOK)
+}
+
+// ReleaseReservationHTTPHandler : removes the reservation holding the shortcode
+func ReleaseReservationHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	shortCode := chi.URLParam(r, "shortcode")
+	if validShortcode := shortcodeValidator(w, shortCode); !validShortcode {
+		return
+	}
+
+	reservation, err := gen.Release(RequestCache.Client, shortCode)
+	if err != nil {
+		writeProblem(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	data, _ := json.Marshal(reservation)
+	write(w, data, http.StatusOK)
+}
+
+// ClaimReservationHTTPHandler : registers a DevEUI for a reserved shortcode
+// the shortcode can only be claimed once
+func ClaimReservationHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	shortCode := chi.URLParam(r, "shortcode")
+	if validShortcode := shortcodeValidator(w, shortCode); !validShortcode {
+		return
+	}
+
+	dev, err := gen.ClaimReserved(RequestCache.Client, shortCode)
+	if err != nil {
+		writeProblem(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err := registerDevice(dev, RequestCache); err != nil {
+		gen.Unclaim(RequestCache.Client, dev.ShortCode)
+		writeProblem(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	write(w, toJSON("deveui", strings.ToUpper(dev.DevEUI)), http.StatusOK)
+}
+
+// decode a json request body into v
+// writes the error response and returns false on failure
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	body, err := ioutil.ReadAll(r.Body)
+	if err == nil {
+		err = json.Unmarshal(body, v)
+	}
+	if err != nil {
+		writeProblem(w, "invalid request body - "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
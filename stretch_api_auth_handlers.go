@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/David-solly/mxbcode/pkg/apikey"
+	"github.com/David-solly/mxbcode/pkg/models"
+	"github.com/David-solly/mxbcode/pkg/tenant"
+	"github.com/go-chi/chi"
+)
+
+// request context key of the access key a request was made with
+const apiKeyContextKey contextKey = "apikey"
+
+// requireAPIKey :
+// Refuses requests without a key - only `-allow-anonymous` lets
+// anonymous requests generate and look up in the shared namespace
+var requireAPIKey = true
+
+// scopes never granted to anonymous requests whatever `requireAPIKey` says
+// the first admin key is created with the `keys` command
+var protectedScopes = map[string]bool{apikey.Admin: true, apikey.Export: true}
+
+// scopes of a tenant API key within its own namespace
+var tenantScopes = []string{apikey.Generate, apikey.Lookup}
+
+// APIKeyMiddleware : identifies the caller from the `X-API-Key` header
+// either a tenant scoped to its namespace or an access key with scopes.
+// Requests without a key stay in the shared namespace
+func APIKeyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("X-API-Key")
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		t, err := tenant.ForAPIKey(RequestCache.Client, key)
+		if err != nil {
+			writeProblem(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if t != nil {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tenantContextKey, t)))
+			return
+		}
+
+		k, err := apikey.Authenticate(RequestCache.Client, key)
+		if err != nil {
+			writeProblem(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if k == nil {
+			writeProblem(w, "unknown API key", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey, k)))
+	})
+}
+
+// the access key or bearer token a request was made with
+// nil for tenant keys and anonymous requests
+func requestAPIKey(r *http.Request) *models.APIKey {
+	k, _ := r.Context().Value(apiKeyContextKey).(*models.APIKey)
+	return k
+}
+
+// RequireScope : only callers granted scope get through.
+// Tenants may generate and look up within their namespace.
+// Anonymous requests may only generate and look up with `-allow-anonymous`
+// - the export token stands in for a key granting export
+func RequireScope(scope string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			k, t := requestAPIKey(r), requestTenant(r)
+			switch {
+			case k != nil && !apikey.Allows(*k, scope):
+				errorMessage := fmt.Sprintf("%q is not granted the %q scope", k.ID, scope)
+				writeProblem(w, errorMessage, http.StatusForbidden)
+				return
+
+			case k == nil && t != nil && !apikey.Allows(models.APIKey{Scopes: tenantScopes}, scope):
+				errorMessage := fmt.Sprintf("tenant API keys are not granted the %q scope", scope)
+				writeProblem(w, errorMessage, http.StatusForbidden)
+				return
+
+			case k == nil && t == nil && (requireAPIKey || protectedScopes[scope]) && !exportTokenGrants(r, scope):
+				w.Header().Set("WWW-Authenticate", "API-Key")
+				writeProblem(w, "an API key is required", http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// the export token grants the export scope to requests without a key
+func exportTokenGrants(r *http.Request, scope string) bool {
+	return scope == apikey.Export && exportAllowed(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+}
+
+// access key creation request body
+type apiKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// access key creation and rotation response
+// the key is only ever shown here
+type apiKeyResponse struct {
+	models.APIKey
+	Key string `json:"key"`
+}
+
+// CreateAPIKeyHTTPHandler : adds an access key granting scopes
+func CreateAPIKeyHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	req := apiKeyRequest{}
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	k, key, err := apikey.Create(RequestCache.Client, req.Name, req.Scopes)
+	if err != nil {
+		writeProblem(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	data, _ := json.Marshal(apiKeyResponse{APIKey: k, Key: key})
+	write(w, data, http.StatusCreated)
+}
+
+// ListAPIKeysHTTPHandler : every access key including revoked ones
+func ListAPIKeysHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	mediaType, ok := negotiate(w, r)
+	if !ok {
+		return
+	}
+
+	list, err := apikey.List(RequestCache.Client)
+	if err != nil {
+		writeProblem(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respond(w, mediaType, list, http.StatusOK)
+}
+
+// RotateAPIKeyHTTPHandler : a new secret for an access key
+// the previous one stops working straight away
+func RotateAPIKeyHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	k, key, err := apikey.Rotate(RequestCache.Client, chi.URLParam(r, "id"))
+	if err != nil {
+		writeProblem(w, err.Error(), apiKeyErrorCode(err))
+		return
+	}
+
+	data, _ := json.Marshal(apiKeyResponse{APIKey: k, Key: key})
+	write(w, data, http.StatusOK)
+}
+
+// RevokeAPIKeyHTTPHandler : stops an access key from authenticating
+func RevokeAPIKeyHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	k, err := apikey.Revoke(RequestCache.Client, chi.URLParam(r, "id"))
+	if err != nil {
+		writeProblem(w, err.Error(), apiKeyErrorCode(err))
+		return
+	}
+
+	data, _ := json.Marshal(k)
+	write(w, data, http.StatusOK)
+}
+
+// unknown keys are not found - revoked ones are gone for good
+func apiKeyErrorCode(err error) int {
+	if strings.HasSuffix(err.Error(), "does not exist") {
+		return http.StatusNotFound
+	}
+	return http.StatusGone
+}
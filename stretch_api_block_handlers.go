@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	gen "github.com/David-solly/mxbcode/pkg/generator"
+	"github.com/David-solly/mxbcode/pkg/models"
+	"github.com/David-solly/mxbcode/pkg/tenant"
+	"github.com/go-chi/chi"
+)
+
+// cached responses of block scoped generation
+// kept apart from the block records themselves
+const blockRequestPrefix = "BLOCK-REQ:"
+
+// block allocation request body
+// either `size` or both `from` and `to` are required
+type blockRequest struct {
+	Owner string `json:"owner"`
+	From  string `json:"from,omitempty"`
+	To    string `json:"to,omitempty"`
+	Size  int64  `json:"size,omitempty"`
+}
+
+// AllocateBlockHTTPHandler : carves a contiguous range of shortcodes
+// out for an owner - eg a factory that may work offline
+func AllocateBlockHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	req := blockRequest{}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if tenant.OwnsBlock(req.Owner) {
+		errorMessage := fmt.Sprintf("blocks of %q are allocated to tenants when they are created", tenant.BlockOwnerPrefix)
+		writeProblem(w, errorMessage, http.StatusUnprocessableEntity)
+		return
+	}
+
+	block, err := gen.AllocateBlock(RequestCache.Client, req.Owner, req.From, req.To, req.Size)
+	if err != nil {
+		writeProblem(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	data, _ := json.Marshal(block)
+	write(w, data, http.StatusCreated)
+}
+
+// ListBlocksHTTPHandler : every block and how far its owner has got through it
+func ListBlocksHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	mediaType, ok := negotiate(w, r)
+	if !ok {
+		return
+	}
+
+	list, err := gen.Blocks(RequestCache.Client)
+	if err != nil {
+		writeProblem(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respond(w, mediaType, list, http.StatusOK)
+}
+
+// BlockHTTPHandler : the block of a single owner
+// `exhausted` is set once every shortcode in it has been issued
+func BlockHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	block, ok := ownerBlock(w, r)
+	if !ok {
+		return
+	}
+
+	data, _ := json.Marshal(block)
+	write(w, data, http.StatusOK)
+}
+
+// GenerateBlockBatchHTTPHandler : Idempotent generate endpoint scoped to a block
+// behaves as GenerateBatchHTTPHandler but draws from the owners counter.
+// The final batch of a block may be smaller than 100
+func GenerateBlockBatchHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	mediaType, ok := negotiate(w, r)
+	if !ok {
+		return
+	}
+	block, ok := ownerBlock(w, r)
+	if !ok {
+		return
+	}
+	if tenant.OwnsBlock(block.Owner) {
+		errorMessage := fmt.Sprintf("block of %q is generated from through its tenant", block.Owner)
+		writeProblem(w, errorMessage, http.StatusForbidden)
+		return
+	}
+
+	requestKey := blockRequestPrefix + block.Owner + ":" + createRequestIDKey(r)
+	rq, found, _ := RequestCache.Client.ReadCache(requestKey) //check cache for existing request
+	if found {
+		respondBatch(w, mediaType, rq) //return cached result
+		return
+	}
+
+	if block.Exhausted {
+		errorMessage := fmt.Sprintf("block %s-%s of %q is exhausted", block.From, block.To, block.Owner)
+		writeProblem(w, errorMessage, http.StatusUnprocessableEntity)
+		return
+	}
+
+	count := idsToGenerate
+	if block.Remaining < count {
+		count = block.Remaining
+	}
+
+	count, settle, ok := reserveDaily(w, r, count)
+	if !ok {
+		return
+	}
+
+	data := generateForRequest(count, c, gen.ForBlock(block.Owner))
+	settle(registeredCount(data))
+	RequestCache.Client.StoreDUIDGenResponse(models.ApiResponseCacheObject{Key: requestKey, Response: data, Timeout: cacheDuration})
+	respondBatch(w, mediaType, data)
+}
+
+// look up the block named in the url
+// writes a 404 when the owner has none
+func ownerBlock(w http.ResponseWriter, r *http.Request) (*models.Block, bool) {
+	owner := chi.URLParam(r, "owner")
+	block, err := gen.BlockFor(RequestCache.Client, owner)
+	if err != nil {
+		writeProblem(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if block == nil {
+		errorMessage := fmt.Sprintf("no block allocated to %q", owner)
+		writeProblem(w, errorMessage, http.StatusNotFound)
+		return nil, false
+	}
+	return block, true
+}
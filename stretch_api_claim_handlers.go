@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/David-solly/mxbcode/pkg/claim"
+	"github.com/go-chi/chi"
+)
+
+// claim request body
+type claimRequest struct {
+	Serial string `json:"serial"`
+}
+
+// ClaimHTTPHandler : binds a hardware serial to the next registered DevEUI
+// repeating the request with the same serial returns the original binding
+// - 201 when the device is newly bound, 200 when it already was and 409
+// while another request for the serial is still registering its device.
+// Claims are kept in the shared namespace so tenants are refused
+func ClaimHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	if !sharedNamespaceOnly(w, r, "the claim register") {
+		return
+	}
+	req := claimRequest{}
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	cl, created, err := claim.Claim(RequestCache.Client, req.Serial, claimSource(RequestCache))
+	if err == claim.ErrInProgress {
+		w.Header().Set("Retry-After", "1")
+		writeProblem(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		writeProblem(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	code := http.StatusOK
+	if created {
+		code = http.StatusCreated
+	}
+	data, _ := json.Marshal(cl)
+	write(w, data, code)
+}
+
+// LookupClaimHTTPHandler : the DevEUI bound to a serial
+func LookupClaimHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	if !sharedNamespaceOnly(w, r, "the claim register") {
+		return
+	}
+	serial := chi.URLParam(r, "serial")
+	cl, err := claim.Get(RequestCache.Client, serial)
+	if err != nil {
+		writeProblem(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if cl == nil {
+		errorMessage := fmt.Sprintf("serial - %v has not claimed a device", serial)
+		writeProblem(w, errorMessage, http.StatusNotFound)
+		return
+	}
+
+	data, _ := json.Marshal(cl)
+	write(w, data, http.StatusOK)
+}
+
+// DeviceClaimHTTPHandler : reverse lookup of the serial bound to a device
+// accepts the full 16 digit DevEUI or its 5 digit shortcode
+func DeviceClaimHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	if !sharedNamespaceOnly(w, r, "the claim register") {
+		return
+	}
+	device, ok := resolveDevice(w, chi.URLParam(r, "device"))
+	if !ok {
+		return
+	}
+
+	cl, err := claim.ForDevEUI(RequestCache.Client, device)
+	if err != nil {
+		writeProblem(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if cl == nil {
+		errorMessage := fmt.Sprintf("device - %v has not been claimed", device)
+		writeProblem(w, errorMessage, http.StatusNotFound)
+		return
+	}
+
+	data, _ := json.Marshal(cl)
+	write(w, data, http.StatusOK)
+}
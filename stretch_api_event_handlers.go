@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/David-solly/mxbcode/pkg/apikey"
+	"github.com/David-solly/mxbcode/pkg/events"
+	"github.com/David-solly/mxbcode/pkg/models"
+	"github.com/David-solly/mxbcode/pkg/websocket"
+)
+
+// how often an idle event feed is pinged to keep proxies from closing it
+var eventsPingInterval = 30 * time.Second
+
+// closed when the server shuts down - websockets are hijacked
+// so the server can't close them itself
+var (
+	eventFeedsClosed = make(chan struct{})
+	closeFeedsOnce   sync.Once
+)
+
+func closeEventFeeds() {
+	closeFeedsOnce.Do(func() { close(eventFeedsClosed) })
+}
+
+// EventsHTTPHandler :
+// Upgrades to a websocket pushing provisioning events as JSON text messages.
+// `?type=registered,failed` narrows the event types. Tenant API keys only ever
+// see their own events and other callers those of the shared namespace -
+// admin keys see every tenant unless `?tenant=acme` or `/t/{tenant}` picks one
+func EventsHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	types, err := events.ParseTypes(r.URL.Query().Get("type"))
+	if err != nil {
+		writeProblem(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	filter, ok := eventsFilter(w, r)
+	if !ok {
+		return
+	}
+	filter.Types = types
+
+	// subscribed before the handshake completes
+	// so nothing published once the client is connected is missed
+	sub := eventBus.Subscribe(filter, 0)
+	defer sub.Close()
+
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		if h, k := err.(websocket.HandshakeError); k {
+			writeProblem(w, h.Message, h.Status)
+		}
+		return
+	}
+	defer conn.Close(websocket.CloseGoingAway, "")
+
+	// clients only ever close the feed
+	// reading answers their pings and notices the close
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(eventsPingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+
+		case <-eventFeedsClosed:
+			return
+
+		case <-ping.C:
+			if conn.Ping(nil) != nil {
+				return
+			}
+
+		case e := <-sub.C:
+			if n := sub.Dropped(); n > 0 {
+				if sendEvent(conn, models.Event{Type: events.Dropped, Time: time.Now().UTC(), Count: int(n)}) != nil {
+					return
+				}
+			}
+			if sendEvent(conn, e) != nil {
+				return
+			}
+		}
+	}
+}
+
+// the events visible to the caller of r
+func eventsFilter(w http.ResponseWriter, r *http.Request) (events.Filter, bool) {
+	name := strings.ToLower(r.URL.Query().Get("tenant"))
+	k, t := requestAPIKey(r), requestTenant(r)
+	if t != nil {
+		if name != "" && name != t.Name {
+			errorMessage := fmt.Sprintf("events of tenant %q are not visible to tenant %q", name, t.Name)
+			writeProblem(w, errorMessage, http.StatusForbidden)
+			return events.Filter{}, false
+		}
+		name = t.Name
+	}
+
+	switch {
+	case k != nil && apikey.Allows(*k, apikey.Admin):
+		return events.Filter{Tenant: name}, true
+
+	// a tenant API key - the tenant of a `/t/{tenant}` path has to match it
+	case t != nil && k == nil && r.Header.Get("X-API-Key") != "":
+		return events.Filter{Tenant: t.Name}, true
+
+	case name != "":
+		errorMessage := fmt.Sprintf("events of tenant %q are only visible to its API key and admin keys", name)
+		writeProblem(w, errorMessage, http.StatusForbidden)
+		return events.Filter{}, false
+	}
+	return events.Filter{Shared: true}, true
+}
+
+func sendEvent(conn *websocket.Conn, e models.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return conn.WriteText(data)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+
+	"github.com/David-solly/mxbcode/pkg/export"
+)
+
+// ExportHTTPHandler : the device store in a network server import format
+//
+// `?format=` csv, ndjson, chirpstack or tts - csv by default
+// `?columns=` comma separated fields of csv and ndjson
+// `?batch=`, `?since=` and `?until=` narrow the devices and
+// `?deveui=` may be repeated to pick devices.
+// `application_id`, `device_profile_id` and `frequency_plan` fill in the import.
+// Under `/export/keys` root keys are included and every device is audited
+func ExportHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	opts := export.Options{
+		Format:          q.Get("format"),
+		Columns:         export.ParseColumns(q.Get("columns")),
+		ApplicationID:   q.Get("application_id"),
+		DeviceProfileID: q.Get("device_profile_id"),
+		FrequencyPlan:   q.Get("frequency_plan"),
+	}
+	if opts.Format == "" {
+		opts.Format = "csv"
+	}
+	if err := opts.Validate(); err != nil {
+		writeProblem(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f, err := exportFilter(q.Get("batch"), q.Get("since"), q.Get("until"))
+	if err != nil {
+		writeProblem(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	withKeys := strings.HasSuffix(r.URL.Path, "/keys")
+	actor := ""
+	if withKeys {
+		actor = "token:" + exportTokenHash[:8]
+	}
+	rows, err := exportRows(requestCache(r).Client, f, q["deveui"], withKeys, actor, r.RemoteAddr)
+	if err != nil {
+		writeProblem(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	buf := bytes.Buffer{}
+	if err := export.Write(&buf, rows, opts); err != nil {
+		writeProblem(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", export.ContentType(opts.Format))
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
@@ -1,12 +1,18 @@
 package main
 
 import (
+	"bytes"
 	"crypto/sha1"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"regexp"
+	"strings"
 
+	"github.com/David-solly/mxbcode/pkg/cache"
+	gen "github.com/David-solly/mxbcode/pkg/generator"
+	"github.com/David-solly/mxbcode/pkg/media"
+	"github.com/David-solly/mxbcode/pkg/models"
 	"github.com/go-chi/chi"
 )
 
@@ -22,12 +28,72 @@ func toJSON(key string, value string) []byte {
 }
 
 // DRY method to write to output
+// headers must be set before WriteHeader sends them
 func write(w http.ResponseWriter, data []byte, code int) {
+	w.Header().Set("Content-Type", media.JSON)
 	w.WriteHeader(code)
-	w.Header().Add("Content-Type", "application/json")
 	w.Write([]byte(data))
 }
 
+// Writes an RFC 7807 problem describing the error
+func writeProblem(w http.ResponseWriter, detail string, code int) {
+	data, _ := json.Marshal(media.NewProblem(code, detail))
+	w.Header().Set("Content-Type", media.ProblemJSON)
+	w.WriteHeader(code)
+	w.Write(data)
+}
+
+// The media type the client accepts for the response
+// writes a 406 and returns false when none can be sent.
+// Called before any work is done so a refused request changes nothing
+func negotiate(w http.ResponseWriter, r *http.Request) (string, bool) {
+	mediaType, ok := media.Negotiate(r.Header.Get("Accept"))
+	if !ok {
+		errorMessage := fmt.Sprintf("cannot respond with %q - one of %s", r.Header.Get("Accept"), strings.Join(media.Types, ", "))
+		writeProblem(w, errorMessage, http.StatusNotAcceptable)
+		return "", false
+	}
+	return mediaType, true
+}
+
+// Writes v as the negotiated media type
+func respond(w http.ResponseWriter, mediaType string, v interface{}, code int) {
+	buf := bytes.Buffer{}
+	if err := media.Encode(&buf, mediaType, v); err != nil {
+		writeProblem(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Add("Vary", "Accept")
+	w.WriteHeader(code)
+	w.Write(buf.Bytes())
+}
+
+// Writes a batch generated as json in the negotiated media type
+func respondBatch(w http.ResponseWriter, mediaType string, data string) {
+	if mediaType == media.JSON {
+		w.Header().Add("Vary", "Accept")
+		write(w, []byte(data), http.StatusOK)
+		return
+	}
+	registered := models.RegisteredDevEUIList{}
+	if err := json.Unmarshal([]byte(data), &registered); err != nil {
+		writeProblem(w, "invalid batch - "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respond(w, mediaType, registered, http.StatusOK)
+}
+
+// Generates a batch for a request. Unlike the cli a request isn't
+// interrupted by signals - the server drains it on shutdown instead
+func generateForRequest(count int64, cc cache.Cache, source gen.BatchFunc) string {
+	_, data, err := generateScopedBatchIDs(count, cc, make(chan bool), source)
+	if err != nil {
+		fmt.Println(err)
+	}
+	return data
+}
+
 // Used to create a lookup key to check for cached results
 // Useful for idempotency
 func createRequestIDKey(r *http.Request) (uniqeResponseKey string) {
@@ -44,9 +110,25 @@ func shortcodeValidator(w http.ResponseWriter, sc string) bool {
 	validHex, err := regexp.MatchString(`^[a-fA-F0-9]{1,5}$`, sc) //validate 5 digit hex
 	if err != nil || !validHex {
 		errorMessage := fmt.Sprintf("invalid shortcode - %v", sc)
-		write(w, toJSON("error", errorMessage), http.StatusUnprocessableEntity)
+		writeProblem(w, errorMessage, http.StatusUnprocessableEntity)
 		return false
 	}
 
 	return true
 }
+
+// Resolves a 16 digit DevEUI or 5 digit shortcode to the DevEUI
+// writes the error response and returns false when it cannot
+func resolveDevice(w http.ResponseWriter, device string) (string, bool) {
+	deveui, found, err := lookupDevice(RequestCache.Client, device)
+	if err != nil {
+		writeProblem(w, err.Error(), http.StatusUnprocessableEntity)
+		return "", false
+	}
+	if !found {
+		errorMessage := fmt.Sprintf("shortcode - %v is Not Found", device)
+		writeProblem(w, errorMessage, http.StatusNotFound)
+		return "", false
+	}
+	return deveui, true
+}
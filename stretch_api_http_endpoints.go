@@ -3,6 +3,7 @@ package main
 import (
 	"time"
 
+	"github.com/David-solly/mxbcode/pkg/apikey"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 )
@@ -11,19 +12,124 @@ import (
 func GetRouter() *chi.Mux {
 	r := chi.NewRouter()
 	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
+	r.Use(RealIPMiddleware)
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
-	r.Use(middleware.Timeout(2000 * time.Millisecond))
+	r.Use(timeoutUnlessStreaming(2000 * time.Millisecond))
+
+	// anonymous requests and failed logins are limited by address
+	// ahead of authentication - the rest by their key afterwards
+	r.Use(AddressRateLimitMiddleware)
+	r.Use(APIKeyMiddleware)
+	r.Use(BearerTokenMiddleware)
+	r.Use(ClientCertMiddleware)
+	r.Use(RateLimitMiddleware)
+
+	// every route but the status check is held to a scope - anonymous requests
+	// are refused admin and export, and everything unless -allow-anonymous
+	generate, lookup := RequireScope(apikey.Generate), RequireScope(apikey.Lookup)
+	export, admin := RequireScope(apikey.Export), RequireScope(apikey.Admin)
 
 	// Generates a list of 100 id's
 	// the reqID can be any value to make differentiate requests
 	// you can cycle up from 1 to infinity if you like
-	r.Get("/generate/{reqID}", GenerateBatchHTTPHandler)
+	// `?stream=true` streams each device as it is registered
+	r.With(generate).Get("/generate/{reqID}", GenerateBatchHTTPHandler)
 
 	// retrieve a full 16 digit HEX device id  from the 5 digit 'shortcode'
 	// if one does not exist. An appropriate message is returned
-	r.Get("/view/{shortcode}", LookupShortcodeHTTPHandler)
+	r.With(lookup).Get("/view/{shortcode}", LookupShortcodeHTTPHandler)
+
+	// accounting of the shortcode ID space
+	r.With(lookup).Get("/stats", StatsHTTPHandler)
+
+	// registration clients in the Prometheus text format
+	r.With(lookup).Get("/metrics", MetricsHTTPHandler)
+
+	// pops already registered devices from the pool
+	// instantly rather than registering on demand
+	r.With(generate).Post("/allocate", AllocateHTTPHandler)
+	r.With(lookup).Get("/pool", PoolHTTPHandler)
+
+	// bind hardware serial numbers to registered devices exactly once
+	// and look the binding up from either side
+	r.With(generate).Post("/claims", ClaimHTTPHandler)
+	r.With(lookup).Get("/claims/{serial}", LookupClaimHTTPHandler)
+	r.With(lookup).Get("/devices/{device}/claim", DeviceClaimHTTPHandler)
+
+	// TR005 onboarding payload of a device and its QR code
+	r.With(lookup).Get("/devices/{device}/tr005", PayloadHTTPHandler)
+	r.With(lookup).Get("/devices/{device}/qr.png", QRCodeHTTPHandler)
+	r.With(lookup).Get("/devices/{device}/qr.svg", QRCodeHTTPHandler)
+
+	// printable label sheets of a batch or any list of shortcodes
+	r.With(lookup).Post("/labels.pdf", LabelsHTTPHandler)
+	r.With(lookup).Post("/labels.svg", LabelsHTTPHandler)
+
+	// the same as ZPL for Zebra thermal printers
+	r.With(lookup).Post("/labels.zpl", ZPLLabelsHTTPHandler)
+	r.With(lookup).Get("/devices/{device}/label.zpl", DeviceZPLHTTPHandler)
+
+	// OTAA root keys leave the store only with the export token
+	// every attempt is audited - checked first so denials are too
+	r.With(ExportKeysAuthMiddleware, export).Get("/devices/{device}/keys", ExportKeysHTTPHandler)
+
+	// the device store in network server import formats
+	// root keys are only included with the export token
+	r.With(export).Get("/export", ExportHTTPHandler)
+	r.With(ExportKeysAuthMiddleware, export).Get("/export/keys", ExportHTTPHandler)
+
+	// root keys wrapped with RFC 3394 for a join server
+	r.With(ExportKeysAuthMiddleware, export).Get("/keys/export/{label}", WrapKeysHTTPHandler)
+
+	// websocket feed of provisioning events
+	// `?type=` narrows what is pushed and admin keys pick a tenant with `?tenant=`
+	r.With(lookup).Get("/events", EventsHTTPHandler)
+
+	// the same endpoints scoped to a tenants namespace
+	// requests carrying another tenants API key are forbidden
+	r.Route("/t/{tenant}", func(r chi.Router) {
+		r.Use(TenantPathMiddleware)
+		r.With(generate).Get("/generate/{reqID}", GenerateBatchHTTPHandler)
+		r.With(lookup).Get("/view/{shortcode}", LookupShortcodeHTTPHandler)
+		r.With(lookup).Get("/stats", StatsHTTPHandler)
+		r.With(lookup).Get("/events", EventsHTTPHandler)
+	})
+
+	// blocks of the shortcode space allocated to owners
+	// each owner generates from its own counter inside the block
+	r.With(lookup).Get("/blocks", ListBlocksHTTPHandler)
+	r.With(admin).Post("/blocks", AllocateBlockHTTPHandler)
+	r.With(lookup).Get("/blocks/{owner}", BlockHTTPHandler)
+	r.With(generate).Get("/blocks/{owner}/generate/{reqID}", GenerateBlockBatchHTTPHandler)
+
+	// administration of the shortcode space
+	r.Route("/admin", func(r chi.Router) {
+		r.Use(admin)
+
+		// reserve explicit shortcodes or ranges ahead of the counter
+		// and claim them later as registered devices
+		r.Get("/reservations", ListReservationsHTTPHandler)
+		r.Post("/reservations", ReserveHTTPHandler)
+		r.Delete("/reservations/{shortcode}", ReleaseReservationHTTPHandler)
+		r.Post("/reservations/{shortcode}/claim", ClaimReservationHTTPHandler)
+
+		// tenants sharing the deployment
+		r.Get("/tenants", ListTenantsHTTPHandler)
+		r.Post("/tenants", CreateTenantHTTPHandler)
+		r.Get("/tenants/{tenant}", TenantHTTPHandler)
+		r.Delete("/tenants/{tenant}", DeleteTenantHTTPHandler)
+
+		// access keys and their scopes
+		// a key is shown once when created or rotated
+		r.Get("/api-keys", ListAPIKeysHTTPHandler)
+		r.Post("/api-keys", CreateAPIKeyHTTPHandler)
+		r.Post("/api-keys/{id}/rotate", RotateAPIKeyHTTPHandler)
+		r.Delete("/api-keys/{id}", RevokeAPIKeyHTTPHandler)
+
+		// who has exported root keys
+		r.With(ExportKeysAuthMiddleware).Get("/keys/audit", KeyAuditHTTPHandler)
+	})
 
 	//check the basic status of the API
 	r.Get("/", StatusHTTPHandler)
@@ -1,12 +1,16 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"time"
 
+	"github.com/David-solly/mxbcode/pkg/breaker"
 	"github.com/David-solly/mxbcode/pkg/cache"
+	gen "github.com/David-solly/mxbcode/pkg/generator"
 	"github.com/David-solly/mxbcode/pkg/models"
+	"github.com/David-solly/mxbcode/pkg/tenant"
 	"github.com/go-chi/chi"
 )
 
@@ -29,40 +33,138 @@ var (
 // future requests made within 'cacheDuration' of each other with the same key
 // will return the same cached results that were generated by a previous request
 func GenerateBatchHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	stream := streamFormat(r)
+	mediaType := stream
+	if stream == "" {
+		var ok bool
+		if mediaType, ok = negotiate(w, r); !ok {
+			return
+		}
+	}
+
+	rc := requestCache(r)
 	requestKey := createRequestIDKey(r)
-	rq, found, _ := RequestCache.Client.ReadCache(requestKey) //check cache for existing request
+	rq, found, _ := rc.Client.ReadCache(requestKey) //check cache for existing request
 	if found {
-		write(w, []byte(rq), http.StatusOK) //return cached result
+		if stream != "" {
+			streamCached(w, stream, rq)
+			return
+		}
+		respondBatch(w, mediaType, rq) //return cached result
+		return
+	}
+
+	var registered int64
+	count, cc, source := idsToGenerate, c, gen.BatchFunc(gen.GenerateDUIDBatch)
+	if t := requestTenant(r); t != nil {
+		// tenants generate from their block of the shared space into
+		// their own namespace and may not exceed their quota
+		cc, source = rc, tenant.Batch(RequestCache.Client, t.Name)
+		reserved, ok := reserveDevices(w, t, count)
+		if !ok {
+			return
+		}
+		// whatever isn't registered is given back however the request ends
+		defer func() { tenant.ReleaseDevices(RequestCache.Client, t.Name, reserved-registered) }()
+		count = reserved
+
+		b, ok := tenantBlock(w, t)
+		if !ok {
+			return
+		}
+		if b.Exhausted {
+			errorMessage := fmt.Sprintf("tenant %q has used up its block %s-%s", t.Name, b.From, b.To)
+			writeProblem(w, errorMessage, http.StatusForbidden)
+			return
+		}
+		if b.Remaining < count {
+			count = b.Remaining
+		}
+	}
+
+	count, settle, ok := reserveDaily(w, r, count)
+	if !ok {
 		return
 	}
 
-	data :=  runGenerator(idsToGenerate) // Generate the  DevEUIs
+	var data string
+	if stream != "" {
+		data = streamGenerator(w, r, stream, count, cc, source)
+	} else {
+		data = generateForRequest(count, cc, source) // Generate the  DevEUIs
+	}
+	registered = registeredCount(data)
+	settle(registered)
+
 	// store generated results temporarily
 	// in case of multiple requests
-	RequestCache.Client.StoreDUIDGenResponse(models.ApiResponseCacheObject{Key: requestKey, Response: data, Timeout: cacheDuration})
-	write(w, []byte(data), http.StatusOK)
+	rc.Client.StoreDUIDGenResponse(models.ApiResponseCacheObject{Key: requestKey, Response: data, Timeout: cacheDuration})
+	if stream == "" {
+		respondBatch(w, mediaType, data)
+	}
+}
+
+// the device found for a shortcode
+type deviceLookup struct {
+	DevEUI string `json:"deveui"`
 }
 
 // LookupShortcodeHTTPHandler : The handler responsible for device lookup
 // supply a 5 digit shortcode - returns the full device id
 func LookupShortcodeHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	mediaType, ok := negotiate(w, r)
+	if !ok {
+		return
+	}
+
 	shortCode := chi.URLParam(r, "shortcode")
 	if validShortcode := shortcodeValidator(w, shortCode); !validShortcode {
 		return
 	}
 
-	fullDeviceID, found, _ := RequestCache.Client.ReadCache(shortCode) // check if shotrcode exists
+	fullDeviceID, found, _ := requestCache(r).Client.ReadCache(shortCode) // check if shotrcode exists
 	if !found {
 		errorMessage := fmt.Sprintf("shortcode - %v is Not Found", shortCode)
-		write(w, toJSON("error", errorMessage), http.StatusUnprocessableEntity)
+		writeProblem(w, errorMessage, http.StatusUnprocessableEntity)
 		return
 	}
 
 	// return the found device ID to the user
-	write(w, toJSON("deveui", fullDeviceID), http.StatusOK)
+	respond(w, mediaType, deviceLookup{DevEUI: fullDeviceID}, http.StatusOK)
+}
+
+// StatsHTTPHandler : reports how much of the shortcode space
+// has been issued, skipped and remains
+func StatsHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	mediaType, ok := negotiate(w, r)
+	if !ok {
+		return
+	}
+
+	// tenants only see how far they have got through their block
+	if t := requestTenant(r); t != nil {
+		if b, ok := tenantBlock(w, t); ok {
+			respond(w, mediaType, b, http.StatusOK)
+		}
+		return
+	}
+
+	stats, err := gen.Stats(requestCache(r).Client)
+	if err != nil {
+		writeProblem(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respond(w, mediaType, stats, http.StatusOK)
 }
 
 // StatusHTTPHandler : basic endpoint to signal api is ok
+// and whether devices are being registered - the breaker of every registrar
 func StatusHTTPHandler(w http.ResponseWriter, r *http.Request) {
-	write(w, toJSON("status", "API is up"), http.StatusOK)
+	breakers := map[string]breaker.Stats{}
+	for _, reg := range allRegistrars() {
+		breakers[reg.Name] = reg.Breaker.Stats()
+	}
+	data, _ := json.Marshal(map[string]interface{}{"status": "API is up", "registrars": breakers})
+	write(w, data, http.StatusOK)
 }
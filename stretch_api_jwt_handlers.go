@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/David-solly/mxbcode/pkg/apikey"
+	"github.com/David-solly/mxbcode/pkg/jwt"
+	"github.com/David-solly/mxbcode/pkg/models"
+	"github.com/David-solly/mxbcode/pkg/tenant"
+)
+
+var (
+	// verifies bearer tokens from the identity provider
+	// tokens are not accepted while it is nil
+	jwtVerifier *jwt.Verifier
+
+	// claims naming the tenant and the scopes of a token
+	jwtTenantClaim = "tenant"
+	jwtScopeClaim  = "scope"
+)
+
+// configure bearer token verification from the commandline
+// keysFile is a JWKS or PEM public key, secretFile an HS256 secret
+func startJWT(keysFile, secretFile, issuer, audience, tenantClaim, scopeClaim string) error {
+	if keysFile == "" && secretFile == "" {
+		return nil
+	}
+
+	list := []jwt.Key{}
+	if keysFile != "" {
+		k, err := jwt.LoadKeys(keysFile)
+		if err != nil {
+			return err
+		}
+		list = append(list, k...)
+	}
+	if secretFile != "" {
+		k, err := jwt.LoadSecret(secretFile)
+		if err != nil {
+			return err
+		}
+		list = append(list, k)
+	}
+
+	v, err := jwt.NewVerifier(list, issuer, audience)
+	if err != nil {
+		return err
+	}
+	jwtVerifier = v
+	if tenantClaim != "" {
+		jwtTenantClaim = tenantClaim
+	}
+	if scopeClaim != "" {
+		jwtScopeClaim = scopeClaim
+	}
+	return nil
+}
+
+// BearerTokenMiddleware : identifies the caller from a JWT in the
+// `Authorization: Bearer` header once token keys are configured.
+// The tenant and scope claims are held to the same rules as API keys -
+// a token naming a tenant without scopes may generate and look up.
+// Opaque bearer tokens such as the export token are passed on untouched
+func BearerTokenMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if jwtVerifier == nil || strings.Count(token, ".") != 2 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if requestAPIKey(r) != nil || requestTenant(r) != nil {
+			writeProblem(w, "send either an API key or a bearer token", http.StatusBadRequest)
+			return
+		}
+
+		claims, err := jwtVerifier.Verify(token)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			writeProblem(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		ctx := r.Context()
+		scopes := []string{}
+		for _, s := range claims.Strings(jwtScopeClaim) {
+			// identity providers grant scopes of other services too
+			if _, err := apikey.ParseScopes(s); err == nil {
+				scopes = append(scopes, s)
+			}
+		}
+
+		if name := claims.String(jwtTenantClaim); name != "" {
+			t, err := tenant.Get(RequestCache.Client, name)
+			if err != nil {
+				writeProblem(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if t == nil {
+				errorMessage := fmt.Sprintf("tenant %q does not exist", name)
+				writeProblem(w, errorMessage, http.StatusForbidden)
+				return
+			}
+			if len(scopes) == 0 {
+				scopes = tenantScopes
+			}
+			ctx = context.WithValue(ctx, tenantContextKey, t)
+		}
+
+		k := &models.APIKey{ID: "jwt:" + claims.String("sub"), Name: claims.String("sub"), Scopes: scopes}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, apiKeyContextKey, k)))
+	})
+}
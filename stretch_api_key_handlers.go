@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/David-solly/mxbcode/pkg/keys"
+	"github.com/David-solly/mxbcode/pkg/models"
+	"github.com/go-chi/chi"
+)
+
+// ExportKeysAuthMiddleware : only the configured export token may reach key material
+// denied attempts are written to the audit trail along with the exports
+func ExportKeysAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if keyVault == nil || exportTokenHash == "" {
+			writeProblem(w, "key export is not enabled", http.StatusServiceUnavailable)
+			return
+		}
+
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !exportAllowed(token) {
+			keys.Audit(keyVault.Client, models.KeyAudit{Action: "denied", Actor: "anonymous", Remote: r.RemoteAddr, DevEUI: strings.ToUpper(chi.URLParam(r, "device"))})
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeProblem(w, "a valid export token is required", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ExportKeysHTTPHandler : the unsealed root keys of a device
+// every call is audited
+func ExportKeysHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	device := chi.URLParam(r, "device")
+	if !deviceIDPattern.MatchString(device) {
+		errorMessage := fmt.Sprintf("invalid DevEUI - %v", device)
+		writeProblem(w, errorMessage, http.StatusUnprocessableEntity)
+		return
+	}
+
+	k, err := keyVault.Export(device, "token:"+exportTokenHash[:8], r.RemoteAddr)
+	if err != nil {
+		writeProblem(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if k == nil {
+		errorMessage := fmt.Sprintf("no keys are held for device - %v", device)
+		writeProblem(w, errorMessage, http.StatusNotFound)
+		return
+	}
+
+	data, _ := json.Marshal(k)
+	write(w, data, http.StatusOK)
+}
+
+// WrapKeysHTTPHandler : a manifest of root keys wrapped under the named KEK
+// `?deveui=` may be repeated to pick devices - every device by default
+func WrapKeysHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	if *kekD == "" {
+		writeProblem(w, "no KEK directory is configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	label := chi.URLParam(r, "label")
+	path, err := keys.KEKFile(*kekD, label)
+	if err != nil {
+		writeProblem(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	kek, err := keys.LoadKEK(path)
+	if err != nil {
+		errorMessage := fmt.Sprintf("KEK - %v is not available", label)
+		writeProblem(w, errorMessage, http.StatusNotFound)
+		return
+	}
+
+	m, err := keyVault.WrapManifest(r.URL.Query()["deveui"], kek, label, "token:"+exportTokenHash[:8], r.RemoteAddr)
+	if err != nil {
+		writeProblem(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	data, _ := json.Marshal(m)
+	write(w, data, http.StatusOK)
+}
+
+// KeyAuditHTTPHandler : the audit trail of key exports oldest first
+func KeyAuditHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	mediaType, ok := negotiate(w, r)
+	if !ok {
+		return
+	}
+
+	list, err := keys.AuditLog(keyVault.Client)
+	if err != nil {
+		writeProblem(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respond(w, mediaType, list, http.StatusOK)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/David-solly/mxbcode/pkg/label"
+	"github.com/go-chi/chi"
+)
+
+// media type of ZPL print jobs
+const zplContentType = "application/x-zpl"
+
+// label sheet request body
+// a batch from `/generate` can be posted as it is
+type labelRequest struct {
+	Template   string          `json:"template,omitempty"`
+	Sheet      *label.Template `json:"sheet,omitempty"`
+	DevEUIs    []string        `json:"deveuis,omitempty"`
+	ShortCodes []string        `json:"shortcodes,omitempty"`
+}
+
+// LabelsHTTPHandler : printable label sheets of the posted devices
+// `labels.pdf` returns every sheet in one document
+// `labels.svg` returns the sheet picked with `?page=` - the first by default
+// the number of sheets is sent in the X-Label-Pages header
+func LabelsHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	lr := labelRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&lr); err != nil {
+		writeProblem(w, "invalid request body - "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tmpl, err := lr.template()
+	if err != nil {
+		writeProblem(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	labels, err := deviceLabels(requestCache(r).Client, append(lr.DevEUIs, lr.ShortCodes...))
+	if err != nil {
+		writeProblem(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	format := "pdf"
+	if strings.HasSuffix(r.URL.Path, ".svg") {
+		format = "svg"
+	}
+	docs, contentType, err := renderLabels(tmpl, labels, format)
+	if err != nil {
+		writeProblem(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	pages := tmpl.Pages(len(labels))
+	page := 1
+	if q := r.URL.Query().Get("page"); q != "" && format == "svg" {
+		n, err := strconv.Atoi(q)
+		if err != nil || n < 1 || n > pages {
+			writeProblem(w, fmt.Sprintf("invalid page - %v of %d", q, pages), http.StatusBadRequest)
+			return
+		}
+		page = n
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Label-Pages", strconv.Itoa(pages))
+	w.WriteHeader(http.StatusOK)
+	w.Write(docs[page-1])
+}
+
+// the sheet the labels are laid out on
+// a custom sheet wins over a named template - a4 by default
+func (lr labelRequest) template() (label.Template, error) {
+	if lr.Sheet != nil {
+		return *lr.Sheet, lr.Sheet.Validate()
+	}
+	if lr.Template == "" {
+		return label.TemplateFor("a4")
+	}
+	return label.TemplateFor(lr.Template)
+}
+
+// ZPLLabelsHTTPHandler : one ZPL job labelling the posted devices
+// for a Zebra thermal printer - the body is the same as `labels.pdf`
+func ZPLLabelsHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	lr := labelRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&lr); err != nil {
+		writeProblem(w, "invalid request body - "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	job, err := deviceZPL(requestCache(r).Client, zplLayout, append(lr.DevEUIs, lr.ShortCodes...))
+	if err != nil {
+		writeProblem(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	writeZPL(w, job)
+}
+
+// DeviceZPLHTTPHandler : the ZPL label of a single device
+func DeviceZPLHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	deveui, ok := resolveDevice(w, chi.URLParam(r, "device"))
+	if !ok {
+		return
+	}
+
+	job, err := deviceZPL(RequestCache.Client, zplLayout, []string{deveui})
+	if err != nil {
+		writeProblem(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	writeZPL(w, job)
+}
+
+func writeZPL(w http.ResponseWriter, job []byte) {
+	w.Header().Set("Content-Type", zplContentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(job)
+}
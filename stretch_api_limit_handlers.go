@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/David-solly/mxbcode/pkg/models"
+	"github.com/David-solly/mxbcode/pkg/ratelimit"
+	"github.com/go-chi/chi/middleware"
+)
+
+// request context key of requests already counted against their address
+const addressLimitedContextKey contextKey = "address-limited"
+
+var (
+	// token buckets of every client
+	// requests are not limited while it is nil
+	rateLimit *ratelimit.Bucket
+
+	// DevEUIs each client may generate a day
+	// unlimited while it is nil
+	dailyQuota *ratelimit.Quota
+)
+
+// configure rate limits from the commandline - 0 leaves a limit off
+// buckets and quotas live in the request store so replicas share them on redis
+func startLimits(rate float64, burst, quota int64) error {
+	if rate > 0 {
+		b, err := ratelimit.NewBucket(RequestCache.Client, rate, burst)
+		if err != nil {
+			return err
+		}
+		rateLimit = b
+	}
+	if quota > 0 {
+		q, err := ratelimit.NewQuota(RequestCache.Client, quota)
+		if err != nil {
+			return err
+		}
+		dailyQuota = q
+	}
+	return nil
+}
+
+// the client a request is counted against
+// its access key or token, its tenant or else its address
+func requestClient(r *http.Request) string {
+	if k := requestAPIKey(r); k != nil {
+		return "key:" + k.ID
+	}
+	if t := requestTenant(r); t != nil {
+		return "tenant:" + t.Name
+	}
+	return addressClient(r)
+}
+
+// the address a request is counted against
+// forwarding headers only count from trusted proxies
+func addressClient(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// whether a request carries a key, token or client certificate to authenticate
+func hasCredentials(r *http.Request) bool {
+	return r.Header.Get("X-API-Key") != "" || r.Header.Get("Authorization") != "" ||
+		(r.TLS != nil && len(r.TLS.VerifiedChains) > 0)
+}
+
+// AddressRateLimitMiddleware : limits by address ahead of authentication.
+// Requests without credentials take a token from the bucket of their
+// address here. Failed logins have a bucket of their own per address -
+// requests with credentials are held while it is empty and take a token
+// once refused with 401. Guessing keys is throttled before any key is
+// looked up while valid keys behind the same address aren't held up by
+// anonymous traffic
+func AddressRateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rateLimit == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		client := addressClient(r)
+		if !hasCredentials(r) {
+			if takeToken(w, client) {
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), addressLimitedContextKey, true)))
+			}
+			return
+		}
+
+		failed := "auth:" + client
+		if ok, wait := rateLimit.Peek(failed); !ok {
+			tooManyRequests(w, wait, rateLimitExceeded())
+			return
+		}
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+		if ww.Status() == http.StatusUnauthorized {
+			rateLimit.Take(failed)
+		}
+	})
+}
+
+// RateLimitMiddleware : takes a token from the bucket of the client
+// an empty bucket is refused with 429 until the next token is due.
+// Requests counted by address ahead of authentication aren't counted again
+func RateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rateLimit == nil || r.Context().Value(addressLimitedContextKey) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if takeToken(w, requestClient(r)) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// takes a token from the bucket of client
+// writes a 429 or 503 and returns false when none is taken
+func takeToken(w http.ResponseWriter, client string) bool {
+	ok, wait, err := rateLimit.Take(client)
+	if err != nil {
+		writeProblem(w, err.Error(), http.StatusServiceUnavailable)
+		return false
+	}
+	if !ok {
+		tooManyRequests(w, wait, rateLimitExceeded())
+		return false
+	}
+	return true
+}
+
+func rateLimitExceeded() string {
+	return fmt.Sprintf("rate limit of %v requests a second exceeded", rateLimit.Rate)
+}
+
+// reserves up to count devices of the daily quota of the client.
+// The count granted is returned with a func settling the reservation
+// with the devices the batch registered - the rest are given back
+// to the day they were taken from.
+// Writes a 429 when the quota is used up
+func reserveDaily(w http.ResponseWriter, r *http.Request, count int64) (int64, func(registered int64), bool) {
+	if dailyQuota == nil {
+		return count, func(int64) {}, true
+	}
+
+	client := requestClient(r)
+	granted, day, wait, err := dailyQuota.Reserve(client, count)
+	if err != nil {
+		writeProblem(w, err.Error(), http.StatusServiceUnavailable)
+		return 0, nil, false
+	}
+	if granted == 0 {
+		errorMessage := fmt.Sprintf("daily quota of %d devices used up", dailyQuota.Limit)
+		tooManyRequests(w, wait, errorMessage)
+		return 0, nil, false
+	}
+
+	settle := func(registered int64) {
+		dailyQuota.Refund(client, day, granted-registered)
+	}
+	return granted, settle, true
+}
+
+// the devices registered by a generated batch
+func registeredCount(data string) int64 {
+	registered := models.RegisteredDevEUIList{}
+	json.Unmarshal([]byte(data), &registered)
+	return int64(len(registered.DevEUIs))
+}
+
+// writes a 429 telling the client when to try again
+// Retry-After is in whole seconds rounded up
+func tooManyRequests(w http.ResponseWriter, wait time.Duration, detail string) {
+	seconds := int64(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+	writeProblem(w, detail, http.StatusTooManyRequests)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+
+	"github.com/David-solly/mxbcode/pkg/breaker"
+	"github.com/David-solly/mxbcode/pkg/concurrency"
+	"github.com/David-solly/mxbcode/pkg/registrar"
+)
+
+// the state of a registrar when metrics are read
+type registrarSample struct {
+	name    string
+	limiter concurrency.Stats
+	breaker breaker.Stats
+}
+
+// a gauge or counter of every registrar
+type registrarMetric struct {
+	name, kind, help string
+	value            func(s registrarSample) float64
+}
+
+var registrarMetrics = []registrarMetric{
+	{"mxbcode_registrar_concurrency_limit", "gauge", "Registration requests currently allowed in flight",
+		func(s registrarSample) float64 { return float64(s.limiter.Limit) }},
+	{"mxbcode_registrar_concurrency_max", "gauge", "Registration requests the limit may grow to",
+		func(s registrarSample) float64 { return float64(s.limiter.Max) }},
+	{"mxbcode_registrar_in_flight", "gauge", "Registration requests waiting on the registrar",
+		func(s registrarSample) float64 { return float64(s.limiter.InFlight) }},
+	{"mxbcode_registrar_backoffs_total", "counter", "Times the limit was cut on 429, 5xx, failures or latency spikes",
+		func(s registrarSample) float64 { return float64(s.limiter.Backoffs) }},
+	{"mxbcode_registrar_latency_seconds", "gauge", "Usual response time of the registrar",
+		func(s registrarSample) float64 { return s.limiter.Latency.Seconds() }},
+	{"mxbcode_registrar_breaker_trips_total", "counter", "Times the breaker opened on the registrar",
+		func(s registrarSample) float64 { return float64(s.breaker.Trips) }},
+}
+
+// every state of the breakers - 1 for the current state
+var breakerStates = []breaker.State{breaker.Closed, breaker.HalfOpen, breaker.Open}
+
+// MetricsHTTPHandler :
+// Metrics of the registration clients in the Prometheus text format
+func MetricsHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	samples := []registrarSample{}
+	for _, reg := range allRegistrars() {
+		samples = append(samples, sampleRegistrar(reg))
+	}
+
+	buf := bytes.Buffer{}
+	for _, m := range registrarMetrics {
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+		for _, s := range samples {
+			fmt.Fprintf(&buf, "%s{registrar=%q} %v\n", m.name, s.name, m.value(s))
+		}
+	}
+
+	name := "mxbcode_registrar_breaker_state"
+	fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s gauge\n", name, "State of the breaker on the registrar", name)
+	for _, s := range samples {
+		for _, state := range breakerStates {
+			value := 0
+			if s.breaker.State == state.String() {
+				value = 1
+			}
+			fmt.Fprintf(&buf, "%s{registrar=%q,state=%q} %d\n", name, s.name, state.String(), value)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+func sampleRegistrar(r *registrar.Registrar) registrarSample {
+	return registrarSample{name: r.Name, limiter: r.Limiter.Stats(), breaker: r.Breaker.Stats()}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/David-solly/mxbcode/pkg/models"
+)
+
+// pool status response
+type poolStatus struct {
+	Available int64 `json:"available"`
+	Size      int64 `json:"size"`
+	LowWater  int64 `json:"low_water"`
+}
+
+// AllocateHTTPHandler : pops already registered devices from the pool
+// `?count=n` for more than one - a short pool returns what it holds.
+// The pool is filled in the shared namespace so tenants are refused
+func AllocateHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	if !sharedNamespaceOnly(w, r, "the device pool") {
+		return
+	}
+	if devicePool == nil {
+		writeProblem(w, "device pool is not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	count := 1
+	if q := r.URL.Query().Get("count"); q != "" {
+		n, err := strconv.Atoi(q)
+		if err != nil {
+			writeProblem(w, "invalid count - "+q, http.StatusBadRequest)
+			return
+		}
+		count = n
+	}
+
+	devices, err := devicePool.Allocate(count)
+	if err != nil {
+		writeProblem(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if len(devices) == 0 {
+		w.Header().Set("Retry-After", "5")
+		writeProblem(w, "device pool is empty - replenishing", http.StatusServiceUnavailable)
+		return
+	}
+
+	data, _ := json.Marshal(models.RegisteredDevEUIList{DevEUIs: devices})
+	write(w, data, http.StatusOK)
+}
+
+// PoolHTTPHandler : how many devices are ready to allocate
+func PoolHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	if !sharedNamespaceOnly(w, r, "the device pool") {
+		return
+	}
+	if devicePool == nil {
+		writeProblem(w, "device pool is not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	available, err := devicePool.Available()
+	if err != nil {
+		writeProblem(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data, _ := json.Marshal(poolStatus{Available: available, Size: devicePool.Size, LowWater: devicePool.LowWater})
+	write(w, data, http.StatusOK)
+}
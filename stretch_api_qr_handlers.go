@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/David-solly/mxbcode/pkg/tr005"
+	"github.com/go-chi/chi"
+)
+
+// pixels per module unless `?scale=` says otherwise
+const defaultQRScale = 8
+
+// onboarding payload response
+type payloadResponse struct {
+	Text string `json:"payload"`
+	tr005.Payload
+}
+
+// PayloadHTTPHandler : the TR005 onboarding payload of a device
+func PayloadHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	deveui, ok := resolveDevice(w, chi.URLParam(r, "device"))
+	if !ok {
+		return
+	}
+
+	p, err := devicePayload(deveui)
+	if err != nil {
+		writeProblem(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	data, _ := json.Marshal(payloadResponse{Text: p.String(), Payload: p})
+	write(w, data, http.StatusOK)
+}
+
+// QRCodeHTTPHandler : the TR005 payload of a device as a QR code
+// `qr.png` or `qr.svg` - `?scale=` sets the pixels per module
+func QRCodeHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	deveui, ok := resolveDevice(w, chi.URLParam(r, "device"))
+	if !ok {
+		return
+	}
+
+	scale := defaultQRScale
+	if q := r.URL.Query().Get("scale"); q != "" {
+		n, err := strconv.Atoi(q)
+		if err != nil || n < 1 || n > 32 {
+			writeProblem(w, "invalid scale - "+q, http.StatusBadRequest)
+			return
+		}
+		scale = n
+	}
+
+	format := "png"
+	if strings.HasSuffix(r.URL.Path, ".svg") {
+		format = "svg"
+	}
+	data, contentType, err := deviceQR(deveui, format, scale)
+	if err != nil {
+		writeProblem(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
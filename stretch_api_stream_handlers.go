@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/David-solly/mxbcode/pkg/cache"
+	gen "github.com/David-solly/mxbcode/pkg/generator"
+	"github.com/David-solly/mxbcode/pkg/media"
+	"github.com/David-solly/mxbcode/pkg/models"
+	"github.com/David-solly/mxbcode/pkg/websocket"
+	"github.com/go-chi/chi/middleware"
+)
+
+const sseContentType = "text/event-stream"
+
+// how often progress is sent while a batch streams
+var streamProgressInterval = time.Second
+
+// The format a batch is streamed in - blank when it is not streamed.
+// `?stream=true` is NDJSON unless the client accepts only server sent
+// events, `?stream=sse` is always server sent events
+func streamFormat(r *http.Request) string {
+	switch r.URL.Query().Get("stream") {
+	case "sse":
+		return sseContentType
+	case "true", "1":
+		if strings.HasPrefix(r.Header.Get("Accept"), sseContentType) {
+			return sseContentType
+		}
+		return media.NDJSON
+	}
+	return ""
+}
+
+// requests time out after d unless they stream their response
+// or upgrade to a websocket - a streamed batch lasts as long as
+// registration takes and the event feed as long as the client stays
+func timeoutUnlessStreaming(d time.Duration) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		timed := middleware.Timeout(d)(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if streamFormat(r) != "" || websocket.IsUpgrade(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			timed.ServeHTTP(w, r)
+		})
+	}
+}
+
+// writes batch events as NDJSON lines or server sent events
+// each event is flushed straight to the client
+type eventWriter struct {
+	w   http.ResponseWriter
+	sse bool
+}
+
+func newEventWriter(w http.ResponseWriter, format string) *eventWriter {
+	w.Header().Set("Content-Type", format)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	return &eventWriter{w: w, sse: format == sseContentType}
+}
+
+func (e *eventWriter) send(ev models.BatchEvent) {
+	data, _ := json.Marshal(ev)
+	if e.sse {
+		fmt.Fprintf(e.w, "event: %s\ndata: %s\n\n", ev.Event, data)
+	} else {
+		e.w.Write(append(data, '\n'))
+	}
+	if f, k := e.w.(http.Flusher); k {
+		f.Flush()
+	}
+}
+
+// Generates a batch streaming each device as the provider confirms it
+// with progress every streamProgressInterval. The batch is cancelled when
+// the client goes away. Returns the batch as generated for the request cache
+func streamGenerator(w http.ResponseWriter, r *http.Request, format string, count int64, cc cache.Cache, source gen.BatchFunc) string {
+	ew := newEventWriter(w, format)
+	cancel := make(chan struct{})
+	p := newBatchProgress(count, cancel)
+
+	var data string
+	var err error
+	go func() {
+		_, data, err = generateObservedBatchIDs(count, cc, make(chan bool), source, p)
+		close(p.events)
+	}()
+
+	ticker := time.NewTicker(streamProgressInterval)
+	defer ticker.Stop()
+	gone := r.Context().Done()
+	for events := p.events; events != nil; {
+		select {
+		case ev, open := <-events:
+			if !open {
+				events = nil
+				continue
+			}
+			ew.send(ev)
+		case <-ticker.C:
+			ew.send(models.BatchEvent{Event: eventProgress, Progress: p.snapshot()})
+		case <-gone:
+			close(cancel)
+			gone = nil
+		}
+	}
+
+	if err != nil {
+		ew.send(models.BatchEvent{Event: eventError, Error: err.Error()})
+	}
+	registered := models.RegisteredDevEUIList{}
+	json.Unmarshal([]byte(data), &registered)
+	done := p.snapshot()
+	done.Batch, done.Skipped = registered.Batch, registered.Skipped
+	ew.send(models.BatchEvent{Event: eventDone, Progress: done})
+	return data
+}
+
+// streams a batch already generated for the request
+func streamCached(w http.ResponseWriter, format string, data string) {
+	ew := newEventWriter(w, format)
+	registered := models.RegisteredDevEUIList{}
+	json.Unmarshal([]byte(data), &registered)
+	for _, d := range registered.DevEUIs {
+		ew.send(models.BatchEvent{Event: eventDevice, DevEUI: d, ShortCode: d[len(d)-5:]})
+	}
+	n := int64(len(registered.DevEUIs))
+	ew.send(models.BatchEvent{Event: eventDone, Progress: &models.BatchProgress{Batch: registered.Batch, Requested: n, Registered: n, Skipped: registered.Skipped}})
+}
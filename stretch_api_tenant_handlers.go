@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/David-solly/mxbcode/pkg/cache"
+	"github.com/David-solly/mxbcode/pkg/models"
+	"github.com/David-solly/mxbcode/pkg/tenant"
+	"github.com/go-chi/chi"
+)
+
+type contextKey string
+
+// request context key of the tenant a request is scoped to
+const tenantContextKey contextKey = "tenant"
+
+// TenantPathMiddleware : identifies the tenant from the `/t/{tenant}` prefix
+// an API key belonging to another tenant is forbidden
+func TenantPathMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.ToLower(chi.URLParam(r, "tenant"))
+		if t := requestTenant(r); t != nil && t.Name != name {
+			errorMessage := fmt.Sprintf("API key does not belong to tenant %q", name)
+			writeProblem(w, errorMessage, http.StatusForbidden)
+			return
+		}
+
+		t, err := tenant.Get(RequestCache.Client, name)
+		if err != nil {
+			writeProblem(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if t == nil {
+			errorMessage := fmt.Sprintf("tenant %q does not exist", name)
+			writeProblem(w, errorMessage, http.StatusNotFound)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tenantContextKey, t)))
+	})
+}
+
+// the tenant a request is scoped to - nil for the shared namespace
+func requestTenant(r *http.Request) *models.Tenant {
+	t, _ := r.Context().Value(tenantContextKey).(*models.Tenant)
+	return t
+}
+
+// the store a request reads from and caches responses in
+// tenants only ever see their own namespace
+func requestCache(r *http.Request) cache.Cache {
+	if t := requestTenant(r); t != nil {
+		return cache.Cache{Client: observe(tenant.Namespace(RequestCache.Client, t.Name), t.Name)}
+	}
+	return RequestCache
+}
+
+// up to count devices of what is left of the tenants quota
+func reserveDevices(w http.ResponseWriter, t *models.Tenant, count int64) (int64, bool) {
+	granted, err := tenant.ReserveDevices(RequestCache.Client, *t, count)
+	if err != nil {
+		writeProblem(w, err.Error(), http.StatusServiceUnavailable)
+		return 0, false
+	}
+	if granted == 0 {
+		errorMessage := fmt.Sprintf("tenant %q has used its quota of %d devices", t.Name, t.Quota)
+		writeProblem(w, errorMessage, http.StatusForbidden)
+		return 0, false
+	}
+	return granted, true
+}
+
+// the block of the shared space the tenant draws from
+func tenantBlock(w http.ResponseWriter, t *models.Tenant) (*models.Block, bool) {
+	b, err := tenant.Block(RequestCache.Client, t.Name)
+	if err != nil {
+		writeProblem(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if b == nil {
+		errorMessage := fmt.Sprintf("tenant %q has no block of shortcodes", t.Name)
+		writeProblem(w, errorMessage, http.StatusInternalServerError)
+		return nil, false
+	}
+	return b, true
+}
+
+// refuses requests scoped to a tenant for what only the shared namespace has
+// rather than serving the tenant devices that are not in its namespace
+func sharedNamespaceOnly(w http.ResponseWriter, r *http.Request, what string) bool {
+	if t := requestTenant(r); t != nil {
+		errorMessage := fmt.Sprintf("%s is not available to tenant %q - only the shared namespace has one", what, t.Name)
+		writeProblem(w, errorMessage, http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// tenant creation request body
+type tenantRequest struct {
+	Name  string `json:"name"`
+	Quota int64  `json:"quota,omitempty"`
+}
+
+// tenant creation response
+// the API key is only ever shown here
+type tenantResponse struct {
+	models.Tenant
+	APIKey string `json:"api_key"`
+}
+
+// CreateTenantHTTPHandler : adds a tenant with its own namespace
+// and returns the API key identifying it
+func CreateTenantHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	req := tenantRequest{}
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	t, key, err := tenant.Create(RequestCache.Client, req.Name, req.Quota)
+	if err != nil {
+		writeProblem(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	data, _ := json.Marshal(tenantResponse{Tenant: t, APIKey: key})
+	write(w, data, http.StatusCreated)
+}
+
+// ListTenantsHTTPHandler : every tenant ordered by name
+func ListTenantsHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	mediaType, ok := negotiate(w, r)
+	if !ok {
+		return
+	}
+
+	list, err := tenant.List(RequestCache.Client)
+	if err != nil {
+		writeProblem(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respond(w, mediaType, list, http.StatusOK)
+}
+
+// TenantHTTPHandler : a single tenant
+func TenantHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "tenant")
+	t, err := tenant.Get(RequestCache.Client, name)
+	if err != nil {
+		writeProblem(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if t == nil {
+		errorMessage := fmt.Sprintf("tenant %q does not exist", name)
+		writeProblem(w, errorMessage, http.StatusNotFound)
+		return
+	}
+
+	data, _ := json.Marshal(t)
+	write(w, data, http.StatusOK)
+}
+
+// DeleteTenantHTTPHandler : removes a tenant along with
+// its API keys and every record in its namespace
+func DeleteTenantHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "tenant")
+	if err := tenant.Delete(RequestCache.Client, name); err != nil {
+		writeProblem(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	write(w, toJSON("deleted", strings.ToLower(name)), http.StatusOK)
+}
@@ -1,11 +1,40 @@
 package main
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"image/png"
+	"io/ioutil"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/David-solly/mxbcode/pkg/apikey"
+	"github.com/David-solly/mxbcode/pkg/cache"
+	gen "github.com/David-solly/mxbcode/pkg/generator"
+	"github.com/David-solly/mxbcode/pkg/jwt"
+	"github.com/David-solly/mxbcode/pkg/keys"
+	"github.com/David-solly/mxbcode/pkg/models"
+	"github.com/David-solly/mxbcode/pkg/pool"
+	"github.com/David-solly/mxbcode/pkg/qr"
+	"github.com/David-solly/mxbcode/pkg/ratelimit"
+	"github.com/David-solly/mxbcode/pkg/registry"
+	"github.com/David-solly/mxbcode/pkg/tenant"
+	"github.com/David-solly/mxbcode/pkg/websocket"
 	"github.com/docker/docker/pkg/testutil/assert"
 	"github.com/go-chi/chi"
 )
@@ -26,6 +55,8 @@ func TestStretchAPI(t *testing.T) {
 			{"GET", "/generate/b", "code", 200},
 			{"GET", "/generate/b", "code", 200},
 			{"GET", "/view/0000b", "code", 422},
+			{"GET", "/stats", "code", 200},
+			{"GET", "/stats", "body", "\"remaining\""},
 			{"GET", "/g/20/a", "code", 404},
 		}
 
@@ -82,6 +113,689 @@ func TestStretchApiIdempotency(t *testing.T) {
 
 }
 
+func TestStretchApiReservations(t *testing.T) {
+	reset()
+	defer gen.Release(RequestCache.Client, "F0000")
+	admin := adminKey(t)
+
+	t.Run("TEST reservations", func(t *testing.T) {
+		expected := []struct {
+			method  string
+			url     string
+			body    string
+			section string
+			want    interface{}
+		}{
+			{"POST", "/admin/reservations", `{"from":"F0000","to":"F000F","label":"demo"}`, "code", 201},
+			{"POST", "/admin/reservations", `{"from":"F0008"}`, "code", 422},
+			{"POST", "/admin/reservations", `{"from":`, "code", 400},
+			{"GET", "/admin/reservations", "", "body", `"label":"demo"`},
+			{"POST", "/admin/reservations/F0003/claim", "", "code", 200},
+			{"POST", "/admin/reservations/F0003/claim", "", "body", "already been claimed"},
+			{"GET", "/view/F0003", "", "code", 200},
+			{"POST", "/admin/reservations/F0010/claim", "", "code", 422},
+			{"DELETE", "/admin/reservations/F0004", "", "code", 200},
+			{"DELETE", "/admin/reservations/F0004", "", "code", 404},
+		}
+		for i, test := range expected {
+			t.Run(fmt.Sprintf("#%d: %q:%s", i, test.method, test.url), func(t *testing.T) {
+				response := callHTTPEndpointHandlerWithKey(t, admin, test.method, test.url, test.body)
+				switch test.section {
+				case "code":
+					checkError(t, response.Code, test.want, fmt.Sprintf("%q%q", test.method, test.url))
+				case "body":
+					assert.Contains(t, response.Body.String(), test.want.(string))
+				}
+			})
+		}
+	})
+}
+
+func TestStretchApiBlocks(t *testing.T) {
+	reset()
+	// generation runs against the application cache
+	// share it with the handlers as in server mode
+	defer func(rc cache.Cache) {
+		for _, owner := range []string{"line-1", "line-2"} {
+			c.Client.DeleteValue(gen.BlockKeyPrefix + owner)
+			c.Client.DeleteValue(gen.BlockCounterPrefix + owner)
+		}
+		RequestCache = rc
+	}(RequestCache)
+	RequestCache = c
+	admin := adminKey(t)
+
+	t.Run("TEST blocks", func(t *testing.T) {
+		expected := []struct {
+			method  string
+			url     string
+			body    string
+			section string
+			want    interface{}
+		}{
+			{"POST", "/blocks", `{"owner":"line-1","size":120}`, "code", 201},
+			{"POST", "/blocks", `{"owner":"line-2","from":"D0000","to":"D0004"}`, "code", 201},
+			{"POST", "/blocks", `{"owner":"line-1","size":10}`, "code", 422},
+			{"GET", "/blocks", "", "body", `"owner":"line-2"`},
+			{"GET", "/blocks/line-2", "", "body", `"remaining":5`},
+			{"GET", "/blocks/line-3", "", "code", 404},
+			{"GET", "/blocks/line-1/generate/1", "", "body", "FFF88"},
+			{"GET", "/blocks/line-2/generate/1", "", "body", "D0004"},
+			{"GET", "/blocks/line-2", "", "body", `"exhausted":true`},
+			{"GET", "/blocks/line-2/generate/1", "", "code", 200},
+			{"GET", "/blocks/line-3/generate/1", "", "code", 404},
+		}
+		for i, test := range expected {
+			t.Run(fmt.Sprintf("#%d: %q:%s", i, test.method, test.url), func(t *testing.T) {
+				response := callHTTPEndpointHandlerWithKey(t, admin, test.method, test.url, test.body)
+				switch test.section {
+				case "code":
+					checkError(t, response.Code, test.want, fmt.Sprintf("%q%q", test.method, test.url))
+				case "body":
+					assert.Contains(t, response.Body.String(), test.want.(string))
+				}
+			})
+		}
+	})
+}
+
+func TestStretchApiTenants(t *testing.T) {
+	reset()
+	defer dropTenant(RequestCache.Client, "acme")
+	defer dropTenant(RequestCache.Client, "beta")
+	admin := adminKey(t)
+
+	keys := map[string]string{}
+	for _, body := range []string{`{"name":"acme"}`, `{"name":"beta","quota":3}`} {
+		response := callHTTPEndpointHandlerWithKey(t, admin, "POST", "/admin/tenants", body)
+		assert.Equal(t, response.Code, 201)
+		created := map[string]interface{}{}
+		json.Unmarshal(response.Body.Bytes(), &created)
+		keys[created["name"].(string)] = created["api_key"].(string)
+	}
+
+	// each tenant draws from a block of its own in the shared space
+	acme, _ := tenant.Block(RequestCache.Client, "acme")
+	beta, _ := tenant.Block(RequestCache.Client, "beta")
+	assert.Equal(t, beta.To < acme.From, true)
+
+	t.Run("TEST tenants", func(t *testing.T) {
+		expected := []struct {
+			method  string
+			url     string
+			key     string
+			section string
+			want    interface{}
+		}{
+			{"POST", "/admin/tenants", admin, "code", 400},
+			{"POST", "/admin/tenants", "", "code", 401},
+			{"GET", "/admin/tenants", admin, "body", `"name":"beta","quota":3`},
+			{"GET", "/admin/tenants/acme", admin, "code", 200},
+			{"GET", "/admin/tenants/gamma", admin, "code", 404},
+			{"GET", "/t/acme/generate/1", "", "body", acme.From + `"`},
+			{"GET", "/t/acme/stats", "", "body", `"issued":100`},
+			{"GET", "/t/acme/view/" + acme.From, "", "code", 200},
+			{"GET", "/view/" + acme.From, keys["acme"], "code", 200},
+			{"GET", "/t/acme/view/" + acme.From, keys["acme"], "code", 200},
+			{"GET", "/t/beta/view/" + acme.From, keys["acme"], "code", 403},
+			{"GET", "/view/" + acme.From, keys["beta"], "code", 422},
+			{"GET", "/view/" + acme.From, "", "code", 422},
+			{"GET", "/view/" + acme.From, "unknown", "code", 401},
+			{"GET", "/t/gamma/view/" + acme.From, "", "code", 404},
+			{"GET", "/generate/1", keys["beta"], "body", beta.To + `"`},
+			{"GET", "/t/beta/stats", "", "body", `"issued":3`},
+			// tenant blocks are only generated from through the tenant
+			{"GET", "/blocks/tenant-acme/generate/1", "", "code", 403},
+		}
+		for i, test := range expected {
+			t.Run(fmt.Sprintf("#%d: %q:%s", i, test.method, test.url), func(t *testing.T) {
+				request, err := http.NewRequest(test.method, test.url, strings.NewReader("{"))
+				assert.NilError(t, err)
+				if test.key != "" {
+					request.Header.Set("X-API-Key", test.key)
+				}
+				response := httptest.NewRecorder()
+				rt.ServeHTTP(response, request)
+				switch test.section {
+				case "code":
+					checkError(t, response.Code, test.want, fmt.Sprintf("%q%q", test.method, test.url))
+				case "body":
+					assert.Contains(t, response.Body.String(), test.want.(string))
+				}
+			})
+		}
+	})
+
+	t.Run("TEST tenant quota", func(t *testing.T) {
+		// a different client so the previous response is not replayed
+		request, _ := http.NewRequest("GET", "/generate/2", nil)
+		request.Header.Set("X-API-Key", keys["beta"])
+		request.Header.Set("User-Agent", "quota-test")
+		response := httptest.NewRecorder()
+		rt.ServeHTTP(response, request)
+		assert.Equal(t, response.Code, 403)
+		assert.Contains(t, response.Body.String(), "quota of 3 devices")
+
+		// the refused request gave back what it reserved
+		devices, _, _ := tenant.Namespace(RequestCache.Client, "beta").ReadCache(tenant.DevicesKey)
+		assert.Equal(t, devices, "3")
+	})
+
+	t.Run("TEST tenant blocks are reserved", func(t *testing.T) {
+		response := callHTTPEndpointHandlerWithKey(t, admin, "POST", "/blocks", `{"owner":"tenant-gamma","size":4}`)
+		assert.Equal(t, response.Code, 422)
+		assert.Contains(t, response.Body.String(), "allocated to tenants when they are created")
+	})
+}
+
+func TestStretchApiPool(t *testing.T) {
+	reset()
+	response := callHTTPEndpointHandler(t, "POST", "/allocate")
+	assert.Equal(t, response.Code, 503)
+
+	p, err := pool.New(c.Client, 5, 2, fillPool)
+	assert.NilError(t, err)
+	added, err := p.Replenish()
+	assert.NilError(t, err)
+	assert.Equal(t, added, 5)
+
+	devicePool = p
+	defer func() {
+		devicePool = nil
+		c.Client.DeleteValue(pool.Key)
+	}()
+
+	t.Run("TEST pool refused to tenants", func(t *testing.T) {
+		defer dropTenant(RequestCache.Client, "acme")
+		_, tenantKey, _ := tenant.Create(RequestCache.Client, "acme", 0)
+
+		response := callHTTPEndpointHandlerWithKey(t, tenantKey, "POST", "/allocate", "")
+		assert.Equal(t, response.Code, 403)
+		assert.Equal(t, callHTTPEndpointHandlerWithKey(t, tenantKey, "GET", "/pool", "").Code, 403)
+		available, _ := p.Available()
+		assert.Equal(t, available, int64(5))
+	})
+
+	t.Run("TEST pool", func(t *testing.T) {
+		expected := []struct {
+			method  string
+			url     string
+			section string
+			want    interface{}
+		}{
+			{"GET", "/pool", "body", `{"available":5,"size":5,"low_water":2}`},
+			{"POST", "/allocate", "code", 200},
+			{"POST", "/allocate?count=3", "body", `{"deveuis":["`},
+			{"GET", "/pool", "body", `"available":1`},
+			{"POST", "/allocate?count=3", "code", 200},
+			{"POST", "/allocate", "code", 503},
+			{"POST", "/allocate?count=abc", "code", 400},
+			{"POST", "/allocate?count=0", "code", 422},
+		}
+		for i, test := range expected {
+			t.Run(fmt.Sprintf("#%d: %q:%s", i, test.method, test.url), func(t *testing.T) {
+				response := callHTTPEndpointHandler(t, test.method, test.url)
+				switch test.section {
+				case "code":
+					checkError(t, response.Code, test.want, fmt.Sprintf("%q%q", test.method, test.url))
+				case "body":
+					assert.Contains(t, response.Body.String(), test.want.(string))
+				}
+			})
+		}
+	})
+}
+
+func TestStretchApiClaims(t *testing.T) {
+	reset()
+	var deveui string
+	t.Run("CLAIM serial", func(t *testing.T) {
+		response := callHTTPEndpointHandlerWithBody(t, "POST", "/claims", `{"serial":"LINE1-0001"}`)
+		assert.Equal(t, response.Code, 201)
+		cl := models.Claim{}
+		assert.NilError(t, json.Unmarshal(response.Body.Bytes(), &cl))
+		assert.Equal(t, cl.Serial, "LINE1-0001")
+		assert.Equal(t, len(cl.DevEUI), 16)
+		deveui = cl.DevEUI
+
+		// the same serial never draws a second device
+		response = callHTTPEndpointHandlerWithBody(t, "POST", "/claims", `{"serial":"LINE1-0001"}`)
+		assert.Equal(t, response.Code, 200)
+		assert.Contains(t, response.Body.String(), deveui)
+	})
+
+	t.Run("CLAIM refused to tenants", func(t *testing.T) {
+		defer dropTenant(RequestCache.Client, "acme")
+		_, tenantKey, _ := tenant.Create(RequestCache.Client, "acme", 0)
+
+		response := callHTTPEndpointHandlerWithKey(t, tenantKey, "POST", "/claims", `{"serial":"LINE1-0009"}`)
+		assert.Equal(t, response.Code, 403)
+		assert.Equal(t, callHTTPEndpointHandlerWithKey(t, tenantKey, "GET", "/claims/LINE1-0001", "").Code, 403)
+		assert.Equal(t, callHTTPEndpointHandlerWithKey(t, tenantKey, "GET", "/devices/"+deveui+"/claim", "").Code, 403)
+		assert.Equal(t, callHTTPEndpointHandler(t, "GET", "/claims/LINE1-0009").Code, 404)
+	})
+
+	t.Run("TEST claims", func(t *testing.T) {
+		expected := []struct {
+			method  string
+			url     string
+			body    string
+			section string
+			want    interface{}
+		}{
+			{"GET", "/claims/LINE1-0001", "", "body", deveui},
+			{"GET", "/claims/LINE1-0002", "", "code", 404},
+			{"GET", "/devices/" + deveui + "/claim", "", "body", `"serial":"LINE1-0001"`},
+			{"GET", "/devices/" + strings.ToLower(deveui[11:]) + "/claim", "", "body", `"serial":"LINE1-0001"`},
+			{"GET", "/devices/FFFFFFFFFFFFFFFF/claim", "", "code", 404},
+			{"GET", "/devices/XYZ/claim", "", "code", 422},
+			{"POST", "/claims", `{"serial":"LINE1 0002"}`, "code", 422},
+			{"POST", "/claims", `{"serial":`, "code", 400},
+		}
+		for i, test := range expected {
+			t.Run(fmt.Sprintf("#%d: %q:%s", i, test.method, test.url), func(t *testing.T) {
+				response := callHTTPEndpointHandlerWithBody(t, test.method, test.url, test.body)
+				switch test.section {
+				case "code":
+					checkError(t, response.Code, test.want, fmt.Sprintf("%q%q", test.method, test.url))
+				case "body":
+					assert.Contains(t, response.Body.String(), test.want.(string))
+				}
+			})
+		}
+	})
+}
+
+func TestStretchApiKeys(t *testing.T) {
+	reset()
+	admin := adminKey(t)
+	response := callHTTPEndpointHandlerWithKey(t, admin, "GET", "/admin/keys/audit", "")
+	assert.Equal(t, response.Code, 503)
+
+	master, _ := keys.ParseMasterKey("000102030405060708090A0B0C0D0E0F")
+	v, err := keys.NewVault(c.Client, master)
+	assert.NilError(t, err)
+	keyVault, provisioning, exportTokenHash = v, true, tenant.HashAPIKey("export-secret")
+	dir, _ := ioutil.TempDir("", "kek")
+	ioutil.WriteFile(filepath.Join(dir, "js1.kek"), []byte("00112233445566778899AABBCCDDEEFF\n"), 0600)
+	*kekD = dir
+	defer func() {
+		keyVault, provisioning, exportTokenHash, *kekD = nil, false, "", ""
+		os.RemoveAll(dir)
+	}()
+
+	before, _ := keys.AuditLog(c.Client)
+
+	// claimed devices are registered with fresh root keys
+	response = callHTTPEndpointHandlerWithBody(t, "POST", "/claims", `{"serial":"KEYS-0001"}`)
+	assert.Equal(t, response.Code, 201)
+	cl := models.Claim{}
+	json.Unmarshal(response.Body.Bytes(), &cl)
+
+	t.Run("TEST keys", func(t *testing.T) {
+		expected := []struct {
+			url     string
+			token   string
+			section string
+			want    interface{}
+		}{
+			{"/devices/" + cl.DevEUI + "/keys", "", "code", 401},
+			{"/devices/" + cl.DevEUI + "/keys", "wrong", "code", 401},
+			{"/devices/" + cl.DevEUI + "/keys", "export-secret", "body", `"appkey":"`},
+			{"/devices/FFFFFFFFFFFFFFFF/keys", "export-secret", "code", 404},
+			{"/devices/FFFFF/keys", "export-secret", "code", 422},
+			{"/view/" + cl.ShortCode, "", "body", `{"deveui":"` + cl.DevEUI + `"}`},
+			{"/keys/export/js1?deveui=" + cl.DevEUI, "", "code", 401},
+			{"/keys/export/js1?deveui=" + cl.DevEUI, "export-secret", "body", `"kek_label":"js1","algorithm":"AES-KW-RFC3394"`},
+			{"/keys/export/js2", "export-secret", "code", 404},
+			{"/keys/export/js1?deveui=FFFFFFFFFFFFFFFF", "export-secret", "code", 422},
+			{"/admin/keys/audit", "", "code", 401},
+			{"/admin/keys/audit", "export-secret", "body", `"action":"export","actor":"token:`},
+		}
+		for i, test := range expected {
+			t.Run(fmt.Sprintf("#%d: %q", i, test.url), func(t *testing.T) {
+				request, err := http.NewRequest("GET", test.url, nil)
+				assert.NilError(t, err)
+				if test.token != "" {
+					request.Header.Set("Authorization", "Bearer "+test.token)
+				}
+				// the audit log also needs an admin key
+				if strings.HasPrefix(test.url, "/admin") {
+					request.Header.Set("X-API-Key", admin)
+				}
+				response := httptest.NewRecorder()
+				rt.ServeHTTP(response, request)
+				switch test.section {
+				case "code":
+					checkError(t, response.Code, test.want, test.url)
+				case "body":
+					assert.Contains(t, response.Body.String(), test.want.(string))
+				}
+			})
+		}
+	})
+
+	log, err := keys.AuditLog(c.Client)
+	assert.NilError(t, err)
+	log = log[len(before):]
+	assert.Equal(t, len(log), 7)
+	assert.Equal(t, log[0].Action, "denied")
+	assert.Equal(t, log[5].Action, "wrap:js1")
+}
+
+func TestStretchApiQR(t *testing.T) {
+	reset()
+	response := callHTTPEndpointHandlerWithBody(t, "POST", "/claims", `{"serial":"QR-0001"}`)
+	assert.Equal(t, response.Code, 201)
+	cl := models.Claim{}
+	json.Unmarshal(response.Body.Bytes(), &cl)
+
+	payload := "LW:D0:0000000000000000:" + cl.DevEUI + ":00000000:SQR-0001"
+	expected := []struct {
+		url         string
+		code        int
+		contentType string
+		contains    string
+	}{
+		{"/devices/" + cl.DevEUI + "/tr005", 200, "application/json", `"payload":"` + payload + `"`},
+		{"/devices/" + cl.ShortCode + "/tr005", 200, "application/json", `"serial":"QR-0001"`},
+		{"/devices/" + cl.DevEUI + "/qr.png", 200, "image/png", "\x89PNG"},
+		{"/devices/" + cl.DevEUI + "/qr.svg?scale=2", 200, "image/svg+xml", "<svg"},
+		{"/devices/" + cl.DevEUI + "/qr.svg?scale=0", 400, "", ""},
+		{"/devices/FFFFE/qr.png", 404, "", ""},
+		{"/devices/XYZ/qr.png", 422, "", ""},
+	}
+	for i, test := range expected {
+		t.Run(fmt.Sprintf("#%d: %q", i, test.url), func(t *testing.T) {
+			response := callHTTPEndpointHandler(t, "GET", test.url)
+			checkError(t, response.Code, test.code, test.url)
+			if test.contentType != "" {
+				assert.Equal(t, response.Header().Get("Content-Type"), test.contentType)
+			}
+			assert.Contains(t, response.Body.String(), test.contains)
+		})
+	}
+
+	// the png holds a scannable sized symbol with its quiet zone
+	response = callHTTPEndpointHandler(t, "GET", "/devices/"+cl.DevEUI+"/qr.png?scale=1")
+	img, err := png.Decode(response.Body)
+	assert.NilError(t, err)
+	code, _ := qr.Encode(payload, qr.Medium)
+	assert.Equal(t, img.Bounds().Dx(), code.Size+qr.QuietZone*2)
+}
+
+func TestStretchApiLabels(t *testing.T) {
+	reset()
+	RequestCache.Client.StoreDUID(models.DevEUI{ShortCode: "0A0A0", DevEUI: "000000000000A0A0"})
+	RequestCache.Client.StoreDUID(models.DevEUI{ShortCode: "0B0B0", DevEUI: "000000000000B0B0"})
+
+	// a batch as returned by /generate and a list of shortcodes
+	batch := `{"deveuis":["000000000000A0A0","000000000000B0B0"]}`
+	sheet := `{"sheet":{"name":"roll","page_width":100,"page_height":50,"columns":1,"rows":1},"shortcodes":["0a0a0","0b0b0"]}`
+	expected := []struct {
+		url         string
+		body        string
+		code        int
+		contentType string
+		pages       string
+		contains    string
+	}{
+		{"/labels.pdf", batch, 200, "application/pdf", "1", "(0A0A0) Tj"},
+		{"/labels.svg", `{"template":"letter","shortcodes":["0B0B0"]}`, 200, "image/svg+xml", "1", `width="215.9mm"`},
+		{"/labels.svg?page=2", sheet, 200, "image/svg+xml", "2", ">0B0B0</text>"},
+		{"/labels.svg?page=3", sheet, 400, "", "", "invalid page"},
+		{"/labels.pdf", `{"template":"a3","shortcodes":["0A0A0"]}`, 400, "", "", "unknown label template"},
+		{"/labels.pdf", `{"shortcodes":["FFFFE"]}`, 422, "", "", "Not Found"},
+		{"/labels.pdf", `{}`, 422, "", "", "no devices"},
+		{"/labels.pdf", `[`, 400, "", "", "invalid request body"},
+	}
+	for i, test := range expected {
+		t.Run(fmt.Sprintf("#%d: %q", i, test.url), func(t *testing.T) {
+			response := callHTTPEndpointHandlerWithBody(t, "POST", test.url, test.body)
+			checkError(t, response.Code, test.code, test.url)
+			if test.contentType != "" {
+				assert.Equal(t, response.Header().Get("Content-Type"), test.contentType)
+				assert.Equal(t, response.Header().Get("X-Label-Pages"), test.pages)
+			}
+			assert.Contains(t, response.Body.String(), test.contains)
+		})
+	}
+}
+
+func TestStretchApiZPL(t *testing.T) {
+	reset()
+	RequestCache.Client.StoreDUID(models.DevEUI{ShortCode: "0C0C0", DevEUI: "000000000000C0C0"})
+
+	expected := []struct {
+		method   string
+		url      string
+		body     string
+		code     int
+		contains string
+	}{
+		{"GET", "/devices/000000000000C0C0/label.zpl", "", 200, "^FD0C0C0^FS"},
+		{"GET", "/devices/0c0c0/label.zpl", "", 200, "^FDMA,LW:D0:0000000000000000:000000000000C0C0:00000000^FS"},
+		{"GET", "/devices/FFFFE/label.zpl", "", 404, "Not Found"},
+		{"POST", "/labels.zpl", `{"deveuis":["000000000000C0C0"],"shortcodes":["0C0C0"]}`, 200, "^XZ\n^XA"},
+		{"POST", "/labels.zpl", `{"shortcodes":["FFFFE"]}`, 422, "Not Found"},
+		{"POST", "/labels.zpl", `[`, 400, "invalid request body"},
+	}
+	for i, test := range expected {
+		t.Run(fmt.Sprintf("#%d: %q", i, test.url), func(t *testing.T) {
+			response := callHTTPEndpointHandlerWithBody(t, test.method, test.url, test.body)
+			checkError(t, response.Code, test.code, test.url)
+			if test.code == 200 {
+				assert.Equal(t, response.Header().Get("Content-Type"), zplContentType)
+			}
+			assert.Contains(t, response.Body.String(), test.contains)
+		})
+	}
+}
+
+func TestStretchApiExport(t *testing.T) {
+	reset()
+	master, _ := keys.ParseMasterKey("000102030405060708090A0B0C0D0E0F")
+	v, err := keys.NewVault(c.Client, master)
+	assert.NilError(t, err)
+	keyVault, provisioning, exportTokenHash = v, true, tenant.HashAPIKey("export-secret")
+	defer func() {
+		keyVault, provisioning, exportTokenHash = nil, false, ""
+	}()
+	admin := adminKey(t)
+
+	// devices generated through the api are recorded with their batch
+	response := callHTTPEndpointHandlerWithBody(t, "POST", "/claims", `{"serial":"EXPORT-0001"}`)
+	assert.Equal(t, response.Code, 201)
+	cl := models.Claim{}
+	json.Unmarshal(response.Body.Bytes(), &cl)
+	d, _ := registry.Get(RequestCache.Client, cl.DevEUI)
+	assert.Equal(t, d != nil && d.Batch != "", true)
+	k, _ := keyVault.Load(cl.DevEUI)
+
+	expected := []struct {
+		url         string
+		token       string
+		code        int
+		contentType string
+		contains    string
+	}{
+		{"/export?deveui=" + cl.DevEUI, admin, 200, "text/csv", cl.DevEUI + "," + cl.ShortCode + "," + d.Batch},
+		{"/export?format=ndjson&columns=deveui,serial&batch=" + d.Batch, admin, 200, "application/x-ndjson", `{"deveui":"` + cl.DevEUI + `","serial":"EXPORT-0001"}`},
+		{"/export?format=chirpstack&deveui=" + cl.DevEUI, admin, 200, "text/csv", strings.ToLower(cl.DevEUI) + ",0000000000000000,,"},
+		{"/export/keys?format=tts&application_id=line&deveui=" + cl.DevEUI, "export-secret", 200, "application/x-ndjson", `"app_key":{"key":"` + k.AppKey + `"}`},
+		{"/export/keys?format=tts", "", 401, "", ""},
+		{"/export/keys?format=tts", admin, 401, "", "a valid export token is required"},
+		{"/export?format=chirpstack", "", 401, "", "an API key is required"},
+		{"/export?format=chirpstack", "export-secret", 200, "text/csv", strings.ToLower(cl.DevEUI)},
+		{"/export?format=xml", admin, 400, "", "unknown export format"},
+		{"/export?columns=colour", admin, 400, "", "unknown export column"},
+		{"/export?until=tomorrow", admin, 400, "", "invalid time"},
+	}
+	for i, test := range expected {
+		t.Run(fmt.Sprintf("#%d: %q", i, test.url), func(t *testing.T) {
+			request, _ := http.NewRequest("GET", test.url, nil)
+			switch test.token {
+			case "":
+			case admin:
+				request.Header.Set("X-API-Key", admin)
+			default:
+				request.Header.Set("Authorization", "Bearer "+test.token)
+			}
+			response := httptest.NewRecorder()
+			rt.ServeHTTP(response, request)
+			checkError(t, response.Code, test.code, test.url)
+			if test.contentType != "" {
+				assert.Equal(t, response.Header().Get("Content-Type"), test.contentType)
+			}
+			assert.Contains(t, response.Body.String(), test.contains)
+		})
+	}
+
+	// every device exported with keys is audited
+	log, _ := keys.AuditLog(c.Client)
+	exported := false
+	for _, entry := range log {
+		exported = exported || (entry.Action == "export" && entry.DevEUI == cl.DevEUI)
+	}
+	assert.Equal(t, exported, true)
+}
+
+func TestStretchApiNegotiation(t *testing.T) {
+	reset()
+	RequestCache.Client.StoreDUID(models.DevEUI{DevEUI: "00000000000C0DE1", ShortCode: "C0DE1"})
+	defer RequestCache.Client.DeleteValue("C0DE1")
+	gen.Reserve(RequestCache.Client, "F0100", "F0101", "negotiated")
+	defer gen.Release(RequestCache.Client, "F0100")
+	admin := adminKey(t)
+
+	expected := []struct {
+		url         string
+		accept      string
+		code        int
+		contentType string
+		contains    string
+	}{
+		{"/view/C0DE1", "", 200, "application/json", `{"deveui":"00000000000C0DE1"}`},
+		{"/view/C0DE1", "text/csv", 200, "text/csv", "deveui\n00000000000C0DE1\n"},
+		{"/view/C0DE1", "text/xml", 200, "application/xml", "<response><deveui>00000000000C0DE1</deveui></response>"},
+		{"/view/C0DE1", "image/png", 406, "application/problem+json", `"title":"Not Acceptable","status":406`},
+		{"/view/C0DE2", "text/csv", 422, "application/problem+json", `"detail":"shortcode - C0DE2 is Not Found"`},
+		{"/admin/reservations", "application/x-ndjson", 200, "application/x-ndjson", `"from":"F0100","to":"F0101","label":"negotiated"`},
+		{"/admin/reservations", "text/csv;q=0.9, application/xml", 200, "application/xml", "<label>negotiated</label>"},
+		{"/stats", "text/csv", 200, "text/csv", "last,capacity,issued,skipped,remaining,blocked,reserved,allocated\n"},
+		{"/generate/negotiated", "text/csv", 200, "text/csv", "deveui,shortcode,batch\n"},
+		{"/generate/refused", "text/html", 406, "application/problem+json", "application/json, text/csv"},
+		{"/blocks/nobody", "", 404, "application/problem+json", `"type":"about:blank"`},
+	}
+	for i, test := range expected {
+		t.Run(fmt.Sprintf("#%d: %q %q", i, test.url, test.accept), func(t *testing.T) {
+			request, _ := http.NewRequest("GET", test.url, nil)
+			if test.accept != "" {
+				request.Header.Set("Accept", test.accept)
+			}
+			if strings.HasPrefix(test.url, "/admin") {
+				request.Header.Set("X-API-Key", admin)
+			}
+			response := httptest.NewRecorder()
+			rt.ServeHTTP(response, request)
+			checkError(t, response.Code, test.code, test.url)
+			assert.Equal(t, response.Header().Get("Content-Type"), test.contentType)
+			assert.Contains(t, response.Body.String(), test.contains)
+		})
+	}
+
+	// the cached batch is served in whichever type is asked for
+	request, _ := http.NewRequest("GET", "/generate/negotiated", nil)
+	request.Header.Set("Accept", "application/json")
+	response := httptest.NewRecorder()
+	rt.ServeHTTP(response, request)
+	assert.Contains(t, response.Body.String(), `"deveuis":[`)
+}
+
+func TestStretchApiStream(t *testing.T) {
+	reset()
+	interval := streamProgressInterval
+	streamProgressInterval = time.Millisecond
+	defer func() { streamProgressInterval = interval }()
+
+	events := func(body string) []models.BatchEvent {
+		list := []models.BatchEvent{}
+		for _, line := range strings.Split(strings.TrimSpace(body), "\n") {
+			ev := models.BatchEvent{}
+			assert.NilError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev))
+			list = append(list, ev)
+		}
+		return list
+	}
+
+	// requests are told apart by their user agent
+	call := func(url, agent, accept string, ctx context.Context) *httptest.ResponseRecorder {
+		request, _ := http.NewRequest("GET", url, nil)
+		request.Header.Set("User-Agent", agent)
+		request.Header.Set("Accept", accept)
+		response := httptest.NewRecorder()
+		rt.ServeHTTP(response, request.WithContext(ctx))
+		return response
+	}
+
+	t.Run("STREAM ndjson", func(t *testing.T) {
+		response := call("/generate/streamed?stream=true", "stream-ndjson", "", context.Background())
+		assert.Equal(t, response.Code, 200)
+		assert.Equal(t, response.Header().Get("Content-Type"), "application/x-ndjson")
+
+		list := events(response.Body.String())
+		devices, progress := 0, 0
+		for _, ev := range list {
+			switch ev.Event {
+			case "device":
+				devices++
+				assert.Equal(t, ev.ShortCode, ev.DevEUI[11:])
+			case "progress":
+				progress++
+			}
+		}
+		done := list[len(list)-1]
+		assert.Equal(t, done.Event, "done")
+		assert.Equal(t, devices, 100)
+		assert.Equal(t, done.Progress.Registered, int64(100))
+		assert.Equal(t, done.Progress.InFlight, int64(0))
+		assert.Equal(t, done.Progress.Batch != "", true)
+		assert.Equal(t, progress > 0, true)
+
+		// the same request replays the batch
+		response = call("/generate/streamed?stream=true", "stream-ndjson", "", context.Background())
+		replayed := events(response.Body.String())
+		assert.Equal(t, len(replayed), 101)
+		assert.Equal(t, replayed[100].Progress.Batch, done.Progress.Batch)
+	})
+
+	t.Run("STREAM server sent events", func(t *testing.T) {
+		response := call("/generate/streamed-sse?stream=true", "stream-sse", "text/event-stream", context.Background())
+		assert.Equal(t, response.Header().Get("Content-Type"), "text/event-stream")
+		assert.Contains(t, response.Body.String(), "event: device\ndata: {\"event\":\"device\",\"deveui\":\"")
+		assert.Contains(t, response.Body.String(), "event: done\ndata: ")
+	})
+
+	t.Run("STREAM cancelled by the client", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		response := call("/generate/streamed-gone?stream=sse", "stream-gone", "", ctx)
+
+		list := []models.BatchEvent{}
+		for _, line := range strings.Split(response.Body.String(), "\n") {
+			if strings.HasPrefix(line, "data: ") {
+				ev := models.BatchEvent{}
+				json.Unmarshal([]byte(line[6:]), &ev)
+				list = append(list, ev)
+			}
+		}
+		done := list[len(list)-1]
+		assert.Equal(t, done.Event, "done")
+		assert.Equal(t, done.Progress.Cancelled, true)
+		assert.Equal(t, done.Progress.Registered < 100, true)
+	})
+}
+
 // DRY Helper method to check errors
 func checkError(t *testing.T, got, want interface{}, reqPath string) {
 	if got != want {
@@ -101,3 +815,615 @@ func callHTTPEndpointHandler(t *testing.T, httpMethod, url string) *httptest.Res
 	rt.ServeHTTP(response, request)
 	return response
 }
+
+// DRY Helper method to perform a http request with a body on an endpoint
+func callHTTPEndpointHandlerWithBody(t *testing.T, httpMethod, url, body string) *httptest.ResponseRecorder {
+	return callHTTPEndpointHandlerWithKey(t, "", httpMethod, url, body)
+}
+
+// the same with an API key - anonymous when blank
+func callHTTPEndpointHandlerWithKey(t *testing.T, key, httpMethod, url, body string) *httptest.ResponseRecorder {
+	request, err := http.NewRequest(httpMethod, url, strings.NewReader(body))
+	assert.NilError(t, err)
+	if key != "" {
+		request.Header.Set("X-API-Key", key)
+	}
+	response := httptest.NewRecorder()
+	rt.ServeHTTP(response, request)
+	return response
+}
+
+// an admin key in the request store for the length of the test
+// anonymous requests are refused the admin and export routes
+func adminKey(t *testing.T) string {
+	store := RequestCache.Client
+	k, key, err := apikey.Create(store, "test-admin", []string{apikey.Admin})
+	assert.NilError(t, err)
+	t.Cleanup(func() { store.DeleteValue(apikey.KeyPrefix + k.ID) })
+	return key
+}
+
+// removes a tenant along with the block it would otherwise keep
+func dropTenant(c cache.Service, name string) {
+	tenant.Delete(c, name)
+	c.DeleteValue(gen.BlockKeyPrefix + tenant.BlockOwnerPrefix + name)
+	c.DeleteValue(gen.BlockCounterPrefix + tenant.BlockOwnerPrefix + name)
+	c.DeleteValue(gen.BlockHeldPrefix + tenant.BlockOwnerPrefix + name)
+}
+
+func TestStretchApiEvents(t *testing.T) {
+	reset()
+	defer dropTenant(RequestCache.Client, "acme")
+	defer dropTenant(RequestCache.Client, "beta")
+
+	// stored devices are reported by the cache layer as in server mode
+	client := c.Client
+	c.Client = observe(client, "")
+	defer func() { c.Client = client }()
+
+	keys := map[string]string{}
+	for _, name := range []string{"acme", "beta"} {
+		_, key, err := tenant.Create(RequestCache.Client, name, 0)
+		assert.NilError(t, err)
+		keys[name] = key
+	}
+
+	ts := httptest.NewServer(rt)
+	defer ts.Close()
+	feed := strings.Replace(ts.URL, "http", "ws", 1)
+
+	// the next n events of conn - failing when they take too long
+	next := func(conn *websocket.Conn, n int) []models.Event {
+		received := make(chan []models.Event, 1)
+		go func() {
+			list := []models.Event{}
+			for len(list) < n {
+				_, data, err := conn.ReadMessage()
+				if err != nil {
+					break
+				}
+				e := models.Event{}
+				json.Unmarshal(data, &e)
+				list = append(list, e)
+			}
+			received <- list
+		}()
+		select {
+		case list := <-received:
+			return list
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %d events", n)
+		}
+		return nil
+	}
+
+	generate := func(url, agent, key string) {
+		request, _ := http.NewRequest("GET", url, nil)
+		request.Header.Set("User-Agent", agent)
+		request.Header.Set("X-API-Key", key)
+		response := httptest.NewRecorder()
+		rt.ServeHTTP(response, request)
+		assert.Equal(t, response.Code, 200)
+	}
+
+	t.Run("EVENTS refused", func(t *testing.T) {
+		expected := []struct {
+			url  string
+			key  string
+			want int
+		}{
+			{"/events", "", 400},
+			{"/events?type=exploded", "", 400},
+			{"/events?tenant=beta", keys["acme"], 403},
+			{"/t/beta/events", keys["acme"], 403},
+			{"/events?tenant=beta", "", 403},
+			{"/t/beta/events", "", 403},
+		}
+		for i, test := range expected {
+			t.Run(fmt.Sprintf("#%d: %q", i, test.url), func(t *testing.T) {
+				request, _ := http.NewRequest("GET", test.url, nil)
+				request.Header.Set("X-API-Key", test.key)
+				response := httptest.NewRecorder()
+				rt.ServeHTTP(response, request)
+				assert.Equal(t, response.Code, test.want)
+			})
+		}
+	})
+
+	t.Run("EVENTS shared feed", func(t *testing.T) {
+		conn, err := websocket.Dial(feed+"/events?type=generated,registered", nil)
+		assert.NilError(t, err)
+		defer conn.Close(websocket.CloseNormal, "")
+
+		generate("/generate/events", "events-shared", "")
+		list := next(conn, 101)
+		assert.Equal(t, list[0].Type, "generated")
+		assert.Equal(t, list[0].Count, 100)
+		for _, e := range list[1:] {
+			assert.Equal(t, e.Type, "registered")
+			assert.Equal(t, e.Batch, list[0].Batch)
+			assert.Equal(t, e.ShortCode, e.DevEUI[11:])
+			assert.Equal(t, e.Tenant, "")
+		}
+	})
+
+	t.Run("EVENTS tenant feed", func(t *testing.T) {
+		reset()
+		conn, err := websocket.Dial(feed+"/t/acme/events?type=stored", http.Header{"X-Api-Key": {keys["acme"]}})
+		assert.NilError(t, err)
+		defer conn.Close(websocket.CloseNormal, "")
+		keyed, err := websocket.Dial(feed+"/events?type=stored", http.Header{"X-Api-Key": {keys["beta"]}})
+		assert.NilError(t, err)
+		defer keyed.Close(websocket.CloseNormal, "")
+		anonymous, err := websocket.Dial(feed+"/events?type=stored", nil)
+		assert.NilError(t, err)
+		defer anonymous.Close(websocket.CloseNormal, "")
+		admin, err := websocket.Dial(feed+"/events?type=stored&tenant=beta", http.Header{"X-Api-Key": {adminKey(t)}})
+		assert.NilError(t, err)
+		defer admin.Close(websocket.CloseNormal, "")
+
+		generate("/t/acme/generate/events", "events-acme", keys["acme"])
+		generate("/generate/events", "events-shared-2", "")
+		reset()
+		generate("/generate/events", "events-beta", keys["beta"])
+
+		// each feed only sees its own tenant
+		for _, e := range next(conn, 100) {
+			assert.Equal(t, e.Type, "stored")
+			assert.Equal(t, e.Tenant, "acme")
+		}
+		for _, e := range next(keyed, 100) {
+			assert.Equal(t, e.Tenant, "beta")
+		}
+		// acme is published first - a leak would show before the shared batch
+		for _, e := range next(anonymous, 100) {
+			assert.Equal(t, e.Tenant, "")
+		}
+		for _, e := range next(admin, 100) {
+			assert.Equal(t, e.Tenant, "beta")
+		}
+	})
+}
+
+func TestStretchApiKeyScopes(t *testing.T) {
+	reset()
+	defer dropTenant(RequestCache.Client, "acme")
+	_, tenantKey, _ := tenant.Create(RequestCache.Client, "acme", 0)
+
+	keys := map[string]string{}
+	ids := map[string]string{}
+	for name, scopes := range map[string][]string{"station": {apikey.Lookup}, "ops": {apikey.Admin}} {
+		k, key, err := apikey.Create(RequestCache.Client, name, scopes)
+		assert.NilError(t, err)
+		keys[name], ids[name] = key, k.ID
+		defer RequestCache.Client.DeleteValue(apikey.KeyPrefix + k.ID)
+	}
+
+	call := func(method, url, key, body string) *httptest.ResponseRecorder {
+		request, _ := http.NewRequest(method, url, strings.NewReader(body))
+		request.Header.Set("X-API-Key", key)
+		response := httptest.NewRecorder()
+		rt.ServeHTTP(response, request)
+		return response
+	}
+
+	t.Run("SCOPES enforced", func(t *testing.T) {
+		expected := []struct {
+			method string
+			url    string
+			key    string
+			want   int
+		}{
+			{"GET", "/stats", keys["station"], 200},
+			{"GET", "/generate/scoped", keys["station"], 403},
+			{"GET", "/export", keys["station"], 403},
+			{"GET", "/admin/tenants", keys["station"], 403},
+			{"GET", "/admin/tenants", keys["ops"], 200},
+			{"GET", "/t/acme/stats", keys["ops"], 200},
+			{"GET", "/stats", tenantKey, 200},
+			{"GET", "/admin/tenants", tenantKey, 403},
+			{"GET", "/stats", "unknown", 401},
+			{"GET", "/stats", "", 200},
+			{"GET", "/", "", 200},
+		}
+		for i, test := range expected {
+			t.Run(fmt.Sprintf("#%d: %q:%s", i, test.method, test.url), func(t *testing.T) {
+				response := call(test.method, test.url, test.key, "")
+				checkError(t, response.Code, test.want, fmt.Sprintf("%q%q", test.method, test.url))
+			})
+		}
+	})
+
+	t.Run("SCOPES required by default", func(t *testing.T) {
+		// the server sets it from the flag left at its default
+		requireAPIKey = !*anon
+		defer func() { requireAPIKey = false }()
+
+		response := call("GET", "/generate/anonymous", "", "")
+		assert.Equal(t, response.Code, 401)
+		assert.Equal(t, response.Header().Get("WWW-Authenticate"), "API-Key")
+		assert.Equal(t, call("GET", "/view/00001", "", "").Code, 401)
+	})
+
+	t.Run("SCOPES required", func(t *testing.T) {
+		requireAPIKey = true
+		defer func() { requireAPIKey = false }()
+
+		response := call("GET", "/stats", "", "")
+		assert.Equal(t, response.Code, 401)
+		assert.Equal(t, response.Header().Get("WWW-Authenticate"), "API-Key")
+		assert.Equal(t, call("GET", "/stats", keys["station"], "").Code, 200)
+		assert.Equal(t, call("GET", "/", "", "").Code, 200)
+	})
+
+	t.Run("SCOPES key administration", func(t *testing.T) {
+		response := call("POST", "/admin/api-keys", keys["ops"], `{"name":"printer","scopes":["lookup"]}`)
+		assert.Equal(t, response.Code, 201)
+		created := struct {
+			ID  string `json:"id"`
+			Key string `json:"key"`
+		}{}
+		json.Unmarshal(response.Body.Bytes(), &created)
+		defer RequestCache.Client.DeleteValue(apikey.KeyPrefix + created.ID)
+		assert.Equal(t, call("GET", "/stats", created.Key, "").Code, 200)
+
+		assert.Equal(t, call("POST", "/admin/api-keys", keys["ops"], `{"name":"printer","scopes":["root"]}`).Code, 422)
+		assert.Contains(t, call("GET", "/admin/api-keys", keys["ops"], "").Body.String(), `"name":"printer"`)
+
+		response = call("POST", "/admin/api-keys/"+created.ID+"/rotate", keys["ops"], "")
+		assert.Equal(t, response.Code, 200)
+		previous := created.Key
+		json.Unmarshal(response.Body.Bytes(), &created)
+		assert.Equal(t, call("GET", "/stats", previous, "").Code, 401)
+		assert.Equal(t, call("GET", "/stats", created.Key, "").Code, 200)
+
+		assert.Equal(t, call("DELETE", "/admin/api-keys/"+created.ID, keys["ops"], "").Code, 200)
+		assert.Equal(t, call("GET", "/stats", created.Key, "").Code, 401)
+		assert.Equal(t, call("DELETE", "/admin/api-keys/"+created.ID, keys["ops"], "").Code, 410)
+		assert.Equal(t, call("DELETE", "/admin/api-keys/00000000", keys["ops"], "").Code, 404)
+	})
+}
+
+func TestStretchApiBearerTokens(t *testing.T) {
+	reset()
+	defer dropTenant(RequestCache.Client, "acme")
+	_, tenantKey, _ := tenant.Create(RequestCache.Client, "acme", 0)
+
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	v, err := jwt.NewVerifier([]jwt.Key{{Algorithm: jwt.HS256, Key: secret}, {ID: "es", Algorithm: jwt.ES256, Key: &ecKey.PublicKey}}, "https://id.example.com", "mxbcode")
+	assert.NilError(t, err)
+	jwtVerifier = v
+	defer func() { jwtVerifier = nil }()
+
+	token := func(extra jwt.Claims) string {
+		c := jwt.Claims{"iss": "https://id.example.com", "aud": "mxbcode", "sub": "station-1", "exp": time.Now().Add(time.Hour).Unix()}
+		for k, val := range extra {
+			c[k] = val
+		}
+		signed, err := jwt.Sign(jwt.ES256, "es", ecKey, c)
+		assert.NilError(t, err)
+		return signed
+	}
+	hs, _ := jwt.Sign(jwt.HS256, "", secret, jwt.Claims{"iss": "https://id.example.com", "aud": "mxbcode", "scope": "lookup", "exp": time.Now().Add(time.Hour).Unix()})
+
+	expected := []struct {
+		testName string
+		url      string
+		bearer   string
+		key      string
+		want     int
+	}{
+		{"TOKEN - HS256 lookup", "/stats", hs, "", 200},
+		{"TOKEN - lookup scope", "/stats", token(jwt.Claims{"scope": "openid lookup"}), "", 200},
+		{"TOKEN - missing scope", "/generate/jwt", token(jwt.Claims{"scope": "lookup"}), "", 403},
+		{"TOKEN - scope list", "/admin/tenants", token(jwt.Claims{"scope": []string{"admin"}}), "", 200},
+		{"TOKEN - no scopes", "/stats", token(nil), "", 403},
+		{"TOKEN - tenant", "/t/acme/stats", token(jwt.Claims{"tenant": "acme"}), "", 200},
+		{"TOKEN - tenant without admin", "/admin/tenants", token(jwt.Claims{"tenant": "acme"}), "", 403},
+		{"TOKEN - other tenant", "/t/beta/stats", token(jwt.Claims{"tenant": "acme"}), "", 403},
+		{"TOKEN - unknown tenant", "/stats", token(jwt.Claims{"tenant": "gamma"}), "", 403},
+		{"TOKEN - expired", "/stats", token(jwt.Claims{"scope": "lookup", "exp": time.Now().Add(-time.Hour).Unix()}), "", 401},
+		{"TOKEN - audience", "/stats", token(jwt.Claims{"scope": "lookup", "aud": "other"}), "", 401},
+		{"TOKEN - issuer", "/stats", token(jwt.Claims{"scope": "lookup", "iss": "https://evil.example.com"}), "", 401},
+		{"TOKEN - with API key", "/stats", token(jwt.Claims{"scope": "lookup"}), tenantKey, 400},
+		{"TOKEN - opaque bearer", "/stats", "export-token", "", 200},
+	}
+	for i, test := range expected {
+		t.Run(fmt.Sprintf("#%d: %q", i, test.testName), func(t *testing.T) {
+			request, _ := http.NewRequest("GET", test.url, nil)
+			request.Header.Set("Authorization", "Bearer "+test.bearer)
+			request.Header.Set("X-API-Key", test.key)
+			response := httptest.NewRecorder()
+			rt.ServeHTTP(response, request)
+			checkError(t, response.Code, test.want, test.url)
+		})
+	}
+
+	t.Run("TOKEN required", func(t *testing.T) {
+		requireAPIKey = true
+		defer func() { requireAPIKey = false }()
+
+		request, _ := http.NewRequest("GET", "/stats", nil)
+		request.Header.Set("Authorization", "Bearer "+token(jwt.Claims{"scope": "lookup"}))
+		response := httptest.NewRecorder()
+		rt.ServeHTTP(response, request)
+		assert.Equal(t, response.Code, 200)
+
+		request.Header.Set("Authorization", "Bearer "+token(jwt.Claims{"scope": "lookup", "exp": time.Now().Add(-time.Hour).Unix()}))
+		response = httptest.NewRecorder()
+		rt.ServeHTTP(response, request)
+		assert.Equal(t, response.Code, 401)
+		assert.Equal(t, response.Header().Get("WWW-Authenticate"), `Bearer error="invalid_token"`)
+	})
+}
+
+func TestStretchApiRateLimits(t *testing.T) {
+	reset()
+	clear := func() {
+		keys, _ := RequestCache.Client.ListKeys(ratelimit.BucketPrefix)
+		quotas, _ := RequestCache.Client.ListKeys(ratelimit.QuotaPrefix)
+		for _, k := range append(keys, quotas...) {
+			RequestCache.Client.DeleteValue(k)
+		}
+	}
+	defer clear()
+	k, key, _ := apikey.Create(RequestCache.Client, "limited", []string{apikey.Generate, apikey.Lookup})
+	defer RequestCache.Client.DeleteValue(apikey.KeyPrefix + k.ID)
+
+	callFrom := func(addr, forwarded, url, agent, key string) *httptest.ResponseRecorder {
+		request, _ := http.NewRequest("GET", url, nil)
+		request.RemoteAddr = addr
+		request.Header.Set("User-Agent", agent)
+		request.Header.Set("X-API-Key", key)
+		if forwarded != "" {
+			request.Header.Set("X-Forwarded-For", forwarded)
+		}
+		response := httptest.NewRecorder()
+		rt.ServeHTTP(response, request)
+		return response
+	}
+	call := func(url, agent, key string) *httptest.ResponseRecorder {
+		return callFrom("", "", url, agent, key)
+	}
+
+	t.Run("LIMIT requests", func(t *testing.T) {
+		assert.NilError(t, startLimits(0.5, 2, 0))
+		defer func() { rateLimit = nil }()
+
+		assert.Equal(t, call("/stats", "limit", "").Code, 200)
+		assert.Equal(t, call("/stats", "limit", "").Code, 200)
+		response := call("/stats", "limit", "")
+		assert.Equal(t, response.Code, 429)
+		assert.Equal(t, response.Header().Get("Retry-After"), "2")
+		assert.Contains(t, response.Body.String(), "rate limit of 0.5 requests a second exceeded")
+
+		// each key has a bucket of its own
+		assert.Equal(t, call("/stats", "limit", key).Code, 200)
+	})
+
+	t.Run("LIMIT failed logins", func(t *testing.T) {
+		assert.NilError(t, startLimits(0.5, 2, 0))
+		defer func() { rateLimit = nil }()
+		defer clear()
+
+		addr := "198.51.100.1:4000"
+		assert.Equal(t, callFrom(addr, "", "/stats", "login", "guess-1").Code, 401)
+		assert.Equal(t, callFrom(addr, "", "/stats", "login", "guess-2").Code, 401)
+		response := callFrom(addr, "", "/stats", "login", "guess-3")
+		assert.Equal(t, response.Code, 429)
+		assert.Contains(t, response.Body.String(), "rate limit of 0.5 requests a second exceeded")
+
+		// other addresses still log in and anonymous requests count apart
+		assert.Equal(t, callFrom("198.51.100.2:4000", "", "/stats", "login", "guess-4").Code, 401)
+		assert.Equal(t, callFrom(addr, "", "/stats", "login", "").Code, 200)
+	})
+
+	t.Run("LIMIT forwarded addresses", func(t *testing.T) {
+		assert.NilError(t, startLimits(0.5, 1, 0))
+		assert.NilError(t, startProxies("10.0.0.0/8"))
+		defer func() { rateLimit, trustedProxies = nil, nil }()
+		defer clear()
+
+		// a client can't spoof its way to a new bucket
+		assert.Equal(t, callFrom("198.51.100.1:4000", "203.0.113.1", "/stats", "forwarded", "").Code, 200)
+		assert.Equal(t, callFrom("198.51.100.1:4000", "203.0.113.2", "/stats", "forwarded", "").Code, 429)
+
+		// clients behind a trusted proxy each have their own
+		assert.Equal(t, callFrom("10.0.0.1:4000", "203.0.113.1", "/stats", "forwarded", "").Code, 200)
+		assert.Equal(t, callFrom("10.0.0.1:4000", "203.0.113.2", "/stats", "forwarded", "").Code, 200)
+		assert.Equal(t, callFrom("10.0.0.1:4000", "203.0.113.2", "/stats", "forwarded", "").Code, 429)
+	})
+
+	t.Run("LIMIT daily quota", func(t *testing.T) {
+		assert.NilError(t, startLimits(0, 0, 150))
+		defer func() { dailyQuota = nil }()
+
+		response := call("/generate/quota", "quota-1", "")
+		assert.Equal(t, response.Code, 200)
+		assert.Equal(t, strings.Count(response.Body.String(), ","), 100)
+
+		// the rest of the quota - replays are free
+		response = call("/generate/quota", "quota-2", "")
+		assert.Equal(t, strings.Count(response.Body.String(), ","), 50)
+		assert.Equal(t, call("/generate/quota", "quota-2", "").Code, 200)
+
+		response = call("/generate/quota", "quota-3", "")
+		assert.Equal(t, response.Code, 429)
+		assert.Equal(t, response.Header().Get("Retry-After") != "", true)
+		assert.Contains(t, response.Body.String(), "daily quota of 150 devices used up")
+
+		// counted per client
+		response = call("/generate/quota", "quota-4", key)
+		assert.Equal(t, response.Code, 200)
+		used, _ := dailyQuota.Used("key:" + k.ID)
+		assert.Equal(t, used, int64(100))
+	})
+}
+
+func TestStretchApiForwardedFor(t *testing.T) {
+	assert.NilError(t, startProxies("10.0.0.0/8, 192.0.2.7"))
+	defer func() { trustedProxies = nil }()
+
+	expected := []struct {
+		testName  string
+		addr      string
+		forwarded string
+		realIP    string
+		want      string
+	}{
+		{"PROXY - untrusted peer", "198.51.100.1:4000", "203.0.113.1", "203.0.113.2", ""},
+		{"PROXY - trusted peer", "10.0.0.1:4000", "203.0.113.1", "", "203.0.113.1"},
+		{"PROXY - single trusted address", "192.0.2.7:4000", "203.0.113.1", "", "203.0.113.1"},
+		{"PROXY - spoofed hops ignored", "10.0.0.1:4000", "1.1.1.1, 203.0.113.1, 10.0.0.2", "", "203.0.113.1"},
+		{"PROXY - real ip", "10.0.0.1:4000", "", "203.0.113.2", "203.0.113.2"},
+		{"PROXY - garbage", "10.0.0.1:4000", "not-an-ip", "", ""},
+	}
+	for i, test := range expected {
+		t.Run(fmt.Sprintf("#%d: %q", i, test.testName), func(t *testing.T) {
+			request, _ := http.NewRequest("GET", "/", nil)
+			request.RemoteAddr = test.addr
+			request.Header.Set("X-Forwarded-For", test.forwarded)
+			request.Header.Set("X-Real-IP", test.realIP)
+			assert.Equal(t, forwardedFor(request), test.want)
+		})
+	}
+
+	assert.Error(t, startProxies("10.0.0.0/33"), "invalid trusted proxy")
+	assert.Error(t, startProxies("proxy.local"), "invalid trusted proxy")
+}
+
+// a certificate for cn signed by parent - a CA when parent is nil
+func issueCertificate(t *testing.T, cn string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, []byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NilError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn, Organization: []string{"Acme"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  parent == nil,
+		BasicConstraintsValid: true,
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	assert.NilError(t, err)
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	return cert, key,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestStretchApiClientCertificates(t *testing.T) {
+	reset()
+	defer dropTenant(RequestCache.Client, "acme")
+	tenant.Create(RequestCache.Client, "acme", 0)
+	k, key, _ := apikey.Create(RequestCache.Client, "ops", []string{apikey.Admin})
+	defer RequestCache.Client.DeleteValue(apikey.KeyPrefix + k.ID)
+
+	dir, _ := ioutil.TempDir("", "tls")
+	defer os.RemoveAll(dir)
+	write := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		ioutil.WriteFile(path, data, 0600)
+		return path
+	}
+
+	ca, caKey, caPEM, _ := issueCertificate(t, "factory-ca", nil, nil)
+	rogue, rogueKey, _, _ := issueCertificate(t, "rogue-ca", nil, nil)
+	_, _, serverPEM, serverKeyPEM := issueCertificate(t, "mxbcode", ca, caKey)
+	clients := map[string]tls.Certificate{}
+	for _, cn := range []string{"station-1", "station-2", "station-3"} {
+		_, _, certPEM, keyPEM := issueCertificate(t, cn, ca, caKey)
+		clients[cn], _ = tls.X509KeyPair(certPEM, keyPEM)
+	}
+	_, _, roguePEM, rogueKeyPEM := issueCertificate(t, "station-1", rogue, rogueKey)
+	clients["rogue"], _ = tls.X509KeyPair(roguePEM, rogueKeyPEM)
+
+	_, err := startTLS("", "", write("ca.pem", caPEM), "", false)
+	assert.Error(t, err, "client certificates need -tls-cert and -tls-key")
+
+	cfg, err := startTLS(write("server.crt", serverPEM), write("server.key", serverKeyPEM), write("ca.pem", caPEM),
+		write("tenants", []byte("acme CN=station-1,O=Acme\nghost CN=station-2\n")), false)
+	assert.NilError(t, err)
+	defer func() { certTenants = nil }()
+
+	listen := func(cfg *tls.Config) (string, func() error) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.NilError(t, err)
+		srv := &http.Server{Handler: rt}
+		go srv.Serve(tls.NewListener(ln, cfg))
+		return ln.Addr().String(), srv.Close
+	}
+	addr, stop := listen(cfg)
+	defer stop()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	call := func(addr, station, url, agent, key string) (int, string) {
+		config := &tls.Config{RootCAs: roots}
+		if station != "" {
+			config.Certificates = []tls.Certificate{clients[station]}
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+		request, _ := http.NewRequest("GET", "https://"+addr+url, nil)
+		request.Header.Set("User-Agent", agent)
+		request.Header.Set("X-API-Key", key)
+		resp, err := client.Do(request)
+		if err != nil {
+			return 0, err.Error()
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	expected := []struct {
+		testName string
+		station  string
+		url      string
+		key      string
+		want     int
+		body     string
+	}{
+		{"CERT - station scoped to its tenant", "station-1", "/admin/api-keys", "", 403, `is not granted the \"admin\" scope`},
+		{"CERT - station generates for its tenant", "station-1", "/generate/cert", "", 200, `"deveuis"`},
+		{"CERT - tenant missing", "station-2", "/stats", "", 403, `tenant \"ghost\" of certificate \"CN=station-2,O=Acme\" does not exist`},
+		{"CERT - unmapped", "station-3", "/admin/api-keys", "", 401, "an API key is required"},
+		{"CERT - none", "", "/admin/api-keys", "", 401, "an API key is required"},
+		{"CERT - key takes its place", "station-1", "/admin/api-keys", key, 200, `"name":"ops"`},
+		{"CERT - unknown CA not presented", "rogue", "/admin/api-keys", "", 401, "an API key is required"},
+	}
+	for i, test := range expected {
+		t.Run(fmt.Sprintf("#%d: %q", i, test.testName), func(t *testing.T) {
+			code, body := call(addr, test.station, test.url, fmt.Sprintf("cert-%d", i), test.key)
+			assert.Equal(t, code, test.want)
+			assert.Contains(t, body, test.body)
+		})
+	}
+
+	devices, _ := tenant.DeviceCount(RequestCache.Client, "acme")
+	assert.Equal(t, devices, int64(100))
+
+	t.Run("CERT - required", func(t *testing.T) {
+		_, err := startTLS(filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key"), "", "", true)
+		assert.Error(t, err, "requiring client certificates needs -tls-client-ca")
+
+		cfg, err := startTLS(filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key"), filepath.Join(dir, "ca.pem"), "", true)
+		assert.NilError(t, err)
+		addr, stop := listen(cfg)
+		defer stop()
+
+		code, _ := call(addr, "station-3", "/stats", "cert-required", "")
+		assert.Equal(t, code, 200)
+		code, _ = call(addr, "rogue", "/stats", "cert-required", "")
+		assert.Equal(t, code, 0)
+		code, _ = call(addr, "", "/stats", "cert-required", "")
+		assert.Equal(t, code, 0)
+	})
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+
+	"github.com/David-solly/mxbcode/pkg/certs"
+	"github.com/David-solly/mxbcode/pkg/models"
+	"github.com/David-solly/mxbcode/pkg/tenant"
+)
+
+// tenants of client certificates
+// certificates only secure the connection while it is nil
+var certTenants certs.SubjectMap
+
+// configure HTTPS from the commandline - nil serves plain HTTP.
+// A client CA bundle turns on client certificates, required
+// with `requireCert` and otherwise verified when they are sent
+func startTLS(certFile, keyFile, clientCA, tenantsFile string, requireCert bool) (*tls.Config, error) {
+	if certFile == "" && keyFile == "" {
+		if clientCA != "" {
+			return nil, fmt.Errorf("client certificates need -tls-cert and -tls-key")
+		}
+		return nil, nil
+	}
+
+	r, err := certs.NewReloader(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{GetCertificate: r.GetCertificate, MinVersion: tls.VersionTLS12}
+
+	if requireCert && clientCA == "" {
+		return nil, fmt.Errorf("requiring client certificates needs -tls-client-ca")
+	}
+	if clientCA != "" {
+		pool, err := certs.LoadCertPool(clientCA)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+		if requireCert {
+			cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	if tenantsFile != "" {
+		if clientCA == "" {
+			return nil, fmt.Errorf("mapping certificates to tenants needs -tls-client-ca")
+		}
+		m, err := certs.LoadSubjectMap(tenantsFile)
+		if err != nil {
+			return nil, err
+		}
+		certTenants = m
+	}
+
+	return cfg, nil
+}
+
+// ClientCertMiddleware : identifies factory stations from their verified
+// client certificate once subjects are mapped to tenants. The station
+// is scoped to its tenant like a tenant key. An API key or bearer token
+// sent over the connection takes its place
+func ClientCertMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if certTenants == nil || r.TLS == nil || len(r.TLS.VerifiedChains) == 0 ||
+			requestAPIKey(r) != nil || requestTenant(r) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cert := r.TLS.VerifiedChains[0][0]
+		name, found := certTenants.Tenant(cert)
+		if !found {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		t, err := tenant.Get(RequestCache.Client, name)
+		if err != nil {
+			writeProblem(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if t == nil {
+			errorMessage := fmt.Sprintf("tenant %q of certificate %q does not exist", name, cert.Subject.String())
+			writeProblem(w, errorMessage, http.StatusForbidden)
+			return
+		}
+
+		k := &models.APIKey{ID: "cert:" + cert.Subject.String(), Name: cert.Subject.CommonName, Scopes: tenantScopes}
+		ctx := context.WithValue(r.Context(), tenantContextKey, t)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, apiKeyContextKey, k)))
+	})
+}
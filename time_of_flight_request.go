@@ -4,9 +4,14 @@ import (
 	"fmt"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/David-solly/mxbcode/pkg/cache"
+	"github.com/David-solly/mxbcode/pkg/events"
+	gen "github.com/David-solly/mxbcode/pkg/generator"
 	"github.com/David-solly/mxbcode/pkg/models"
+	"github.com/David-solly/mxbcode/pkg/registry"
+	"github.com/David-solly/mxbcode/pkg/tenant"
 )
 
 // loop through generated id's
@@ -15,23 +20,46 @@ import (
 // listen for SIGINT and return current tally
 //
 func registerBatch(batch []*models.DevEUI, c cache.Cache, sigint chan bool, registered *models.RegisteredDevEUIList) (models.RegisteredDevEUIList, int, error) {
+	return registerObservedBatch(batch, c, sigint, registered, nil)
+}
+
+// registerBatch reporting each device to `p` as it completes
+// the batch stops early when `p` is cancelled
+func registerObservedBatch(batch []*models.DevEUI, c cache.Cache, sigint chan bool, registered *models.RegisteredDevEUIList, p *batchProgress) (models.RegisteredDevEUIList, int, error) {
 	m := sync.Mutex{}
 
-	tofMaxRequests := 10
-	tof := make(chan int, tofMaxRequests)
+	r := registrarFor(c)
 
 	var wg sync.WaitGroup
 
 	for i, deveui := range batch {
+		// an open breaker holds the batch rather than sending requests
+		// bound to fail - the batch can still be stopped while it waits
+		interrupt := (<-chan bool)(sigint)
+		if holdWhileOpen(r.Breaker.Wait, sigint, p) {
+			interrupt = interrupted
+		}
+
 		select {
-		case <-sigint:
+		case <-interrupt:
 			// Wait for inflight requests to finish monitor buffer channel until depleted
 			wg.Wait()
 			fmt.Println("Generated and registered ", len(registered.DevEUIs))
 
 			// Output the completed and registered devices to the user
-			// save current shortcode value to cache
-			c.Client.StoreLastDUID(models.LastDevEUI{ShortCode: deveui.ShortCode})
+			// the unsent shortcodes go back to the counter they came from
+			rewindBatch(c, batch[i:], registered.Batch)
+			return *registered, len(registered.DevEUIs), nil
+
+		case <-batches.drained():
+			// the server is shutting down - hold what was drawn but not sent
+			wg.Wait()
+			batches.checkpoint(c, batch[i:], registered.Batch)
+			return *registered, len(registered.DevEUIs), nil
+
+		case <-p.cancelled():
+			// nobody is waiting for the rest of the batch
+			wg.Wait()
 			return *registered, len(registered.DevEUIs), nil
 
 		default:
@@ -43,11 +71,11 @@ func registerBatch(batch []*models.DevEUI, c cache.Cache, sigint chan bool, regi
 			//
 			// time.Sleep(100 * time.Millisecond)
 
-			// Start filling the buffered channel with values
-			// blocks when there are 10 and waits for free space
-			// maximum value can be adjusted accordingly
-			// Maximum of 10 concurrent requests as per spec
-			tof <- i
+			// blocks while the registrar has its fill and waits for free space
+			// the limit adapts to how the registrar copes
+			// up to its concurrency - 10 concurrent requests by default as per spec
+			started := r.Limiter.Acquire()
+			p.sent()
 
 			wg.Add(1)
 
@@ -56,9 +84,12 @@ func registerBatch(batch []*models.DevEUI, c cache.Cache, sigint chan bool, regi
 				defer wg.Done()
 
 				// request parameters are in upper case hex as per request
-				_, code, err := register(strings.ToUpper(deveui.ShortCode), url, tof)
+				status, code, err := registerWith(r.Client, strings.ToUpper(deveui.ShortCode), r.URL)
+				r.Limiter.Release(started, code, err)
+				r.Breaker.Record(code, err)
 				if err != nil {
 					fmt.Printf("Error registering %q:\n%s", deveui.ShortCode, err.Error())
+					status = err.Error()
 				}
 
 				if code == 200 {
@@ -66,6 +97,13 @@ func registerBatch(batch []*models.DevEUI, c cache.Cache, sigint chan bool, regi
 					c.Client.StoreDUID(*deveui)
 					registered.DevEUIs = append(registered.DevEUIs, strings.ToUpper(deveui.DevEUI))
 					m.Unlock()
+					recordDevice(c, deveui, registered.Batch)
+					provisionKeys(deveui.DevEUI)
+					p.confirmed(deveui)
+					publish(c.Client, models.Event{Type: events.Registered, Batch: registered.Batch, DevEUI: strings.ToUpper(deveui.DevEUI), ShortCode: strings.ToUpper(deveui.ShortCode)})
+				} else {
+					p.refused(deveui, status)
+					publish(c.Client, models.Event{Type: events.Failed, Batch: registered.Batch, DevEUI: strings.ToUpper(deveui.DevEUI), ShortCode: strings.ToUpper(deveui.ShortCode), Detail: status})
 				}
 
 			}(deveui)
@@ -78,3 +116,87 @@ func registerBatch(batch []*models.DevEUI, c cache.Cache, sigint chan bool, regi
 
 	return *registered, len(registered.DevEUIs), nil
 }
+
+// give the shortcodes of an interrupted batch back to the counter they
+// were drawn from - the global one or a block. Once another batch has
+// drawn after them they are checkpointed instead
+func rewindBatch(c cache.Cache, unsent []*models.DevEUI, batch string) {
+	shortcodes := make([]string, len(unsent))
+	for i, d := range unsent {
+		shortcodes[i] = strings.ToUpper(d.ShortCode)
+	}
+
+	store := tenant.Shared(c.Client)
+	rewound, err := gen.Rewind(store, shortcodes)
+	if err == nil && !rewound {
+		_, err = gen.Checkpoint(store, shortcodes, "interrupted batch "+batch)
+	}
+	if err != nil {
+		fmt.Printf("could not give back the unregistered shortcodes of batch %s: %v\n", batch, err)
+	}
+}
+
+// register a single device outside of a batch
+// used when claiming reserved shortcodes
+// the device is only stored once the provider accepts it
+func registerDevice(deveui *models.DevEUI, c cache.Cache) error {
+	r := registrarFor(c)
+	if wait := r.Breaker.Wait(); wait > 0 {
+		return fmt.Errorf("registrar %q is down - retry in %v", r.Name, wait.Round(time.Second))
+	}
+	started := r.Limiter.Acquire()
+	status, code, err := registerWith(r.Client, strings.ToUpper(deveui.ShortCode), r.URL)
+	r.Limiter.Release(started, code, err)
+	r.Breaker.Record(code, err)
+	if err != nil {
+		return err
+	}
+	if code != 200 {
+		return fmt.Errorf("registration of %q refused - %s", strings.ToUpper(deveui.ShortCode), status)
+	}
+
+	if _, err = c.Client.StoreDUID(*deveui); err != nil {
+		return err
+	}
+	recordDevice(c, deveui, "")
+	provisionKeys(deveui.DevEUI)
+	return nil
+}
+
+// an interrupt that came in while a batch was held
+var interrupted = func() chan bool {
+	ch := make(chan bool)
+	close(ch)
+	return ch
+}()
+
+// waits as long as the breaker of the registrar says while
+// the batch is not stopped - true when the wait ended with
+// an interrupt from sigint
+func holdWhileOpen(retryIn func() time.Duration, sigint chan bool, p *batchProgress) bool {
+	for {
+		wait := retryIn()
+		if wait <= 0 {
+			return false
+		}
+
+		select {
+		case <-sigint:
+			return true
+		case <-batches.drained():
+			return false
+		case <-p.cancelled():
+			return false
+		case <-time.After(wait):
+		}
+	}
+}
+
+// keep the registration record used by exports
+// a failure is reported but does not undo the registration
+func recordDevice(c cache.Cache, deveui *models.DevEUI, batch string) {
+	d := models.Device{DevEUI: deveui.DevEUI, ShortCode: deveui.ShortCode, Batch: batch}
+	if err := registry.Record(c.Client, d); err != nil {
+		fmt.Printf("Error recording %q:\n%s\n", deveui.ShortCode, err.Error())
+	}
+}